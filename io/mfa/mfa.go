@@ -0,0 +1,187 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderPrompt  = "prompt"
+	ProviderTOTP    = "totp"
+	ProviderProcess = "process"
+	ProviderAskpass = "askpass"
+
+	tokenLength = 6
+	waitDelay   = time.Second
+)
+
+// Request describes the MFA device a code is requested for.
+type Request struct {
+	ProfileName string
+	Account     string
+	UserName    string
+}
+
+// Provider returns a one-time MFA code.
+type Provider interface {
+	Token(ctx context.Context, request Request) (string, error)
+}
+
+// ProviderFunc adapts a function to Provider.
+type ProviderFunc func(ctx context.Context, request Request) (string, error)
+
+func (f ProviderFunc) Token(ctx context.Context, request Request) (string, error) {
+	return f(ctx, request)
+}
+
+// Settings are the per-profile provider settings read from the AWS config
+// (actool_mfa_provider, actool_mfa_process and actool_mfa_askpass).
+type Settings struct {
+	Provider string
+	Process  string
+	Askpass  string
+}
+
+type commandProvider struct {
+	command string
+}
+
+type askpassProvider struct {
+	program string
+}
+
+// NewProvider chooses the provider configured by settings. prompt is used when
+// nothing is configured; totp is used for the built-in TOTP generator.
+func NewProvider(settings Settings, prompt Provider, totp Provider) (Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(settings.Provider))
+	if name == "" && strings.TrimSpace(settings.Process) != "" {
+		name = ProviderProcess
+	}
+
+	switch name {
+	case "", ProviderPrompt:
+		if prompt == nil {
+			return nil, errors.New("no terminal MFA prompt is available")
+		}
+		return prompt, nil
+	case ProviderTOTP:
+		if totp == nil {
+			return nil, errors.New("the built-in TOTP provider is not available")
+		}
+		return totp, nil
+	case ProviderProcess:
+		if strings.TrimSpace(settings.Process) == "" {
+			return nil, errors.New("actool_mfa_provider = process requires actool_mfa_process")
+		}
+		return NewCommandProvider(settings.Process), nil
+	case ProviderAskpass:
+		return NewAskpassProvider(settings.Askpass)
+	default:
+		return nil, fmt.Errorf("unsupported MFA provider: %s", settings.Provider)
+	}
+}
+
+// NewCommandProvider runs command through the platform shell and reads the
+// code from its stdout, so tools such as `ykman oath accounts code --single`,
+// `op item get --otp` and `oathtool` can be used unchanged.
+func NewCommandProvider(command string) Provider {
+	return &commandProvider{command: command}
+}
+
+// NewAskpassProvider runs an SSH_ASKPASS-style helper with the prompt text as
+// its only argument. When program is empty, ACTOOL_ASKPASS, SSH_ASKPASS and
+// SUDO_ASKPASS are consulted in that order.
+func NewAskpassProvider(program string) (Provider, error) {
+	program = strings.TrimSpace(program)
+	for _, name := range []string{"ACTOOL_ASKPASS", "SSH_ASKPASS", "SUDO_ASKPASS"} {
+		if program != "" {
+			break
+		}
+		program = strings.TrimSpace(os.Getenv(name))
+	}
+	if program == "" {
+		return nil, errors.New("no askpass helper configured; set actool_mfa_askpass or SSH_ASKPASS")
+	}
+	return &askpassProvider{program: program}, nil
+}
+
+func (c *commandProvider) Token(ctx context.Context, request Request) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", c.command)
+	}
+	output, err := runProvider(ctx, cmd, request)
+	if err != nil {
+		return "", fmt.Errorf("MFA process failed: %w", err)
+	}
+	return parseToken(output)
+}
+
+func (a *askpassProvider) Token(ctx context.Context, request Request) (string, error) {
+	prompt := fmt.Sprintf("MFA code for %s (account %s, user %s):", request.ProfileName, request.Account, request.UserName)
+	output, err := runProvider(ctx, exec.CommandContext(ctx, a.program, prompt), request)
+	if err != nil {
+		return "", fmt.Errorf("askpass helper failed: %w", err)
+	}
+	return parseToken(output)
+}
+
+func runProvider(ctx context.Context, cmd *exec.Cmd, request Request) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	// Hardware tokens print touch prompts on stderr; keep them visible.
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"ACTOOL_MFA_PROFILE="+request.ProfileName,
+		"ACTOOL_MFA_ACCOUNT="+request.Account,
+		"ACTOOL_MFA_USER="+request.UserName,
+	)
+	// A canceled shell may leave children holding stdout open; do not wait
+	// for them once the shell itself has been killed.
+	cmd.WaitDelay = waitDelay
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseToken takes the last field of the last non-empty line, which covers
+// both bare codes and "<account>  <code>" listings.
+func parseToken(output []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) == 0 {
+		return "", errors.New("MFA provider returned no code")
+	}
+	token := fields[len(fields)-1]
+	if err := ValidateToken(token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ValidateToken checks that token looks like an AWS MFA code.
+func ValidateToken(token string) error {
+	if len(token) != tokenLength {
+		return errors.New("MFA provider returned an invalid token")
+	}
+	for _, character := range token {
+		if character < '0' || character > '9' {
+			return errors.New("MFA provider returned an invalid token")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func writeStandInScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in scripts use /bin/sh")
+	}
+	path := filepath.Join(t.TempDir(), "provider.sh")
+	assert.NilError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700))
+	return path
+}
+
+func TestCommandProvider(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "bare code", body: "echo 123456\n", want: "123456"},
+		{name: "ykman style listing", body: "echo 'AWS:dev@123456789012  654321'\n", want: "654321"},
+		{name: "request environment", body: "test \"$ACTOOL_MFA_PROFILE:$ACTOOL_MFA_ACCOUNT:$ACTOOL_MFA_USER\" = 'dev:123456789012:alice' && echo 111111\n", want: "111111"},
+		{name: "invalid output", body: "echo not-a-code\n", wantErr: "invalid token"},
+		{name: "empty output", body: "true\n", wantErr: "no code"},
+		{name: "command failure", body: "exit 3\n", wantErr: "MFA process failed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			script := writeStandInScript(t, tc.body)
+			provider, err := NewProvider(Settings{Process: script}, nil, nil)
+			assert.NilError(t, err)
+
+			token, err := provider.Token(context.Background(), Request{ProfileName: "dev", Account: "123456789012", UserName: "alice"})
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, token, tc.want)
+		})
+	}
+}
+
+func TestCommandProviderIsCanceledWithContext(t *testing.T) {
+	script := writeStandInScript(t, "exec sleep 10\n")
+	provider := NewCommandProvider("exec " + script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err := provider.Token(ctx, Request{ProfileName: "dev"})
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.Assert(t, time.Since(started) < 5*time.Second)
+}
+
+func TestAskpassProviderPassesPrompt(t *testing.T) {
+	script := writeStandInScript(t, "case \"$1\" in *'MFA code for dev'*) echo 222222 ;; *) exit 1 ;; esac\n")
+
+	provider, err := NewProvider(Settings{Provider: ProviderAskpass, Askpass: script}, nil, nil)
+	assert.NilError(t, err)
+	token, err := provider.Token(context.Background(), Request{ProfileName: "dev", Account: "123456789012", UserName: "alice"})
+	assert.NilError(t, err)
+	assert.Equal(t, token, "222222")
+}
+
+func TestAskpassProviderFallsBackToEnvironment(t *testing.T) {
+	script := writeStandInScript(t, "echo 333333\n")
+	t.Setenv("ACTOOL_ASKPASS", "")
+	t.Setenv("SSH_ASKPASS", script)
+
+	provider, err := NewAskpassProvider("")
+	assert.NilError(t, err)
+	token, err := provider.Token(context.Background(), Request{ProfileName: "dev"})
+	assert.NilError(t, err)
+	assert.Equal(t, token, "333333")
+
+	t.Setenv("SSH_ASKPASS", "")
+	t.Setenv("SUDO_ASKPASS", "")
+	_, err = NewAskpassProvider("")
+	assert.ErrorContains(t, err, "no askpass helper")
+}
+
+func TestNewProviderSelection(t *testing.T) {
+	prompt := ProviderFunc(func(context.Context, Request) (string, error) { return "prompt", nil })
+	totp := ProviderFunc(func(context.Context, Request) (string, error) { return "totp", nil })
+
+	cases := []struct {
+		name     string
+		settings Settings
+		want     string
+		wantErr  string
+	}{
+		{name: "default prompt", want: "prompt"},
+		{name: "explicit prompt", settings: Settings{Provider: "Prompt"}, want: "prompt"},
+		{name: "totp", settings: Settings{Provider: ProviderTOTP}, want: "totp"},
+		{name: "process without command", settings: Settings{Provider: ProviderProcess}, wantErr: "requires actool_mfa_process"},
+		{name: "unknown", settings: Settings{Provider: "sms"}, wantErr: "unsupported MFA provider"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := NewProvider(tc.settings, prompt, totp)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			token, err := provider.Token(context.Background(), Request{})
+			assert.NilError(t, err)
+			assert.Equal(t, token, tc.want)
+		})
+	}
+}
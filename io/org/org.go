@@ -0,0 +1,146 @@
+// Package org lists the accounts of an AWS Organization for actool org
+// sync.
+package org
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awsorg "github.com/aws/aws-sdk-go-v2/service/organizations"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const (
+	// EndpointEnv replaces the Organizations endpoint, which lets org sync
+	// run against a local stand-in.
+	EndpointEnv = "ACTOOL_ORGANIZATIONS_ENDPOINT"
+
+	// DefaultRegion is used when the source profile sets no region.
+	// Organizations is served from us-east-1 in the aws partition.
+	DefaultRegion = "us-east-1"
+
+	// StatusActive is the status of an account that can be used.
+	StatusActive = "ACTIVE"
+)
+
+// Account is a member account of the organization.
+type Account struct {
+	ID     string
+	Name   string
+	Email  string
+	Status string
+}
+
+type Service interface {
+	ListAccounts(ctx context.Context) ([]Account, error)
+}
+
+// Options select the Organizations region and endpoint.
+type Options struct {
+	Region   string
+	Endpoint string
+	// Timeout bounds each request; zero uses ACTOOL_TIMEOUT or
+	// sts.DefaultTimeout.
+	Timeout time.Duration
+}
+
+type service struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	options      Options
+
+	httpClient *http.Client
+}
+
+// NewService signs requests with the given credentials, normally those of
+// the organization's management or delegated administrator account.
+func NewService(accessKey string, secretKey string, sessionToken string, options Options) Service {
+	return &service{accessKey: accessKey, secretKey: secretKey, sessionToken: sessionToken, options: options}
+}
+
+func (s *service) ListAccounts(ctx context.Context) ([]Account, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	paginator := awsorg.NewListAccountsPaginator(client, &awsorg.ListAccountsInput{})
+	for paginator.HasMorePages() {
+		page, err := s.nextPage(ctx, paginator)
+		if err != nil {
+			return nil, fmt.Errorf("organizations fail: %w", err)
+		}
+		for _, account := range page.Accounts {
+			accounts = append(accounts, Account{
+				ID:     aws.ToString(account.Id),
+				Name:   aws.ToString(account.Name),
+				Email:  aws.ToString(account.Email),
+				Status: string(account.Status),
+			})
+		}
+	}
+	if accounts == nil {
+		return nil, errors.New("organizations returned no accounts")
+	}
+	return accounts, nil
+}
+
+// nextPage bounds each page request rather than the whole listing, as large
+// organizations take many pages.
+func (s *service) nextPage(ctx context.Context, paginator *awsorg.ListAccountsPaginator) (*awsorg.ListAccountsOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	return paginator.NextPage(ctx)
+}
+
+func (s *service) client(ctx context.Context) (*awsorg.Client, error) {
+	region := strings.TrimSpace(s.options.Region)
+	if region == "" {
+		region = DefaultRegion
+	}
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.accessKey, s.secretKey, s.sessionToken)),
+	}
+	if s.httpClient != nil {
+		loadOptions = append(loadOptions, config.WithHTTPClient(s.httpClient))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return awsorg.NewFromConfig(cfg, func(o *awsorg.Options) {
+		if endpoint := s.endpoint(); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+func (s *service) endpoint() string {
+	if endpoint := strings.TrimSpace(os.Getenv(EndpointEnv)); endpoint != "" {
+		return endpoint
+	}
+	return strings.TrimSpace(s.options.Endpoint)
+}
+
+func (s *service) timeout() time.Duration {
+	if s.options.Timeout > 0 {
+		return s.options.Timeout
+	}
+	if value := strings.TrimSpace(os.Getenv(sts.TimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return sts.DefaultTimeout
+}
@@ -0,0 +1,79 @@
+package org
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// orgStandIn answers ListAccounts in pages of two accounts.
+type orgStandIn struct {
+	mu       sync.Mutex
+	accounts []map[string]string
+	requests []*http.Request
+}
+
+func (s *orgStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	if r.Header.Get("X-Amz-Target") != "AWSOrganizationsV20161128.ListAccounts" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body := map[string]interface{}{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	start := 0
+	if token, ok := body["NextToken"].(string); ok && token == "2" {
+		start = 2
+	}
+	end := min(start+2, len(s.accounts))
+	output := map[string]interface{}{"Accounts": s.accounts[start:end]}
+	if end < len(s.accounts) {
+		output["NextToken"] = "2"
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func newStandInService(t *testing.T, standIn *orgStandIn) Service {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+	t.Cleanup(server.Close)
+	t.Setenv(EndpointEnv, server.URL)
+	return NewService("ACCESSKEY", "SECRETKEY", "SESSIONTOKEN", Options{})
+}
+
+func TestListAccountsAgainstLocalStandIn(t *testing.T) {
+	standIn := &orgStandIn{accounts: []map[string]string{
+		{"Id": "111111111111", "Name": "Management", "Email": "root@example.com", "Status": "ACTIVE"},
+		{"Id": "222222222222", "Name": "Dev", "Email": "dev@example.com", "Status": "ACTIVE"},
+		{"Id": "333333333333", "Name": "Old", "Email": "old@example.com", "Status": "SUSPENDED"},
+	}}
+	service := newStandInService(t, standIn)
+
+	accounts, err := service.ListAccounts(context.Background())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, accounts, []Account{
+		{ID: "111111111111", Name: "Management", Email: "root@example.com", Status: StatusActive},
+		{ID: "222222222222", Name: "Dev", Email: "dev@example.com", Status: StatusActive},
+		{ID: "333333333333", Name: "Old", Email: "old@example.com", Status: "SUSPENDED"},
+	})
+	assert.Equal(t, len(standIn.requests), 2)
+	assert.Assert(t, standIn.requests[0].Header.Get("X-Amz-Security-Token") == "SESSIONTOKEN")
+}
+
+func TestListAccountsWithoutAccounts(t *testing.T) {
+	service := newStandInService(t, &orgStandIn{})
+	_, err := service.ListAccounts(context.Background())
+	assert.ErrorContains(t, err, "organizations returned no accounts")
+}
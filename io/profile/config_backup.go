@@ -0,0 +1,238 @@
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// ConfigBackupsEnv sets how many backups of ~/.aws/config are kept. 0
+	// turns backups off.
+	ConfigBackupsEnv = "ACTOOL_CONFIG_BACKUPS"
+
+	configBackupDirName      = "config-backups"
+	configBackupPrefix       = "config."
+	configBackupTimeFormat   = "20060102T150405.000Z"
+	defaultConfigBackupLimit = 10
+)
+
+// ConfigBackup is one saved copy of ~/.aws/config, named by the UTC time it
+// was replaced.
+type ConfigBackup struct {
+	Timestamp string
+	Time      time.Time
+	Path      string
+	Size      int64
+}
+
+// configBackupStore keeps the previous contents of ~/.aws/config in the
+// actool config directory before each rewrite.
+type configBackupStore struct {
+	dir   string
+	limit int
+}
+
+func newConfigBackupStore(statePath string) *configBackupStore {
+	return &configBackupStore{dir: filepath.Join(filepath.Dir(statePath), configBackupDirName), limit: configBackupLimit()}
+}
+
+func configBackupLimit() int {
+	if value := strings.TrimSpace(os.Getenv(ConfigBackupsEnv)); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+	}
+	return defaultConfigBackupLimit
+}
+
+// Save writes data as a new backup taken at now and drops the oldest
+// backups beyond the limit.
+func (s *configBackupStore) Save(data []byte, now time.Time) (string, error) {
+	if s.limit == 0 {
+		return "", nil
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+	now = now.UTC()
+	for attempt := 0; attempt < 1000; attempt++ {
+		path := filepath.Join(s.dir, configBackupPrefix+now.Format(configBackupTimeFormat))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			// Two rewrites within the same millisecond.
+			now = now.Add(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return "", err
+		}
+		return path, s.prune()
+	}
+	return "", fmt.Errorf("could not create a unique config backup in %s", s.dir)
+}
+
+func (s *configBackupStore) prune() error {
+	backups, err := s.List()
+	if err != nil {
+		return err
+	}
+	for len(backups) > s.limit {
+		if err := os.Remove(backups[len(backups)-1].Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[:len(backups)-1]
+	}
+	return nil
+}
+
+// List returns the backups, newest first.
+func (s *configBackupStore) List() ([]ConfigBackup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []ConfigBackup
+	for _, entry := range entries {
+		timestamp, ok := strings.CutPrefix(entry.Name(), configBackupPrefix)
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		backupTime, err := time.Parse(configBackupTimeFormat, timestamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, ConfigBackup{
+			Timestamp: timestamp,
+			Time:      backupTime,
+			Path:      filepath.Join(s.dir, entry.Name()),
+			Size:      info.Size(),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+func (s *configBackupStore) Find(timestamp string) (ConfigBackup, error) {
+	backups, err := s.List()
+	if err != nil {
+		return ConfigBackup{}, err
+	}
+	for _, backup := range backups {
+		if backup.Timestamp == timestamp {
+			return backup, nil
+		}
+	}
+	return ConfigBackup{}, fmt.Errorf("no config backup %q; run actool config restore --list", timestamp)
+}
+
+// writeConfig replaces the AWS config with data after backing up what it
+// held, or plans both in a dry run.
+func (p *profile) writeConfig(data []byte) error {
+	if p.plan != nil {
+		if p.backups != nil && p.backups.limit > 0 {
+			if _, err := os.Stat(p.configPath); err == nil {
+				p.plan.note("back up %s to %s", p.configPath, p.backups.dir)
+			}
+		}
+		return p.plan.writeFile(p.configPath, data, false)
+	}
+	if p.backups != nil {
+		current, err := os.ReadFile(p.configPath)
+		switch {
+		case err == nil && !bytes.Equal(current, data):
+			if _, err := p.backups.Save(current, p.now()); err != nil {
+				return fmt.Errorf("back up %s: %w", p.configPath, err)
+			}
+		case err != nil && !os.IsNotExist(err):
+			return err
+		}
+	}
+	// A config linked from a dotfiles repository is replaced where the link
+	// points, keeping the link and the target's mode.
+	target, err := resolveSymlinks(p.configPath)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode()
+	}
+	return writeFileAtomic(target, data, mode)
+}
+
+func (p *profile) ConfigBackups() ([]ConfigBackup, error) {
+	if p.backups == nil {
+		return nil, nil
+	}
+	return p.backups.List()
+}
+
+// RestoreConfigBackup replaces the AWS config with a backup. The current
+// contents are backed up first, so a restore can itself be restored. A backup
+// that would bring back static credentials is refused.
+func (p *profile) RestoreConfigBackup(timestamp string) (*ConfigBackup, error) {
+	if p.backups == nil {
+		return nil, errors.New("config backups are not available")
+	}
+	var restored ConfigBackup
+	err := p.withLock(func() error {
+		backup, err := p.backups.Find(timestamp)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(backup.Path)
+		if err != nil {
+			return err
+		}
+		backupConfig, err := ini.LoadSources(configLoadOptions(), data)
+		if err != nil {
+			return fmt.Errorf("config backup %q is not a valid AWS config: %w", timestamp, err)
+		}
+		current, err := p.loadConfigFile()
+		if err != nil {
+			return err
+		}
+		for _, section := range backupConfig.Sections() {
+			if !hasStaticCredentials(section) {
+				continue
+			}
+			if currentSection, err := current.GetSection(section.Name()); err == nil && hasStaticCredentials(currentSection) {
+				continue
+			}
+			return fmt.Errorf("config backup %q contains static credentials in [%s]; actool did not restore it", timestamp, section.Name())
+		}
+		if err := p.writeConfig(data); err != nil {
+			return err
+		}
+		restored = backup
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
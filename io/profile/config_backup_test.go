@@ -0,0 +1,179 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func newBackupTestProfile(t *testing.T, limit int) *profile {
+	t.Helper()
+	p := newTestProfile(t, newFakeSecretStore())
+	p.backups = &configBackupStore{dir: filepath.Join(filepath.Dir(p.configPath), configBackupDirName), limit: limit}
+	return p
+}
+
+func TestConfigBackupLimit(t *testing.T) {
+	cases := []struct {
+		value string
+		want  int
+	}{
+		{value: "", want: defaultConfigBackupLimit},
+		{value: "3", want: 3},
+		{value: "0", want: 0},
+		{value: "-1", want: defaultConfigBackupLimit},
+		{value: "many", want: defaultConfigBackupLimit},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(ConfigBackupsEnv, tc.value)
+			assert.Equal(t, configBackupLimit(), tc.want)
+		})
+	}
+}
+
+func TestConfigBackupStoreKeepsNewest(t *testing.T) {
+	store := &configBackupStore{dir: filepath.Join(t.TempDir(), configBackupDirName), limit: 3}
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := store.Save([]byte{byte('a' + i)}, start.Add(time.Duration(i)*time.Minute))
+		assert.NilError(t, err)
+	}
+	// Same instant as the last one.
+	path, err := store.Save([]byte("f"), start.Add(4*time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, filepath.Base(path), "config.20260301T090400.001Z")
+
+	backups, err := store.List()
+	assert.NilError(t, err)
+	var timestamps []string
+	for _, backup := range backups {
+		timestamps = append(timestamps, backup.Timestamp)
+	}
+	assert.DeepEqual(t, timestamps, []string{"20260301T090400.001Z", "20260301T090400.000Z", "20260301T090300.000Z"})
+
+	info, err := os.Stat(backups[0].Path)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+	dirInfo, err := os.Stat(store.dir)
+	assert.NilError(t, err)
+	assert.Equal(t, dirInfo.Mode().Perm(), os.FileMode(0o700))
+}
+
+func TestConfigBackupStoreDisabled(t *testing.T) {
+	store := &configBackupStore{dir: filepath.Join(t.TempDir(), configBackupDirName), limit: 0}
+	path, err := store.Save([]byte("x"), time.Now())
+	assert.NilError(t, err)
+	assert.Equal(t, path, "")
+	_, err = os.Stat(store.dir)
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSyncConfigBacksUpPreviousConfig(t *testing.T) {
+	p := newBackupTestProfile(t, 10)
+	original := "[default]\nregion = us-east-1\n"
+	writeTestFile(t, p.configPath, original)
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+
+	assert.NilError(t, p.SetSelected("dev"))
+	// Selecting it again leaves the config as it is, so nothing is backed up.
+	assert.NilError(t, p.SetSelected("dev"))
+
+	backups, err := p.ConfigBackups()
+	assert.NilError(t, err)
+	assert.Equal(t, len(backups), 1)
+	data, err := os.ReadFile(backups[0].Path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), original)
+}
+
+func TestRestoreConfigBackup(t *testing.T) {
+	p := newBackupTestProfile(t, 10)
+	original := "[default]\nregion = us-east-1\n\n[profile hand-tuned]\nregion = eu-west-1\n"
+	writeTestFile(t, p.configPath, original)
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+	rewritten, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	backups, err := p.ConfigBackups()
+	assert.NilError(t, err)
+	assert.Equal(t, len(backups), 1)
+
+	_, err = p.RestoreConfigBackup("20000101T000000.000Z")
+	assert.ErrorContains(t, err, "no config backup")
+
+	restored, err := p.RestoreConfigBackup(backups[0].Timestamp)
+	assert.NilError(t, err)
+	assert.Equal(t, restored.Timestamp, backups[0].Timestamp)
+	data, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), original)
+
+	// The config the restore replaced is itself backed up.
+	backups, err = p.ConfigBackups()
+	assert.NilError(t, err)
+	assert.Equal(t, len(backups), 2)
+	data, err = os.ReadFile(backups[0].Path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), string(rewritten))
+}
+
+func TestRestoreConfigBackupRefusesStaticCredentials(t *testing.T) {
+	cases := []struct {
+		name    string
+		backup  string
+		current string
+		wantErr string
+	}{
+		{
+			name:    "credentials the current config does not have",
+			backup:  "[profile dev]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = SECRET\n",
+			current: "[profile dev]\nregion = us-east-1\n",
+			wantErr: "static credentials in [profile dev]",
+		},
+		{
+			name:    "credentials already in the current config",
+			backup:  "[profile ci]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = SECRET\nregion = us-east-1\n",
+			current: "[profile ci]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = SECRET\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newBackupTestProfile(t, 10)
+			writeTestFile(t, p.configPath, tc.current)
+			path, err := p.backups.Save([]byte(tc.backup), time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+			assert.NilError(t, err)
+
+			_, err = p.RestoreConfigBackup(strings.TrimPrefix(filepath.Base(path), configBackupPrefix))
+			data, readErr := os.ReadFile(p.configPath)
+			assert.NilError(t, readErr)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				assert.Equal(t, string(data), tc.current)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, string(data), tc.backup)
+		})
+	}
+}
+
+func TestDryRunDoesNotBackUpConfig(t *testing.T) {
+	p := newBackupTestProfile(t, 10)
+	writeTestFile(t, p.configPath, "[default]\nregion = us-east-1\n")
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+	plan := startDryRun(t, p)
+
+	assert.NilError(t, p.SetSelected("dev"))
+
+	_, err := os.Stat(p.backups.dir)
+	assert.Assert(t, os.IsNotExist(err))
+	output := planOutput(t, plan)
+	assert.Assert(t, strings.Contains(output, "back up "+p.configPath+" to "+p.backups.dir), output)
+}
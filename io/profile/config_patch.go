@@ -0,0 +1,292 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// maxSymlinks bounds how many links resolveSymlinks follows, as the kernel
+// does, so that a loop is reported instead of followed forever.
+const maxSymlinks = 40
+
+// configLine is one line of the AWS config as it is on disk, with its line
+// ending.
+type configLine struct {
+	text string
+	// key is the name of the key the line sets, or empty for headers,
+	// comments, blank lines and value continuations.
+	key string
+	// header is set on a section header line.
+	header bool
+	// continuation is set on the indented lines of a nested value.
+	continuation bool
+}
+
+// configBlock is a section header with the lines up to the next one. The
+// comments directly above a header belong to its block, so that they go
+// with the section when it is removed. The block before the first header
+// holds the default section and has no header.
+type configBlock struct {
+	section string
+	lines   []configLine
+}
+
+// patchConfig returns original changed to hold cfg. Only the lines of keys
+// whose value changed are rewritten. New keys go after the last key of
+// their section, new sections to the end of the file, and removed keys and
+// sections lose just their own lines. Everything else, comments, blank
+// lines, spacing and order, is kept byte for byte.
+func patchConfig(original []byte, cfg *ini.File) ([]byte, error) {
+	previous, err := ini.LoadSources(configLoadOptions(), original)
+	if err != nil {
+		return nil, err
+	}
+	newline := "\n"
+	if bytes.Contains(original, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	blocks := splitConfigBlocks(original)
+
+	// New keys are added to the last block of a section written more than
+	// once.
+	last := make(map[string]int)
+	for i, block := range blocks {
+		last[block.section] = i
+	}
+
+	var out strings.Builder
+	dropped := false
+	for i, block := range blocks {
+		section, err := cfg.GetSection(block.section)
+		if err != nil {
+			dropped = true
+			continue
+		}
+		dropped = false
+		oldSection, err := previous.GetSection(block.section)
+		if err != nil {
+			return nil, err
+		}
+		insertAt := -1
+		if last[block.section] == i {
+			insertAt = lastKeyLine(block)
+		}
+		if insertAt == -1 && last[block.section] == i && block.section == ini.DefaultSection {
+			writeNewKeys(&out, section, previous, newline)
+		}
+		skipping := false
+		for j, line := range block.lines {
+			switch {
+			case line.continuation && skipping:
+			case line.key != "":
+				skipping = true
+				if !section.HasKey(line.key) {
+					break
+				}
+				value := section.Key(line.key).Value()
+				if oldSection.HasKey(line.key) && oldSection.Key(line.key).Value() == value {
+					skipping = false
+					out.WriteString(line.text)
+					break
+				}
+				out.WriteString(rewriteConfigLine(line.text, value, newline))
+			default:
+				skipping = false
+				out.WriteString(line.text)
+			}
+			if j == insertAt {
+				writeNewKeys(&out, section, previous, newline)
+			}
+		}
+	}
+	data := out.String()
+	if dropped {
+		data = strings.TrimRight(data, "\r\n")
+		if data != "" {
+			data += newline
+		}
+	}
+
+	for _, section := range cfg.Sections() {
+		if _, ok := last[section.Name()]; ok {
+			continue
+		}
+		var lines strings.Builder
+		if section.Name() == ini.DefaultSection {
+			if len(section.Keys()) == 0 {
+				continue
+			}
+			writeNewKeys(&lines, section, previous, newline)
+			if data != "" {
+				lines.WriteString(newline)
+			}
+			data = lines.String() + data
+			continue
+		}
+		if data != "" && !strings.HasSuffix(data, "\n") {
+			data += newline
+		}
+		if data != "" && !endsWithBlankLine(data) {
+			data += newline
+		}
+		fmt.Fprintf(&lines, "[%s]%s", section.Name(), newline)
+		writeNewKeys(&lines, section, previous, newline)
+		data += lines.String()
+	}
+	return []byte(data), nil
+}
+
+func endsWithBlankLine(data string) bool {
+	data = strings.TrimSuffix(strings.TrimSuffix(data, "\n"), "\r")
+	return data == "" || strings.HasSuffix(data, "\n")
+}
+
+// splitConfigBlocks reads original line by line the way ini reads it with
+// configLoadOptions.
+func splitConfigBlocks(original []byte) []configBlock {
+	blocks := []configBlock{{section: ini.DefaultSection}}
+	inValue := false
+	for _, text := range strings.SplitAfter(string(original), "\n") {
+		if text == "" {
+			continue
+		}
+		current := &blocks[len(blocks)-1]
+		if inValue && (text[0] == ' ' || text[0] == '\t' || text[0] == '\f') {
+			current.lines = append(current.lines, configLine{text: text, continuation: true})
+			continue
+		}
+		inValue = false
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+			current.lines = append(current.lines, configLine{text: text})
+		case trimmed[0] == '[':
+			name := trimmed[1:]
+			if end := strings.LastIndexByte(name, ']'); end >= 0 {
+				name = name[:end]
+			}
+			// Comments directly above the header move with it.
+			start := len(current.lines)
+			for start > 0 {
+				previous := current.lines[start-1]
+				if previous.key != "" || previous.header || previous.continuation || isBlankLine(previous.text) {
+					break
+				}
+				start--
+			}
+			block := configBlock{section: name}
+			block.lines = append(block.lines, current.lines[start:]...)
+			block.lines = append(block.lines, configLine{text: text, header: true})
+			current.lines = current.lines[:start]
+			blocks = append(blocks, block)
+		default:
+			key, _, _ := strings.Cut(trimmed, "=")
+			key, _, _ = strings.Cut(key, ":")
+			current.lines = append(current.lines, configLine{text: text, key: strings.TrimSpace(key)})
+			inValue = strings.HasSuffix(text, "\n")
+		}
+	}
+	if len(blocks[0].lines) == 0 {
+		blocks = blocks[1:]
+	}
+	return blocks
+}
+
+func isBlankLine(text string) bool {
+	return strings.TrimSpace(text) == ""
+}
+
+// lastKeyLine returns the index of the line that new keys of the block go
+// after: the last line of its last key, or its header.
+func lastKeyLine(block configBlock) int {
+	index := -1
+	for i, line := range block.lines {
+		if line.key != "" || line.continuation || line.header {
+			index = i
+		}
+	}
+	return index
+}
+
+// writeNewKeys writes the keys of section that previous does not have.
+func writeNewKeys(out *strings.Builder, section *ini.Section, previous *ini.File, newline string) {
+	oldSection, err := previous.GetSection(section.Name())
+	for _, key := range section.Keys() {
+		if err == nil && oldSection.HasKey(key.Name()) {
+			continue
+		}
+		separator := " = "
+		if strings.Contains(key.Value(), "\n") {
+			separator = " ="
+		}
+		out.WriteString(key.Name() + separator + formatConfigValue(key.Value(), newline) + newline)
+	}
+}
+
+// rewriteConfigLine replaces the value of a key line, keeping the
+// indentation and the spacing around the delimiter.
+func rewriteConfigLine(text, value, newline string) string {
+	delimiter := strings.IndexAny(text, "=:")
+	prefix := text[:delimiter+1]
+	rest := text[delimiter+1:]
+	spacing := rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+	switch {
+	case strings.Contains(value, "\n"):
+		spacing = ""
+	case spacing == "" && strings.TrimSpace(rest) == "":
+		// The key held a nested value or nothing.
+		spacing = " "
+	}
+	return prefix + spacing + formatConfigValue(value, newline) + newline
+}
+
+// formatConfigValue quotes value the way ini does, except that nested
+// values are written as indented lines after "key =", as the AWS CLI
+// writes them.
+func formatConfigValue(value, newline string) string {
+	switch {
+	case strings.Contains(value, "\n"):
+		return strings.ReplaceAll(value, "\n", newline)
+	case strings.Contains(value, "`"):
+		value = `"""` + value + `"""`
+	case strings.ContainsAny(value, "#;"):
+		value = "`" + value + "`"
+	case len(strings.TrimSpace(value)) != len(value):
+		value = `"` + value + `"`
+	}
+	return value
+}
+
+// resolveSymlinks follows path while it is a symbolic link, so that a
+// config kept in a dotfiles repository is written where the link points
+// and the link stays in place. A link to a file that does not exist yet
+// resolves to that file.
+func resolveSymlinks(path string) (string, error) {
+	resolved := path
+	for range maxSymlinks {
+		info, err := os.Lstat(resolved)
+		if os.IsNotExist(err) {
+			return resolved, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return resolved, nil
+		}
+		target, err := os.Readlink(resolved)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(resolved), target)
+		}
+		resolved = target
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+}
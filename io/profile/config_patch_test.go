@@ -0,0 +1,155 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/ini.v1"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/golden"
+)
+
+// Run go test ./io/profile -update to rewrite the .golden files after
+// changing patchConfig, and review the diff.
+func TestPatchConfigGolden(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(t *testing.T, cfg *ini.File)
+	}{
+		{
+			name: "comments",
+			edit: func(t *testing.T, cfg *ini.File) {
+				cfg.Section("profile dev").Key(Region).SetValue("eu-central-1")
+				cfg.Section("profile dev").Key(CredentialProcess).SetValue("actool credential-process --profile dev")
+				cfg.Section("default").DeleteKey(Output)
+			},
+		},
+		{
+			name: "remove-section",
+			edit: func(t *testing.T, cfg *ini.File) {
+				cfg.DeleteSection("profile old")
+				cfg.DeleteSection("profile last")
+			},
+		},
+		{
+			name: "new-section",
+			edit: func(t *testing.T, cfg *ini.File) {
+				section, err := cfg.NewSection("profile new")
+				assert.NilError(t, err)
+				_, err = section.NewKey(Region, "us-west-1")
+				assert.NilError(t, err)
+				_, err = section.NewKey(CredentialProcess, "actool credential-process --profile new")
+				assert.NilError(t, err)
+			},
+		},
+		{
+			name: "nested",
+			edit: func(t *testing.T, cfg *ini.File) {
+				cfg.Section("profile dev").Key("s3").SetValue("\n    max_concurrent_requests = 10\n    max_queue_size = 1000")
+				cfg.Section("profile dev").DeleteKey(Output)
+				cfg.Section("profile ci").Key(Region).SetValue("us-east-1")
+			},
+		},
+		{
+			name: "crlf",
+			edit: func(t *testing.T, cfg *ini.File) {
+				cfg.Section("profile dev").Key(Region).SetValue("us-west-2")
+				_, err := cfg.NewSection("profile new")
+				assert.NilError(t, err)
+			},
+		},
+		{
+			name: "empty",
+			edit: func(t *testing.T, cfg *ini.File) {
+				cfg.Section("default").Key(CredentialProcess).SetValue("actool credential-process")
+				cfg.Section("profile dev").Key(Region).SetValue("us-east-1")
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			original, err := os.ReadFile(filepath.Join("testdata", "config-patch", tc.name+".input"))
+			assert.NilError(t, err)
+			cfg, err := ini.LoadSources(configLoadOptions(), original)
+			assert.NilError(t, err)
+
+			// A config that did not change is written back as it was.
+			data, err := patchConfig(original, cfg)
+			assert.NilError(t, err)
+			assert.Equal(t, string(data), string(original))
+
+			tc.edit(t, cfg)
+			data, err = patchConfig(original, cfg)
+			assert.NilError(t, err)
+			golden.AssertBytes(t, data, filepath.Join("config-patch", tc.name+".golden"))
+
+			// The result reads back as the edited config.
+			patched, err := ini.LoadSources(configLoadOptions(), data)
+			assert.NilError(t, err)
+			for _, section := range cfg.Sections() {
+				got, err := patched.GetSection(section.Name())
+				assert.NilError(t, err)
+				assert.DeepEqual(t, got.KeysHash(), section.KeysHash())
+			}
+			assert.Equal(t, len(patched.Sections()), len(cfg.Sections()))
+		})
+	}
+}
+
+func TestSaveConfigFollowsSymlinks(t *testing.T) {
+	cases := []struct {
+		name     string
+		existing bool
+	}{
+		{name: "existing target", existing: true},
+		{name: "dangling link"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProfile(t, newFakeSecretStore())
+			dotfiles := filepath.Join(t.TempDir(), "dotfiles")
+			assert.NilError(t, os.MkdirAll(dotfiles, 0o700))
+			target := filepath.Join(dotfiles, "aws-config")
+			if tc.existing {
+				assert.NilError(t, os.WriteFile(target, []byte("# mine\n[default]\nregion = us-east-1\n"), 0o644))
+			}
+			link, err := filepath.Rel(filepath.Dir(p.configPath), target)
+			assert.NilError(t, err)
+			assert.NilError(t, os.Symlink(link, p.configPath))
+
+			cfg, err := p.loadConfigFile()
+			assert.NilError(t, err)
+			cfg.Section("profile dev").Key(Region).SetValue("eu-west-1")
+			assert.NilError(t, p.saveConfig(cfg))
+
+			info, err := os.Lstat(p.configPath)
+			assert.NilError(t, err)
+			assert.Assert(t, info.Mode()&os.ModeSymlink != 0)
+			data, err := os.ReadFile(target)
+			assert.NilError(t, err)
+			info, err = os.Stat(target)
+			assert.NilError(t, err)
+			if tc.existing {
+				assert.Equal(t, string(data), "# mine\n[default]\nregion = us-east-1\n\n[profile dev]\nregion = eu-west-1\n")
+				assert.Equal(t, info.Mode().Perm(), os.FileMode(0o644))
+			} else {
+				assert.Equal(t, string(data), "[profile dev]\nregion = eu-west-1\n")
+				assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+			}
+		})
+	}
+}
+
+func TestResolveSymlinksReportsLoops(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	assert.NilError(t, os.Symlink(second, first))
+	assert.NilError(t, os.Symlink(first, second))
+
+	_, err := resolveSymlinks(first)
+	assert.ErrorContains(t, err, "too many levels of symbolic links")
+}
@@ -0,0 +1,211 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// DefaultKeysEnv lists the AWS config keys copied from the selected
+	// profile into [default], separated by commas, or "all" for every key
+	// except credential sources.
+	DefaultKeysEnv = "ACTOOL_DEFAULT_KEYS"
+	DefaultKeysAll = "all"
+
+	// CopiedKeys records in [default] which keys actool copied there, so that
+	// they can be removed when the next selected profile does not define
+	// them. Keys the user set in [default] directly are left alone.
+	CopiedKeys = "actool_copied_keys"
+
+	// OriginalKeyPrefix keeps the value a copied key replaced in [default],
+	// as actool_original_<key>, until the key is no longer copied and the
+	// value is put back.
+	OriginalKeyPrefix = "actool_original_"
+
+	actoolConfigKeyPrefix = "actool_"
+)
+
+var (
+	defaultCopiedKeys = []string{Region, Output}
+
+	// credentialSourceKeys make a profile get its credentials from somewhere
+	// other than actool.
+	credentialSourceKeys = []string{
+		RoleARN,
+		SourceProfile,
+		CredentialSource,
+		"web_identity_token_file",
+		"web_identity_token_process",
+		SSOStartURL,
+		SSOSession,
+		SSOAccountID,
+		SSORoleName,
+	}
+
+	// uncopiableKeys are never copied into [default], since they would change
+	// where its credentials come from or belong to the selected profile's own
+	// credential-process call.
+	uncopiableKeys = append(slices.Clone(credentialSourceKeys),
+		CredentialProcess,
+		AWSAccessKeyId,
+		AWSSecretAccessKey,
+		AWSSessionToken,
+		SSORegion,
+		SSORegistrationScopes,
+		ExternalID,
+		RoleSessionName,
+		DurationSeconds,
+		MFASerial,
+	)
+
+	configKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// defaultKeySelection is the parsed ACTOOL_DEFAULT_KEYS.
+type defaultKeySelection struct {
+	all  bool
+	keys []string
+}
+
+func defaultKeysFromEnv() (defaultKeySelection, error) {
+	return parseDefaultKeys(os.Getenv(DefaultKeysEnv))
+}
+
+func parseDefaultKeys(value string) (defaultKeySelection, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultKeySelection{keys: defaultCopiedKeys}, nil
+	}
+	if strings.EqualFold(value, DefaultKeysAll) {
+		return defaultKeySelection{all: true}, nil
+	}
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if !configKeyPattern.MatchString(key) {
+			return defaultKeySelection{}, fmt.Errorf("%s contains an invalid key name: %q", DefaultKeysEnv, key)
+		}
+		if !copiableKey(key) {
+			return defaultKeySelection{}, fmt.Errorf("%s must not contain %s; it is a credential setting", DefaultKeysEnv, key)
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return defaultKeySelection{keys: keys}, nil
+}
+
+func copiableKey(key string) bool {
+	return !slices.Contains(uncopiableKeys, key) && !strings.HasPrefix(key, actoolConfigKeyPrefix)
+}
+
+// keysToCopy returns the selected keys that source defines, in the order
+// they appear in source. Empty values are copied too: cli_pager = turns the
+// pager off.
+func (s defaultKeySelection) keysToCopy(source *ini.Section) []string {
+	var keys []string
+	for _, key := range source.Keys() {
+		name := key.Name()
+		if s.all && copiableKey(name) || !s.all && slices.Contains(s.keys, name) {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// copySelectedProfileConfig copies the configured keys of the selected
+// profile into [default] and removes keys copied for an earlier selection
+// that the selected profile does not define. A value the user had set in
+// [default] is kept under OriginalKeyPrefix while it is replaced.
+func (p *profile) copySelectedProfileConfig(cfg *ini.File, defaultSection *ini.Section, selectedProfile string) (bool, error) {
+	selection, err := defaultKeysFromEnv()
+	if err != nil {
+		return false, err
+	}
+	if selectedProfile == Default {
+		// [default] is the selected profile's own section; what it holds now
+		// is what the user sees for it, so it is left as it is.
+		return false, nil
+	}
+	sourceSection, _ := cfg.GetSection(profileSectionName(selectedProfile))
+
+	changed := false
+	previous := splitKeyList(keyValue(defaultSection, CopiedKeys))
+	var copied []string
+	if sourceSection != nil {
+		copied = selection.keysToCopy(sourceSection)
+		for _, keyName := range copied {
+			original := OriginalKeyPrefix + keyName
+			if !slices.Contains(previous, keyName) && defaultSection.HasKey(keyName) && !defaultSection.HasKey(original) {
+				_, _ = defaultSection.NewKey(original, defaultSection.Key(keyName).String())
+				changed = true
+			}
+			changed = copyKey(defaultSection, keyName, sourceSection.Key(keyName).String()) || changed
+		}
+	}
+
+	for _, keyName := range previous {
+		if slices.Contains(copied, keyName) || !copiableKey(keyName) {
+			continue
+		}
+		changed = restoreDefaultKey(defaultSection, keyName) || changed
+	}
+
+	slices.Sort(copied)
+	if len(copied) == 0 {
+		if defaultSection.HasKey(CopiedKeys) {
+			defaultSection.DeleteKey(CopiedKeys)
+			changed = true
+		}
+		return changed, nil
+	}
+	return ensureKey(defaultSection, CopiedKeys, strings.Join(copied, ",")) || changed, nil
+}
+
+// restoreDefaultKey takes a copied key out of [default], putting back the
+// value it replaced, if any.
+func restoreDefaultKey(defaultSection *ini.Section, keyName string) bool {
+	original := OriginalKeyPrefix + keyName
+	if defaultSection.HasKey(original) {
+		value := defaultSection.Key(original).String()
+		defaultSection.DeleteKey(original)
+		copyKey(defaultSection, keyName, value)
+		return true
+	}
+	if !defaultSection.HasKey(keyName) {
+		return false
+	}
+	defaultSection.DeleteKey(keyName)
+	return true
+}
+
+// copyKey is ensureKey for values that may be empty.
+func copyKey(section *ini.Section, key, value string) bool {
+	if !section.HasKey(key) {
+		_, _ = section.NewKey(key, value)
+		return true
+	}
+	if section.Key(key).String() == value {
+		return false
+	}
+	section.Key(key).SetValue(value)
+	return true
+}
+
+func splitKeyList(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
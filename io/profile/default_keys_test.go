@@ -0,0 +1,208 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseDefaultKeys(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    defaultKeySelection
+		wantErr string
+	}{
+		{value: "", want: defaultKeySelection{keys: []string{Region, Output}}},
+		{value: "all", want: defaultKeySelection{all: true}},
+		{value: " ALL ", want: defaultKeySelection{all: true}},
+		{value: "region, cli_pager,,S3,region", want: defaultKeySelection{keys: []string{"region", "cli_pager", "s3"}}},
+		{value: "region,role_arn", wantErr: "must not contain role_arn"},
+		{value: "credential_process", wantErr: "credential setting"},
+		{value: "actool_mfa_provider", wantErr: "credential setting"},
+		{value: "region;output", wantErr: "invalid key name"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseDefaultKeys(tc.value)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, got.all, tc.want.all)
+			assert.DeepEqual(t, got.keys, tc.want.keys)
+		})
+	}
+}
+
+const defaultKeysTestConfig = `[default]
+cli_history = enabled
+
+[profile wide]
+region = eu-west-1
+output = json
+cli_pager =
+endpoint_url = https://localhost:4566
+retry_mode = adaptive
+max_attempts = 5
+ca_bundle = /etc/ssl/corp.pem
+mfa_serial = arn:aws:iam::123456789012:mfa/user
+actool_mfa_provider = totp
+s3 =
+    max_concurrent_requests = 20
+    addressing_style = path
+
+[profile narrow]
+region = us-east-1
+`
+
+func TestSyncConfigCopiesConfiguredKeysIntoDefault(t *testing.T) {
+	cases := []struct {
+		name        string
+		keys        string
+		selections  []string
+		wantKeys    map[string]string
+		wantMissing []string
+	}{
+		{
+			name:        "region and output by default",
+			selections:  []string{"wide"},
+			wantKeys:    map[string]string{Region: "eu-west-1", Output: "json", CopiedKeys: "output,region", "cli_history": "enabled"},
+			wantMissing: []string{"retry_mode", "s3"},
+		},
+		{
+			name:        "everything except credential sources",
+			keys:        "all",
+			selections:  []string{"wide"},
+			wantKeys:    map[string]string{Region: "eu-west-1", "retry_mode": "adaptive", "max_attempts": "5", "ca_bundle": "/etc/ssl/corp.pem", EndpointURL: "https://localhost:4566", "cli_pager": "", CopiedKeys: "ca_bundle,cli_pager,endpoint_url,max_attempts,output,region,retry_mode,s3"},
+			wantMissing: []string{MFASerial, MFAProvider},
+		},
+		{
+			name:        "keys the next profile lacks are removed",
+			keys:        "all",
+			selections:  []string{"wide", "narrow"},
+			wantKeys:    map[string]string{Region: "us-east-1", CopiedKeys: "region", "cli_history": "enabled"},
+			wantMissing: []string{Output, "cli_pager", "retry_mode", "max_attempts", "ca_bundle", EndpointURL, "s3"},
+		},
+		{
+			name:        "explicit list",
+			keys:        "region,retry_mode,cli_history",
+			selections:  []string{"wide", "narrow"},
+			wantKeys:    map[string]string{Region: "us-east-1", CopiedKeys: "region", "cli_history": "enabled"},
+			wantMissing: []string{"retry_mode", Output},
+		},
+		{
+			name:        "profile without a section",
+			keys:        "all",
+			selections:  []string{"wide", "plain"},
+			wantKeys:    map[string]string{"cli_history": "enabled"},
+			wantMissing: []string{Region, "s3", CopiedKeys},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(DefaultKeysEnv, tc.keys)
+			p := newTestProfile(t, newFakeSecretStore())
+			writeTestFile(t, p.configPath, defaultKeysTestConfig)
+			for _, name := range []string{"wide", "narrow", "plain"} {
+				storeBaseCredential(t, p, name, "ACCESSKEY", "SECRETKEY", nil)
+			}
+			for _, name := range tc.selections {
+				assert.NilError(t, p.SetSelected(name))
+			}
+
+			cfg, err := p.loadConfigFile()
+			assert.NilError(t, err)
+			section := cfg.Section(Default)
+			for key, want := range tc.wantKeys {
+				assert.Assert(t, section.HasKey(key), "[default] is missing %s", key)
+				assert.Equal(t, section.Key(key).String(), want, key)
+			}
+			for _, key := range tc.wantMissing {
+				assert.Assert(t, !section.HasKey(key), "[default] still has %s", key)
+			}
+		})
+	}
+}
+
+func TestSyncConfigRestoresValuesSetInDefault(t *testing.T) {
+	t.Setenv(DefaultKeysEnv, "all")
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, strings.Replace(defaultKeysTestConfig, "cli_history = enabled\n", "cli_history = enabled\nregion = ap-northeast-1\nca_bundle = /etc/ssl/mine.pem\ncli_pager = less\n", 1))
+	for _, name := range []string{"wide", "narrow", "plain"} {
+		storeBaseCredential(t, p, name, "ACCESSKEY", "SECRETKEY", nil)
+	}
+
+	steps := []struct {
+		selected    string
+		wantKeys    map[string]string
+		wantMissing []string
+	}{
+		{
+			selected: "wide",
+			wantKeys: map[string]string{
+				Region: "eu-west-1", "ca_bundle": "/etc/ssl/corp.pem", "cli_pager": "",
+				OriginalKeyPrefix + Region: "ap-northeast-1", OriginalKeyPrefix + "ca_bundle": "/etc/ssl/mine.pem", OriginalKeyPrefix + "cli_pager": "less",
+			},
+			wantMissing: []string{OriginalKeyPrefix + "retry_mode"},
+		},
+		{
+			selected:    "narrow",
+			wantKeys:    map[string]string{Region: "us-east-1", "ca_bundle": "/etc/ssl/mine.pem", "cli_pager": "less", OriginalKeyPrefix + Region: "ap-northeast-1"},
+			wantMissing: []string{"retry_mode", OriginalKeyPrefix + "ca_bundle", OriginalKeyPrefix + "cli_pager"},
+		},
+		{
+			selected: "wide",
+			wantKeys: map[string]string{"ca_bundle": "/etc/ssl/corp.pem", OriginalKeyPrefix + Region: "ap-northeast-1", OriginalKeyPrefix + "ca_bundle": "/etc/ssl/mine.pem"},
+		},
+		{
+			selected:    "plain",
+			wantKeys:    map[string]string{Region: "ap-northeast-1", "ca_bundle": "/etc/ssl/mine.pem", "cli_pager": "less", "cli_history": "enabled"},
+			wantMissing: []string{"retry_mode", CopiedKeys, OriginalKeyPrefix + Region, OriginalKeyPrefix + "ca_bundle", OriginalKeyPrefix + "cli_pager"},
+		},
+	}
+	for _, step := range steps {
+		assert.NilError(t, p.SetSelected(step.selected))
+		section := loadTestConfig(t, p).Section(Default)
+		for key, want := range step.wantKeys {
+			assert.Assert(t, section.HasKey(key), "after %s, [default] is missing %s", step.selected, key)
+			assert.Equal(t, section.Key(key).String(), want, "after %s: %s", step.selected, key)
+		}
+		for _, key := range step.wantMissing {
+			assert.Assert(t, !section.HasKey(key), "after %s, [default] still has %s", step.selected, key)
+		}
+	}
+}
+
+func TestSyncConfigWritesNestedSettings(t *testing.T) {
+	t.Setenv(DefaultKeysEnv, "all")
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, defaultKeysTestConfig)
+	storeBaseCredential(t, p, "wide", "ACCESSKEY", "SECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("wide"))
+
+	data, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), `"""`), string(data))
+	assert.Equal(t, strings.Count(string(data), "\n    max_concurrent_requests = 20\n    addressing_style = path\n"), 2, string(data))
+
+	// AWS CLI reads the nested block as sub-settings of s3.
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowPythonMultilineValues: true}, filepath.Clean(p.configPath))
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Section(Default).Key("s3").String(), cfg.Section("profile wide").Key("s3").String())
+	assert.Assert(t, !cfg.Section(Default).HasKey("addressing_style"))
+}
+
+func TestSyncConfigRejectsInvalidDefaultKeys(t *testing.T) {
+	t.Setenv(DefaultKeysEnv, "source_profile")
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+
+	assert.ErrorContains(t, p.SetSelected("dev"), DefaultKeysEnv)
+}
@@ -0,0 +1,232 @@
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DirectoryProfileFile and AWSProfileFile name the profile to use in a
+	// directory and below it. DirectoryProfileFile wins when both exist.
+	DirectoryProfileFile = ".actool-profile"
+	AWSProfileFile       = ".aws-profile"
+
+	// ProfileAuto makes credential-process use the profile named by the
+	// trusted profile file of its working directory.
+	ProfileAuto = "auto"
+
+	trustFileName = "trusted-directories.json"
+	trustVersion  = 1
+)
+
+// DirectoryProfile is a profile file found above a directory.
+type DirectoryProfile struct {
+	// Path is the profile file, with symbolic links resolved.
+	Path    string
+	Profile string
+	// Trusted reports whether the file was trusted with its current
+	// contents.
+	Trusted bool
+
+	hash string
+}
+
+// DirectoryTrust finds profile files and keeps the list of trusted ones. A
+// file is trusted together with its contents, so editing it requires
+// trusting it again, as with direnv allow.
+type DirectoryTrust interface {
+	// Lookup walks up from dir to the nearest profile file. It returns nil
+	// when there is none.
+	Lookup(dir string) (*DirectoryProfile, error)
+	Trust(dir string) (*DirectoryProfile, error)
+	Untrust(dir string) (*DirectoryProfile, error)
+}
+
+type directoryTrust struct {
+	path string
+	plan *Plan
+}
+
+type trustFile struct {
+	Version int
+	// Files maps a profile file to the SHA-256 of the contents it was
+	// trusted with.
+	Files map[string]string
+}
+
+// NewDirectoryTrust opens the trust store in the actool state directory.
+// With a plan, changes are recorded there instead.
+func NewDirectoryTrust(plan *Plan) DirectoryTrust {
+	return newDirectoryTrust(defaultStatePath(), plan)
+}
+
+func newDirectoryTrust(statePath string, plan *Plan) *directoryTrust {
+	return &directoryTrust{path: filepath.Join(filepath.Dir(statePath), trustFileName), plan: plan}
+}
+
+func (d *directoryTrust) Lookup(dir string) (*DirectoryProfile, error) {
+	found, err := findDirectoryProfile(dir)
+	if err != nil || found == nil {
+		return found, err
+	}
+	trusted, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	found.Trusted = trusted.Files[found.Path] == found.hash
+	return found, nil
+}
+
+func (d *directoryTrust) Trust(dir string) (*DirectoryProfile, error) {
+	return d.update(dir, func(trusted *trustFile, found *DirectoryProfile) {
+		trusted.Files[found.Path] = found.hash
+		found.Trusted = true
+	})
+}
+
+func (d *directoryTrust) Untrust(dir string) (*DirectoryProfile, error) {
+	return d.update(dir, func(trusted *trustFile, found *DirectoryProfile) {
+		delete(trusted.Files, found.Path)
+		found.Trusted = false
+	})
+}
+
+func (d *directoryTrust) update(dir string, change func(trusted *trustFile, found *DirectoryProfile)) (*DirectoryProfile, error) {
+	found, err := findDirectoryProfile(dir)
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no %s or %s found in %s or above it", DirectoryProfileFile, AWSProfileFile, dir)
+	}
+	trusted, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	change(&trusted, found)
+	data, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if d.plan != nil {
+		return found, d.plan.writeFile(d.path, data, false)
+	}
+	return found, writeFileAtomic(d.path, data, 0o600)
+}
+
+func (d *directoryTrust) load() (trustFile, error) {
+	trusted := trustFile{Version: trustVersion, Files: make(map[string]string)}
+	var data []byte
+	var err error
+	if d.plan != nil {
+		data, err = d.plan.readFile(d.path)
+	} else {
+		data, err = os.ReadFile(d.path)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return trusted, nil
+		}
+		return trustFile{}, err
+	}
+	if err := json.Unmarshal(data, &trusted); err != nil {
+		return trustFile{}, fmt.Errorf("read %s: %w", d.path, err)
+	}
+	if trusted.Version > trustVersion {
+		return trustFile{}, fmt.Errorf("%s was written by a newer actool (version %d)", d.path, trusted.Version)
+	}
+	if trusted.Files == nil {
+		trusted.Files = make(map[string]string)
+	}
+	return trusted, nil
+}
+
+// findDirectoryProfile walks up from dir to the nearest directory with a
+// profile file.
+func findDirectoryProfile(dir string) (*DirectoryProfile, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for _, name := range []string{DirectoryProfileFile, AWSProfileFile} {
+			path := filepath.Join(dir, name)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			return parseDirectoryProfile(path, data)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// parseDirectoryProfile reads the first line that is neither blank nor a
+// # comment as the profile name.
+func parseDirectoryProfile(path string, data []byte) (*DirectoryProfile, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	profileName := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			profileName = line
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if profileName == ProfileAuto {
+		return nil, fmt.Errorf("%s must name a profile, not %q", path, ProfileAuto)
+	}
+	if err := validateProfileName(profileName); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &DirectoryProfile{
+		Path:    resolved,
+		Profile: profileName,
+		hash:    fmt.Sprintf("%x", sha256.Sum256(data)),
+	}, nil
+}
+
+// autoProfile resolves --profile auto from the working directory. Without
+// a profile file it falls back to the selected profile.
+func (p *profile) autoProfile() (string, error) {
+	if p.directories == nil {
+		return "", errors.New("directory profiles are not available")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	found, err := p.directories.Lookup(dir)
+	if err != nil {
+		return "", err
+	}
+	if found == nil {
+		return "", nil
+	}
+	if !found.Trusted {
+		return "", fmt.Errorf("%s is not trusted; review it and run actool dir trust %s", found.Path, filepath.Dir(found.Path))
+	}
+	return found.Profile, nil
+}
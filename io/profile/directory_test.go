@@ -0,0 +1,173 @@
+package profile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFindDirectoryProfile(t *testing.T) {
+	cases := []struct {
+		name        string
+		files       map[string]string
+		start       string
+		wantPath    string
+		wantProfile string
+		wantErr     string
+	}{
+		{
+			name:        "walks up to the nearest file",
+			files:       map[string]string{"repo/.actool-profile": "dev\n", ".actool-profile": "prod\n"},
+			start:       "repo/src/pkg",
+			wantPath:    "repo/.actool-profile",
+			wantProfile: "dev",
+		},
+		{
+			name:        "aws-profile file",
+			files:       map[string]string{"repo/.aws-profile": "  AWS Account Dev  \n"},
+			start:       "repo",
+			wantPath:    "repo/.aws-profile",
+			wantProfile: "AWS Account Dev",
+		},
+		{
+			name:        "actool-profile wins in the same directory",
+			files:       map[string]string{"repo/.actool-profile": "dev", "repo/.aws-profile": "prod"},
+			start:       "repo",
+			wantPath:    "repo/.actool-profile",
+			wantProfile: "dev",
+		},
+		{
+			name:        "comments and blank lines",
+			files:       map[string]string{"repo/.actool-profile": "# staging account\n\nstaging\nignored\n"},
+			start:       "repo",
+			wantPath:    "repo/.actool-profile",
+			wantProfile: "staging",
+		},
+		{name: "none", start: "repo"},
+		{name: "empty file", files: map[string]string{"repo/.actool-profile": "# nothing\n"}, start: "repo", wantErr: "profile name is empty"},
+		{name: "auto", files: map[string]string{"repo/.actool-profile": "auto\n"}, start: "repo", wantErr: "must name a profile"},
+		{name: "section delimiter", files: map[string]string{"repo/.actool-profile": "[dev]\n"}, start: "repo", wantErr: "INI section delimiter"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+			assert.NilError(t, err)
+			assert.NilError(t, os.MkdirAll(filepath.Join(root, tc.start), 0o700))
+			for name, contents := range tc.files {
+				assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o700))
+				writeTestFile(t, filepath.Join(root, name), contents)
+			}
+
+			found, err := findDirectoryProfile(filepath.Join(root, tc.start))
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			if tc.wantPath == "" {
+				assert.Assert(t, found == nil)
+				return
+			}
+			assert.Equal(t, found.Path, filepath.Join(root, tc.wantPath))
+			assert.Equal(t, found.Profile, tc.wantProfile)
+		})
+	}
+}
+
+func TestDirectoryTrust(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	repo := filepath.Join(root, "repo")
+	assert.NilError(t, os.MkdirAll(filepath.Join(repo, "src"), 0o700))
+	profileFile := filepath.Join(repo, DirectoryProfileFile)
+	writeTestFile(t, profileFile, "dev\n")
+	trust := newDirectoryTrust(filepath.Join(root, "actool", "state.json"), nil)
+
+	found, err := trust.Lookup(filepath.Join(repo, "src"))
+	assert.NilError(t, err)
+	assert.Assert(t, !found.Trusted)
+
+	found, err = trust.Trust(filepath.Join(repo, "src"))
+	assert.NilError(t, err)
+	assert.Equal(t, found.Path, profileFile)
+	assert.Assert(t, found.Trusted)
+	info, err := os.Stat(trust.path)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	found, err = trust.Lookup(repo)
+	assert.NilError(t, err)
+	assert.Assert(t, found.Trusted)
+
+	// Changing the file revokes the trust.
+	writeTestFile(t, profileFile, "prod\n")
+	found, err = trust.Lookup(repo)
+	assert.NilError(t, err)
+	assert.Equal(t, found.Profile, "prod")
+	assert.Assert(t, !found.Trusted)
+
+	_, err = trust.Trust(repo)
+	assert.NilError(t, err)
+	found, err = trust.Untrust(repo)
+	assert.NilError(t, err)
+	assert.Assert(t, !found.Trusted)
+	found, err = trust.Lookup(repo)
+	assert.NilError(t, err)
+	assert.Assert(t, !found.Trusted)
+
+	_, err = trust.Trust(root)
+	assert.ErrorContains(t, err, "no .actool-profile or .aws-profile found")
+}
+
+func TestCredentialProcessAutoProfile(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "PRODACCESSKEY", "PRODSECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	trust := newDirectoryTrust(filepath.Join(root, "actool", "state.json"), nil)
+	p.directories = trust
+	repo := filepath.Join(root, "repo")
+	assert.NilError(t, os.MkdirAll(repo, 0o700))
+	writeTestFile(t, filepath.Join(repo, AWSProfileFile), "prod\n")
+
+	// Outside any profile directory the selected profile is used.
+	t.Chdir(root)
+	payload, err := p.CredentialProcessPayload(context.Background(), ProfileAuto)
+	assert.NilError(t, err)
+	assert.Equal(t, credentialProcessJSON(t, payload)["AccessKeyId"], "DEVACCESSKEY")
+
+	t.Chdir(repo)
+	_, err = p.CredentialProcessPayload(context.Background(), ProfileAuto)
+	assert.ErrorContains(t, err, "is not trusted")
+
+	_, err = trust.Trust(repo)
+	assert.NilError(t, err)
+	payload, err = p.CredentialProcessPayload(context.Background(), ProfileAuto)
+	assert.NilError(t, err)
+	assert.Equal(t, credentialProcessJSON(t, payload)["AccessKeyId"], "PRODACCESSKEY")
+}
+
+func TestDryRunDirectoryTrustWritesNothing(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	writeTestFile(t, filepath.Join(root, DirectoryProfileFile), "dev\n")
+	plan := NewPlan()
+	trust := newDirectoryTrust(filepath.Join(root, "actool", "state.json"), plan)
+
+	found, err := trust.Trust(root)
+	assert.NilError(t, err)
+	assert.Assert(t, found.Trusted)
+	found, err = trust.Lookup(root)
+	assert.NilError(t, err)
+	assert.Assert(t, found.Trusted)
+
+	_, err = os.Stat(trust.path)
+	assert.Assert(t, os.IsNotExist(err))
+	assert.Assert(t, !plan.Empty())
+}
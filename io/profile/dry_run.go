@@ -0,0 +1,400 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/textdiff"
+)
+
+// Plan collects what a dry run would change. A profile opened with
+// NewDryRunProfile reads the real files and keyring but sends every write
+// here, so later steps of the same command see the planned state.
+type Plan struct {
+	mu      sync.Mutex
+	secrets []plannedSecret
+	files   []*plannedFile
+	notes   []string
+}
+
+type plannedSecret struct {
+	store  string
+	key    string
+	kind   string
+	remove bool
+}
+
+// plannedFile tracks one file from its contents on disk to its planned
+// contents. Files marked redact may hold secrets and are never diffed.
+type plannedFile struct {
+	path      string
+	original  []byte
+	existed   bool
+	content   []byte
+	exists    bool
+	renamedTo string
+	redact    bool
+}
+
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Empty reports whether the dry run found nothing to change.
+func (p *Plan) Empty() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.secrets) > 0 || len(p.notes) > 0 {
+		return false
+	}
+	for _, file := range p.files {
+		if file.changed() {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo prints the keyring changes without their values, the file
+// changes, and a unified diff of each changed text file that is not
+// redacted.
+func (p *Plan) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b bytes.Buffer
+	b.WriteString("Dry run: nothing was written.\n")
+	if len(p.secrets) > 0 {
+		b.WriteString("\nKeyring changes (values not shown):\n")
+		for _, secret := range p.secrets {
+			action := "set"
+			if secret.remove {
+				action = "remove"
+			}
+			fmt.Fprintf(&b, "  %-7s %s: %s (%s)\n", action, secret.store, secret.key, secret.kind)
+		}
+	}
+
+	var diffs []string
+	var fileLines []string
+	for _, file := range p.files {
+		if !file.changed() {
+			continue
+		}
+		switch {
+		case file.renamedTo != "":
+			fileLines = append(fileLines, fmt.Sprintf("  rename  %s -> %s", file.path, file.renamedTo))
+		case !file.exists:
+			fileLines = append(fileLines, fmt.Sprintf("  delete  %s", file.path))
+		case !file.existed:
+			fileLines = append(fileLines, fmt.Sprintf("  create  %s", file.path))
+		default:
+			fileLines = append(fileLines, fmt.Sprintf("  write   %s", file.path))
+		}
+		if file.redact || file.renamedTo != "" {
+			continue
+		}
+		oldName, newName := file.path, file.path+" (dry run)"
+		if !file.existed {
+			oldName = "/dev/null"
+		}
+		diffs = append(diffs, textdiff.Unified(oldName, newName, string(file.original), string(file.content)))
+	}
+	if len(fileLines) > 0 {
+		b.WriteString("\nFile changes:\n")
+		b.WriteString(strings.Join(fileLines, "\n"))
+		b.WriteString("\n")
+	}
+	if len(p.notes) > 0 {
+		b.WriteString("\nOther changes:\n")
+		for _, note := range p.notes {
+			fmt.Fprintf(&b, "  %s\n", note)
+		}
+	}
+	if len(p.secrets) == 0 && len(fileLines) == 0 && len(p.notes) == 0 {
+		b.WriteString("No changes.\n")
+	}
+	for _, diff := range diffs {
+		b.WriteString("\n")
+		b.WriteString(diff)
+	}
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+func (f *plannedFile) changed() bool {
+	return f.renamedTo != "" || f.existed != f.exists || !bytes.Equal(f.original, f.content)
+}
+
+// file returns the tracked file for path, reading its current contents on
+// first use. The caller holds p.mu.
+func (p *Plan) file(path string) (*plannedFile, error) {
+	for _, file := range p.files {
+		if file.path == path {
+			return file, nil
+		}
+	}
+	file := &plannedFile{path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		file.original, file.content = data, data
+		file.existed, file.exists = true, true
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	p.files = append(p.files, file)
+	return file, nil
+}
+
+func (p *Plan) readFile(path string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, err := p.file(path)
+	if err != nil {
+		return nil, err
+	}
+	if !file.exists {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), file.content...), nil
+}
+
+func (p *Plan) writeFile(path string, data []byte, redact bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, err := p.file(path)
+	if err != nil {
+		return err
+	}
+	file.content = append([]byte(nil), data...)
+	file.exists = true
+	file.redact = file.redact || redact
+	return nil
+}
+
+func (p *Plan) removeFile(path string, redact bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, err := p.file(path)
+	if err != nil {
+		return err
+	}
+	file.content = nil
+	file.exists = false
+	file.redact = file.redact || redact
+	return nil
+}
+
+func (p *Plan) renameFile(path, newPath string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, err := p.file(path)
+	if err != nil {
+		return err
+	}
+	if !file.exists {
+		return &os.PathError{Op: "rename", Path: path, Err: os.ErrNotExist}
+	}
+	file.renamedTo = newPath
+	file.exists = false
+	file.redact = true
+	return nil
+}
+
+func (p *Plan) recordSecret(store, key, kind string, remove bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.secrets = slices.DeleteFunc(p.secrets, func(s plannedSecret) bool {
+		return s.store == store && s.key == key
+	})
+	p.secrets = append(p.secrets, plannedSecret{store: store, key: key, kind: kind, remove: remove})
+}
+
+func (p *Plan) note(format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	note := fmt.Sprintf(format, args...)
+	if !slices.Contains(p.notes, note) {
+		p.notes = append(p.notes, note)
+	}
+}
+
+// dryRunStore overlays planned keyring changes on a real store.
+type dryRunStore struct {
+	store   secretStore
+	plan    *Plan
+	name    string
+	values  map[string][]byte
+	removed map[string]bool
+}
+
+func newDryRunStore(store secretStore, plan *Plan, name string) *dryRunStore {
+	return &dryRunStore{store: store, plan: plan, name: name, values: make(map[string][]byte), removed: make(map[string]bool)}
+}
+
+func (d *dryRunStore) Get(key string) ([]byte, error) {
+	if d.removed[key] {
+		return nil, errSecretNotFound
+	}
+	if value, ok := d.values[key]; ok {
+		return append([]byte(nil), value...), nil
+	}
+	return d.store.Get(key)
+}
+
+func (d *dryRunStore) Set(key string, value []byte) error {
+	d.values[key] = append([]byte(nil), value...)
+	delete(d.removed, key)
+	d.plan.recordSecret(d.name, key, classifyKey(key, value, time.Now()).Kind, false)
+	return nil
+}
+
+func (d *dryRunStore) Remove(key string) error {
+	if _, err := d.Get(key); err != nil {
+		return err
+	}
+	delete(d.values, key)
+	d.removed[key] = true
+	kind := "item"
+	if entry, ok := classifyKeyName(key); ok {
+		kind = entry.Kind
+	}
+	d.plan.recordSecret(d.name, key, kind, true)
+	return nil
+}
+
+func (d *dryRunStore) Keys() ([]string, error) {
+	keys, err := d.store.Keys()
+	if err != nil && !errors.Is(err, errSecretNotFound) {
+		return nil, err
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return d.removed[key] })
+	for key := range d.values {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// dryRunStateStore keeps the planned state in memory and shows it as a
+// change of state.json.
+type dryRunStateStore struct {
+	store stateStore
+	plan  *Plan
+	path  string
+	state *profileState
+}
+
+func (d *dryRunStateStore) Load() (profileState, error) {
+	if d.state != nil {
+		return *d.state, nil
+	}
+	return d.store.Load()
+}
+
+func (d *dryRunStateStore) Save(state profileState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := d.plan.writeFile(d.path, append(data, '\n'), false); err != nil {
+		return err
+	}
+	d.state = &state
+	return nil
+}
+
+// dryRunHistoryStore keeps planned history entries in memory.
+type dryRunHistoryStore struct {
+	store   historyStore
+	plan    *Plan
+	pending []HistoryEntry
+}
+
+func (d *dryRunHistoryStore) Append(entry HistoryEntry) error {
+	d.pending = append(d.pending, entry)
+	from := entry.From
+	if from == "" {
+		from = "(none)"
+	}
+	d.plan.note("record %s in history: %s -> %s", entry.Action, from, entry.To)
+	return nil
+}
+
+func (d *dryRunHistoryStore) Entries() ([]HistoryEntry, error) {
+	entries, err := d.store.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, d.pending...), nil
+}
+
+// enableDryRun routes every write of p into plan. statePath names the
+// state file in the plan's output.
+func (p *profile) enableDryRun(plan *Plan, statePath string) {
+	secrets := p.secrets
+	p.plan = plan
+	p.secrets = newDryRunStore(secrets, plan, "keyring")
+	p.state = &dryRunStateStore{store: p.state, plan: plan, path: statePath}
+	p.history = &dryRunHistoryStore{store: p.history, plan: plan}
+	p.lock = noopLock{}
+	openLegacy := p.legacyStoreFactory
+	if openLegacy == nil {
+		return
+	}
+	p.legacyStoreFactory = func() (secretStore, error) {
+		store, err := openLegacy()
+		if err != nil {
+			return nil, err
+		}
+		if store == secrets {
+			return p.secrets, nil
+		}
+		return newDryRunStore(store, plan, "legacy actool keyring"), nil
+	}
+}
+
+// readFile reads path as the command would see it, including planned
+// changes in a dry run.
+func (p *profile) readFile(path string) ([]byte, error) {
+	if p.plan != nil {
+		return p.plan.readFile(path)
+	}
+	return os.ReadFile(path)
+}
+
+// writeSecretFile creates path with mode 0600, or plans it in a dry run
+// without showing its contents.
+func (p *profile) writeSecretFile(path string, data []byte) error {
+	if p.plan != nil {
+		return p.plan.writeFile(path, data, true)
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+// removeFile deletes path, or plans it in a dry run. Removed files are
+// never shown in a diff, since they can be plaintext credentials.
+func (p *profile) removeFile(path string) error {
+	if p.plan != nil {
+		if _, err := p.plan.readFile(path); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return p.plan.removeFile(path, true)
+	}
+	return removeIfExists(path)
+}
@@ -0,0 +1,137 @@
+package profile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// startDryRun switches p to a dry run; anything set up before is real.
+func startDryRun(t *testing.T, p *profile) *Plan {
+	t.Helper()
+	plan := NewPlan()
+	p.enableDryRun(plan, filepath.Join(filepath.Dir(p.configPath), "state.json"))
+	return plan
+}
+
+func planOutput(t *testing.T, plan *Plan) string {
+	t.Helper()
+	var b bytes.Buffer
+	_, err := plan.WriteTo(&b)
+	assert.NilError(t, err)
+	return b.String()
+}
+
+func TestDryRunLoadImportsNothing(t *testing.T) {
+	store := newFakeSecretStore()
+	dir := t.TempDir()
+	p := newProfile(filepath.Join(dir, "config"), filepath.Join(dir, "credentials"), "actool", store, func(string) (bool, error) {
+		return false, nil
+	})
+	writeTestFile(t, p.configPath, "[default]\nregion = us-east-1\n")
+	writeTestFile(t, p.legacyCredentialsPath, `[dev]
+aws_access_key_id = DEVACCESSKEY
+aws_secret_access_key = DEVSECRETKEY
+`)
+	plan := startDryRun(t, p)
+
+	model, err := p.Load()
+	assert.NilError(t, err)
+	// Later steps of the same command see the planned import.
+	assert.DeepEqual(t, credentialNames(model.Credentials), []string{"dev"})
+
+	assert.Equal(t, len(store.values), 0)
+	config, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(config), "[default]\nregion = us-east-1\n")
+	_, err = os.Stat(p.legacyCredentialsPath)
+	assert.NilError(t, err)
+	_, err = os.Stat(p.legacyCredentialsPath + ".actool-backup")
+	assert.Assert(t, os.IsNotExist(err))
+
+	output := planOutput(t, plan)
+	assert.Assert(t, strings.Contains(output, "set     keyring: dev (credential)"), output)
+	assert.Assert(t, strings.Contains(output, "rename  "+p.legacyCredentialsPath+" -> "+p.legacyCredentialsPath+".actool-backup"), output)
+	assert.Assert(t, strings.Contains(output, "+++ "+p.configPath+" (dry run)"), output)
+	assert.Assert(t, strings.Contains(output, "+credential_process = actool credential-process --profile dev"), output)
+	for _, secret := range []string{"DEVACCESSKEY", "DEVSECRETKEY"} {
+		assert.Assert(t, !strings.Contains(output, secret), output)
+	}
+}
+
+func TestDryRunSetSelectedDiffsConfig(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	original := "[default]\nregion = us-east-1\n\n[profile dev]\nregion = eu-west-1\n"
+	writeTestFile(t, p.configPath, original)
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+	plan := startDryRun(t, p)
+
+	assert.NilError(t, p.SetSelected("dev"))
+
+	config, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(config), original)
+	state, err := p.loadState()
+	assert.NilError(t, err)
+	assert.Equal(t, state.SelectedProfile, "dev")
+
+	output := planOutput(t, plan)
+	assert.Assert(t, strings.Contains(output, "\n-region = us-east-1\n"), output)
+	assert.Assert(t, strings.Contains(output, "\n+actool_copied_keys = region\n"), output)
+	assert.Assert(t, strings.Contains(output, "record select in history: (none) -> dev"), output)
+	assert.Assert(t, !strings.Contains(output, "Keyring changes"), output)
+}
+
+func TestDryRunMFASeedIsRedacted(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+	plan := startDryRun(t, p)
+
+	assert.NilError(t, p.SetMFASeed("dev", "JBSWY3DPEHPK3PXP"))
+	_, err := store.Get(secretKey(mfaSeedKeyPrefix, "dev"))
+	assert.ErrorIs(t, err, errSecretNotFound)
+
+	output := planOutput(t, plan)
+	assert.Assert(t, strings.Contains(output, "keyring: "+secretKey(mfaSeedKeyPrefix, "dev")), output)
+	assert.Assert(t, !strings.Contains(output, "JBSWY3DPEHPK3PXP"), output)
+}
+
+func TestDryRunStoreOverlaysPlannedChanges(t *testing.T) {
+	store := newFakeSecretStore()
+	assert.NilError(t, store.Set("kept", []byte("1")))
+	assert.NilError(t, store.Set("removed", []byte("2")))
+	plan := NewPlan()
+	overlay := newDryRunStore(store, plan, "keyring")
+
+	assert.NilError(t, overlay.Set("added", []byte("3")))
+	assert.NilError(t, overlay.Remove("removed"))
+	assert.ErrorIs(t, overlay.Remove("missing"), errSecretNotFound)
+
+	keys, err := overlay.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"added", "kept"})
+	value, err := overlay.Get("added")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "3")
+	_, err = overlay.Get("removed")
+	assert.ErrorIs(t, err, errSecretNotFound)
+
+	storeKeys, err := store.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, storeKeys, []string{"kept", "removed"})
+	assert.Assert(t, !plan.Empty())
+}
+
+func TestPlanWithoutChanges(t *testing.T) {
+	plan := NewPlan()
+	_, err := plan.readFile(filepath.Join(t.TempDir(), "config"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.Assert(t, plan.Empty())
+	assert.Equal(t, planOutput(t, plan), "Dry run: nothing was written.\nNo changes.\n")
+}
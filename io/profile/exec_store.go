@@ -0,0 +1,78 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/secretexec"
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+// ExecBackendCommandEnv names the command used by AWS_VAULT_BACKEND=exec.
+const ExecBackendCommandEnv = "ACTOOL_SECRET_BACKEND_COMMAND"
+
+// execStore delegates to an external program speaking the secretexec
+// protocol, for secrets kept in a password manager such as 1Password. Each
+// call is bounded like an STS request, so that a password manager waiting
+// for an unlock does not hang credential-process.
+type execStore struct {
+	client  *secretexec.Client
+	timeout time.Duration
+}
+
+func openExecStore() (secretStore, error) {
+	client, err := secretexec.NewClient(os.Getenv(ExecBackendCommandEnv))
+	if err != nil {
+		return nil, fmt.Errorf("AWS_VAULT_BACKEND=%s requires %s: %w", execBackend, ExecBackendCommandEnv, err)
+	}
+	return &execStore{client: client, timeout: execTimeout()}, nil
+}
+
+func execTimeout() time.Duration {
+	if value := strings.TrimSpace(os.Getenv(sts.TimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return sts.DefaultTimeout
+}
+
+func (e *execStore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), e.timeout)
+}
+
+func (e *execStore) Get(key string) ([]byte, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+	value, err := e.client.Get(ctx, key)
+	if errors.Is(err, secretexec.ErrNotFound) {
+		return nil, errSecretNotFound
+	}
+	return value, err
+}
+
+func (e *execStore) Set(key string, value []byte) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	return e.client.Set(ctx, key, value)
+}
+
+func (e *execStore) Remove(key string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	err := e.client.Remove(ctx, key)
+	if errors.Is(err, secretexec.ErrNotFound) {
+		return errSecretNotFound
+	}
+	return err
+}
+
+func (e *execStore) Keys() ([]string, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+	return e.client.Keys(ctx)
+}
@@ -0,0 +1,93 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+func setupExecBackend(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in scripts use /bin/sh")
+	}
+	script, err := filepath.Abs(filepath.Join("..", "secretexec", "testdata", "stand-in.sh"))
+	assert.NilError(t, err)
+	dir := t.TempDir()
+	t.Setenv("SECRET_STORE_DIR", dir)
+	t.Setenv("AWS_VAULT_BACKEND", execBackend)
+	t.Setenv(ExecBackendCommandEnv, script)
+	return dir
+}
+
+func TestExecBackendRoundTripsCredential(t *testing.T) {
+	setupExecBackend(t)
+
+	store, err := openAWSVaultStore()
+	assert.NilError(t, err)
+	_, ok := store.(*execStore)
+	assert.Assert(t, ok)
+
+	_, err = store.Get("dev")
+	assert.Assert(t, errors.Is(err, errSecretNotFound), "got %v", err)
+	assert.Assert(t, errors.Is(store.Remove("dev"), errSecretNotFound))
+
+	assert.NilError(t, store.Set("dev", []byte(`{"AccessKeyID":"ACCESSKEY","SecretAccessKey":"SECRETKEY"}`)))
+	value, err := store.Get("dev")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, credentialProcessJSON(t, value), map[string]interface{}{
+		"AccessKeyID":     "ACCESSKEY",
+		"SecretAccessKey": "SECRETKEY",
+	})
+
+	keys, err := store.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev"})
+}
+
+func TestExecBackendProfileListsStoredCredentials(t *testing.T) {
+	setupExecBackend(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"))
+
+	store, err := openAWSVaultStore()
+	assert.NilError(t, err)
+	assert.NilError(t, store.Set("dev", []byte(`{"AccessKeyID":"ACCESSKEY","SecretAccessKey":"SECRETKEY"}`)))
+
+	p, err := NewProfile()
+	assert.NilError(t, err)
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, len(model.Credentials), 1)
+	assert.Equal(t, model.Credentials[0].AccessKey, "ACCESSKEY")
+}
+
+func TestExecBackendTimesOut(t *testing.T) {
+	setupExecBackend(t)
+	t.Setenv(ExecBackendCommandEnv, "exec sleep 10")
+	t.Setenv(sts.TimeoutEnv, "100ms")
+
+	store, err := openAWSVaultStore()
+	assert.NilError(t, err)
+	start := time.Now()
+	_, err = store.Get("dev")
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.Assert(t, time.Since(start) < 5*time.Second, "took %s", time.Since(start))
+}
+
+func TestExecBackendRequiresCommand(t *testing.T) {
+	t.Setenv("AWS_VAULT_BACKEND", execBackend)
+	t.Setenv(ExecBackendCommandEnv, "")
+
+	_, err := openAWSVaultStore()
+	assert.ErrorContains(t, err, "AWS_VAULT_BACKEND=exec requires "+ExecBackendCommandEnv)
+}
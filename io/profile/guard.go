@@ -0,0 +1,167 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// Environment in a profile section names the environment the profile
+	// belongs to. EnvironmentProduction marks it as production.
+	Environment           = "actool_environment"
+	EnvironmentProduction = "production"
+
+	// RestrictedKey in a profile section restricts the profile: it is never
+	// written into [default] and only serves an explicit --profile.
+	RestrictedKey = "actool_restricted"
+
+	// ProductionPatternEnv is a regular expression; profiles whose name
+	// matches it are production profiles.
+	ProductionPatternEnv = "ACTOOL_PRODUCTION_PATTERN"
+
+	// RestrictProductionEnv restricts every production profile when true.
+	RestrictProductionEnv = "ACTOOL_RESTRICT_PRODUCTION"
+)
+
+// ProductionConfirmPrompt asks the user to confirm a production profile and
+// returns what they typed, which has to be the profile name.
+type ProductionConfirmPrompt func(profileName string) (string, error)
+
+// Guard holds the guardrails of a profile.
+type Guard struct {
+	// Production profiles are shown in red and are selected only after the
+	// profile name has been typed to confirm.
+	Production bool
+	// Restricted profiles never become [default]. They are used with an
+	// explicit --profile or selected for one shell.
+	Restricted bool
+}
+
+// Check returns why profileName cannot be selected with options, asking
+// options.ConfirmProduction for a production profile.
+func (g Guard) Check(profileName string, options SelectOptions) error {
+	if g.Restricted && !options.Shell {
+		return fmt.Errorf("profile %q is restricted and does not become [default]; use it with --profile %s or select it with actool shell-init", profileName, quoteCommandArg(profileName))
+	}
+	if !g.Production {
+		return nil
+	}
+	if options.ConfirmProduction == nil {
+		return fmt.Errorf("profile %q is a production profile; type its name to confirm, or pass --confirm-production %s", profileName, quoteCommandArg(profileName))
+	}
+	typed, err := options.ConfirmProduction(profileName)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(typed) != profileName {
+		return fmt.Errorf("confirmation %q does not match production profile %q; nothing was changed", typed, profileName)
+	}
+	return nil
+}
+
+// unattendedProfiles returns the profiles in profileNames that actool may
+// select on its own, when the selected profile is gone or restricted.
+// Production profiles are left out as well: they are only selected after
+// their name has been typed.
+func unattendedProfiles(profileNames []string, guards map[string]Guard) []string {
+	profiles := make([]string, 0, len(profileNames))
+	for _, profileName := range profileNames {
+		if guard := guards[profileName]; !guard.Production && !guard.Restricted {
+			profiles = append(profiles, profileName)
+		}
+	}
+	return profiles
+}
+
+// guardRules are read once per command from the environment.
+type guardRules struct {
+	pattern            *regexp.Regexp
+	restrictProduction bool
+}
+
+func loadGuardRules() (guardRules, error) {
+	var rules guardRules
+	if value := strings.TrimSpace(os.Getenv(ProductionPatternEnv)); value != "" {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return guardRules{}, fmt.Errorf("invalid %s: %w", ProductionPatternEnv, err)
+		}
+		rules.pattern = pattern
+	}
+	if value := strings.TrimSpace(os.Getenv(RestrictProductionEnv)); value != "" {
+		restrict, err := strconv.ParseBool(value)
+		if err != nil {
+			return guardRules{}, fmt.Errorf("invalid %s: %w", RestrictProductionEnv, err)
+		}
+		rules.restrictProduction = restrict
+	}
+	return rules, nil
+}
+
+// guard returns the guardrails of profileName from its section in cfg and
+// the rules.
+func (r guardRules) guard(cfg *ini.File, profileName string) Guard {
+	var guard Guard
+	section, err := cfg.GetSection(profileSectionName(profileName))
+	if err == nil {
+		environment := strings.ToLower(keyValue(section, Environment))
+		guard.Production = environment == EnvironmentProduction || environment == "prod"
+		guard.Restricted = section.Key(RestrictedKey).MustBool(false)
+	}
+	if r.pattern != nil && r.pattern.MatchString(profileName) {
+		guard.Production = true
+	}
+	if guard.Production && r.restrictProduction {
+		guard.Restricted = true
+	}
+	return guard
+}
+
+// profileGuards returns the guardrails of profileNames from the AWS config.
+func (p *profile) profileGuards(profileNames []string) (map[string]Guard, error) {
+	rules, err := loadGuardRules()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	guards := make(map[string]Guard, len(profileNames))
+	for _, profileName := range profileNames {
+		guards[profileName] = rules.guard(cfg, profileName)
+	}
+	return guards, nil
+}
+
+func (p *profile) profileGuard(profileName string) (Guard, error) {
+	guards, err := p.profileGuards([]string{profileName})
+	if err != nil {
+		return Guard{}, err
+	}
+	return guards[profileName], nil
+}
+
+// checkDefaultCredentialProcess refuses to serve a restricted profile to
+// [default], which still names it when it was selected before it was
+// restricted.
+func (p *profile) checkDefaultCredentialProcess(profileName string) error {
+	guard, err := p.profileGuard(profileName)
+	if err != nil || !guard.Restricted {
+		return err
+	}
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return err
+	}
+	section, err := cfg.GetSection(Default)
+	if err != nil || keyValue(section, CredentialProcess) != p.credentialProcessCommand(profileName) {
+		return nil
+	}
+	return fmt.Errorf("profile %q is restricted but still selected in [default]; run actool to select another profile", profileName)
+}
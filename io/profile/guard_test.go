@@ -0,0 +1,187 @@
+package profile
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestProfileGuards(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		pattern  string
+		restrict string
+		want     map[string]Guard
+		wantErr  string
+	}{
+		{
+			name:   "environment key",
+			config: "[profile live]\nactool_environment = Production\n[profile dev]\nactool_environment = development\n",
+			want:   map[string]Guard{"live": {Production: true}, "dev": {}, "prod-eu": {}},
+		},
+		{
+			name:    "name pattern",
+			pattern: `^prod`,
+			want:    map[string]Guard{"live": {}, "dev": {}, "prod-eu": {Production: true}},
+		},
+		{
+			name:   "restricted key",
+			config: "[profile dev]\nactool_restricted = true\n",
+			want:   map[string]Guard{"live": {}, "dev": {Restricted: true}, "prod-eu": {}},
+		},
+		{
+			name:     "restrict every production profile",
+			config:   "[profile live]\nactool_environment = prod\n",
+			pattern:  `^prod`,
+			restrict: "true",
+			want:     map[string]Guard{"live": {Production: true, Restricted: true}, "dev": {}, "prod-eu": {Production: true, Restricted: true}},
+		},
+		{name: "invalid pattern", pattern: `(prod`, wantErr: "invalid ACTOOL_PRODUCTION_PATTERN"},
+		{name: "invalid restrict flag", restrict: "sometimes", wantErr: "invalid ACTOOL_RESTRICT_PRODUCTION"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(ProductionPatternEnv, tc.pattern)
+			t.Setenv(RestrictProductionEnv, tc.restrict)
+			p := newTestProfile(t, newFakeSecretStore())
+			writeTestFile(t, p.configPath, tc.config)
+
+			guards, err := p.profileGuards([]string{"live", "dev", "prod-eu"})
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, guards, tc.want)
+		})
+	}
+}
+
+func newGuardTestProfile(t *testing.T, config string) *profile {
+	t.Helper()
+	t.Setenv(ProductionPatternEnv, "")
+	t.Setenv(RestrictProductionEnv, "")
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "PRODACCESSKEY", "PRODSECRETKEY", nil)
+	writeTestFile(t, p.configPath, config)
+	return p
+}
+
+func typed(name string) ProductionConfirmPrompt {
+	return func(string) (string, error) { return name, nil }
+}
+
+func TestSelectingProductionProfileNeedsConfirmation(t *testing.T) {
+	cases := []struct {
+		name    string
+		options SelectOptions
+		wantErr string
+	}{
+		{name: "no prompt", wantErr: "pass --confirm-production prod"},
+		{name: "wrong name", options: SelectOptions{ConfirmProduction: typed("dev")}, wantErr: `confirmation "dev" does not match production profile "prod"`},
+		{name: "typed name", options: SelectOptions{ConfirmProduction: typed("prod")}},
+		{name: "shell selection", options: SelectOptions{Shell: true}, wantErr: "production profile"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newGuardTestProfile(t, "[profile prod]\nactool_environment = production\n")
+			assert.NilError(t, p.SetSelected("dev"))
+
+			err := p.SetSelectedWithOptions("prod", tc.options)
+			state, stateErr := p.loadState()
+			assert.NilError(t, stateErr)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				assert.Equal(t, state.SelectedProfile, "dev")
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, state.SelectedProfile, "prod")
+		})
+	}
+
+	// Undo back onto a production profile is confirmed the same way.
+	p := newGuardTestProfile(t, "[profile prod]\nactool_environment = production\n")
+	assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{ConfirmProduction: typed("prod")}))
+	assert.NilError(t, p.SetSelected("dev"))
+	_, err := p.Undo()
+	assert.ErrorContains(t, err, "production profile")
+	entry, err := p.UndoWithOptions(SelectOptions{ConfirmProduction: typed("prod")})
+	assert.NilError(t, err)
+	assert.Equal(t, entry.To, "prod")
+}
+
+func TestRestrictedProfileNeverBecomesDefault(t *testing.T) {
+	p := newGuardTestProfile(t, "[profile prod]\nactool_restricted = true\n")
+	assert.NilError(t, p.SetSelected("dev"))
+
+	err := p.SetSelectedWithOptions("prod", SelectOptions{ConfirmProduction: typed("prod")})
+	assert.ErrorContains(t, err, `profile "prod" is restricted`)
+	expiration := p.now().Add(time.Hour)
+	err = p.StoreSessionTokenWithOptions("prod", &Credential{AccessKey: "SESSIONKEY", SecretKey: "SESSIONSECRET", SessionToken: "TOKEN", Expiration: &expiration}, SelectOptions{})
+	assert.ErrorContains(t, err, `profile "prod" is restricted`)
+	_, found, err := p.sessionCredentialForProfile("prod", func(string) bool { return true })
+	assert.NilError(t, err)
+	assert.Assert(t, !found, "the session was stored")
+
+	// A shell selection and an explicit --profile still work.
+	assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{Shell: true}))
+	payload, err := p.CredentialProcessPayload(context.Background(), "prod")
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(payload), "PRODACCESSKEY"))
+	cfg := loadTestConfig(t, p)
+	assert.Assert(t, !strings.Contains(keyValue(cfg.Section(Default), CredentialProcess), "prod"))
+}
+
+func TestProfileRestrictedAfterSelection(t *testing.T) {
+	p := newGuardTestProfile(t, "")
+	assert.NilError(t, p.SetSelected("prod"))
+	t.Setenv(ProductionPatternEnv, "^prod$")
+	t.Setenv(RestrictProductionEnv, "1")
+
+	// [default] still names prod, so credential-process refuses it.
+	_, err := p.CredentialProcessPayload(context.Background(), "prod")
+	assert.ErrorContains(t, err, "still selected in [default]")
+	_, err = p.CredentialProcessPayload(context.Background(), "")
+	assert.ErrorContains(t, err, "only used with an explicit --profile")
+
+	// The next interactive run moves [default] to another profile.
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "dev")
+	assert.DeepEqual(t, model.Guards["prod"], Guard{Production: true, Restricted: true})
+	_, err = p.CredentialProcessPayload(context.Background(), "prod")
+	assert.NilError(t, err)
+}
+
+func TestLoadFallbackSkipsProductionProfiles(t *testing.T) {
+	p := newGuardTestProfile(t, "[profile prod]\nactool_environment = production\n")
+	storeBaseCredential(t, p, "stage", "STAGEACCESSKEY", "STAGESECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+
+	// dev becomes restricted; Load moves on to stage, not to prod.
+	writeTestFile(t, p.configPath, "[profile prod]\nactool_environment = production\n[profile dev]\nactool_restricted = true\n")
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "stage")
+
+	// Without a profile that needs no confirmation nothing is selected.
+	writeTestFile(t, p.configPath, "[profile prod]\nactool_environment = production\n[profile dev]\nactool_restricted = true\n[profile stage]\nactool_restricted = true\n")
+	model, err = p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "")
+
+	// A production profile the user confirmed stays selected.
+	writeTestFile(t, p.configPath, "[profile prod]\nactool_environment = production\n")
+	assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{ConfirmProduction: typed("prod")}))
+	model, err = p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "prod")
+}
@@ -0,0 +1,210 @@
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+const (
+	historyFileName = "history.jsonl"
+	// historyMaxBytes caps history.jsonl. Once an append would exceed it,
+	// the oldest entries are dropped until half of it is left.
+	historyMaxBytes = 256 << 10
+
+	HistoryActionSelect  = "select"
+	HistoryActionSession = "session"
+	HistoryActionUndo    = "undo"
+	// HistoryActionShell is a selection made for one shell only. Undo skips
+	// it, since [default] did not change.
+	HistoryActionShell = "shell"
+)
+
+// HistoryEntry records one change of the selected profile.
+type HistoryEntry struct {
+	Time   time.Time
+	From   string `json:",omitempty"`
+	To     string
+	Action string
+	TTY    string `json:",omitempty"`
+}
+
+type historyStore interface {
+	Append(entry HistoryEntry) error
+	Entries() ([]HistoryEntry, error)
+}
+
+type fileHistoryStore struct {
+	path     string
+	maxBytes int64
+}
+
+type memoryHistoryStore struct {
+	entries []HistoryEntry
+}
+
+func newFileHistoryStore(statePath string) *fileHistoryStore {
+	return &fileHistoryStore{path: filepath.Join(filepath.Dir(statePath), historyFileName), maxBytes: historyMaxBytes}
+}
+
+// Append adds entry as one JSON line. It runs under the state lock, so the
+// trim and the append cannot interleave with another actool process.
+func (f *fileHistoryStore) Append(entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	if info, err := os.Stat(f.path); err == nil && info.Size()+int64(len(line)) > f.maxBytes {
+		if err := f.trim(f.maxBytes / 2); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// trim keeps the newest whole lines that fit in limit bytes.
+func (f *fileHistoryStore) trim(limit int64) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	for int64(len(data)) > limit {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			data = nil
+			break
+		}
+		data = data[end+1:]
+	}
+	return writeFileAtomic(f.path, data, 0o600)
+}
+
+// Entries returns the history oldest first. Lines that cannot be decoded,
+// such as one cut short by a crash, are skipped.
+func (f *fileHistoryStore) Entries() ([]HistoryEntry, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), historyMaxBytes)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.To == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (m *memoryHistoryStore) Append(entry HistoryEntry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memoryHistoryStore) Entries() ([]HistoryEntry, error) {
+	return append([]HistoryEntry(nil), m.entries...), nil
+}
+
+// terminalName names the terminal actool runs in, or is empty when stdin
+// is not a terminal.
+func terminalName() string {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return ""
+	}
+	if name, err := os.Readlink("/proc/self/fd/0"); err == nil {
+		return name
+	}
+	return "tty"
+}
+
+func (p *profile) recordHistory(from, to, action string) error {
+	return p.history.Append(HistoryEntry{
+		Time:   p.now().UTC(),
+		From:   from,
+		To:     to,
+		Action: action,
+		TTY:    p.terminalName(),
+	})
+}
+
+// History returns the recorded profile switches, oldest first.
+func (p *profile) History() ([]HistoryEntry, error) {
+	return p.history.Entries()
+}
+
+// Undo selects the profile that was active before the most recent switch
+// that has not been undone yet, so repeated undos walk back through the
+// history. The selection goes through the same path as SetSelected.
+func (p *profile) Undo() (*HistoryEntry, error) {
+	return p.UndoWithOptions(SelectOptions{})
+}
+
+// UndoWithOptions undoes like Undo, selecting the restored profile with
+// options.
+func (p *profile) UndoWithOptions(options SelectOptions) (*HistoryEntry, error) {
+	var restored *HistoryEntry
+	err := p.withLock(func() error {
+		entries, err := p.history.Entries()
+		if err != nil {
+			return err
+		}
+		target := ""
+		undone := 0
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Action == HistoryActionUndo {
+				undone++
+				continue
+			}
+			if entries[i].Action == HistoryActionShell {
+				continue
+			}
+			if undone > 0 {
+				undone--
+				continue
+			}
+			target = entries[i].From
+			break
+		}
+		if target == "" {
+			return errors.New("nothing to undo: no earlier profile selection is recorded")
+		}
+		if err := p.setSelected(target, HistoryActionUndo, options); err != nil {
+			return fmt.Errorf("restore profile %q: %w", target, err)
+		}
+		entries, err = p.history.Entries()
+		if err != nil {
+			return err
+		}
+		restored = &entries[len(entries)-1]
+		return nil
+	})
+	return restored, err
+}
@@ -0,0 +1,170 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFileHistoryStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "actool")
+	store := newFileHistoryStore(filepath.Join(dir, "state.json"))
+	store.maxBytes = 1024
+
+	entries, err := store.Entries()
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := range 40 {
+		entry := HistoryEntry{Time: start.Add(time.Duration(i) * time.Minute), From: fmt.Sprintf("p%02d", i), To: fmt.Sprintf("p%02d", i+1), Action: HistoryActionSelect}
+		assert.NilError(t, store.Append(entry))
+		info, err := os.Stat(store.path)
+		assert.NilError(t, err)
+		assert.Assert(t, info.Size() <= store.maxBytes, "history grew to %d bytes", info.Size())
+		assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+	}
+
+	entries, err = store.Entries()
+	assert.NilError(t, err)
+	assert.Assert(t, len(entries) > 1 && len(entries) < 40)
+	assert.Equal(t, entries[len(entries)-1].To, "p40")
+	for i := 1; i < len(entries); i++ {
+		assert.Assert(t, entries[i].Time.After(entries[i-1].Time))
+	}
+
+	// A line cut short by a crash is skipped.
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	assert.NilError(t, err)
+	_, err = file.WriteString(`{"Time":"2026-`)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+	after, err := store.Entries()
+	assert.NilError(t, err)
+	assert.Equal(t, len(after), len(entries))
+}
+
+func TestSelectionChangesAreRecorded(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "PRODACCESSKEY", "PRODSECRETKEY", nil)
+	p.terminalName = func() string { return "/dev/pts/3" }
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return clock }
+
+	assert.NilError(t, p.SetSelected("dev"))
+	expiration := time.Now().Add(time.Hour)
+	assert.NilError(t, p.StoreSessionToken("prod", &Credential{AccessKey: "A", SecretKey: "S", SessionToken: "T", Expiration: &expiration}))
+	assert.ErrorContains(t, p.SetSelected("missing"), "profile not found")
+
+	entries, err := p.History()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, entries, []HistoryEntry{
+		{Time: clock, To: "dev", Action: HistoryActionSelect, TTY: "/dev/pts/3"},
+		{Time: clock, From: "dev", To: "prod", Action: HistoryActionSession, TTY: "/dev/pts/3"},
+	})
+}
+
+func TestUndo(t *testing.T) {
+	cases := []struct {
+		name    string
+		selects []string
+		undos   int
+		want    string
+		wantErr string
+	}{
+		{name: "previous selection", selects: []string{"default", "dev", "prod"}, undos: 1, want: "dev"},
+		{name: "repeated undo walks back", selects: []string{"default", "dev", "prod"}, undos: 2, want: "default"},
+		{name: "nothing before the first selection", selects: []string{"default"}, undos: 1, wantErr: "nothing to undo"},
+		{name: "history exhausted", selects: []string{"default", "dev"}, undos: 2, wantErr: "nothing to undo"},
+		{name: "no history", undos: 1, wantErr: "nothing to undo"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProfile(t, newFakeSecretStore())
+			for _, name := range []string{"default", "dev", "prod"} {
+				storeBaseCredential(t, p, name, "ACCESSKEY", "SECRETKEY", nil)
+			}
+			for _, name := range tc.selects {
+				assert.NilError(t, p.SetSelected(name))
+			}
+
+			var err error
+			var restored *HistoryEntry
+			for range tc.undos {
+				restored, err = p.Undo()
+				if err != nil {
+					break
+				}
+			}
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, restored.To, tc.want)
+			assert.Equal(t, restored.Action, HistoryActionUndo)
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.Equal(t, state.SelectedProfile, tc.want)
+			config, err := os.ReadFile(p.configPath)
+			assert.NilError(t, err)
+			assert.Assert(t, strings.Contains(string(config), "--profile "+tc.want), string(config))
+		})
+	}
+}
+
+func TestUndoOfRemovedProfileFails(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "PRODACCESSKEY", "PRODSECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+	assert.NilError(t, p.SetSelected("prod"))
+	delete(store.values, "dev")
+
+	_, err := p.Undo()
+	assert.ErrorContains(t, err, `restore profile "dev"`)
+}
+
+func TestShellSelectionLeavesDefaultAlone(t *testing.T) {
+	t.Setenv(AWSProfileEnv, "dev")
+	p := newTestProfile(t, newFakeSecretStore())
+	for _, name := range []string{"dev", "prod"} {
+		storeBaseCredential(t, p, name, "ACCESSKEY", "SECRETKEY", nil)
+	}
+	assert.NilError(t, p.SetSelected("dev"))
+	before, err := p.loadConfigFile()
+	assert.NilError(t, err)
+
+	assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{Shell: true}))
+	expiration := time.Now().Add(time.Hour)
+	assert.NilError(t, p.StoreSessionTokenWithOptions("prod", &Credential{AccessKey: "A", SecretKey: "S", SessionToken: "T", Expiration: &expiration}, SelectOptions{Shell: true}))
+
+	cfg, err := p.loadConfigFile()
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(cfg.Section(Default), CredentialProcess), keyValue(before.Section(Default), CredentialProcess))
+	assert.Equal(t, keyValue(cfg.Section("profile prod"), CredentialProcess), "actool credential-process --profile prod")
+	state, err := p.loadState()
+	assert.NilError(t, err)
+	assert.Equal(t, state.SelectedProfile, "dev")
+	assert.Assert(t, state.Profiles["prod"].LastUsed != nil)
+	assert.Assert(t, state.Profiles["prod"].LastSessionRefresh != nil)
+
+	entries, err := p.History()
+	assert.NilError(t, err)
+	last := entries[len(entries)-1]
+	assert.Equal(t, last.Action, HistoryActionShell)
+	assert.Equal(t, last.From, "dev")
+	assert.Equal(t, last.To, "prod")
+
+	// Undo ignores the shell selection and finds nothing global to undo.
+	_, err = p.Undo()
+	assert.ErrorContains(t, err, "nothing to undo")
+}
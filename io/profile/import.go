@@ -0,0 +1,338 @@
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/99designs/keyring"
+)
+
+// Import source formats.
+const (
+	// ImportFromCSV is the accessKeys.csv the IAM console offers for a new
+	// access key.
+	ImportFromCSV = "csv"
+	// ImportFromAWSVaultFile is the directory of an aws-vault file keyring,
+	// such as ~/.awsvault/keys.
+	ImportFromAWSVaultFile = "aws-vault-file"
+	// ImportFromEnv is a file of AWS_ACCESS_KEY_ID=... lines, as written for
+	// env files and shell exports.
+	ImportFromEnv = "env"
+	// ImportFromINI is a shared credentials file like ~/.aws/credentials.
+	ImportFromINI = "ini"
+)
+
+// ImportFormats lists the supported import formats.
+var ImportFormats = []string{ImportFromCSV, ImportFromAWSVaultFile, ImportFromEnv, ImportFromINI}
+
+// Import entry states.
+const (
+	ImportNew       = "new"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+)
+
+// ImportEntry is one credential of an import plan. It never holds the
+// secret key.
+type ImportEntry struct {
+	Profile     string
+	AccessKeyID string
+	Status      string
+}
+
+// ImportPlan is what an import would store, built before anything is
+// written so that it can be previewed.
+type ImportPlan struct {
+	Format  string
+	Source  string
+	Entries []ImportEntry
+
+	credentials map[string]*Credential
+}
+
+// Changes counts the entries that would be stored.
+func (p *ImportPlan) Changes() int {
+	changes := 0
+	for _, entry := range p.Entries {
+		if entry.Status != ImportUnchanged {
+			changes++
+		}
+	}
+	return changes
+}
+
+// PlanImport reads source in format and compares it with the keyring.
+// profileName names the credential for formats that carry no profile name,
+// and is required for them unless the source names a user.
+func (p *profile) PlanImport(format, source, profileName string) (*ImportPlan, error) {
+	credentials, err := readImportSource(format, source, strings.TrimSpace(profileName))
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("no credentials found in %s", source)
+	}
+
+	plan := &ImportPlan{Format: format, Source: source, credentials: credentials}
+	names := make([]string, 0, len(credentials))
+	for name := range credentials {
+		names = append(names, name)
+	}
+	sortProfileNames(names)
+	for _, name := range names {
+		credential := credentials[name]
+		status := ImportNew
+		existing, err := p.secrets.Get(name)
+		switch {
+		case err == nil:
+			status = ImportUpdate
+			if current, decodeErr := decodeCredential(existing, name); decodeErr == nil && credentialsEqual(current, credential) {
+				status = ImportUnchanged
+			}
+		case !errors.Is(err, errSecretNotFound):
+			return nil, err
+		}
+		plan.Entries = append(plan.Entries, ImportEntry{Profile: name, AccessKeyID: credential.AccessKey, Status: status})
+	}
+	return plan, nil
+}
+
+// ApplyImport stores the plan in the keyring. Unless overwrite is set, a
+// plan that would replace a different stored credential is refused. The
+// imported profiles get their credential_process in ~/.aws/config.
+func (p *profile) ApplyImport(plan *ImportPlan, overwrite bool) error {
+	if plan == nil || plan.credentials == nil {
+		return errors.New("import plan is empty")
+	}
+	if !overwrite {
+		for _, entry := range plan.Entries {
+			if entry.Status == ImportUpdate {
+				return fmt.Errorf("profile %q already has a different credential; pass --overwrite to replace it", entry.Profile)
+			}
+		}
+	}
+	return p.withLock(func() error {
+		if err := p.importCredentialPlanWithOptions(plan.credentials, overwrite); err != nil {
+			return err
+		}
+		profileNames, err := p.profileNames()
+		if err != nil {
+			return err
+		}
+		return p.syncConfig("", profileNames, false)
+	})
+}
+
+// ShredImportSource overwrites a plaintext import source with random bytes
+// before removing it. On copy-on-write file systems and SSDs the old blocks
+// may survive; it is still better than a plain delete.
+func (p *profile) ShredImportSource(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file; actool only shreds files", path)
+	}
+	if p.plan != nil {
+		return p.removeFile(path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(file, rand.Reader, info.Size())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return removeIfExists(path)
+}
+
+func readImportSource(format, source, profileName string) (map[string]*Credential, error) {
+	if format == ImportFromAWSVaultFile {
+		return readAWSVaultFileKeyring(source)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ImportFromCSV:
+		return parseAccessKeysCSV(data, profileName)
+	case ImportFromEnv:
+		return parseEnvCredentials(data, profileName)
+	case ImportFromINI:
+		credentials, _, err := parseLegacyCredentials(data)
+		return credentials, err
+	}
+	return nil, fmt.Errorf("unknown import format %q; use one of %s", format, strings.Join(ImportFormats, ", "))
+}
+
+// parseAccessKeysCSV reads the IAM console's accessKeys.csv, either the
+// current two-column file or the older one with a user name column. The user
+// name names the profile when profileName is empty.
+func parseAccessKeysCSV(data []byte, profileName string) (map[string]*Credential, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read access keys CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("access keys CSV has no rows")
+	}
+	columns := map[string]int{}
+	for index, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	accessColumn, hasAccess := columns["access key id"]
+	secretColumn, hasSecret := columns["secret access key"]
+	if !hasAccess || !hasSecret {
+		return nil, errors.New(`access keys CSV needs "Access key ID" and "Secret access key" columns`)
+	}
+	userColumn, hasUser := columns["user name"]
+	rows := records[1:]
+	if profileName != "" && len(rows) > 1 {
+		return nil, fmt.Errorf("access keys CSV has %d rows; --profile names a single credential", len(rows))
+	}
+
+	credentials := make(map[string]*Credential)
+	for _, row := range rows {
+		name := profileName
+		if name == "" && hasUser && userColumn < len(row) {
+			name = strings.TrimSpace(row[userColumn])
+		}
+		if name == "" {
+			return nil, errors.New("access keys CSV does not name a user; pass --profile")
+		}
+		if err := validateProfileName(name); err != nil {
+			return nil, err
+		}
+		if accessColumn >= len(row) || secretColumn >= len(row) {
+			return nil, fmt.Errorf("access keys CSV row for %q is incomplete", name)
+		}
+		credential := &Credential{Name: name, AccessKey: strings.TrimSpace(row[accessColumn]), SecretKey: strings.TrimSpace(row[secretColumn])}
+		if credential.AccessKey == "" || credential.SecretKey == "" {
+			return nil, fmt.Errorf("access keys CSV row for %q is incomplete", name)
+		}
+		if _, ok := credentials[name]; ok {
+			return nil, fmt.Errorf("access keys CSV has more than one row for %q", name)
+		}
+		credentials[name] = credential
+	}
+	return credentials, nil
+}
+
+// parseEnvCredentials reads KEY=value lines with an optional export prefix
+// and quotes. AWS_PROFILE names the profile when profileName is empty.
+func parseEnvCredentials(data []byte, profileName string) (map[string]*Credential, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	credential := &Credential{
+		AccessKey: values["AWS_ACCESS_KEY_ID"],
+		SecretKey: values["AWS_SECRET_ACCESS_KEY"],
+	}
+	if credential.AccessKey == "" && credential.SecretKey == "" {
+		return nil, nil
+	}
+	if credential.AccessKey == "" || credential.SecretKey == "" {
+		return nil, errors.New("env file needs both AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	// Keys with a session token are temporary. Stored as base credentials
+	// they would be served without an expiration long after they stopped
+	// working.
+	if strings.TrimSpace(values["AWS_SESSION_TOKEN"]) != "" {
+		return nil, errors.New("env file sets AWS_SESSION_TOKEN; temporary credentials cannot be imported, import long-term access keys instead")
+	}
+	if profileName == "" {
+		profileName = strings.TrimSpace(values[AWSProfileEnv])
+	}
+	if profileName == "" {
+		return nil, errors.New("env file does not set AWS_PROFILE; pass --profile")
+	}
+	if err := validateProfileName(profileName); err != nil {
+		return nil, err
+	}
+	credential.Name = profileName
+	return map[string]*Credential{profileName: credential}, nil
+}
+
+// readAWSVaultFileKeyring reads the long-term credentials of an aws-vault
+// file keyring directory. Sessions and SSO tokens are skipped.
+func readAWSVaultFileKeyring(dir string) (map[string]*Credential, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not an aws-vault file keyring directory", dir)
+	}
+	ring, err := keyring.Open(keyring.Config{
+		ServiceName:      awsVaultServiceName,
+		AllowedBackends:  []keyring.BackendType{keyring.FileBackend},
+		FileDir:          dir,
+		FilePasswordFunc: fileKeyringPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+	store := &keyringStore{keyring: ring}
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	credentials := make(map[string]*Credential)
+	for _, key := range keys {
+		if isNonProfileKey(key) || validateProfileName(key) != nil {
+			continue
+		}
+		value, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("read %q from %s: %w", key, dir, err)
+		}
+		credential, err := decodeCredential(value, key)
+		if err != nil || credential.Expiration != nil {
+			continue
+		}
+		credentials[key] = credential
+	}
+	return credentials, nil
+}
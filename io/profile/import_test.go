@@ -0,0 +1,188 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"gotest.tools/v3/assert"
+)
+
+func TestReadImportSource(t *testing.T) {
+	cases := []struct {
+		name        string
+		format      string
+		contents    string
+		profileName string
+		want        map[string]string
+		wantErr     string
+	}{
+		{
+			name:        "csv without user name",
+			format:      ImportFromCSV,
+			contents:    "\xef\xbb\xbfAccess key ID,Secret access key\nDEVACCESSKEY,DEVSECRETKEY\n",
+			profileName: "dev",
+			want:        map[string]string{"dev": "DEVACCESSKEY"},
+		},
+		{
+			name:     "csv with user names",
+			format:   ImportFromCSV,
+			contents: "User name,Password,Access key ID,Secret access key,Console login link\ndev,,DEVACCESSKEY,DEVSECRETKEY,\nprod,,PRODACCESSKEY,PRODSECRETKEY,\n",
+			want:     map[string]string{"dev": "DEVACCESSKEY", "prod": "PRODACCESSKEY"},
+		},
+		{
+			name:     "csv needs a profile name",
+			format:   ImportFromCSV,
+			contents: "Access key ID,Secret access key\nDEVACCESSKEY,DEVSECRETKEY\n",
+			wantErr:  "pass --profile",
+		},
+		{
+			name:     "csv without key columns",
+			format:   ImportFromCSV,
+			contents: "User name,Password\ndev,secret\n",
+			wantErr:  `needs "Access key ID" and "Secret access key" columns`,
+		},
+		{
+			name:        "csv with several rows and a profile",
+			format:      ImportFromCSV,
+			contents:    "User name,Access key ID,Secret access key\ndev,A,B\nprod,C,D\n",
+			profileName: "dev",
+			wantErr:     "--profile names a single credential",
+		},
+		{
+			name:     "env with export and quotes",
+			format:   ImportFromEnv,
+			contents: "# dev account\nexport AWS_ACCESS_KEY_ID=\"DEVACCESSKEY\"\nexport AWS_SECRET_ACCESS_KEY='DEVSECRETKEY'\nAWS_PROFILE=dev\nAWS_REGION=us-east-1\n",
+			want:     map[string]string{"dev": "DEVACCESSKEY"},
+		},
+		{
+			name:        "env profile flag wins",
+			format:      ImportFromEnv,
+			contents:    "AWS_ACCESS_KEY_ID=DEVACCESSKEY\nAWS_SECRET_ACCESS_KEY=DEVSECRETKEY\nAWS_PROFILE=dev\n",
+			profileName: "staging",
+			want:        map[string]string{"staging": "DEVACCESSKEY"},
+		},
+		{
+			name:     "env without secret",
+			format:   ImportFromEnv,
+			contents: "AWS_ACCESS_KEY_ID=DEVACCESSKEY\nAWS_PROFILE=dev\n",
+			wantErr:  "needs both AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY",
+		},
+		{
+			name:     "env with session token",
+			format:   ImportFromEnv,
+			contents: "AWS_ACCESS_KEY_ID=ASIATEMPKEY\nAWS_SECRET_ACCESS_KEY=TEMPSECRET\nAWS_SESSION_TOKEN=TOKEN\nAWS_PROFILE=dev\n",
+			wantErr:  "temporary credentials cannot be imported",
+		},
+		{
+			name:     "ini",
+			format:   ImportFromINI,
+			contents: "[default]\naws_access_key_id = DEFAULTACCESSKEY\naws_secret_access_key = DEFAULTSECRETKEY\n[dev]\naws_access_key_id = DEVACCESSKEY\naws_secret_access_key = DEVSECRETKEY\n",
+			want:     map[string]string{"default": "DEFAULTACCESSKEY", "dev": "DEVACCESSKEY"},
+		},
+		{name: "unknown format", format: "json", contents: "{}", wantErr: `unknown import format "json"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "source")
+			writeTestFile(t, path, tc.contents)
+
+			credentials, err := readImportSource(tc.format, path, tc.profileName)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			got := make(map[string]string, len(credentials))
+			for name, credential := range credentials {
+				assert.Equal(t, credential.Name, name)
+				assert.Assert(t, credential.SecretKey != "")
+				got[name] = credential.AccessKey
+			}
+			assert.DeepEqual(t, got, tc.want)
+		})
+	}
+}
+
+func TestReadAWSVaultFileKeyring(t *testing.T) {
+	t.Setenv("AWS_VAULT_FILE_PASSPHRASE", "test-passphrase")
+	dir := t.TempDir()
+	ring, err := keyring.Open(keyring.Config{
+		ServiceName:      awsVaultServiceName,
+		AllowedBackends:  []keyring.BackendType{keyring.FileBackend},
+		FileDir:          dir,
+		FilePasswordFunc: fileKeyringPassword,
+	})
+	assert.NilError(t, err)
+	store := &keyringStore{keyring: ring}
+	assert.NilError(t, store.Set("dev", []byte(`{"AccessKeyID":"DEVACCESSKEY","SecretAccessKey":"DEVSECRETKEY"}`)))
+	assert.NilError(t, store.Set("expiring", []byte(`{"AccessKeyID":"ASIAKEY","SecretAccessKey":"SECRET","SessionToken":"TOKEN","CanExpire":true,"Expires":"2030-01-01T00:00:00Z"}`)))
+
+	credentials, err := readAWSVaultFileKeyring(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(credentials), 1)
+	assert.Equal(t, credentials["dev"].SecretKey, "DEVSECRETKEY")
+
+	_, err = readAWSVaultFileKeyring(filepath.Join(dir, "missing"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestImportPlanAndApply(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "OLDACCESSKEY", "OLDSECRETKEY", nil)
+	source := filepath.Join(t.TempDir(), "credentials")
+	writeTestFile(t, source, "[dev]\naws_access_key_id = DEVACCESSKEY\naws_secret_access_key = DEVSECRETKEY\n"+
+		"[prod]\naws_access_key_id = PRODACCESSKEY\naws_secret_access_key = PRODSECRETKEY\n"+
+		"[staging]\naws_access_key_id = STAGINGACCESSKEY\naws_secret_access_key = STAGINGSECRETKEY\n")
+
+	plan, err := p.PlanImport(ImportFromINI, source, "")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, plan.Entries, []ImportEntry{
+		{Profile: "dev", AccessKeyID: "DEVACCESSKEY", Status: ImportUnchanged},
+		{Profile: "prod", AccessKeyID: "PRODACCESSKEY", Status: ImportUpdate},
+		{Profile: "staging", AccessKeyID: "STAGINGACCESSKEY", Status: ImportNew},
+	})
+	assert.Equal(t, plan.Changes(), 2)
+
+	assert.ErrorContains(t, p.ApplyImport(plan, false), `profile "prod" already has a different credential`)
+	_, err = p.baseCredential("staging")
+	assert.ErrorContains(t, err, "profile not found")
+
+	assert.NilError(t, p.ApplyImport(plan, true))
+	for name, accessKey := range map[string]string{"prod": "PRODACCESSKEY", "staging": "STAGINGACCESSKEY"} {
+		credential, err := p.baseCredential(name)
+		assert.NilError(t, err)
+		assert.Equal(t, credential.AccessKey, accessKey)
+	}
+	config, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(config), "[profile staging]"), string(config))
+
+	plan, err = p.PlanImport(ImportFromINI, source, "")
+	assert.NilError(t, err)
+	assert.Equal(t, plan.Changes(), 0)
+}
+
+func TestShredImportSource(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	dir := t.TempDir()
+	source := filepath.Join(dir, "accessKeys.csv")
+	writeTestFile(t, source, "Access key ID,Secret access key\nDEVACCESSKEY,DEVSECRETKEY\n")
+
+	assert.ErrorContains(t, p.ShredImportSource(dir), "is not a regular file")
+
+	plan := startDryRun(t, p)
+	assert.NilError(t, p.ShredImportSource(source))
+	_, err := os.Stat(source)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(planOutput(t, plan), "DEVSECRETKEY"))
+
+	p = newTestProfile(t, newFakeSecretStore())
+	assert.NilError(t, p.ShredImportSource(source))
+	_, err = os.Stat(source)
+	assert.Assert(t, os.IsNotExist(err))
+}
@@ -0,0 +1,307 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyringIndexFileName = "keyring-index.json"
+	keyringIndexVersion  = 1
+
+	keyKindCredential = "credential"
+	keyKindSession    = "session"
+	keyKindOIDC       = "oidc"
+	keyKindMetadata   = "metadata"
+	keyKindOther      = "other"
+
+	// Entries that could not be decoded are re-read after this long, in case
+	// another tool has since stored a credential under the same name. Stores
+	// that report modification times have them re-read as soon as they are
+	// written.
+	otherKeyRecheckAfter = time.Hour
+)
+
+// indexEntry describes a keyring item without its secret.
+type indexEntry struct {
+	Key        string
+	Kind       string
+	Profile    string     `json:",omitempty"`
+	Expiration *time.Time `json:",omitempty"`
+	Checked    time.Time
+}
+
+type keyringIndex struct {
+	Version int
+	Entries []indexEntry
+}
+
+// keyClassifier is implemented by stores that know what a key holds without
+// reading its secret.
+type keyClassifier interface {
+	Classify(key string) (indexEntry, bool)
+}
+
+// modificationTimer is implemented by stores that can tell when a key was
+// last written without reading its secret.
+type modificationTimer interface {
+	ModificationTime(key string) (time.Time, bool)
+}
+
+type cacheResetter interface {
+	Reset()
+}
+
+// indexedStore keeps the values read in this process in memory and records
+// what every key holds in a non-secret index file next to state.json. Keys
+// is still answered by the backend, so items added by aws-vault or another
+// actool process are seen; the index only saves reading the secret of keys
+// that are known not to be profile credentials. Set and Remove update both.
+type indexedStore struct {
+	store secretStore
+	path  string
+	now   func() time.Time
+
+	mu         sync.Mutex
+	values     map[string][]byte
+	keys       []string
+	keysLoaded bool
+	index      map[string]indexEntry
+	loaded     bool
+}
+
+func newIndexedStore(store secretStore, path string) *indexedStore {
+	return &indexedStore{store: store, path: path, now: time.Now, values: make(map[string][]byte)}
+}
+
+func keyringIndexPath(statePath string) string {
+	return filepath.Join(filepath.Dir(statePath), keyringIndexFileName)
+}
+
+func (s *indexedStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.values[key]; ok {
+		return append([]byte(nil), value...), nil
+	}
+	value, err := s.store.Get(key)
+	if err != nil {
+		if errors.Is(err, errSecretNotFound) {
+			s.forget(key)
+		}
+		return nil, err
+	}
+	s.values[key] = append([]byte(nil), value...)
+	s.record(classifyKey(key, value, s.now()))
+	return value, nil
+}
+
+func (s *indexedStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.store.Set(key, value); err != nil {
+		// The backend may have changed partially; read it again next time.
+		delete(s.values, key)
+		s.keysLoaded = false
+		return err
+	}
+	s.values[key] = append([]byte(nil), value...)
+	if s.keysLoaded && !slices.Contains(s.keys, key) {
+		s.keys = append(s.keys, key)
+	}
+	s.record(classifyKey(key, value, s.now()))
+	return nil
+}
+
+func (s *indexedStore) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.store.Remove(key)
+	if err != nil && !errors.Is(err, errSecretNotFound) {
+		s.keysLoaded = false
+		return err
+	}
+	s.forget(key)
+	return err
+}
+
+func (s *indexedStore) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.keysLoaded {
+		keys, err := s.store.Keys()
+		if err != nil {
+			return nil, err
+		}
+		s.keys = append([]string(nil), keys...)
+		s.keysLoaded = true
+		s.prune()
+	}
+	return append([]string(nil), s.keys...), nil
+}
+
+// Reset drops the values and keys cached in this process. It is called
+// after taking the state lock, since another process may have changed the
+// keyring while this one waited.
+func (s *indexedStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.values)
+	s.keys = nil
+	s.keysLoaded = false
+}
+
+// Classify returns the indexed entry for key. Keys whose kind follows from
+// their name are classified without the index.
+func (s *indexedStore) Classify(key string) (indexEntry, bool) {
+	if entry, ok := classifyKeyName(key); ok {
+		return entry, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadIndex()
+	entry, ok := s.index[key]
+	if !ok || (entry.Kind == keyKindOther && s.otherKeyChanged(entry)) {
+		return indexEntry{}, false
+	}
+	return entry, true
+}
+
+// otherKeyChanged reports whether an entry that could not be decoded has to
+// be read again.
+func (s *indexedStore) otherKeyChanged(entry indexEntry) bool {
+	if s.now().Sub(entry.Checked) > otherKeyRecheckAfter {
+		return true
+	}
+	if timer, ok := s.store.(modificationTimer); ok {
+		if modified, ok := timer.ModificationTime(entry.Key); ok && modified.After(entry.Checked) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *indexedStore) forget(key string) {
+	delete(s.values, key)
+	if s.keysLoaded {
+		s.keys = slices.DeleteFunc(s.keys, func(existing string) bool { return existing == key })
+	}
+	s.loadIndex()
+	if _, ok := s.index[key]; ok {
+		delete(s.index, key)
+		s.saveIndex()
+	}
+}
+
+func (s *indexedStore) record(entry indexEntry) {
+	s.loadIndex()
+	if previous, ok := s.index[entry.Key]; ok && sameIndexEntry(previous, entry) && (previous.Kind != keyKindOther || !s.otherKeyChanged(previous)) {
+		return
+	}
+	s.index[entry.Key] = entry
+	s.saveIndex()
+}
+
+// prune drops entries for keys that no longer exist in the backend.
+func (s *indexedStore) prune() {
+	s.loadIndex()
+	changed := false
+	for key := range s.index {
+		if !slices.Contains(s.keys, key) {
+			delete(s.index, key)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveIndex()
+	}
+}
+
+// loadIndex treats a missing or unreadable index as empty; it is only a
+// cache and is rebuilt as keys are read.
+func (s *indexedStore) loadIndex() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	s.index = make(map[string]indexEntry)
+	if s.path == "" {
+		return
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return
+	}
+	var index keyringIndex
+	if err := json.Unmarshal(data, &index); err != nil || index.Version != keyringIndexVersion {
+		return
+	}
+	for _, entry := range index.Entries {
+		s.index[entry.Key] = entry
+	}
+}
+
+// saveIndex ignores write errors: a stale or missing index only costs extra
+// reads.
+func (s *indexedStore) saveIndex() {
+	if s.path == "" {
+		return
+	}
+	index := keyringIndex{Version: keyringIndexVersion, Entries: make([]indexEntry, 0, len(s.index))}
+	for _, entry := range s.index {
+		index.Entries = append(index.Entries, entry)
+	}
+	slices.SortFunc(index.Entries, func(a, b indexEntry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = writeFileAtomic(s.path, append(data, '\n'), 0o600)
+}
+
+func sameIndexEntry(a, b indexEntry) bool {
+	if a.Kind != b.Kind || a.Profile != b.Profile || (a.Expiration == nil) != (b.Expiration == nil) {
+		return false
+	}
+	if a.Expiration != nil && !a.Expiration.Equal(*b.Expiration) {
+		return false
+	}
+	// Refresh the check time of undecodable entries once it matters.
+	return a.Kind != keyKindOther || b.Checked.Sub(a.Checked) < otherKeyRecheckAfter/2
+}
+
+func classifyKeyName(key string) (indexEntry, bool) {
+	if metadata, ok := parseSessionKey(key); ok {
+		expiration := metadata.Expiration
+		return indexEntry{Key: key, Kind: keyKindSession, Profile: metadata.ProfileName, Expiration: &expiration}, true
+	}
+	if strings.HasPrefix(key, oidcTokenKeyPrefix) {
+		return indexEntry{Key: key, Kind: keyKindOIDC}, true
+	}
+	if isNonProfileKey(key) || validateProfileName(key) != nil {
+		return indexEntry{Key: key, Kind: keyKindMetadata}, true
+	}
+	return indexEntry{}, false
+}
+
+func classifyKey(key string, value []byte, now time.Time) indexEntry {
+	if entry, ok := classifyKeyName(key); ok {
+		entry.Checked = now.UTC()
+		return entry
+	}
+	entry := indexEntry{Key: key, Kind: keyKindOther, Checked: now.UTC()}
+	if credential, err := decodeCredential(value, key); err == nil {
+		entry.Kind = keyKindCredential
+		entry.Profile = key
+		entry.Expiration = credential.Expiration
+	}
+	return entry
+}
@@ -0,0 +1,308 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// slowSecretStore counts reads and can make each one as slow as a keyring
+// item that needs to be decrypted or fetched over IPC.
+type slowSecretStore struct {
+	*fakeSecretStore
+	delay time.Duration
+	gets  map[string]int
+	keys  int
+}
+
+func newSlowSecretStore(delay time.Duration) *slowSecretStore {
+	return &slowSecretStore{fakeSecretStore: newFakeSecretStore(), delay: delay, gets: make(map[string]int)}
+}
+
+func (s *slowSecretStore) Get(key string) ([]byte, error) {
+	s.gets[key]++
+	time.Sleep(s.delay)
+	return s.fakeSecretStore.Get(key)
+}
+
+func (s *slowSecretStore) Keys() ([]string, error) {
+	s.keys++
+	return s.fakeSecretStore.Keys()
+}
+
+func (s *slowSecretStore) totalGets() int {
+	total := 0
+	for _, count := range s.gets {
+		total += count
+	}
+	return total
+}
+
+func newIndexedTestProfile(t testing.TB, backend *slowSecretStore, indexPath string) *profile {
+	t.Helper()
+	dir := t.TempDir()
+	return newProfile(
+		filepath.Join(dir, "config"),
+		filepath.Join(dir, "credentials"),
+		"actool",
+		newIndexedStore(backend, indexPath),
+		nil,
+	)
+}
+
+// populateKeyring stores base credentials, expired and valid sessions for
+// each of them, and items written by other tools.
+func populateKeyring(t testing.TB, backend *slowSecretStore, profiles int) {
+	t.Helper()
+	p := newProfile(filepath.Join(t.TempDir(), "config"), "", "actool", backend.fakeSecretStore, nil)
+	now := time.Now().UTC()
+	for i := range profiles {
+		name := fmt.Sprintf("profile-%02d", i)
+		data, err := json.Marshal(map[string]string{"AccessKeyID": "AKIA" + name, "SecretAccessKey": "secret-" + name})
+		assert.NilError(t, err)
+		backend.values[name] = data
+		for j, offset := range []time.Duration{-3 * time.Hour, -2 * time.Hour, time.Hour} {
+			expiration := now.Add(offset)
+			credential := &Credential{Name: name, AccessKey: "ASIA", SecretKey: "S", SessionToken: "T", MFASerial: fmt.Sprintf("mfa-%d", j), Expiration: &expiration}
+			assert.NilError(t, p.storeSessionCredential(credential))
+		}
+		backend.values[fmt.Sprintf("notes-%02d", i)] = []byte("not a credential")
+	}
+}
+
+func TestIndexedStoreCachesAndInvalidates(t *testing.T) {
+	backend := newSlowSecretStore(0)
+	backend.values["dev"] = []byte("one")
+	store := newIndexedStore(backend, filepath.Join(t.TempDir(), keyringIndexFileName))
+
+	for range 2 {
+		value, err := store.Get("dev")
+		assert.NilError(t, err)
+		assert.Equal(t, string(value), "one")
+	}
+	assert.Equal(t, backend.gets["dev"], 1)
+
+	keys, err := store.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev"})
+
+	assert.NilError(t, store.Set("dev", []byte("two")))
+	assert.NilError(t, store.Set("prod", []byte("three")))
+	value, err := store.Get("dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "two")
+	assert.Equal(t, backend.gets["dev"], 1)
+
+	assert.NilError(t, store.Remove("dev"))
+	_, err = store.Get("dev")
+	assert.ErrorIs(t, err, errSecretNotFound)
+	keys, err = store.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"prod"})
+	assert.Equal(t, backend.keys, 1)
+
+	backend.failSetKey = "prod"
+	assert.Assert(t, store.Set("prod", []byte("four")) != nil)
+	value, err = store.Get("prod")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "three")
+	assert.Equal(t, backend.gets["prod"], 1)
+}
+
+func TestIndexedStoreSkipsKnownNonCredentialKeys(t *testing.T) {
+	backend := newSlowSecretStore(0)
+	populateKeyring(t, backend, 3)
+	indexPath := filepath.Join(t.TempDir(), keyringIndexFileName)
+
+	names, _, err := newIndexedTestProfile(t, backend, indexPath).loadBaseCredentials()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"profile-00", "profile-01", "profile-02"})
+	assert.Equal(t, backend.gets["notes-00"], 1)
+
+	// A later process reads only the keys that hold credentials.
+	names, _, err = newIndexedTestProfile(t, backend, indexPath).loadBaseCredentials()
+	assert.NilError(t, err)
+	assert.Equal(t, len(names), 3)
+	assert.Equal(t, backend.gets["notes-00"], 1)
+	assert.Equal(t, backend.gets["profile-00"], 2)
+
+	info, err := os.Stat(indexPath)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+	data, err := os.ReadFile(indexPath)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), "secret-profile"), "index must not contain secrets")
+	assert.Assert(t, !strings.Contains(string(data), "not a credential"))
+
+	// Keys that disappeared from the backend are pruned from the index.
+	delete(backend.values, "notes-01")
+	store := newIndexedStore(backend, indexPath)
+	_, err = store.Keys()
+	assert.NilError(t, err)
+	_, known := store.Classify("notes-01")
+	assert.Assert(t, !known)
+	entry, known := store.Classify("notes-02")
+	assert.Assert(t, known)
+	assert.Equal(t, entry.Kind, keyKindOther)
+}
+
+// modifiedSecretStore reports modification times like the file keyring.
+type modifiedSecretStore struct {
+	*slowSecretStore
+	modified map[string]time.Time
+}
+
+func (s *modifiedSecretStore) ModificationTime(key string) (time.Time, bool) {
+	modified, ok := s.modified[key]
+	return modified, ok
+}
+
+func TestIndexedStoreRechecksOtherKeys(t *testing.T) {
+	cases := []struct {
+		name     string
+		modified bool
+		later    time.Duration
+		want     []string
+	}{
+		{name: "checked recently", want: []string{}},
+		{name: "check expired", later: otherKeyRecheckAfter + time.Minute, want: []string{"dev"}},
+		{name: "rewritten since the check", modified: true, want: []string{"dev"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &modifiedSecretStore{slowSecretStore: newSlowSecretStore(0), modified: make(map[string]time.Time)}
+			backend.values["dev"] = []byte("not a credential")
+			backend.modified["dev"] = time.Now().Add(-time.Hour)
+			indexPath := filepath.Join(t.TempDir(), keyringIndexFileName)
+			store := newIndexedStore(backend, indexPath)
+			_, _, err := newProfile(filepath.Join(t.TempDir(), "config"), "", "actool", store, nil).loadBaseCredentials()
+			assert.NilError(t, err)
+
+			backend.values["dev"] = []byte(`{"AccessKeyID":"AKIA","SecretAccessKey":"S"}`)
+			if tc.modified {
+				backend.modified["dev"] = time.Now().Add(time.Minute)
+			}
+			store = newIndexedStore(backend, indexPath)
+			store.now = func() time.Time { return time.Now().Add(tc.later) }
+			names, _, err := newProfile(filepath.Join(t.TempDir(), "config"), "", "actool", store, nil).loadBaseCredentials()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, names, tc.want)
+		})
+	}
+}
+
+func TestSessionLookupSkipsExpiredSessionsByKey(t *testing.T) {
+	backend := newSlowSecretStore(0)
+	populateKeyring(t, backend, 2)
+	p := newIndexedTestProfile(t, backend, "")
+
+	credential, expired, err := p.sessionCredentialForProfile("profile-01", func(string) bool { return true })
+	assert.NilError(t, err)
+	assert.Assert(t, !expired)
+	assert.Equal(t, credential.MFASerial, "mfa-2")
+	assert.Equal(t, backend.totalGets(), 1)
+
+	credential, expired, err = p.sessionCredentialForProfile("profile-01", func(sessionType string) bool { return false })
+	assert.NilError(t, err)
+	assert.Assert(t, credential == nil)
+	assert.Assert(t, !expired)
+}
+
+func TestRuntimeProfileIndexesSecretStore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"))
+	t.Setenv("AWS_VAULT_BACKEND", "file")
+	t.Setenv("AWS_VAULT_FILE_DIR", filepath.Join(home, "aws-vault"))
+	t.Setenv("AWS_VAULT_FILE_PASSPHRASE", "test-passphrase")
+
+	p, err := NewProfile()
+	assert.NilError(t, err)
+	store, ok := p.(*profile).secrets.(*indexedStore)
+	assert.Assert(t, ok)
+	assert.Equal(t, store.path, filepath.Join(filepath.Dir(defaultStatePath()), keyringIndexFileName))
+}
+
+func BenchmarkLoadBaseCredentials(b *testing.B) {
+	backend := newSlowSecretStore(200 * time.Microsecond)
+	populateKeyring(b, backend, 20)
+	indexPath := filepath.Join(b.TempDir(), keyringIndexFileName)
+	_, _, err := newIndexedTestProfile(b, backend, indexPath).loadBaseCredentials()
+	assert.NilError(b, err)
+
+	b.Run("direct", func(b *testing.B) {
+		p := newProfile(filepath.Join(b.TempDir(), "config"), "", "actool", backend, nil)
+		for b.Loop() {
+			if _, _, err := p.loadBaseCredentials(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		// Each iteration is a new process that only has the on-disk index.
+		for b.Loop() {
+			p := newIndexedTestProfile(b, backend, indexPath)
+			if _, _, err := p.loadBaseCredentials(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		p := newIndexedTestProfile(b, backend, indexPath)
+		for b.Loop() {
+			if _, _, err := p.loadBaseCredentials(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSessionCredentialLookup(b *testing.B) {
+	backend := newSlowSecretStore(200 * time.Microsecond)
+	populateKeyring(b, backend, 20)
+	include := func(string) bool { return true }
+
+	b.Run("direct", func(b *testing.B) {
+		p := newProfile(filepath.Join(b.TempDir(), "config"), "", "actool", backend, nil)
+		for b.Loop() {
+			if _, _, err := p.sessionCredentialForProfile("profile-10", include); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		p := newIndexedTestProfile(b, backend, "")
+		for b.Loop() {
+			if _, _, err := p.sessionCredentialForProfile("profile-10", include); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStateLockResetsSecretCache(t *testing.T) {
+	backend := newSlowSecretStore(0)
+	p := newIndexedTestProfile(t, backend, "")
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	_, err := p.Credential("dev")
+	assert.NilError(t, err)
+	_, err = p.Credential("dev")
+	assert.NilError(t, err)
+	gets := backend.gets["dev"]
+
+	// Another process changes the keyring while this one waits for the lock.
+	backend.values["prod"] = backend.values["dev"]
+	profiles, err := p.Load()
+	assert.NilError(t, err)
+	assert.Assert(t, backend.gets["dev"] > gets)
+	assert.Equal(t, len(profiles.Credentials), 2)
+}
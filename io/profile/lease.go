@@ -0,0 +1,121 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// SafeProfileEnv names the profile that [default] falls back to when a
+	// selection lease ends, unless actool use is given --fallback.
+	SafeProfileEnv = "ACTOOL_SAFE_PROFILE"
+
+	// HistoryActionLeaseEnd is the switch back made by the first
+	// interactive run after a lease ended.
+	HistoryActionLeaseEnd = "lease-end"
+)
+
+// selectionLease limits how long Profile stays selected in [default].
+// While a lease is held, [default] runs credential-process without
+// --profile, so that credential-process can tell [default] from an
+// explicit --profile and stop serving Profile to it once the lease ends.
+type selectionLease struct {
+	Profile string
+	Expires time.Time
+	// Fallback is served to [default] after the lease ends. Without it
+	// credential-process fails instead.
+	Fallback string `json:",omitempty"`
+	// Previous was selected before the lease. The next interactive run
+	// selects it again when there is no Fallback.
+	Previous string `json:",omitempty"`
+}
+
+func (l *selectionLease) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// newSelectionLease checks the lease options for selecting profileName
+// after previous. The fallback has to be another profile that is neither
+// production nor restricted.
+func (p *profile) newSelectionLease(profileName, previous string, profileNames []string, options SelectOptions) (*selectionLease, error) {
+	if options.Lease < 0 {
+		return nil, errors.New("lease duration must not be negative")
+	}
+	fallback := strings.TrimSpace(options.Fallback)
+	if options.Lease == 0 {
+		if fallback != "" {
+			return nil, errors.New("a fallback profile needs a lease duration")
+		}
+		return nil, nil
+	}
+	if options.Shell {
+		return nil, errors.New("a lease applies to [default] and cannot be used for a shell selection")
+	}
+	if fallback == "" {
+		fallback = strings.TrimSpace(os.Getenv(SafeProfileEnv))
+	}
+	if fallback != "" {
+		if fallback == profileName {
+			return nil, fmt.Errorf("fallback profile %q is the leased profile", fallback)
+		}
+		if !containsProfile(profileNames, fallback) {
+			if _, isSSO, err := p.ssoSettings(fallback); err != nil || !isSSO {
+				return nil, fmt.Errorf("fallback profile not found. [%s]", fallback)
+			}
+		}
+		guard, err := p.profileGuard(fallback)
+		if err != nil {
+			return nil, err
+		}
+		if guard.Production || guard.Restricted {
+			return nil, fmt.Errorf("fallback profile %q is a production or restricted profile", fallback)
+		}
+	}
+	return &selectionLease{
+		Profile:  profileName,
+		Expires:  p.now().UTC().Add(options.Lease),
+		Fallback: fallback,
+		Previous: previous,
+	}, nil
+}
+
+// leasedProfile returns the profile credential-process serves to
+// [default] for the selection in state, and the end of its lease while the
+// lease is held.
+func (p *profile) leasedProfile(state profileState) (string, *time.Time, error) {
+	lease := state.Lease
+	if lease == nil || lease.Profile != state.SelectedProfile {
+		return state.SelectedProfile, nil, nil
+	}
+	if !lease.expired(p.now()) {
+		expires := lease.Expires
+		return state.SelectedProfile, &expires, nil
+	}
+	if lease.Fallback == "" {
+		return "", nil, fmt.Errorf("the lease on profile %q ended at %s and no safe profile is set; run actool to select a profile", lease.Profile, lease.Expires.Local().Format(time.DateTime))
+	}
+	guard, err := p.profileGuard(lease.Fallback)
+	if err != nil {
+		return "", nil, err
+	}
+	if guard.Production {
+		return "", nil, fmt.Errorf("the lease on profile %q ended and its fallback %q is now a production profile; run actool to select a profile", lease.Profile, lease.Fallback)
+	}
+	return lease.Fallback, nil, nil
+}
+
+// limitToLease returns credential expiring no later than leaseEnd, so that
+// AWS clients call credential-process again when the lease ends instead of
+// keeping the leased profile's credentials.
+func limitToLease(credential *Credential, leaseEnd *time.Time) *Credential {
+	if leaseEnd == nil || (credential.Expiration != nil && !credential.Expiration.After(*leaseEnd)) {
+		return credential
+	}
+	limited := *credential
+	expiration := leaseEnd.UTC()
+	limited.Expiration = &expiration
+	return &limited
+}
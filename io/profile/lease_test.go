@@ -0,0 +1,182 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func newLeaseTestProfile(t *testing.T) (*profile, *time.Time) {
+	t.Helper()
+	t.Setenv(SafeProfileEnv, "")
+	p := newGuardTestProfile(t, "")
+	storeBaseCredential(t, p, "stage", "STAGEACCESSKEY", "STAGESECRETKEY", nil)
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return clock }
+	assert.NilError(t, p.SetSelected("dev"))
+	return p, &clock
+}
+
+func defaultAccessKey(t *testing.T, p *profile) string {
+	t.Helper()
+	payload, err := p.CredentialProcessPayload(context.Background(), "")
+	assert.NilError(t, err)
+	for _, accessKey := range []string{"DEVACCESSKEY", "PRODACCESSKEY", "STAGEACCESSKEY"} {
+		if strings.Contains(string(payload), accessKey) {
+			return accessKey
+		}
+	}
+	return string(payload)
+}
+
+func TestSelectionLeaseFallsBack(t *testing.T) {
+	cases := []struct {
+		name         string
+		fallback     string
+		safeProfile  string
+		wantDefault  string
+		wantErr      string
+		wantSelected string
+	}{
+		{name: "fallback", fallback: "stage", wantDefault: "STAGEACCESSKEY", wantSelected: "stage"},
+		{name: "safe profile", safeProfile: "stage", wantDefault: "STAGEACCESSKEY", wantSelected: "stage"},
+		{name: "no fallback", wantErr: `the lease on profile "prod" ended`, wantSelected: "dev"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, clock := newLeaseTestProfile(t)
+			t.Setenv(SafeProfileEnv, tc.safeProfile)
+
+			assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{Lease: 30 * time.Minute, Fallback: tc.fallback}))
+			cfg := loadTestConfig(t, p)
+			assert.Equal(t, keyValue(cfg.Section(Default), CredentialProcess), "actool credential-process")
+			assert.Equal(t, defaultAccessKey(t, p), "PRODACCESSKEY")
+
+			*clock = clock.Add(30 * time.Minute)
+			if tc.wantErr != "" {
+				_, err := p.CredentialProcessPayload(context.Background(), "")
+				assert.ErrorContains(t, err, tc.wantErr)
+			} else {
+				assert.Equal(t, defaultAccessKey(t, p), tc.wantDefault)
+			}
+			// An explicit --profile is not affected by the lease.
+			payload, err := p.CredentialProcessPayload(context.Background(), "prod")
+			assert.NilError(t, err)
+			assert.Assert(t, strings.Contains(string(payload), "PRODACCESSKEY"))
+
+			// The next interactive run rewrites [default].
+			model, err := p.Load()
+			assert.NilError(t, err)
+			assert.Equal(t, model.SelectedProfile, tc.wantSelected)
+			cfg = loadTestConfig(t, p)
+			assert.Equal(t, keyValue(cfg.Section(Default), CredentialProcess), "actool credential-process --profile "+tc.wantSelected)
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.Assert(t, state.Lease == nil)
+			entries, err := p.History()
+			assert.NilError(t, err)
+			last := entries[len(entries)-1]
+			assert.DeepEqual(t, []string{last.Action, last.From, last.To}, []string{HistoryActionLeaseEnd, "prod", tc.wantSelected})
+		})
+	}
+}
+
+func TestSelectionLeaseIsKeptUntilItEnds(t *testing.T) {
+	p, clock := newLeaseTestProfile(t)
+	assert.NilError(t, p.SetSelectedWithOptions("prod", SelectOptions{Lease: time.Hour, Fallback: "dev"}))
+
+	*clock = clock.Add(59 * time.Minute)
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "prod")
+	assert.Equal(t, keyValue(loadTestConfig(t, p).Section(Default), CredentialProcess), "actool credential-process")
+
+	// A plain selection drops the lease.
+	assert.NilError(t, p.SetSelected("prod"))
+	state, err := p.loadState()
+	assert.NilError(t, err)
+	assert.Assert(t, state.Lease == nil)
+	*clock = clock.Add(time.Hour)
+	assert.Equal(t, defaultAccessKey(t, p), "PRODACCESSKEY")
+}
+
+func TestSelectionLeaseLimitsDefaultExpiration(t *testing.T) {
+	cases := []struct {
+		name    string
+		session time.Duration
+		want    time.Duration
+	}{
+		{name: "base credential without expiration", want: 30 * time.Minute},
+		{name: "session ending after the lease", session: time.Hour, want: 30 * time.Minute},
+		{name: "session ending before the lease", session: 10 * time.Minute, want: 10 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := newLeaseTestProfile(t)
+			// Stored sessions have to expire after the wall clock.
+			start := time.Now().UTC().Truncate(time.Second)
+			p.now = func() time.Time { return start }
+			if tc.session != 0 {
+				expiration := start.Add(tc.session)
+				assert.NilError(t, p.StoreSessionTokenWithOptions("stage", &Credential{AccessKey: "STAGEACCESSKEY", SecretKey: "SESSIONSECRET", SessionToken: "TOKEN", Expiration: &expiration}, SelectOptions{Shell: true}))
+			}
+			assert.NilError(t, p.SetSelectedWithOptions("stage", SelectOptions{Lease: 30 * time.Minute}))
+
+			assert.Equal(t, payloadExpiration(t, p, ""), start.Add(tc.want).Format(time.RFC3339))
+			// An explicit --profile keeps the credential's own expiration.
+			if tc.session == 0 {
+				assert.Equal(t, payloadExpiration(t, p, "stage"), "")
+			}
+		})
+	}
+}
+
+func payloadExpiration(t *testing.T, p *profile, profileName string) string {
+	t.Helper()
+	payload, err := p.CredentialProcessPayload(context.Background(), profileName)
+	assert.NilError(t, err)
+	var response struct{ Expiration string }
+	assert.NilError(t, json.Unmarshal(payload, &response))
+	return response.Expiration
+}
+
+func TestSelectionLeaseOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		options SelectOptions
+		config  string
+		wantErr string
+	}{
+		{name: "negative", options: SelectOptions{Lease: -time.Minute}, wantErr: "must not be negative"},
+		{name: "fallback without lease", options: SelectOptions{Fallback: "dev"}, wantErr: "needs a lease duration"},
+		{name: "shell", options: SelectOptions{Lease: time.Minute, Shell: true}, wantErr: "cannot be used for a shell selection"},
+		{name: "fallback is the leased profile", options: SelectOptions{Lease: time.Minute, Fallback: "prod"}, wantErr: "is the leased profile"},
+		{name: "unknown fallback", options: SelectOptions{Lease: time.Minute, Fallback: "qa"}, wantErr: "fallback profile not found. [qa]"},
+		{
+			name:    "production fallback",
+			options: SelectOptions{Lease: time.Minute, Fallback: "stage"},
+			config:  "[profile stage]\nactool_environment = production\n",
+			wantErr: `fallback profile "stage" is a production or restricted profile`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := newLeaseTestProfile(t)
+			writeTestFile(t, p.configPath, tc.config)
+
+			err := p.SetSelectedWithOptions("prod", tc.options)
+			assert.ErrorContains(t, err, tc.wantErr)
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.Equal(t, state.SelectedProfile, "dev")
+			assert.Assert(t, state.Lease == nil)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// LockTimeoutEnv overrides how long a mutating command waits for another
+	// actool process to finish.
+	LockTimeoutEnv = "ACTOOL_LOCK_TIMEOUT"
+
+	lockFileName       = "state.lock"
+	defaultLockTimeout = 10 * time.Second
+	lockRetryInterval  = 100 * time.Millisecond
+)
+
+// stateLock serializes the commands that change ~/.aws/config, state.json or
+// the keyring. credential-process never takes it.
+type stateLock interface {
+	Acquire() (release func(), err error)
+}
+
+type noopLock struct{}
+
+// fileLock is an advisory lock: a file created with O_EXCL next to
+// state.json that records its owner. A lock whose owner process no longer
+// exists on this host is stale and taken over, see takeOver.
+type fileLock struct {
+	path     string
+	timeout  time.Duration
+	pid      int
+	hostname string
+
+	now          func() time.Time
+	sleep        func(d time.Duration)
+	processAlive func(pid int) bool
+}
+
+type lockOwner struct {
+	PID      int
+	Hostname string
+	Acquired time.Time
+}
+
+func (noopLock) Acquire() (func(), error) {
+	return func() {}, nil
+}
+
+func newFileLock(statePath string) *fileLock {
+	hostname, _ := os.Hostname()
+	return &fileLock{
+		path:         filepath.Join(filepath.Dir(statePath), lockFileName),
+		timeout:      lockTimeout(),
+		pid:          os.Getpid(),
+		hostname:     hostname,
+		now:          time.Now,
+		sleep:        time.Sleep,
+		processAlive: processAlive,
+	}
+}
+
+func lockTimeout() time.Duration {
+	if value := strings.TrimSpace(os.Getenv(LockTimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+			return timeout
+		}
+	}
+	return defaultLockTimeout
+}
+
+func (l *fileLock) Acquire() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return nil, err
+	}
+	deadline := l.now().Add(l.timeout)
+	for {
+		acquired, err := l.tryCreate()
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return l.release, nil
+		}
+
+		owner, info, stale := l.inspect()
+		if stale {
+			if err := l.takeOver(owner, info); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !l.now().Before(deadline) {
+			return nil, l.busyError(owner)
+		}
+		l.sleep(lockRetryInterval)
+	}
+}
+
+func (l *fileLock) tryCreate() (bool, error) {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	data, err := json.Marshal(lockOwner{PID: l.pid, Hostname: l.hostname, Acquired: l.now().UTC()})
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(l.path)
+		return false, err
+	}
+	return true, nil
+}
+
+// inspect reports the current owner and whether the lock is stale. A lock
+// that cannot be read is only stale once it is older than the timeout, since
+// its owner may still be writing it.
+func (l *fileLock) inspect() (*lockOwner, os.FileInfo, bool) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		// Released in the meantime; retry at once.
+		return nil, nil, os.IsNotExist(err)
+	}
+	owner, err := readLockOwner(l.path)
+	if err != nil {
+		return nil, info, l.stale(nil, info)
+	}
+	return owner, info, l.stale(owner, info)
+}
+
+func (l *fileLock) stale(owner *lockOwner, info os.FileInfo) bool {
+	if owner == nil {
+		return l.now().Sub(info.ModTime()) > l.timeout
+	}
+	return owner.Hostname == l.hostname && !l.processAlive(owner.PID)
+}
+
+// takeOver removes the stale lock that inspect found. Another waiter may
+// have taken it over and created a new lock in the meantime, so the file is
+// first renamed to a name only this process uses. It is deleted only when
+// it is still the file with the dead owner; otherwise it is put back.
+func (l *fileLock) takeOver(owner *lockOwner, info os.FileInfo) error {
+	if info == nil {
+		return nil
+	}
+	claimed := fmt.Sprintf("%s.%s.%d.stale", l.path, l.hostname, l.pid)
+	if err := os.Rename(l.path, claimed); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if l.sameStaleLock(claimed, owner, info) {
+		return os.Remove(claimed)
+	}
+	// A link fails when yet another lock was created meanwhile, which then
+	// stays in place.
+	if err := os.Link(claimed, l.path); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Remove(claimed)
+}
+
+func (l *fileLock) sameStaleLock(path string, owner *lockOwner, info os.FileInfo) bool {
+	current, err := os.Stat(path)
+	if err != nil || !os.SameFile(info, current) {
+		return false
+	}
+	currentOwner, err := readLockOwner(path)
+	if owner == nil {
+		return err != nil && l.stale(nil, current)
+	}
+	return err == nil && currentOwner.PID == owner.PID && currentOwner.Hostname == owner.Hostname &&
+		currentOwner.Acquired.Equal(owner.Acquired) && l.stale(currentOwner, current)
+}
+
+// release removes the lock only while it is still ours, so that a process
+// that was considered gone cannot delete its successor's lock.
+func (l *fileLock) release() {
+	owner, err := readLockOwner(l.path)
+	if err != nil || owner.PID != l.pid || owner.Hostname != l.hostname {
+		return
+	}
+	_ = os.Remove(l.path)
+}
+
+func (l *fileLock) busyError(owner *lockOwner) error {
+	if owner == nil {
+		return fmt.Errorf("timed out after %s waiting for the actool lock %s", l.timeout, l.path)
+	}
+	return fmt.Errorf("timed out after %s waiting for the actool lock %s held by PID %d on %s since %s; remove the file if that process is gone",
+		l.timeout, l.path, owner.PID, owner.Hostname, owner.Acquired.Local().Format(time.RFC3339))
+}
+
+func readLockOwner(path string) (*lockOwner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var owner lockOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, err
+	}
+	if owner.PID <= 0 {
+		return nil, errors.New("lock file has no PID")
+	}
+	return &owner, nil
+}
+
+// withLock runs fn while holding the state lock.
+func (p *profile) withLock(fn func() error) error {
+	release, err := p.lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if cache, ok := p.secrets.(cacheResetter); ok {
+		cache.Reset()
+	}
+	return fn()
+}
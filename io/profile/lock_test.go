@@ -0,0 +1,300 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func newTestFileLock(t *testing.T, dir string, pid int) *fileLock {
+	t.Helper()
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &fileLock{
+		path:     filepath.Join(dir, lockFileName),
+		timeout:  time.Second,
+		pid:      pid,
+		hostname: "host-a",
+		now:      func() time.Time { return clock },
+		sleep: func(d time.Duration) {
+			clock = clock.Add(d)
+		},
+		processAlive: func(int) bool { return true },
+	}
+}
+
+func writeLockOwner(t *testing.T, path string, owner lockOwner) {
+	t.Helper()
+	data, err := json.Marshal(owner)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestFileLockAcquireAndRelease(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "actool")
+	lock := newTestFileLock(t, dir, 100)
+
+	release, err := lock.Acquire()
+	assert.NilError(t, err)
+	owner, err := readLockOwner(lock.path)
+	assert.NilError(t, err)
+	assert.Equal(t, owner.PID, 100)
+	assert.Equal(t, owner.Hostname, "host-a")
+
+	release()
+	_, err = os.Stat(lock.path)
+	assert.Assert(t, os.IsNotExist(err))
+
+	release, err = lock.Acquire()
+	assert.NilError(t, err)
+	release()
+}
+
+func TestFileLockWaitsForOtherProcess(t *testing.T) {
+	cases := []struct {
+		name    string
+		owner   *lockOwner
+		raw     string
+		age     time.Duration
+		alive   bool
+		wantErr string
+	}{
+		{name: "live owner times out", owner: &lockOwner{PID: 200, Hostname: "host-a"}, alive: true, wantErr: "held by PID 200 on host-a"},
+		{name: "dead owner is stale", owner: &lockOwner{PID: 200, Hostname: "host-a"}},
+		{name: "owner on another host is not checked", owner: &lockOwner{PID: 200, Hostname: "host-b"}, wantErr: "held by PID 200 on host-b"},
+		{name: "fresh unreadable lock is kept", raw: "", wantErr: "waiting for the actool lock"},
+		{name: "old unreadable lock is stale", raw: "{", age: time.Hour},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			lock := newTestFileLock(t, dir, 100)
+			lock.processAlive = func(pid int) bool {
+				assert.Equal(t, pid, 200)
+				return tc.alive
+			}
+			if tc.owner != nil {
+				writeLockOwner(t, lock.path, *tc.owner)
+			} else {
+				assert.NilError(t, os.WriteFile(lock.path, []byte(tc.raw), 0o600))
+			}
+			modified := lock.now().Add(-tc.age)
+			assert.NilError(t, os.Chtimes(lock.path, modified, modified))
+
+			release, err := lock.Acquire()
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				_, statErr := os.Stat(lock.path)
+				assert.NilError(t, statErr)
+				return
+			}
+			assert.NilError(t, err)
+			owner, err := readLockOwner(lock.path)
+			assert.NilError(t, err)
+			assert.Equal(t, owner.PID, 100)
+			release()
+		})
+	}
+}
+
+func TestFileLockIsAcquiredOnceReleased(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestFileLock(t, dir, 100)
+	second := newTestFileLock(t, dir, 200)
+
+	release, err := first.Acquire()
+	assert.NilError(t, err)
+	waited := 0
+	second.sleep = func(time.Duration) {
+		waited++
+		if waited == 3 {
+			release()
+		}
+	}
+	releaseSecond, err := second.Acquire()
+	assert.NilError(t, err)
+	assert.Equal(t, waited, 3)
+
+	// A late release from the first owner must not remove the new lock.
+	release()
+	owner, err := readLockOwner(second.path)
+	assert.NilError(t, err)
+	assert.Equal(t, owner.PID, 200)
+	releaseSecond()
+}
+
+func TestFileLockStaleTakeoverIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestFileLock(t, dir, 100)
+	second := newTestFileLock(t, dir, 200)
+	for _, lock := range []*fileLock{first, second} {
+		lock.processAlive = func(pid int) bool { return pid != 300 }
+	}
+	writeLockOwner(t, first.path, lockOwner{PID: 300, Hostname: "host-a"})
+
+	// Both waiters find the dead owner before either takes over.
+	firstOwner, firstInfo, stale := first.inspect()
+	assert.Assert(t, stale)
+	secondOwner, secondInfo, stale := second.inspect()
+	assert.Assert(t, stale)
+
+	assert.NilError(t, first.takeOver(firstOwner, firstInfo))
+	acquired, err := first.tryCreate()
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	// The second takeover finds the first waiter's lock and puts it back.
+	assert.NilError(t, second.takeOver(secondOwner, secondInfo))
+	owner, err := readLockOwner(first.path)
+	assert.NilError(t, err)
+	assert.Equal(t, owner.PID, 100)
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+
+	_, err = second.Acquire()
+	assert.ErrorContains(t, err, "held by PID 100 on host-a")
+}
+
+func TestFileLockConcurrentStaleTakeovers(t *testing.T) {
+	dir := t.TempDir()
+	writeLockOwner(t, filepath.Join(dir, lockFileName), lockOwner{PID: 1000, Hostname: "host-a"})
+
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for pid := 1; pid <= cap(errs); pid++ {
+		lock := newTestFileLock(t, dir, pid)
+		lock.timeout = 10 * time.Second
+		lock.now = time.Now
+		lock.sleep = time.Sleep
+		lock.processAlive = func(pid int) bool { return pid != 1000 }
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := lock.Acquire()
+			if err != nil {
+				errs <- err
+				return
+			}
+			held := holders.Add(1)
+			for {
+				current := maxHolders.Load()
+				if held <= current || maxHolders.CompareAndSwap(current, held) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, maxHolders.Load(), int32(1))
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
+
+func TestLockTimeoutFromEnvironment(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: defaultLockTimeout},
+		{value: "2m", want: 2 * time.Minute},
+		{value: "0s", want: 0},
+		{value: "soon", want: defaultLockTimeout},
+		{value: "-1s", want: defaultLockTimeout},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(LockTimeoutEnv, tc.value)
+			assert.Equal(t, lockTimeout(), tc.want)
+		})
+	}
+}
+
+type countingLock struct {
+	acquired int
+	held     bool
+}
+
+func (c *countingLock) Acquire() (func(), error) {
+	c.acquired++
+	c.held = true
+	return func() { c.held = false }, nil
+}
+
+func TestMutatingCommandsTakeStateLock(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	lock := &countingLock{}
+	p.lock = lock
+
+	_, err := p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, lock.acquired, 1)
+
+	assert.NilError(t, p.SetSelected("dev"))
+	assert.Equal(t, lock.acquired, 2)
+
+	expiration := time.Now().Add(time.Hour)
+	assert.NilError(t, p.StoreSessionToken("dev", &Credential{AccessKey: "A", SecretKey: "S", SessionToken: "T", Expiration: &expiration}))
+	assert.Equal(t, lock.acquired, 3)
+
+	assert.NilError(t, p.SetMFASeed("dev", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+	_, err = p.MFAToken(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.NilError(t, p.RemoveMFASeed("dev"))
+	assert.Equal(t, lock.acquired, 6)
+	assert.Assert(t, !lock.held)
+
+	_, err = p.CredentialProcessPayload(context.Background(), "dev")
+	assert.NilError(t, err)
+	_, err = p.Credential("dev")
+	assert.NilError(t, err)
+	assert.Equal(t, lock.acquired, 6)
+}
+
+func TestRuntimeProfileUsesLockNextToState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"))
+	t.Setenv("AWS_VAULT_BACKEND", "file")
+	t.Setenv("AWS_VAULT_FILE_DIR", filepath.Join(home, "aws-vault"))
+	t.Setenv("AWS_VAULT_FILE_PASSPHRASE", "test-passphrase")
+	t.Setenv(LockTimeoutEnv, "0s")
+
+	p, err := NewProfile()
+	assert.NilError(t, err)
+	lock, ok := p.(*profile).lock.(*fileLock)
+	assert.Assert(t, ok)
+	assert.Equal(t, filepath.Dir(lock.path), filepath.Dir(defaultStatePath()))
+
+	assert.NilError(t, os.MkdirAll(filepath.Dir(lock.path), 0o700))
+	writeLockOwner(t, lock.path, lockOwner{PID: os.Getpid(), Hostname: lock.hostname, Acquired: time.Now()})
+	_, err = p.Load()
+	assert.ErrorContains(t, err, "waiting for the actool lock")
+
+	assert.NilError(t, os.Remove(lock.path))
+	_, err = p.Load()
+	assert.NilError(t, err)
+	_, err = os.Stat(lock.path)
+	assert.Assert(t, os.IsNotExist(err))
+}
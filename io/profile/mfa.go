@@ -0,0 +1,118 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/totp"
+)
+
+// mfaSeed is stored as a separate secure-store entry next to the profile's
+// long-lived credentials. LastTimeStep records the most recent RFC 6238 step
+// handed out so the same code is never submitted to STS twice.
+type mfaSeed struct {
+	Secret       string
+	LastTimeStep int64
+}
+
+func (p *profile) SetMFASeed(profileName string, secret string) error {
+	normalized, err := totp.NormalizeSecret(secret)
+	if err != nil {
+		return err
+	}
+	return p.withLock(func() error {
+		if _, err := p.baseCredential(profileName); err != nil {
+			return err
+		}
+		return p.saveMFASeed(profileName, &mfaSeed{Secret: normalized})
+	})
+}
+
+func (p *profile) RemoveMFASeed(profileName string) error {
+	if err := validateProfileName(profileName); err != nil {
+		return err
+	}
+	return p.withLock(func() error {
+		err := p.secrets.Remove(secretKey(mfaSeedKeyPrefix, profileName))
+		if errors.Is(err, errSecretNotFound) {
+			return fmt.Errorf("no MFA seed is stored for profile %q", profileName)
+		}
+		return err
+	})
+}
+
+// MFAToken returns a TOTP code for profileName. If the code for the current
+// time step was already used, it waits for the next step rather than
+// returning a code that STS would reject as replayed; the wait ends early when
+// ctx is canceled. The step is reserved under the state lock before waiting,
+// so two concurrent runs never get the same code and the lock is not held
+// while sleeping.
+func (p *profile) MFAToken(ctx context.Context, profileName string) (string, error) {
+	var seed *mfaSeed
+	var step int64
+	err := p.withLock(func() error {
+		var err error
+		seed, err = p.loadMFASeed(profileName)
+		if err != nil {
+			return err
+		}
+		step = max(totp.TimeStep(p.now()), seed.LastTimeStep+1)
+		reserved := *seed
+		reserved.LastTimeStep = step
+		return p.saveMFASeed(profileName, &reserved)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if wait := totp.StepStart(step).Sub(p.now()); wait > 0 {
+		if err := p.sleep(ctx, wait); err != nil {
+			return "", err
+		}
+	}
+	return totp.Code(seed.Secret, step)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (p *profile) loadMFASeed(profileName string) (*mfaSeed, error) {
+	if err := validateProfileName(profileName); err != nil {
+		return nil, err
+	}
+	data, err := p.secrets.Get(secretKey(mfaSeedKeyPrefix, profileName))
+	if err != nil {
+		if errors.Is(err, errSecretNotFound) {
+			return nil, fmt.Errorf("no MFA seed is stored for profile %q; run actool mfa set-seed --profile %s", profileName, quoteCommandArg(profileName))
+		}
+		return nil, err
+	}
+	var seed mfaSeed
+	if err := json.Unmarshal(data, &seed); err != nil {
+		return nil, fmt.Errorf("MFA seed for profile %q is malformed: %w", profileName, err)
+	}
+	if strings.TrimSpace(seed.Secret) == "" {
+		return nil, fmt.Errorf("MFA seed for profile %q is empty", profileName)
+	}
+	return &seed, nil
+}
+
+func (p *profile) saveMFASeed(profileName string, seed *mfaSeed) error {
+	encoded, err := json.Marshal(seed)
+	if err != nil {
+		return err
+	}
+	return p.secrets.Set(secretKey(mfaSeedKeyPrefix, profileName), encoded)
+}
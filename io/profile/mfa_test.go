@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/totp"
+)
+
+const testMFASeed = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestSetMFASeedStoresMetadataEntry(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+
+	assert.NilError(t, p.SetMFASeed("dev", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq"))
+
+	key := secretKey(mfaSeedKeyPrefix, "dev")
+	_, err := store.Get(key)
+	assert.NilError(t, err)
+	assert.Assert(t, isNonProfileKey(key))
+	assert.ErrorContains(t, validateProfileName(key), "reserved")
+
+	names, err := p.profileNames()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"dev"})
+}
+
+func TestSetMFASeedValidation(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+
+	assert.ErrorContains(t, p.SetMFASeed("missing", testMFASeed), "profile not found")
+	assert.ErrorContains(t, p.SetMFASeed("dev", "not-base32!"), "not valid base32")
+	assert.ErrorContains(t, p.RemoveMFASeed("dev"), "no MFA seed")
+	_, err := p.MFAToken(context.Background(), "dev")
+	assert.ErrorContains(t, err, "actool mfa set-seed")
+}
+
+func TestMFATokenNeverReusesTimeStep(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	assert.NilError(t, p.SetMFASeed("dev", testMFASeed))
+
+	current := time.Unix(1111111111, 0).UTC()
+	var slept []time.Duration
+	p.now = func() time.Time { return current }
+	p.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		current = current.Add(d)
+		return nil
+	}
+
+	first, err := p.MFAToken(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, first, "050471")
+	assert.Equal(t, len(slept), 0)
+
+	second, err := p.MFAToken(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.Assert(t, second != first)
+	assert.DeepEqual(t, slept, []time.Duration{29 * time.Second})
+	want, err := totp.Code(testMFASeed, totp.TimeStep(current))
+	assert.NilError(t, err)
+	assert.Equal(t, second, want)
+
+	seed, err := p.loadMFASeed("dev")
+	assert.NilError(t, err)
+	assert.Equal(t, seed.LastTimeStep, totp.TimeStep(current))
+
+	assert.NilError(t, p.RemoveMFASeed("dev"))
+	_, err = p.MFAToken(context.Background(), "dev")
+	assert.ErrorContains(t, err, "no MFA seed")
+}
+
+func TestMFATokenWaitIsCanceledWithContext(t *testing.T) {
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	assert.NilError(t, p.SetMFASeed("dev", testMFASeed))
+	p.now = func() time.Time { return time.Unix(1111111111, 0).UTC() }
+
+	_, err := p.MFAToken(context.Background(), "dev")
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.MFAToken(ctx, "dev")
+	assert.Assert(t, errors.Is(err, context.Canceled), "got %v", err)
+}
+
+func TestLoadConfigsReadsMFAProviderSettings(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, `
+[profile dev]
+actool_mfa_provider = process
+actool_mfa_process = ykman oath accounts code --single 'AWS:dev'
+actool_mfa_askpass = /usr/libexec/ssh-askpass
+`)
+
+	configs, err := p.loadConfigs()
+	assert.NilError(t, err)
+	assert.Equal(t, len(configs), 1)
+	assert.Equal(t, configs[0].MFAProvider, "process")
+	assert.Equal(t, configs[0].MFAProcess, "ykman oath accounts code --single 'AWS:dev'")
+	assert.Equal(t, configs[0].MFAAskpass, "/usr/libexec/ssh-askpass")
+}
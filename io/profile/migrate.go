@@ -0,0 +1,441 @@
+package profile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Migration sources.
+const (
+	MigrationSourceCredentialsFile = "credentials-file"
+	MigrationSourceActoolKeyring   = "actool-keyring"
+)
+
+// MigrationConflict marks an entry whose profile already holds a different
+// credential in the aws-vault keyring.
+const MigrationConflict = "conflict"
+
+// Conflict resolution strategies.
+const (
+	// MigrateKeep leaves the stored credential and drops the imported one.
+	MigrateKeep = "keep"
+	// MigrateOverwrite replaces the stored credential.
+	MigrateOverwrite = "overwrite"
+	// MigrateRename stores the imported credential under a new name.
+	MigrateRename = "rename"
+)
+
+// MigrationStrategies lists the conflict resolution strategies.
+var MigrationStrategies = []string{MigrateKeep, MigrateOverwrite, MigrateRename}
+
+// MigrationEntry is one credential found in a legacy source. It never holds
+// the secret key.
+type MigrationEntry struct {
+	Source      string
+	Profile     string
+	AccessKeyID string
+	// Status is ImportNew, ImportUnchanged or MigrationConflict.
+	Status string
+	// ExistingAccessKeyID is the stored access key of a conflict.
+	ExistingAccessKeyID string
+}
+
+// MigrationResolution says what to do with a conflicting entry.
+type MigrationResolution struct {
+	Strategy string
+	// NewName is the profile name for MigrateRename.
+	NewName string
+}
+
+// MigrationPlan is what actool migrate found in ~/.aws/credentials and the
+// old actool keyring service. The credentials file wins when both hold the
+// same profile, as it does when Load migrates implicitly.
+type MigrationPlan struct {
+	Entries []MigrationEntry
+	// CredentialsFile is the legacy credentials file, when it exists.
+	CredentialsFile string
+
+	credentials      map[string]*Credential
+	credentialsHash  string
+	suggestedProfile string
+	legacySelected   string
+	legacyStore      bool
+	// stored fingerprints the keyring value each entry was compared with,
+	// empty when there was none.
+	stored map[string]string
+}
+
+// Conflicts returns the profiles that need a resolution.
+func (p *MigrationPlan) Conflicts() []string {
+	var conflicts []string
+	for _, entry := range p.Entries {
+		if entry.Status == MigrationConflict {
+			conflicts = append(conflicts, entry.Profile)
+		}
+	}
+	return conflicts
+}
+
+// Empty reports whether there is nothing to migrate.
+func (p *MigrationPlan) Empty() bool {
+	return len(p.Entries) == 0 && p.CredentialsFile == "" && !p.legacyStore
+}
+
+// MigrationOptions controls how a migration plan is applied.
+type MigrationOptions struct {
+	// Resolutions maps each conflicting profile to its resolution.
+	Resolutions map[string]MigrationResolution
+	// DeleteCredentialsFile removes the legacy credentials file instead of
+	// moving it to a .actool-backup file.
+	DeleteCredentialsFile bool
+}
+
+// MigrationResult lists what actool migrate changed.
+type MigrationResult struct {
+	// Stored are the profiles written to the keyring, under their final
+	// names.
+	Stored []string
+	Kept   []string
+	// Renamed maps a legacy profile name to the name it was stored under.
+	Renamed map[string]string
+	// CredentialsFileBackup is where the credentials file was moved, or
+	// empty when it was deleted or did not exist.
+	CredentialsFileBackup  string
+	CredentialsFileDeleted bool
+}
+
+// PlanMigration reads the legacy sources and compares them with the
+// keyring without changing anything.
+func (p *profile) PlanMigration() (*MigrationPlan, error) {
+	plan := &MigrationPlan{credentials: make(map[string]*Credential), stored: make(map[string]string)}
+	sources := make(map[string]string)
+
+	state, err := p.loadState()
+	if err != nil && !errors.Is(err, errStateNotFound) {
+		return nil, err
+	}
+	if !state.LegacyStoreMigrated {
+		legacyCredentials, selectedProfile, _, err := p.readLegacyActoolStore()
+		if err != nil {
+			return nil, err
+		}
+		plan.legacyStore = len(legacyCredentials) > 0
+		plan.legacySelected = selectedProfile
+		for name, credential := range legacyCredentials {
+			plan.credentials[name] = credential
+			sources[name] = MigrationSourceActoolKeyring
+		}
+	}
+
+	data, err := p.readFile(p.legacyCredentialsPath)
+	switch {
+	case err == nil:
+		fileCredentials, suggestedProfile, err := parseLegacyCredentials(data)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", p.legacyCredentialsPath, err)
+		}
+		plan.CredentialsFile = p.legacyCredentialsPath
+		plan.credentialsHash = fingerprintLegacyCredentials(data)
+		plan.suggestedProfile = suggestedProfile
+		for name, credential := range fileCredentials {
+			plan.credentials[name] = credential
+			sources[name] = MigrationSourceCredentialsFile
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	names := make([]string, 0, len(plan.credentials))
+	for name := range plan.credentials {
+		names = append(names, name)
+	}
+	sortProfileNames(names)
+	for _, name := range names {
+		credential := plan.credentials[name]
+		entry := MigrationEntry{Source: sources[name], Profile: name, AccessKeyID: credential.AccessKey, Status: ImportNew}
+		stored, err := p.secrets.Get(name)
+		switch {
+		case err == nil:
+			entry.Status = MigrationConflict
+			plan.stored[name] = fingerprintSecret(stored)
+			if existing, decodeErr := decodeCredential(stored, name); decodeErr == nil {
+				entry.ExistingAccessKeyID = existing.AccessKey
+				if credentialsEqual(existing, credential) {
+					entry.Status = ImportUnchanged
+				}
+			}
+		case !errors.Is(err, errSecretNotFound):
+			return nil, err
+		}
+		plan.Entries = append(plan.Entries, entry)
+	}
+	return plan, nil
+}
+
+// ApplyMigration stores the plan with the given resolutions, updates the AWS
+// config and state.json, and moves the credentials file out of the AWS CLI's
+// way. Either every step succeeds or the keyring, config and state are put
+// back the way they were. Every conflict needs a resolution.
+func (p *profile) ApplyMigration(plan *MigrationPlan, options MigrationOptions) (*MigrationResult, error) {
+	if plan == nil || plan.credentials == nil {
+		return nil, errors.New("migration plan is empty")
+	}
+	result := &MigrationResult{Renamed: make(map[string]string)}
+	err := p.withLock(func() error {
+		if err := p.checkMigrationPlan(plan); err != nil {
+			return err
+		}
+		target, err := p.resolveMigration(plan, options.Resolutions, result)
+		if err != nil {
+			return err
+		}
+		return p.applyMigration(plan, target, options, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkMigrationPlan fails when the keyring, the credentials file or the
+// old actool keyring service changed since the plan was made, as the plan
+// would then overwrite what it did not show.
+func (p *profile) checkMigrationPlan(plan *MigrationPlan) error {
+	stale := func(what string) error {
+		return fmt.Errorf("%s changed since the migration was planned; the plan is stale, run actool migrate again", what)
+	}
+	for _, entry := range plan.Entries {
+		stored, err := p.secrets.Get(entry.Profile)
+		fingerprint := ""
+		switch {
+		case err == nil:
+			fingerprint = fingerprintSecret(stored)
+		case !errors.Is(err, errSecretNotFound):
+			return err
+		}
+		if fingerprint != plan.stored[entry.Profile] {
+			return stale(fmt.Sprintf("the stored credential of %q", entry.Profile))
+		}
+	}
+
+	data, err := p.readFile(p.legacyCredentialsPath)
+	switch {
+	case err == nil:
+		if plan.CredentialsFile == "" || fingerprintLegacyCredentials(data) != plan.credentialsHash {
+			return stale(p.legacyCredentialsPath)
+		}
+	case os.IsNotExist(err):
+		if plan.CredentialsFile != "" {
+			return stale(p.legacyCredentialsPath)
+		}
+	default:
+		return err
+	}
+
+	if plan.legacyStore {
+		state, err := p.loadState()
+		if err != nil && !errors.Is(err, errStateNotFound) {
+			return err
+		}
+		if state.LegacyStoreMigrated {
+			return stale("the old actool keyring service")
+		}
+	}
+	return nil
+}
+
+func fingerprintSecret(value []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(value))
+}
+
+// resolveMigration turns the plan into the credentials to store, keyed by
+// their final profile names.
+func (p *profile) resolveMigration(plan *MigrationPlan, resolutions map[string]MigrationResolution, result *MigrationResult) (map[string]*Credential, error) {
+	conflicts := make(map[string]bool)
+	for _, name := range plan.Conflicts() {
+		conflicts[name] = true
+	}
+	for name := range resolutions {
+		if !conflicts[name] {
+			return nil, fmt.Errorf("profile %q has no conflict to resolve", name)
+		}
+	}
+
+	target := make(map[string]*Credential)
+	for _, entry := range plan.Entries {
+		credential := plan.credentials[entry.Profile]
+		switch entry.Status {
+		case ImportUnchanged:
+			continue
+		case ImportNew:
+			target[entry.Profile] = credential
+			result.Stored = append(result.Stored, entry.Profile)
+			continue
+		}
+
+		resolution, ok := resolutions[entry.Profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q conflicts with an existing aws-vault credential; choose %s", entry.Profile, strings.Join(MigrationStrategies, ", "))
+		}
+		switch resolution.Strategy {
+		case MigrateKeep:
+			result.Kept = append(result.Kept, entry.Profile)
+		case MigrateOverwrite:
+			target[entry.Profile] = credential
+			result.Stored = append(result.Stored, entry.Profile)
+		case MigrateRename:
+			newName := strings.TrimSpace(resolution.NewName)
+			if err := validateProfileName(newName); err != nil {
+				return nil, fmt.Errorf("new name for %q: %w", entry.Profile, err)
+			}
+			renamed := *credential
+			renamed.Name = newName
+			target[newName] = &renamed
+			result.Renamed[entry.Profile] = newName
+			result.Stored = append(result.Stored, newName)
+		default:
+			return nil, fmt.Errorf("unknown strategy %q for %q; use one of %s", resolution.Strategy, entry.Profile, strings.Join(MigrationStrategies, ", "))
+		}
+	}
+
+	// A new name must not collide with a stored credential or with another
+	// imported one.
+	renamedTo := make(map[string]bool)
+	for from, to := range result.Renamed {
+		if renamedTo[to] {
+			return nil, fmt.Errorf("more than one profile is renamed to %q", to)
+		}
+		renamedTo[to] = true
+		if _, ok := plan.credentials[to]; ok {
+			return nil, fmt.Errorf("cannot rename %q to %q: the migration already imports %q", from, to, to)
+		}
+		if _, err := p.secrets.Get(to); err == nil {
+			return nil, fmt.Errorf("cannot rename %q to %q: a credential with that name is already stored", from, to)
+		} else if !errors.Is(err, errSecretNotFound) {
+			return nil, err
+		}
+	}
+	sortProfileNames(result.Stored)
+	sort.Strings(result.Kept)
+	return target, nil
+}
+
+func (p *profile) applyMigration(plan *MigrationPlan, target map[string]*Credential, options MigrationOptions, result *MigrationResult) error {
+	names := make([]string, 0, len(target))
+	var previous []secretPreviousValue
+	for name := range target {
+		value, err := p.secrets.Get(name)
+		if err != nil && !errors.Is(err, errSecretNotFound) {
+			return err
+		}
+		names = append(names, name)
+		previous = append(previous, secretPreviousValue{key: name, value: value, exists: err == nil})
+	}
+	sort.Strings(names)
+	configBefore, configErr := p.readFile(p.configPath)
+	if configErr != nil && !os.IsNotExist(configErr) {
+		return configErr
+	}
+	stateBefore, stateErr := p.loadState()
+	if stateErr != nil && !errors.Is(stateErr, errStateNotFound) {
+		return stateErr
+	}
+
+	if err := p.importCredentialPlanWithOptions(target, true); err != nil {
+		return err
+	}
+	rollback := func(err error) error {
+		var rollbackErrs []error
+		if rollbackErr := p.rollbackSecretChanges(previous, names); rollbackErr != nil {
+			rollbackErrs = append(rollbackErrs, rollbackErr)
+		}
+		if configErr == nil {
+			if rollbackErr := p.writeConfig(configBefore); rollbackErr != nil {
+				rollbackErrs = append(rollbackErrs, rollbackErr)
+			}
+		} else if rollbackErr := p.removeFile(p.configPath); rollbackErr != nil && !os.IsNotExist(rollbackErr) {
+			rollbackErrs = append(rollbackErrs, rollbackErr)
+		}
+		if stateErr != nil && p.statePath != "" {
+			if rollbackErr := p.removeFile(p.statePath); rollbackErr != nil && !os.IsNotExist(rollbackErr) {
+				rollbackErrs = append(rollbackErrs, rollbackErr)
+			}
+		} else if p.state != nil {
+			if rollbackErr := p.state.Save(stateBefore); rollbackErr != nil {
+				rollbackErrs = append(rollbackErrs, rollbackErr)
+			}
+		}
+		if len(rollbackErrs) > 0 {
+			return fmt.Errorf("migration failed and rollback also failed: %w", errors.Join(append([]error{err}, rollbackErrs...)...))
+		}
+		return fmt.Errorf("migration failed and was rolled back: %w", err)
+	}
+
+	profileNames, err := p.profileNames()
+	if err != nil {
+		return rollback(err)
+	}
+	guards, err := p.profileGuards(profileNames)
+	if err != nil {
+		return rollback(err)
+	}
+	// Like Load, the migration only selects a profile on its own when it is
+	// neither restricted nor production.
+	selectable := unattendedProfiles(profileNames, guards)
+	state := stateBefore
+	selectedProfile := ""
+	if guards[state.SelectedProfile].Restricted || !containsProfile(profileNames, state.SelectedProfile) {
+		for _, candidate := range []string{plan.suggestedProfile, plan.legacySelected} {
+			if renamed, ok := result.Renamed[candidate]; ok {
+				candidate = renamed
+			}
+			if containsProfile(selectable, candidate) {
+				selectedProfile = candidate
+				break
+			}
+		}
+		if selectedProfile == "" {
+			selectedProfile = defaultSelectedProfile(selectable)
+		}
+		state.SelectedProfile = selectedProfile
+	}
+	if err := p.syncConfig(selectedProfile, profileNames, false); err != nil {
+		return rollback(err)
+	}
+	if plan.legacyStore {
+		state.LegacyStoreMigrated = true
+	}
+	if plan.CredentialsFile != "" {
+		state.LegacyCredentialsHash = plan.credentialsHash
+		state.LegacyCleanupPending = false
+	}
+	if err := p.saveState(state); err != nil {
+		return rollback(err)
+	}
+
+	// Moving the credentials file comes last: it is the one step that
+	// cannot be rolled back once it succeeds.
+	if plan.CredentialsFile == "" {
+		return nil
+	}
+	if options.DeleteCredentialsFile {
+		if err := p.removeFile(plan.CredentialsFile); err != nil {
+			return rollback(err)
+		}
+		result.CredentialsFileDeleted = true
+		return nil
+	}
+	backupPath, err := legacyBackupPath(plan.CredentialsFile)
+	if err != nil {
+		return rollback(err)
+	}
+	if err := p.backupLegacyCredentials(plan.CredentialsFile); err != nil {
+		return rollback(err)
+	}
+	result.CredentialsFileBackup = backupPath
+	return nil
+}
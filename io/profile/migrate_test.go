@@ -0,0 +1,269 @@
+package profile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// newMigrationTestProfile stores dev in the keyring and puts a different
+// dev plus stage in the old actool keyring service and prod in the
+// credentials file.
+func newMigrationTestProfile(t *testing.T) (*profile, *fakeSecretStore) {
+	t.Helper()
+	dir := t.TempDir()
+	store := newFakeSecretStore()
+	legacyStore := newFakeSecretStore()
+	for key, accessKey := range map[string]string{
+		secretKey(baseCredentialKeyPrefix, "dev"):   "LEGACYDEVKEY",
+		secretKey(baseCredentialKeyPrefix, "stage"): "LEGACYSTAGEKEY",
+	} {
+		data, err := json.Marshal(map[string]string{"AccessKeyID": accessKey, "SecretAccessKey": accessKey + "SECRET"})
+		assert.NilError(t, err)
+		assert.NilError(t, legacyStore.Set(key, data))
+	}
+	assert.NilError(t, legacyStore.Set(selectedProfileKey, []byte("stage")))
+
+	p := newConfiguredProfile(filepath.Join(dir, "config"), filepath.Join(dir, "credentials"), "actool", store, &memoryStateStore{}, func() (secretStore, error) {
+		return legacyStore, nil
+	}, nil)
+	storeBaseCredential(t, p, "dev", "CURRENTDEVKEY", "CURRENTDEVSECRET", nil)
+	writeTestFile(t, p.legacyCredentialsPath, "[prod]\naws_access_key_id = PRODKEY\naws_secret_access_key = PRODSECRET\n")
+	return p, store
+}
+
+func TestPlanMigration(t *testing.T) {
+	p, _ := newMigrationTestProfile(t)
+
+	plan, err := p.PlanMigration()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, plan.Entries, []MigrationEntry{
+		{Source: MigrationSourceActoolKeyring, Profile: "dev", AccessKeyID: "LEGACYDEVKEY", Status: MigrationConflict, ExistingAccessKeyID: "CURRENTDEVKEY"},
+		{Source: MigrationSourceCredentialsFile, Profile: "prod", AccessKeyID: "PRODKEY", Status: ImportNew},
+		{Source: MigrationSourceActoolKeyring, Profile: "stage", AccessKeyID: "LEGACYSTAGEKEY", Status: ImportNew},
+	})
+	assert.DeepEqual(t, plan.Conflicts(), []string{"dev"})
+	assert.Equal(t, plan.CredentialsFile, p.legacyCredentialsPath)
+
+	// Load still refuses the conflict and points at actool migrate.
+	p, _ = newMigrationTestProfile(t)
+	_, err = p.Load()
+	assert.ErrorContains(t, err, "run actool migrate to resolve it")
+}
+
+func TestApplyMigrationResolutions(t *testing.T) {
+	cases := []struct {
+		name        string
+		resolutions map[string]MigrationResolution
+		wantDev     string
+		wantStored  []string
+		wantErr     string
+	}{
+		{
+			name:        "keep",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateKeep}},
+			wantDev:     "CURRENTDEVKEY",
+			wantStored:  []string{"prod", "stage"},
+		},
+		{
+			name:        "overwrite",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateOverwrite}},
+			wantDev:     "LEGACYDEVKEY",
+			wantStored:  []string{"dev", "prod", "stage"},
+		},
+		{
+			name:        "rename",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateRename, NewName: "dev-legacy"}},
+			wantDev:     "CURRENTDEVKEY",
+			wantStored:  []string{"dev-legacy", "prod", "stage"},
+		},
+		{name: "unresolved", wantErr: `profile "dev" conflicts with an existing aws-vault credential`},
+		{
+			name:        "rename onto an imported profile",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateRename, NewName: "prod"}},
+			wantErr:     `the migration already imports "prod"`,
+		},
+		{
+			name:        "rename onto itself",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateRename, NewName: "dev"}},
+			wantErr:     "already imports",
+		},
+		{
+			name:        "resolution without conflict",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateKeep}, "prod": {Strategy: MigrateKeep}},
+			wantErr:     `profile "prod" has no conflict to resolve`,
+		},
+		{
+			name:        "unknown strategy",
+			resolutions: map[string]MigrationResolution{"dev": {Strategy: "merge"}},
+			wantErr:     `unknown strategy "merge"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, store := newMigrationTestProfile(t)
+			legacyStoreFactory := p.legacyStoreFactory
+			plan, err := p.PlanMigration()
+			assert.NilError(t, err)
+
+			result, err := p.ApplyMigration(plan, MigrationOptions{Resolutions: tc.resolutions})
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				keys, keysErr := store.Keys()
+				assert.NilError(t, keysErr)
+				assert.DeepEqual(t, keys, []string{"dev"})
+				_, statErr := os.Stat(p.legacyCredentialsPath)
+				assert.NilError(t, statErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, result.Stored, tc.wantStored)
+			dev, err := p.baseCredential("dev")
+			assert.NilError(t, err)
+			assert.Equal(t, dev.AccessKey, tc.wantDev)
+
+			_, err = os.Stat(p.legacyCredentialsPath)
+			assert.Assert(t, os.IsNotExist(err))
+			assert.Equal(t, result.CredentialsFileBackup, p.legacyCredentialsPath+".actool-backup")
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.Assert(t, state.LegacyStoreMigrated)
+			assert.Equal(t, state.SelectedProfile, "stage")
+
+			// The resolved store is no longer migrated on Load.
+			p.legacyStoreFactory = legacyStoreFactory
+			p.legacyStoreLoaded = false
+			_, err = p.Load()
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestApplyMigrationRefusesStalePlan(t *testing.T) {
+	cases := []struct {
+		name    string
+		change  func(t *testing.T, p *profile)
+		wantErr string
+	}{
+		{
+			name: "conflict changed",
+			change: func(t *testing.T, p *profile) {
+				storeBaseCredential(t, p, "dev", "CURRENTDEVKEY", "ROTATEDSECRET", nil)
+			},
+			wantErr: `the stored credential of "dev" changed`,
+		},
+		{
+			name: "new profile stored meanwhile",
+			change: func(t *testing.T, p *profile) {
+				storeBaseCredential(t, p, "prod", "OTHERPRODKEY", "OTHERPRODSECRET", nil)
+			},
+			wantErr: `the stored credential of "prod" changed`,
+		},
+		{
+			name: "credentials file edited",
+			change: func(t *testing.T, p *profile) {
+				writeTestFile(t, p.legacyCredentialsPath, "[prod]\naws_access_key_id = NEWPRODKEY\naws_secret_access_key = NEWPRODSECRET\n")
+			},
+			wantErr: "credentials changed",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, store := newMigrationTestProfile(t)
+			plan, err := p.PlanMigration()
+			assert.NilError(t, err)
+			tc.change(t, p)
+			before := make(map[string]string, len(store.values))
+			for key, value := range store.values {
+				before[key] = string(value)
+			}
+
+			_, err = p.ApplyMigration(plan, MigrationOptions{Resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateOverwrite}}})
+			assert.ErrorContains(t, err, tc.wantErr)
+			assert.ErrorContains(t, err, "the plan is stale, run actool migrate again")
+			after := make(map[string]string, len(store.values))
+			for key, value := range store.values {
+				after[key] = string(value)
+			}
+			assert.DeepEqual(t, after, before)
+			_, err = os.Stat(p.legacyCredentialsPath)
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestApplyMigrationRollsBack(t *testing.T) {
+	p, store := newMigrationTestProfile(t)
+	config := "[default]\naws_access_key_id = STATICKEY\naws_secret_access_key = STATICSECRET\n"
+	writeTestFile(t, p.configPath, config)
+	plan, err := p.PlanMigration()
+	assert.NilError(t, err)
+
+	_, err = p.ApplyMigration(plan, MigrationOptions{Resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateOverwrite}}})
+	assert.ErrorContains(t, err, "migration failed and was rolled back")
+	assert.ErrorContains(t, err, "default profile contains static credentials")
+
+	keys, err := store.Keys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev"})
+	dev, err := p.baseCredential("dev")
+	assert.NilError(t, err)
+	assert.Equal(t, dev.AccessKey, "CURRENTDEVKEY")
+	data, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), config)
+	data, err = os.ReadFile(p.legacyCredentialsPath)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "PRODKEY"))
+	state, err := p.loadState()
+	assert.NilError(t, err)
+	assert.Assert(t, !state.LegacyStoreMigrated)
+}
+
+func TestApplyMigrationSkipsGuardedProfiles(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "legacy selection", want: "stage"},
+		{name: "restricted legacy selection", config: "[profile stage]\nactool_restricted = true\n", want: "dev"},
+		{
+			name:   "production profiles",
+			config: "[profile stage]\nactool_restricted = true\n[profile dev]\nactool_environment = production\n",
+			want:   "prod",
+		},
+		{
+			name:   "nothing to select",
+			config: "[profile stage]\nactool_restricted = true\n[profile dev]\nactool_environment = production\n[profile prod]\nactool_environment = production\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(ProductionPatternEnv, "")
+			t.Setenv(RestrictProductionEnv, "")
+			p, _ := newMigrationTestProfile(t)
+			writeTestFile(t, p.configPath, tc.config)
+			plan, err := p.PlanMigration()
+			assert.NilError(t, err)
+
+			_, err = p.ApplyMigration(plan, MigrationOptions{Resolutions: map[string]MigrationResolution{"dev": {Strategy: MigrateKeep}}})
+			assert.NilError(t, err)
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.Equal(t, state.SelectedProfile, tc.want)
+			want := ""
+			if tc.want != "" {
+				want = "actool credential-process --profile " + tc.want
+			}
+			assert.Equal(t, keyValue(loadTestConfig(t, p).Section(Default), CredentialProcess), want)
+		})
+	}
+}
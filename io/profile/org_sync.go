@@ -0,0 +1,247 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/org"
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const (
+	// OrgAccountID marks a profile section written by actool org sync and
+	// holds the account it was generated for.
+	OrgAccountID = "actool_org_account_id"
+
+	// DefaultOrgNameTemplate names generated profiles after the account.
+	DefaultOrgNameTemplate = "{{.Name}}"
+)
+
+// OrgSyncOptions configure actool org sync.
+type OrgSyncOptions struct {
+	// SourceProfile signs the ListAccounts call and becomes the
+	// source_profile of every generated profile.
+	SourceProfile string
+	// RoleName is the role assumed in each account.
+	RoleName string
+	// NameTemplate is a text/template for the profile name. It sees the
+	// account's .Name, .ID and .Email and the .RoleName.
+	NameTemplate string
+	// Region is written to the generated profiles. Empty uses the source
+	// profile's region.
+	Region string
+	// Endpoint replaces the Organizations endpoint.
+	Endpoint string
+	// Prune removes generated profiles whose account is gone or no longer
+	// active.
+	Prune bool
+}
+
+// OrgSyncResult lists the profiles actool org sync changed.
+type OrgSyncResult struct {
+	Created   []string
+	Updated   []string
+	Unchanged []string
+	// Renamed maps the old name of a generated profile to its new one.
+	Renamed map[string]string
+	Pruned  []string
+	// Stale are generated profiles that Prune would have removed.
+	Stale []string
+}
+
+// orgNameData is what the name template sees.
+type orgNameData struct {
+	ID       string
+	Name     string
+	Email    string
+	RoleName string
+}
+
+func newOrgService(credential *Credential, options org.Options) org.Service {
+	return org.NewService(credential.AccessKey, credential.SecretKey, credential.SessionToken, options)
+}
+
+// SyncOrganization writes a role profile for every active account of the
+// organization that SourceProfile belongs to. The profiles are marked with
+// OrgAccountID, so that a later sync with the same source profile and role
+// updates, renames or prunes them. A section that already exists is only
+// taken over when it assumes the same role.
+func (p *profile) SyncOrganization(ctx context.Context, options OrgSyncOptions) (*OrgSyncResult, error) {
+	options.SourceProfile = strings.TrimSpace(options.SourceProfile)
+	options.RoleName = strings.TrimSpace(options.RoleName)
+	if err := validateProfileName(options.SourceProfile); err != nil {
+		return nil, fmt.Errorf("source profile: %w", err)
+	}
+	if options.RoleName == "" {
+		return nil, errors.New("role name is empty")
+	}
+	if strings.TrimSpace(options.NameTemplate) == "" {
+		options.NameTemplate = DefaultOrgNameTemplate
+	}
+	nameTemplate, err := template.New("name").Option("missingkey=error").Parse(options.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	region := strings.TrimSpace(options.Region)
+	if region == "" {
+		if section, err := cfg.GetSection(profileSectionName(options.SourceProfile)); err == nil {
+			region = strings.TrimSpace(keyValue(section, Region))
+		}
+	}
+	credential, _, err := p.resolveCredential(ctx, options.SourceProfile)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := p.orgService(credential, org.Options{Region: region, Endpoint: options.Endpoint}).ListAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	partitionRegion := region
+	if partitionRegion == "" {
+		partitionRegion = org.DefaultRegion
+	}
+	partition := sts.PartitionForRegion(partitionRegion)
+
+	result := &OrgSyncResult{Renamed: make(map[string]string)}
+	err = p.withLock(func() error {
+		cfg, err := p.loadConfigFile()
+		if err != nil {
+			return err
+		}
+		changed, err := syncOrgProfiles(cfg, accounts, nameTemplate, partition, region, options, result)
+		if err != nil || !changed {
+			return err
+		}
+		return p.saveConfig(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func syncOrgProfiles(cfg *ini.File, accounts []org.Account, nameTemplate *template.Template, partition, region string, options OrgSyncOptions, result *OrgSyncResult) (bool, error) {
+	// Generated sections of this source profile and role, by account.
+	generated := make(map[string]*ini.Section)
+	for _, section := range cfg.Sections() {
+		accountID := keyValue(section, OrgAccountID)
+		if accountID == "" || keyValue(section, SourceProfile) != options.SourceProfile || roleFromARN(keyValue(section, RoleARN)) != options.RoleName {
+			continue
+		}
+		generated[accountID] = section
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	changed := false
+	active := make(map[string]bool)
+	names := make(map[string]string)
+	for _, account := range accounts {
+		if account.Status != org.StatusActive {
+			continue
+		}
+		active[account.ID] = true
+		var buffer bytes.Buffer
+		if err := nameTemplate.Execute(&buffer, orgNameData{ID: account.ID, Name: account.Name, Email: account.Email, RoleName: options.RoleName}); err != nil {
+			return false, fmt.Errorf("name template for account %s: %w", account.ID, err)
+		}
+		name := strings.TrimSpace(buffer.String())
+		if err := validateProfileName(name); err != nil {
+			return false, fmt.Errorf("name template for account %s: %w", account.ID, err)
+		}
+		if name == Default || name == options.SourceProfile {
+			return false, fmt.Errorf("name template for account %s gives %q, which actool does not overwrite", account.ID, name)
+		}
+		if other, ok := names[name]; ok {
+			return false, fmt.Errorf("accounts %s and %s both map to profile %q; use a --name-template with {{.ID}}", other, account.ID, name)
+		}
+		names[name] = account.ID
+
+		roleARN := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account.ID, options.RoleName)
+		sectionName := profileSectionName(name)
+		section := generated[account.ID]
+		created, renamed := false, false
+		switch {
+		case section != nil && section.Name() != sectionName:
+			if _, err := cfg.GetSection(sectionName); err == nil {
+				return false, fmt.Errorf("cannot rename [%s] to [%s]: the section already exists", section.Name(), sectionName)
+			}
+			newSection, err := cfg.NewSection(sectionName)
+			if err != nil {
+				return false, err
+			}
+			for _, key := range section.Keys() {
+				_, _ = newSection.NewKey(key.Name(), key.Value())
+			}
+			oldName, _ := configProfileName(section.Name())
+			cfg.DeleteSection(section.Name())
+			result.Renamed[oldName] = name
+			section = newSection
+			renamed = true
+			changed = true
+		case section == nil:
+			existing, err := cfg.GetSection(sectionName)
+			if err == nil {
+				if keyValue(existing, RoleARN) != roleARN {
+					return false, fmt.Errorf("[%s] already exists and does not assume %s; actool did not overwrite it", sectionName, roleARN)
+				}
+				section = existing
+			} else {
+				section, err = cfg.NewSection(sectionName)
+				if err != nil {
+					return false, err
+				}
+				created = true
+			}
+		}
+
+		updated := ensureKey(section, RoleARN, roleARN)
+		updated = ensureKey(section, SourceProfile, options.SourceProfile) || updated
+		updated = ensureKey(section, Region, region) || updated
+		updated = ensureKey(section, OrgAccountID, account.ID) || updated
+		changed = changed || updated
+		switch {
+		case created:
+			result.Created = append(result.Created, name)
+		case updated:
+			result.Updated = append(result.Updated, name)
+		case !renamed:
+			result.Unchanged = append(result.Unchanged, name)
+		}
+	}
+
+	for accountID, section := range generated {
+		if active[accountID] {
+			continue
+		}
+		name, _ := configProfileName(section.Name())
+		if !options.Prune {
+			result.Stale = append(result.Stale, name)
+			continue
+		}
+		cfg.DeleteSection(section.Name())
+		result.Pruned = append(result.Pruned, name)
+		changed = true
+	}
+	for _, list := range [][]string{result.Created, result.Updated, result.Unchanged, result.Pruned, result.Stale} {
+		sortProfileNames(list)
+	}
+	return changed, nil
+}
+
+// roleFromARN returns the role name of an IAM role ARN, with its path.
+func roleFromARN(roleARN string) string {
+	_, role, _ := strings.Cut(roleARN, ":role/")
+	return role
+}
@@ -0,0 +1,176 @@
+package profile
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/org"
+)
+
+type fakeOrgService struct {
+	accounts []org.Account
+}
+
+func (f *fakeOrgService) ListAccounts(context.Context) ([]org.Account, error) {
+	return f.accounts, nil
+}
+
+func newOrgSyncTestProfile(t *testing.T, service *fakeOrgService) (*profile, *[]org.Options) {
+	t.Helper()
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "management", "MGMTACCESSKEY", "MGMTSECRETKEY", nil)
+	writeTestFile(t, p.configPath, "[profile management]\nregion = eu-west-1\n")
+	var calls []org.Options
+	p.orgService = func(credential *Credential, options org.Options) org.Service {
+		assert.Equal(t, credential.AccessKey, "MGMTACCESSKEY")
+		calls = append(calls, options)
+		return service
+	}
+	return p, &calls
+}
+
+func loadTestConfig(t *testing.T, p *profile) *ini.File {
+	t.Helper()
+	data, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	cfg, err := ini.LoadSources(configLoadOptions(), data)
+	assert.NilError(t, err)
+	return cfg
+}
+
+func TestSyncOrganization(t *testing.T) {
+	service := &fakeOrgService{accounts: []org.Account{
+		{ID: "111111111111", Name: "Dev", Status: org.StatusActive},
+		{ID: "222222222222", Name: "Prod", Status: org.StatusActive},
+		{ID: "333333333333", Name: "Closed", Status: "SUSPENDED"},
+	}}
+	p, calls := newOrgSyncTestProfile(t, service)
+	options := OrgSyncOptions{SourceProfile: "management", RoleName: "OrganizationAccountAccessRole", Endpoint: "http://127.0.0.1:9"}
+
+	result, err := p.SyncOrganization(context.Background(), options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Created, []string{"Dev", "Prod"})
+	assert.DeepEqual(t, *calls, []org.Options{{Region: "eu-west-1", Endpoint: "http://127.0.0.1:9"}})
+	cfg := loadTestConfig(t, p)
+	dev, err := cfg.GetSection("profile Dev")
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(dev, RoleARN), "arn:aws:iam::111111111111:role/OrganizationAccountAccessRole")
+	assert.Equal(t, keyValue(dev, SourceProfile), "management")
+	assert.Equal(t, keyValue(dev, Region), "eu-west-1")
+	assert.Equal(t, keyValue(dev, OrgAccountID), "111111111111")
+	_, err = cfg.GetSection("profile Closed")
+	assert.Assert(t, err != nil)
+
+	// Renamed accounts are renamed in place, keeping keys added by hand.
+	dev.Key(Output).SetValue("json")
+	original, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	data, err := patchConfig(original, cfg)
+	assert.NilError(t, err)
+	writeTestFile(t, p.configPath, string(data))
+	service.accounts[0].Name = "Development"
+	result, err = p.SyncOrganization(context.Background(), options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Renamed, map[string]string{"Dev": "Development"})
+	assert.DeepEqual(t, result.Unchanged, []string{"Prod"})
+	cfg = loadTestConfig(t, p)
+	development, err := cfg.GetSection("profile Development")
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(development, Output), "json")
+	_, err = cfg.GetSection("profile Dev")
+	assert.Assert(t, err != nil)
+
+	// Accounts that left are reported, and removed with Prune.
+	service.accounts = service.accounts[:1]
+	result, err = p.SyncOrganization(context.Background(), options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Stale, []string{"Prod"})
+	_, err = loadTestConfig(t, p).GetSection("profile Prod")
+	assert.NilError(t, err)
+
+	options.Prune = true
+	result, err = p.SyncOrganization(context.Background(), options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Pruned, []string{"Prod"})
+	_, err = loadTestConfig(t, p).GetSection("profile Prod")
+	assert.Assert(t, err != nil)
+
+	// A sync for another role leaves these profiles alone.
+	options.RoleName = "ReadOnly"
+	options.NameTemplate = "{{.Name}}-readonly"
+	result, err = p.SyncOrganization(context.Background(), options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Created, []string{"Development-readonly"})
+	assert.Assert(t, result.Pruned == nil)
+}
+
+func TestSyncOrganizationRefusesConflicts(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		template string
+		accounts []org.Account
+		wantErr  string
+	}{
+		{
+			name:     "hand-written section with another role",
+			config:   "[profile Dev]\nrole_arn = arn:aws:iam::111111111111:role/Other\n",
+			accounts: []org.Account{{ID: "111111111111", Name: "Dev", Status: org.StatusActive}},
+			wantErr:  "[profile Dev] already exists and does not assume",
+		},
+		{
+			name:     "two accounts with one name",
+			accounts: []org.Account{{ID: "111111111111", Name: "Dev", Status: org.StatusActive}, {ID: "222222222222", Name: "Dev", Status: org.StatusActive}},
+			wantErr:  `both map to profile "Dev"`,
+		},
+		{
+			name:     "source profile",
+			template: "management",
+			accounts: []org.Account{{ID: "111111111111", Name: "Dev", Status: org.StatusActive}},
+			wantErr:  `gives "management", which actool does not overwrite`,
+		},
+		{
+			name:     "unknown template field",
+			template: "{{.Alias}}",
+			accounts: []org.Account{{ID: "111111111111", Name: "Dev", Status: org.StatusActive}},
+			wantErr:  "name template for account 111111111111",
+		},
+		{
+			name:     "invalid profile name",
+			accounts: []org.Account{{ID: "111111111111", Name: "[Dev]", Status: org.StatusActive}},
+			wantErr:  "INI section delimiter",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := newOrgSyncTestProfile(t, &fakeOrgService{accounts: tc.accounts})
+			config := "[profile management]\nregion = eu-west-1\n" + tc.config
+			writeTestFile(t, p.configPath, config)
+
+			_, err := p.SyncOrganization(context.Background(), OrgSyncOptions{SourceProfile: "management", RoleName: "Admin", NameTemplate: tc.template})
+			assert.ErrorContains(t, err, tc.wantErr)
+			data, err := os.ReadFile(p.configPath)
+			assert.NilError(t, err)
+			assert.Equal(t, string(data), config)
+		})
+	}
+}
+
+func TestSyncOrganizationAdoptsMatchingSection(t *testing.T) {
+	p, _ := newOrgSyncTestProfile(t, &fakeOrgService{accounts: []org.Account{{ID: "111111111111", Name: "Dev", Status: org.StatusActive}}})
+	writeTestFile(t, p.configPath, "[profile management]\n[profile Dev]\nrole_arn = arn:aws:iam::111111111111:role/Admin\nsource_profile = management\n")
+
+	result, err := p.SyncOrganization(context.Background(), OrgSyncOptions{SourceProfile: "management", RoleName: "Admin", Region: "eu-central-1"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result.Updated, []string{"Dev"})
+	data, err := os.ReadFile(p.configPath)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "eu-central-1"), string(data))
+	assert.Assert(t, strings.Contains(string(data), "actool_org_account_id"), string(data))
+}
@@ -1,7 +1,7 @@
 package profile
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,6 +18,10 @@ import (
 
 	"github.com/99designs/keyring"
 	"gopkg.in/ini.v1"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/org"
+	"github.com/tomtwinkle/aws-credential-tool/io/sso"
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
 )
 
 const (
@@ -29,12 +34,46 @@ const (
 	Region                     = "region"
 	Output                     = "output"
 	CredentialProcess          = "credential_process"
+	EndpointURL                = "endpoint_url"
+	STSRegionalEndpoints       = "sts_regional_endpoints"
+	UseFIPSEndpoint            = "use_fips_endpoint"
+	UseDualStackEndpoint       = "use_dualstack_endpoint"
+	MFAProvider                = "actool_mfa_provider"
+	MFAProcess                 = "actool_mfa_process"
+	MFAAskpass                 = "actool_mfa_askpass"
+	SSOSession                 = "sso_session"
+	SSOStartURL                = "sso_start_url"
+	SSORegion                  = "sso_region"
+	SSOAccountID               = "sso_account_id"
+	SSORoleName                = "sso_role_name"
+	SSORegistrationScopes      = "sso_registration_scopes"
+	RoleARN                    = "role_arn"
+	SourceProfile              = "source_profile"
+	CredentialSource           = "credential_source"
+	ExternalID                 = "external_id"
+	RoleSessionName            = "role_session_name"
+	DurationSeconds            = "duration_seconds"
+	MFASerial                  = "mfa_serial"
+
+	// AWSProfileEnv selects the AWS profile for one shell.
+	AWSProfileEnv = "AWS_PROFILE"
 
 	defaultCommandName       = "actool"
 	awsVaultServiceName      = "aws-vault"
 	actoolServiceName        = "actool"
 	credentialProcessCommand = "credential-process"
 	sessionTypeGetSession    = "sts.GetSessionToken"
+	sessionTypeSSORole       = "sso.GetRoleCredentials"
+	sessionTypeAssumeRole    = "sts.AssumeRole"
+	ssoSessionSectionPrefix  = "sso-session "
+	ssoClientName            = "actool"
+	execBackend              = "exec"
+	vaultBackend             = "hashicorp-vault"
+
+	// aws-vault keeps IAM Identity Center tokens under oidc:<start URL>.
+	// actool also caches its OIDC client registration next to them.
+	oidcTokenKeyPrefix  = "oidc:"
+	oidcClientKeyPrefix = oidcTokenKeyPrefix + "client:"
 
 	// These prefixes are kept for importing the secure store format used by
 	// the previous implementation.
@@ -43,7 +82,11 @@ const (
 	selectedProfileKey       = "selected-profile"
 	legacyCredentialsHashKey = "legacy-credentials-hash"
 
-	stateVersion = 1
+	// Keys under this prefix hold actool metadata rather than profiles.
+	actoolKeyPrefix  = "actool/"
+	mfaSeedKeyPrefix = actoolKeyPrefix + "mfa-seed/"
+
+	stateVersion = 2
 )
 
 var (
@@ -58,8 +101,29 @@ type Profile interface {
 	Credential(profileName string) (*Credential, error)
 	Config(model *Model, profileName string) (*Config, error)
 	SetSelected(profileName string) error
+	SetSelectedWithOptions(profileName string, options SelectOptions) error
 	StoreSessionToken(profileName string, credential *Credential) error
-	CredentialProcessPayload(profileName string) ([]byte, error)
+	StoreSessionTokenWithOptions(profileName string, credential *Credential, options SelectOptions) error
+	CredentialProcessPayload(ctx context.Context, profileName string) ([]byte, error)
+	SSOLogin(ctx context.Context, profileName string, prompt SSODevicePrompt) error
+	SSOLoggedIn(profileName string) (bool, error)
+	SetMFASeed(profileName string, secret string) error
+	RemoveMFASeed(profileName string) error
+	MFAToken(ctx context.Context, profileName string) (string, error)
+	Profiles(filter ProfileFilter) ([]ProfileSummary, error)
+	UpdateProfileMetadata(profileName string, update func(metadata *ProfileMetadata)) error
+	History() ([]HistoryEntry, error)
+	Undo() (*HistoryEntry, error)
+	UndoWithOptions(options SelectOptions) (*HistoryEntry, error)
+	ConfigBackups() ([]ConfigBackup, error)
+	RestoreConfigBackup(timestamp string) (*ConfigBackup, error)
+	Uninstall(options UninstallOptions) (*UninstallSummary, error)
+	PlanImport(format, source, profileName string) (*ImportPlan, error)
+	ApplyImport(plan *ImportPlan, overwrite bool) error
+	ShredImportSource(path string) error
+	PlanMigration() (*MigrationPlan, error)
+	ApplyMigration(plan *MigrationPlan, options MigrationOptions) (*MigrationResult, error)
+	SyncOrganization(ctx context.Context, options OrgSyncOptions) (*OrgSyncResult, error)
 }
 
 type profile struct {
@@ -68,16 +132,42 @@ type profile struct {
 	commandName           string
 	secrets               secretStore
 	state                 stateStore
+	history               historyStore
+	backups               *configBackupStore
+	directories           DirectoryTrust
 	deletePrompt          DeleteLegacyCredentialsPrompt
 
+	// statePath is where the runtime state lives; empty for in-memory state.
+	statePath string
+
 	legacyStoreFactory func() (secretStore, error)
 	legacyStoreLoaded  bool
+
+	lock stateLock
+	// plan is set for a dry run; writes are recorded there instead.
+	plan *Plan
+
+	now          func() time.Time
+	terminalName func() string
+	sleep        func(ctx context.Context, d time.Duration) error
+
+	ssoService func(options sso.Options) sso.Service
+	stsService func(credential *Credential, options sts.Options) sts.Service
+	orgService func(credential *Credential, options org.Options) org.Service
 }
 
 type Model struct {
-	Configs         []*Config
-	Credentials     []*Credential
+	Configs     []*Config
+	Credentials []*Credential
+	// SSOProfiles are AWS config profiles served from IAM Identity Center
+	// rather than from stored keys.
+	SSOProfiles     []string
 	SelectedProfile string
+	// Metadata holds the description, tags and other details recorded for
+	// each profile in state.json.
+	Metadata map[string]ProfileMetadata
+	// Guards holds the guardrails of production profiles.
+	Guards map[string]Guard
 }
 
 type Config struct {
@@ -85,6 +175,20 @@ type Config struct {
 	Region            string
 	Output            string
 	CredentialProcess string
+	MFAProvider       string
+	MFAProcess        string
+	MFAAskpass        string
+
+	EndpointURL          string
+	STSRegionalEndpoints string
+	UseFIPSEndpoint      bool
+	UseDualStackEndpoint bool
+
+	SSOSession   string
+	SSOStartURL  string
+	SSORegion    string
+	SSOAccountID string
+	SSORoleName  string
 }
 
 type Credential struct {
@@ -117,6 +221,12 @@ type profileState struct {
 	SelectedProfile       string
 	LegacyCredentialsHash string
 	LegacyCleanupPending  bool
+	Profiles              map[string]ProfileMetadata `json:",omitempty"`
+	// LegacyStoreMigrated is set once actool migrate has resolved the old
+	// actool keyring service, so that Load no longer imports from it.
+	LegacyStoreMigrated bool `json:",omitempty"`
+	// Lease limits how long SelectedProfile stays in [default].
+	Lease *selectionLease `json:",omitempty"`
 }
 
 type fileStateStore struct {
@@ -149,11 +259,20 @@ type secretPreviousValue struct {
 }
 
 func NewProfile() (Profile, error) {
-	return newRuntimeProfile(nil)
+	return newRuntimeProfile(nil, nil)
 }
 
 func NewInteractiveProfile(deletePrompt DeleteLegacyCredentialsPrompt) (Profile, error) {
-	return newRuntimeProfile(deletePrompt)
+	return newRuntimeProfile(deletePrompt, nil)
+}
+
+// NewDryRunProfile opens the same files and keyring as NewProfile but
+// records every change in plan instead of making it.
+func NewDryRunProfile(deletePrompt DeleteLegacyCredentialsPrompt, plan *Plan) (Profile, error) {
+	if plan == nil {
+		return nil, errors.New("dry-run plan is nil")
+	}
+	return newRuntimeProfile(deletePrompt, plan)
 }
 
 // newProfile is kept dependency-injectable for tests and package callers.
@@ -189,10 +308,18 @@ func newConfiguredProfile(
 		state:                 state,
 		deletePrompt:          deletePrompt,
 		legacyStoreFactory:    legacyStoreFactory,
+		history:               &memoryHistoryStore{},
+		lock:                  noopLock{},
+		now:                   time.Now,
+		terminalName:          func() string { return "" },
+		sleep:                 sleepContext,
+		ssoService:            sso.NewService,
+		stsService:            newSTSService,
+		orgService:            newOrgService,
 	}
 }
 
-func newRuntimeProfile(deletePrompt DeleteLegacyCredentialsPrompt) (Profile, error) {
+func newRuntimeProfile(deletePrompt DeleteLegacyCredentialsPrompt, plan *Plan) (Profile, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
@@ -204,14 +331,21 @@ func newRuntimeProfile(deletePrompt DeleteLegacyCredentialsPrompt) (Profile, err
 		return nil, err
 	}
 
-	return newConfiguredProfile(
+	statePath := defaultStatePath()
+	indexPath := keyringIndexPath(statePath)
+	if plan != nil {
+		// The index is only a cache, but a dry run writes nothing at all.
+		indexPath = ""
+	}
+	secrets = newIndexedStore(secrets, indexPath)
+	p := newConfiguredProfile(
 		configPath,
 		credentialsPath,
 		executableCommandName(),
 		secrets,
-		&fileStateStore{path: defaultStatePath()},
+		&fileStateStore{path: statePath},
 		func() (secretStore, error) {
-			if backend := strings.TrimSpace(os.Getenv("AWS_VAULT_BACKEND")); backend == string(keyring.FileBackend) || backend == string(keyring.PassBackend) {
+			if backend := strings.TrimSpace(os.Getenv("AWS_VAULT_BACKEND")); backend == string(keyring.FileBackend) || backend == string(keyring.PassBackend) || backend == execBackend || backend == vaultBackend {
 				// These backends do not namespace entries by ServiceName. Reuse the
 				// opened store to avoid a second passphrase prompt and to migrate
 				// old entries from the same keyring atomically.
@@ -220,10 +354,26 @@ func newRuntimeProfile(deletePrompt DeleteLegacyCredentialsPrompt) (Profile, err
 			return openLegacyActoolStore()
 		},
 		deletePrompt,
-	), nil
+	)
+	p.history = newFileHistoryStore(statePath)
+	p.backups = newConfigBackupStore(statePath)
+	p.statePath = statePath
+	p.directories = newDirectoryTrust(statePath, plan)
+	p.terminalName = terminalName
+	p.lock = newFileLock(statePath)
+	if plan != nil {
+		p.enableDryRun(plan, statePath)
+	}
+	return p, nil
 }
 
 func openAWSVaultStore() (secretStore, error) {
+	switch strings.TrimSpace(os.Getenv("AWS_VAULT_BACKEND")) {
+	case execBackend:
+		return openExecStore()
+	case vaultBackend:
+		return openVaultStore()
+	}
 	return openKeyring(awsVaultKeyringConfig(false))
 }
 
@@ -325,7 +475,19 @@ func fileKeyringPassword(prompt string) (string, error) {
 	return password, nil
 }
 
+// Load migrates and imports credentials and synchronizes ~/.aws/config, so it
+// holds the state lock.
 func (p *profile) Load() (*Model, error) {
+	var model *Model
+	err := p.withLock(func() error {
+		var err error
+		model, err = p.load()
+		return err
+	})
+	return model, err
+}
+
+func (p *profile) load() (*Model, error) {
 	legacySelectedProfile, err := p.migrateLegacyActoolStore()
 	if err != nil {
 		return nil, err
@@ -341,22 +503,59 @@ func (p *profile) Load() (*Model, error) {
 		return nil, err
 	}
 
+	ssoProfiles, err := p.ssoProfileNames(profileNames)
+	if err != nil {
+		return nil, err
+	}
+
 	state, err := p.loadState()
 	if err != nil && !errors.Is(err, errStateNotFound) {
 		return nil, err
 	}
 
+	guards, err := p.profileGuards(append(slices.Clone(profileNames), ssoProfiles...))
+	if err != nil {
+		return nil, err
+	}
+	// A restricted profile is never kept in [default], even when it was
+	// selected before it was restricted. A production profile stays
+	// selected but is not picked in place of a missing one.
+	selectable := unattendedProfiles(profileNames, guards)
+
 	selectedProfile := state.SelectedProfile
-	if !containsProfile(profileNames, selectedProfile) {
+	leased := false
+	leaseEnded := ""
+	if lease := state.Lease; lease != nil {
+		switch {
+		case lease.Profile != selectedProfile:
+			state.Lease = nil
+		case !lease.expired(p.now()):
+			leased = true
+		default:
+			// The lease ended: [default] goes back to the safe profile or
+			// to the one selected before the lease.
+			leaseEnded = lease.Profile
+			state.Lease = nil
+			selectable = slices.DeleteFunc(selectable, func(profileName string) bool { return profileName == leaseEnded })
+			selectedProfile = ""
+			for _, candidate := range []string{lease.Fallback, lease.Previous} {
+				if containsProfile(selectable, candidate) || (containsProfile(ssoProfiles, candidate) && !guards[candidate].Production && !guards[candidate].Restricted && candidate != leaseEnded) {
+					selectedProfile = candidate
+					break
+				}
+			}
+		}
+	}
+	if guards[selectedProfile].Restricted || (!containsProfile(profileNames, selectedProfile) && !containsProfile(ssoProfiles, selectedProfile)) {
 		selectedProfile = ""
-		if pendingImport != nil && containsProfile(profileNames, pendingImport.suggestedProfile) {
+		if pendingImport != nil && containsProfile(selectable, pendingImport.suggestedProfile) {
 			selectedProfile = pendingImport.suggestedProfile
 		}
-		if selectedProfile == "" && containsProfile(profileNames, legacySelectedProfile) {
+		if selectedProfile == "" && containsProfile(selectable, legacySelectedProfile) {
 			selectedProfile = legacySelectedProfile
 		}
 		if selectedProfile == "" {
-			selectedProfile = defaultSelectedProfile(profileNames)
+			selectedProfile = defaultSelectedProfile(selectable)
 		}
 	}
 
@@ -369,23 +568,28 @@ func (p *profile) Load() (*Model, error) {
 		}
 		if pendingImport != nil {
 			if pendingImport.deleteSource {
-				if err := removeIfExists(pendingImport.path); err != nil {
+				if err := p.removeFile(pendingImport.path); err != nil {
 					return nil, err
 				}
 			} else {
-				if err := backupLegacyCredentials(pendingImport.path); err != nil {
+				if err := p.backupLegacyCredentials(pendingImport.path); err != nil {
 					return nil, err
 				}
 			}
 			state.LegacyCleanupPending = false
 		}
 
-		if err := p.syncConfig(selectedProfile, profileNames); err != nil {
+		if err := p.syncConfig(selectedProfile, profileNames, leased); err != nil {
 			return nil, err
 		}
 		if err := p.saveState(state); err != nil {
 			return nil, err
 		}
+		if leaseEnded != "" {
+			if err := p.recordHistory(leaseEnded, selectedProfile, HistoryActionLeaseEnd); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	configs, err := p.loadConfigs()
@@ -395,7 +599,10 @@ func (p *profile) Load() (*Model, error) {
 	return &Model{
 		Configs:         configs,
 		Credentials:     credentials,
+		SSOProfiles:     ssoProfiles,
 		SelectedProfile: selectedProfile,
+		Metadata:        state.Profiles,
+		Guards:          guards,
 	}, nil
 }
 
@@ -430,16 +637,62 @@ func (p *profile) Config(model *Model, profileName string) (*Config, error) {
 	return nil, fmt.Errorf("profile config not found. [%s]", profileName)
 }
 
+// SelectOptions adjust how a profile is selected.
+type SelectOptions struct {
+	// Shell selects the profile for the calling shell only, which exports
+	// AWS_PROFILE itself. [default] and the selected profile in state.json
+	// are left alone; the profile's own section still gets its
+	// credential_process.
+	Shell bool
+	// ConfirmProduction is asked before a production profile is selected.
+	// Without it, production profiles are refused.
+	ConfirmProduction ProductionConfirmPrompt
+	// Lease, when positive, limits how long the profile stays in [default].
+	// Afterwards credential-process serves Fallback to [default], or
+	// SafeProfileEnv when Fallback is empty, or fails.
+	Lease    time.Duration
+	Fallback string
+}
+
 func (p *profile) SetSelected(profileName string) error {
-	if _, err := p.baseCredential(profileName); err != nil {
+	return p.SetSelectedWithOptions(profileName, SelectOptions{})
+}
+
+func (p *profile) SetSelectedWithOptions(profileName string, options SelectOptions) error {
+	action := HistoryActionSelect
+	if options.Shell {
+		action = HistoryActionShell
+	}
+	return p.withLock(func() error {
+		return p.setSelected(profileName, action, options)
+	})
+}
+
+// setSelected records the switch in the history as action.
+func (p *profile) setSelected(profileName string, action string, options SelectOptions) error {
+	guard, err := p.profileGuard(profileName)
+	if err != nil {
+		return err
+	}
+	if err := guard.Check(profileName, options); err != nil {
+		return err
+	}
+
+	_, isSSO, err := p.ssoSettings(profileName)
+	if err != nil {
 		return err
 	}
+	if !isSSO {
+		if _, err := p.baseCredential(profileName); err != nil {
+			return err
+		}
+	}
 
 	profileNames, err := p.profileNames()
 	if err != nil {
 		return err
 	}
-	if !containsProfile(profileNames, profileName) {
+	if !isSSO && !containsProfile(profileNames, profileName) {
 		return fmt.Errorf("profile not found. [%s]", profileName)
 	}
 
@@ -447,19 +700,42 @@ func (p *profile) SetSelected(profileName string) error {
 	if err != nil && !errors.Is(err, errStateNotFound) {
 		return err
 	}
-	if err := p.syncConfig(profileName, profileNames); err != nil {
+	previous := state.SelectedProfile
+	lease, err := p.newSelectionLease(profileName, previous, profileNames, options)
+	if err != nil {
 		return err
 	}
-
+	if options.Shell {
+		// An empty selection syncs the profile sections but not [default].
+		if err := p.syncConfig("", profileNames, false); err != nil {
+			return err
+		}
+		previous = strings.TrimSpace(os.Getenv(AWSProfileEnv))
+	} else {
+		if err := p.syncConfig(profileName, profileNames, lease != nil); err != nil {
+			return err
+		}
+		state.SelectedProfile = profileName
+		state.Lease = lease
+	}
 	state.Version = stateVersion
-	state.SelectedProfile = profileName
+	now := p.now().UTC()
+	state.updateMetadata(profileName, func(metadata *ProfileMetadata) {
+		metadata.LastUsed = &now
+	})
 	if err := p.saveState(state); err != nil {
 		return err
 	}
-	return nil
+	return p.recordHistory(previous, profileName, action)
 }
 
 func (p *profile) StoreSessionToken(profileName string, credential *Credential) error {
+	return p.StoreSessionTokenWithOptions(profileName, credential, SelectOptions{})
+}
+
+// StoreSessionTokenWithOptions stores a new session for profileName. Unless
+// options.Shell is set, the profile is also selected.
+func (p *profile) StoreSessionTokenWithOptions(profileName string, credential *Credential, options SelectOptions) error {
 	if credential == nil {
 		return errors.New("credential is nil")
 	}
@@ -469,22 +745,54 @@ func (p *profile) StoreSessionToken(profileName string, credential *Credential)
 	if strings.TrimSpace(credential.AccessKey) == "" || strings.TrimSpace(credential.SecretKey) == "" || strings.TrimSpace(credential.SessionToken) == "" {
 		return errors.New("session credential is incomplete")
 	}
-	if _, err := p.baseCredential(profileName); err != nil {
-		return err
-	}
 
-	credential.Name = profileName
-	if err := p.storeSessionCredential(credential); err != nil {
-		return err
-	}
-	return p.SetSelected(profileName)
+	return p.withLock(func() error {
+		if _, err := p.baseCredential(profileName); err != nil {
+			return err
+		}
+		if !options.Shell {
+			// Check before storing the session, and ask only once.
+			guard, err := p.profileGuard(profileName)
+			if err != nil {
+				return err
+			}
+			if err := guard.Check(profileName, options); err != nil {
+				return err
+			}
+			options.ConfirmProduction = func(string) (string, error) { return profileName, nil }
+		}
+		credential.Name = profileName
+		if err := p.storeSessionCredential(credential); err != nil {
+			return err
+		}
+		if err := p.recordSessionRefresh(profileName); err != nil {
+			return err
+		}
+		if options.Shell {
+			return nil
+		}
+		return p.setSelected(profileName, HistoryActionSession, options)
+	})
 }
 
-// CredentialProcessPayload is read-only. AWS CLI and SDKs can invoke it
-// concurrently and without a terminal, so migration and config rewrites
-// belong to the interactive actool command.
-func (p *profile) CredentialProcessPayload(profileName string) ([]byte, error) {
-	credential, _, err := p.resolveCredential(profileName)
+// CredentialProcessPayload is read-only and lock-free. AWS CLI and SDKs can
+// invoke it concurrently and without a terminal, so migration and config
+// rewrites belong to the interactive actool command. Its only writes are
+// keyring caches under keys that include their expiration.
+func (p *profile) CredentialProcessPayload(ctx context.Context, profileName string) ([]byte, error) {
+	if strings.TrimSpace(profileName) == ProfileAuto {
+		resolved, err := p.autoProfile()
+		if err != nil {
+			return nil, err
+		}
+		profileName = resolved
+	}
+	if profileName != "" {
+		if err := p.checkDefaultCredentialProcess(profileName); err != nil {
+			return nil, err
+		}
+	}
+	credential, _, err := p.resolveCredential(ctx, profileName)
 	if err != nil {
 		return nil, err
 	}
@@ -507,14 +815,27 @@ func (p *profile) CredentialProcessPayload(profileName string) ([]byte, error) {
 	return append(payload, '\n'), nil
 }
 
-func (p *profile) resolveCredential(profileName string) (*Credential, string, error) {
+func (p *profile) resolveCredential(ctx context.Context, profileName string) (*Credential, string, error) {
 	selectedProfile := strings.TrimSpace(profileName)
+	var leaseEnd *time.Time
 	if selectedProfile == "" {
 		state, err := p.loadState()
 		if err != nil {
 			return nil, "", errors.New("actool is not initialized; run actool once before using AWS CLI")
 		}
-		selectedProfile = state.SelectedProfile
+		selectedProfile, leaseEnd, err = p.leasedProfile(state)
+		if err != nil {
+			return nil, "", err
+		}
+		if selectedProfile != "" {
+			guard, err := p.profileGuard(selectedProfile)
+			if err != nil {
+				return nil, "", err
+			}
+			if guard.Restricted {
+				return nil, "", fmt.Errorf("profile %q is restricted and only used with an explicit --profile; run actool to select another profile", selectedProfile)
+			}
+		}
 	}
 	if selectedProfile == "" {
 		return nil, "", errors.New("no AWS profile is selected; run actool once before using AWS CLI")
@@ -523,46 +844,103 @@ func (p *profile) resolveCredential(profileName string) (*Credential, string, er
 		return nil, "", err
 	}
 
-	session, expired, err := p.sessionCredentialForProfile(selectedProfile)
+	root, hops, err := p.roleChain(selectedProfile)
 	if err != nil {
 		return nil, "", err
 	}
+	if len(hops) > 0 {
+		credential, err := p.chainCredential(ctx, root, hops)
+		if err != nil {
+			return nil, "", err
+		}
+		return limitToLease(credential, leaseEnd), selectedProfile, nil
+	}
+	credential, err := p.directCredential(ctx, selectedProfile)
+	if err != nil {
+		return nil, "", err
+	}
+	return limitToLease(credential, leaseEnd), selectedProfile, nil
+}
+
+// directCredential resolves a profile that is not a role: a cached session,
+// IAM Identity Center role credentials, or the stored keys.
+func (p *profile) directCredential(ctx context.Context, profileName string) (*Credential, error) {
+	session, expired, err := p.sessionCredentialForProfile(profileName, isDirectSessionType)
+	if err != nil {
+		return nil, err
+	}
 	if session != nil {
-		return session, selectedProfile, nil
+		return session, nil
+	}
+	settings, isSSO, err := p.ssoSettings(profileName)
+	if err != nil {
+		return nil, err
+	}
+	if isSSO {
+		return p.ssoRoleCredential(ctx, settings)
 	}
 	if expired {
-		return nil, "", fmt.Errorf("session credentials for profile %q have expired; rerun actool", selectedProfile)
+		return nil, fmt.Errorf("session credentials for profile %q have expired; rerun actool", profileName)
 	}
 
-	base, err := p.baseCredential(selectedProfile)
+	base, err := p.baseCredential(profileName)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	if base.Expiration != nil && !base.Expiration.After(time.Now().UTC()) {
-		return nil, "", fmt.Errorf("credentials for profile %q have expired; rerun actool", selectedProfile)
+		return nil, fmt.Errorf("credentials for profile %q have expired; rerun actool", profileName)
 	}
-	return base, selectedProfile, nil
+	return base, nil
 }
 
-func (p *profile) sessionCredentialForProfile(profileName string) (*Credential, bool, error) {
+func (p *profile) sessionCredentialForProfile(profileName string, include func(sessionType string) bool) (*Credential, bool, error) {
+	return p.matchingSessionCredential(profileName, include, nil)
+}
+
+// matchingSessionCredential is sessionCredentialForProfile that also skips
+// the sessions whose stored secret accept rejects.
+func (p *profile) matchingSessionCredential(profileName string, include func(sessionType string) bool, accept func(data []byte) bool) (*Credential, bool, error) {
 	keys, err := p.secrets.Keys()
 	if err != nil {
 		return nil, false, err
 	}
 
-	var candidates []*Credential
+	// The key records when the session expires, so expired sessions are
+	// skipped without reading their secret and the rest are read newest first.
+	type sessionCandidate struct {
+		key      string
+		metadata sessionMetadata
+	}
+	var candidates []sessionCandidate
 	expired := false
 	now := time.Now().UTC()
 	for _, key := range keys {
 		metadata, ok := parseSessionKey(key)
-		if !ok || metadata.ProfileName != profileName {
+		if !ok || metadata.ProfileName != profileName || !include(metadata.Type) {
 			continue
 		}
+		if !metadata.Expiration.After(now) {
+			expired = true
+			continue
+		}
+		candidates = append(candidates, sessionCandidate{key: key, metadata: metadata})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].metadata.Expiration.After(candidates[j].metadata.Expiration)
+	})
 
-		data, err := p.secrets.Get(key)
+	var best *Credential
+	for _, candidate := range candidates {
+		if best != nil && !candidate.metadata.Expiration.After(*best.Expiration) {
+			break
+		}
+		data, err := p.secrets.Get(candidate.key)
 		if err != nil {
 			return nil, false, err
 		}
+		if accept != nil && !accept(data) {
+			continue
+		}
 		credential, err := decodeCredential(data, profileName)
 		if err != nil {
 			return nil, false, err
@@ -570,28 +948,33 @@ func (p *profile) sessionCredentialForProfile(profileName string) (*Credential,
 		if strings.TrimSpace(credential.SessionToken) == "" {
 			return nil, false, fmt.Errorf("session credential has no session token. [%s]", profileName)
 		}
-		if credential.Expiration == nil || metadata.Expiration.Before(credential.Expiration.UTC()) {
-			expiration := metadata.Expiration.UTC()
+		if credential.Expiration == nil || candidate.metadata.Expiration.Before(credential.Expiration.UTC()) {
+			expiration := candidate.metadata.Expiration.UTC()
 			credential.Expiration = &expiration
 		}
-		credential.MFASerial = metadata.MFASerial
+		credential.MFASerial = candidate.metadata.MFASerial
 
 		if !credential.Expiration.After(now) {
 			expired = true
 			continue
 		}
-		candidates = append(candidates, credential)
+		if best == nil || credential.Expiration.After(*best.Expiration) {
+			best = credential
+		}
 	}
-	if len(candidates) == 0 {
+	if best == nil {
 		return nil, expired, nil
 	}
-	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].Expiration.Before(*candidates[j].Expiration)
-	})
-	return candidates[len(candidates)-1], false, nil
+	return best, false, nil
 }
 
 func (p *profile) storeSessionCredential(credential *Credential) error {
+	return p.storeTypedSessionCredential(sessionTypeGetSession, credential, nil)
+}
+
+// storeTypedSessionCredential replaces any cached session of the same type
+// for the same profile and MFA device. fields are stored with the secret.
+func (p *profile) storeTypedSessionCredential(sessionType string, credential *Credential, fields map[string]string) error {
 	if credential == nil || credential.Expiration == nil {
 		return errors.New("session credential is incomplete")
 	}
@@ -608,7 +991,7 @@ func (p *profile) storeSessionCredential(credential *Credential) error {
 	}
 	for _, key := range keys {
 		metadata, ok := parseSessionKey(key)
-		if !ok || metadata.Type != sessionTypeGetSession || metadata.ProfileName != credential.Name || metadata.MFASerial != credential.MFASerial {
+		if !ok || metadata.Type != sessionType || metadata.ProfileName != credential.Name || metadata.MFASerial != credential.MFASerial {
 			continue
 		}
 		if err := p.removeSecret(key); err != nil {
@@ -617,7 +1000,7 @@ func (p *profile) storeSessionCredential(credential *Credential) error {
 	}
 
 	metadata := sessionMetadata{
-		Type:        sessionTypeGetSession,
+		Type:        sessionType,
 		ProfileName: credential.Name,
 		MFASerial:   credential.MFASerial,
 		Expiration:  credential.Expiration.UTC(),
@@ -628,6 +1011,9 @@ func (p *profile) storeSessionCredential(credential *Credential) error {
 		"SessionToken":    credential.SessionToken,
 		"Expiration":      credential.Expiration.UTC(),
 	}
+	for name, value := range fields {
+		data[name] = value
+	}
 	encoded, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -669,6 +1055,12 @@ func (p *profile) loadBaseCredentials() ([]string, []*Credential, error) {
 		if isNonProfileKey(key) || validateProfileName(key) != nil {
 			continue
 		}
+		if classifier, ok := p.secrets.(keyClassifier); ok {
+			// Skip reading keys already known to hold something else.
+			if entry, known := classifier.Classify(key); known && entry.Kind != keyKindCredential {
+				continue
+			}
+		}
 		credential, err := p.baseCredential(key)
 		if err != nil {
 			// aws-vault's keyring may contain OIDC data or sessions.
@@ -690,7 +1082,7 @@ func (p *profile) loadBaseCredentials() ([]string, []*Credential, error) {
 }
 
 func (p *profile) prepareLegacyCredentialsImport() (*pendingLegacyImport, error) {
-	legacyBytes, err := os.ReadFile(p.legacyCredentialsPath)
+	legacyBytes, err := p.readFile(p.legacyCredentialsPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -787,7 +1179,7 @@ func (p *profile) importCredentialPlanWithOptions(plan map[string]*Credential, o
 				continue
 			}
 			if !overwrite {
-				return fmt.Errorf("legacy credential %q conflicts with an existing aws-vault credential; remove the conflict and run actool again", name)
+				return fmt.Errorf("legacy credential %q conflicts with an existing aws-vault credential; run actool migrate to resolve it", name)
 			}
 		}
 
@@ -836,23 +1228,55 @@ func (p *profile) migrateLegacyActoolStore() (string, error) {
 	if p.legacyStoreFactory == nil {
 		return "", nil
 	}
+	if state, err := p.loadState(); err == nil && state.LegacyStoreMigrated {
+		return "", nil
+	}
+	plan, selectedProfile, sessions, err := p.readLegacyActoolStore()
+	if err != nil {
+		return "", err
+	}
+
+	if len(plan) > 0 {
+		if err := p.importLegacyCredentialPlan(plan); err != nil {
+			return "", err
+		}
+	}
+	for _, credential := range sessions {
+		current, _, sessionErr := p.sessionCredentialForProfile(credential.Name, isDirectSessionType)
+		if sessionErr != nil {
+			return "", sessionErr
+		}
+		if current != nil {
+			continue
+		}
+		if err := p.storeSessionCredential(credential); err != nil {
+			return "", err
+		}
+	}
+	return selectedProfile, nil
+}
+
+// readLegacyActoolStore reads the base credentials, the selected profile
+// and the unexpired sessions of the old actool keyring service. The store
+// is opened at most once per process.
+func (p *profile) readLegacyActoolStore() (map[string]*Credential, string, []*Credential, error) {
 	store, err := openLegacyStoreOnce(p)
 	if err != nil {
 		if errors.Is(err, keyring.ErrNoAvailImpl) {
-			return "", nil
+			return nil, "", nil, nil
 		}
-		return "", err
+		return nil, "", nil, err
 	}
 	if store == nil {
-		return "", nil
+		return nil, "", nil, nil
 	}
 
 	keys, err := store.Keys()
 	if err != nil {
 		if errors.Is(err, errSecretNotFound) {
-			return "", nil
+			return nil, "", nil, nil
 		}
-		return "", err
+		return nil, "", nil, err
 	}
 
 	plan := make(map[string]*Credential)
@@ -864,18 +1288,18 @@ func (p *profile) migrateLegacyActoolStore() (string, error) {
 			if getErr == nil {
 				selectedProfile = strings.TrimSpace(string(value))
 			} else if !errors.Is(getErr, errSecretNotFound) {
-				return "", getErr
+				return nil, "", nil, getErr
 			}
 			continue
 		}
 		if profileName, ok := decodeProfileName(baseCredentialKeyPrefix, key); ok {
 			value, getErr := store.Get(key)
 			if getErr != nil {
-				return "", getErr
+				return nil, "", nil, getErr
 			}
 			credential, decodeErr := decodeCredential(value, profileName)
 			if decodeErr != nil {
-				return "", decodeErr
+				return nil, "", nil, decodeErr
 			}
 			plan[profileName] = credential
 			continue
@@ -883,11 +1307,11 @@ func (p *profile) migrateLegacyActoolStore() (string, error) {
 		if profileName, ok := decodeProfileName(sessionCredentialPrefix, key); ok {
 			value, getErr := store.Get(key)
 			if getErr != nil {
-				return "", getErr
+				return nil, "", nil, getErr
 			}
 			credential, decodeErr := decodeCredential(value, profileName)
 			if decodeErr != nil {
-				return "", decodeErr
+				return nil, "", nil, decodeErr
 			}
 			if credential.Expiration != nil && credential.Expiration.After(time.Now().UTC()) {
 				credential.Name = profileName
@@ -895,25 +1319,7 @@ func (p *profile) migrateLegacyActoolStore() (string, error) {
 			}
 		}
 	}
-
-	if len(plan) > 0 {
-		if err := p.importLegacyCredentialPlan(plan); err != nil {
-			return "", err
-		}
-	}
-	for _, credential := range sessions {
-		current, _, sessionErr := p.sessionCredentialForProfile(credential.Name)
-		if sessionErr != nil {
-			return "", sessionErr
-		}
-		if current != nil {
-			continue
-		}
-		if err := p.storeSessionCredential(credential); err != nil {
-			return "", err
-		}
-	}
-	return selectedProfile, nil
+	return plan, selectedProfile, sessions, nil
 }
 
 func openLegacyStoreOnce(p *profile) (secretStore, error) {
@@ -932,7 +1338,10 @@ func openLegacyStoreOnce(p *profile) (secretStore, error) {
 	return store, nil
 }
 
-func (p *profile) syncConfig(selectedProfile string, profileNames []string) error {
+// syncConfig points [default] at selectedProfile, unless it is empty, and
+// gives every profile section its credential_process. A leased selection
+// is written without --profile, so that credential-process can end it.
+func (p *profile) syncConfig(selectedProfile string, profileNames []string, leased bool) error {
 	cfg, err := p.loadConfigFile()
 	if err != nil {
 		return err
@@ -952,24 +1361,32 @@ func (p *profile) syncConfig(selectedProfile string, profileNames []string) erro
 		}
 		if selectedProfile != Default {
 			if selectedSection, sectionErr := cfg.GetSection(profileSectionName(selectedProfile)); sectionErr == nil {
-				if hasCredentialSource(selectedSection) {
+				if hasCredentialSource(selectedSection) && !isSSOProfileSection(selectedSection) {
 					return fmt.Errorf("selected profile %q contains an unsupported role or external credential source; actool did not rewrite AWS config", selectedProfile)
 				}
 				if hasStaticCredentials(selectedSection) {
 					return fmt.Errorf("selected profile %q contains static credentials in AWS config; actool did not rewrite AWS config", selectedProfile)
 				}
-				existing := strings.TrimSpace(selectedSection.Key(CredentialProcess).String())
+				existing := keyValue(selectedSection, CredentialProcess)
 				if existing != "" && !p.isActoolCredentialProcess(existing) {
 					return fmt.Errorf("selected profile %q already has a different credential_process; actool did not rewrite AWS config", selectedProfile)
 				}
 			}
 		}
-		existing := strings.TrimSpace(defaultSection.Key(CredentialProcess).String())
+		existing := keyValue(defaultSection, CredentialProcess)
 		if existing != "" && !p.isActoolCredentialProcess(existing) {
 			return errors.New("default profile already has a different credential_process; remove it before selecting a profile with actool")
 		}
-		changed = ensureKey(defaultSection, CredentialProcess, p.credentialProcessCommand(selectedProfile)) || changed
-		changed = p.copySelectedProfileConfig(cfg, defaultSection, selectedProfile) || changed
+		command := p.credentialProcessCommand(selectedProfile)
+		if leased {
+			command = p.credentialProcessCommand("")
+		}
+		changed = ensureKey(defaultSection, CredentialProcess, command) || changed
+		copied, err := p.copySelectedProfileConfig(cfg, defaultSection, selectedProfile)
+		if err != nil {
+			return err
+		}
+		changed = copied || changed
 	}
 
 	for _, profileName := range profileNames {
@@ -987,34 +1404,63 @@ func (p *profile) syncConfig(selectedProfile string, profileNames []string) erro
 		if hasStaticCredentials(section) {
 			continue
 		}
-		existing := strings.TrimSpace(section.Key(CredentialProcess).String())
+		existing := keyValue(section, CredentialProcess)
 		if existing != "" && !p.isActoolCredentialProcess(existing) {
 			continue
 		}
 		changed = ensureKey(section, CredentialProcess, p.credentialProcessCommand(profileName)) || changed
 	}
 
+	chained, err := p.syncChainProfiles(cfg, profileNames)
+	if err != nil {
+		return err
+	}
+	changed = chained || changed
+
 	if !changed {
 		return nil
 	}
-	return saveConfigAtomic(p.configPath, cfg)
+	return p.saveConfig(cfg)
 }
 
-func (p *profile) copySelectedProfileConfig(cfg *ini.File, defaultSection *ini.Section, selectedProfile string) bool {
-	sourceSection := defaultSection
-	if selectedProfile != Default {
-		if section, err := cfg.GetSection(profileSectionName(selectedProfile)); err == nil {
-			sourceSection = section
+// syncChainProfiles gives the profile named by each role profile's
+// actool_chain_profile a credential_process for the role. A name that is a
+// stored profile, or a section with its own credentials, is left alone.
+func (p *profile) syncChainProfiles(cfg *ini.File, profileNames []string) (bool, error) {
+	type chain struct{ name, role string }
+	var chains []chain
+	for _, section := range cfg.Sections() {
+		roleName, ok := configProfileName(section.Name())
+		if !ok || keyValue(section, RoleARN) == "" {
+			continue
+		}
+		chainName := keyValue(section, ChainProfile)
+		if chainName == "" || chainName == roleName || chainName == Default || slices.Contains(profileNames, chainName) {
+			continue
 		}
+		if validateProfileName(chainName) != nil {
+			continue
+		}
+		chains = append(chains, chain{name: chainName, role: roleName})
 	}
+
 	changed := false
-	for _, keyName := range []string{Region, Output} {
-		value := strings.TrimSpace(sourceSection.Key(keyName).String())
-		if value != "" {
-			changed = ensureKey(defaultSection, keyName, value) || changed
+	for _, chain := range chains {
+		section, created, err := ensureSection(cfg, profileSectionName(chain.name))
+		if err != nil {
+			return false, err
 		}
+		changed = created || changed
+		if hasCredentialSource(section) || hasStaticCredentials(section) {
+			continue
+		}
+		existing := keyValue(section, CredentialProcess)
+		if existing != "" && !p.isActoolCredentialProcess(existing) {
+			continue
+		}
+		changed = ensureKey(section, CredentialProcess, p.credentialProcessCommand(chain.role)) || changed
 	}
-	return changed
+	return changed, nil
 }
 
 func (p *profile) isActoolCredentialProcess(value string) bool {
@@ -1036,27 +1482,27 @@ func (p *profile) isActoolCredentialProcess(value string) bool {
 }
 
 func hasCredentialSource(section *ini.Section) bool {
-	for _, keyName := range []string{
-		"role_arn",
-		"source_profile",
-		"credential_source",
-		"web_identity_token_file",
-		"web_identity_token_process",
-		"sso_start_url",
-		"sso_session",
-		"sso_account_id",
-		"sso_role_name",
-	} {
-		if strings.TrimSpace(section.Key(keyName).String()) != "" {
+	for _, keyName := range credentialSourceKeys {
+		if keyValue(section, keyName) != "" {
 			return true
 		}
 	}
 	return false
 }
 
+// keyValue reads a key of a section that is written back. Unlike
+// section.Key, it does not add the key when it is missing.
+func keyValue(section *ini.Section, keyName string) string {
+	key, err := section.GetKey(keyName)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(key.String())
+}
+
 func hasStaticCredentials(section *ini.Section) bool {
 	for _, keyName := range []string{AWSAccessKeyId, AWSSecretAccessKey, AWSSessionToken} {
-		if strings.TrimSpace(section.Key(keyName).String()) != "" {
+		if keyValue(section, keyName) != "" {
 			return true
 		}
 	}
@@ -1079,6 +1525,20 @@ func (p *profile) loadConfigs() ([]*Config, error) {
 			Region:            strings.TrimSpace(section.Key(Region).String()),
 			Output:            strings.TrimSpace(section.Key(Output).String()),
 			CredentialProcess: strings.TrimSpace(section.Key(CredentialProcess).String()),
+			MFAProvider:       strings.TrimSpace(section.Key(MFAProvider).String()),
+			MFAProcess:        strings.TrimSpace(section.Key(MFAProcess).String()),
+			MFAAskpass:        strings.TrimSpace(section.Key(MFAAskpass).String()),
+
+			EndpointURL:          strings.TrimSpace(section.Key(EndpointURL).String()),
+			STSRegionalEndpoints: strings.TrimSpace(section.Key(STSRegionalEndpoints).String()),
+			UseFIPSEndpoint:      section.Key(UseFIPSEndpoint).MustBool(false),
+			UseDualStackEndpoint: section.Key(UseDualStackEndpoint).MustBool(false),
+
+			SSOSession:   strings.TrimSpace(section.Key(SSOSession).String()),
+			SSOStartURL:  ssoSessionValue(cfg, section, SSOStartURL),
+			SSORegion:    ssoSessionValue(cfg, section, SSORegion),
+			SSOAccountID: strings.TrimSpace(section.Key(SSOAccountID).String()),
+			SSORoleName:  strings.TrimSpace(section.Key(SSORoleName).String()),
 		})
 	}
 	sort.Slice(configs, func(i, j int) bool {
@@ -1087,19 +1547,26 @@ func (p *profile) loadConfigs() ([]*Config, error) {
 	return configs, nil
 }
 
-func (p *profile) loadConfigFile() (*ini.File, error) {
-	options := ini.LoadOptions{
+func configLoadOptions() ini.LoadOptions {
+	return ini.LoadOptions{
 		Loose:                   true,
 		PreserveSurroundedQuote: true,
+		// Indented lines continue the previous key, as in nested settings
+		// such as s3 = followed by indented sub-settings.
+		AllowPythonMultilineValues: true,
 	}
-	cfg, err := ini.LoadSources(options, p.configPath)
+}
+
+func (p *profile) loadConfigFile() (*ini.File, error) {
+	options := configLoadOptions()
+	data, err := p.readFile(p.configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return ini.Empty(options), nil
 		}
 		return nil, err
 	}
-	return cfg, nil
+	return ini.LoadSources(options, data)
 }
 
 func (p *profile) loadState() (profileState, error) {
@@ -1117,6 +1584,20 @@ func (p *profile) saveState(state profileState) error {
 	return p.state.Save(state)
 }
 
+// recordSessionRefresh notes when a session was last fetched for
+// profileName.
+func (p *profile) recordSessionRefresh(profileName string) error {
+	state, err := p.loadState()
+	if err != nil && !errors.Is(err, errStateNotFound) {
+		return err
+	}
+	now := p.now().UTC()
+	state.updateMetadata(profileName, func(metadata *ProfileMetadata) {
+		metadata.LastSessionRefresh = &now
+	})
+	return p.saveState(state)
+}
+
 func (p *profile) removeSecret(key string) error {
 	err := p.secrets.Remove(key)
 	if errors.Is(err, errSecretNotFound) {
@@ -1173,6 +1654,16 @@ func (k *keyringStore) Keys() ([]string, error) {
 	return keys, nil
 }
 
+// ModificationTime is reported by the backends that keep it outside the
+// encrypted item, such as the file keyring.
+func (k *keyringStore) ModificationTime(key string) (time.Time, bool) {
+	metadata, err := k.keyring.GetMetadata(key)
+	if err != nil || metadata.ModificationTime.IsZero() {
+		return time.Time{}, false
+	}
+	return metadata.ModificationTime, true
+}
+
 func (f *fileStateStore) Load() (profileState, error) {
 	data, err := os.ReadFile(f.path)
 	if err != nil {
@@ -1181,17 +1672,17 @@ func (f *fileStateStore) Load() (profileState, error) {
 		}
 		return profileState{}, err
 	}
-	var state profileState
-	if err := json.Unmarshal(data, &state); err != nil {
+	state, _, err := decodeState(data)
+	if err != nil {
 		return profileState{}, err
 	}
-	if state.Version != 0 && state.Version != stateVersion {
-		return profileState{}, fmt.Errorf("unsupported actool state version: %d", state.Version)
-	}
 	return state, nil
 }
 
 func (f *fileStateStore) Save(state profileState) error {
+	if err := f.backupOlderState(); err != nil {
+		return err
+	}
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
@@ -1524,7 +2015,7 @@ func isNonProfileKey(key string) bool {
 	if key == selectedProfileKey || key == legacyCredentialsHashKey || key == "selected_profile" || key == "selectedProfile" {
 		return true
 	}
-	if strings.HasPrefix(key, "oidc:") || strings.HasPrefix(key, "credential/") {
+	if strings.HasPrefix(key, "oidc:") || strings.HasPrefix(key, "credential/") || strings.HasPrefix(key, actoolKeyPrefix) {
 		return true
 	}
 	_, isSession := parseSessionKey(key)
@@ -1578,7 +2069,7 @@ func validateProfileName(profileName string) error {
 	if strings.ContainsAny(profileName, "[]") {
 		return fmt.Errorf("profile name contains an INI section delimiter: %q", profileName)
 	}
-	if profileName == selectedProfileKey || profileName == legacyCredentialsHashKey || profileName == "selected_profile" || profileName == "selectedProfile" || strings.HasPrefix(profileName, "credential/") || strings.HasPrefix(profileName, "oidc:") {
+	if profileName == selectedProfileKey || profileName == legacyCredentialsHashKey || profileName == "selected_profile" || profileName == "selectedProfile" || strings.HasPrefix(profileName, "credential/") || strings.HasPrefix(profileName, "oidc:") || strings.HasPrefix(profileName, actoolKeyPrefix) {
 		return fmt.Errorf("profile name is reserved for secure-store metadata: %q", profileName)
 	}
 	if _, ok := parseSessionKey(profileName); ok {
@@ -1885,12 +2376,18 @@ func (p *profile) credentialProcessCommand(profileName string) string {
 	return strings.Join(quoted, " ")
 }
 
-func saveConfigAtomic(path string, cfg *ini.File) error {
-	var buffer bytes.Buffer
-	if _, err := cfg.WriteTo(&buffer); err != nil {
+// saveConfig writes cfg as the AWS config, rewriting only the lines that
+// changed.
+func (p *profile) saveConfig(cfg *ini.File) error {
+	original, err := p.readFile(p.configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := patchConfig(original, cfg)
+	if err != nil {
 		return err
 	}
-	return writeFileAtomic(path, buffer.Bytes(), 0o600)
+	return p.writeConfig(data)
 }
 
 func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
@@ -1933,10 +2430,26 @@ func removeIfExists(path string) error {
 	return err
 }
 
-func backupLegacyCredentials(path string) error {
+func (p *profile) backupLegacyCredentials(path string) error {
+	if p.plan != nil {
+		backupPath, err := legacyBackupPath(path)
+		if err != nil {
+			return err
+		}
+		return p.plan.renameFile(path, backupPath)
+	}
 	if err := os.Chmod(path, 0o600); err != nil {
 		return err
 	}
+	backupPath, err := legacyBackupPath(path)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, backupPath)
+}
+
+// legacyBackupPath picks the first free name beside path.
+func legacyBackupPath(path string) (string, error) {
 	baseBackupPath := path + ".actool-backup"
 	for suffix := 0; suffix < 100; suffix++ {
 		backupPath := baseBackupPath
@@ -1946,14 +2459,11 @@ func backupLegacyCredentials(path string) error {
 		if _, err := os.Lstat(backupPath); err == nil {
 			continue
 		} else if !os.IsNotExist(err) {
-			return err
-		}
-		if err := os.Rename(path, backupPath); err != nil {
-			return err
+			return "", err
 		}
-		return nil
+		return backupPath, nil
 	}
-	return fmt.Errorf("could not create a unique legacy credentials backup beside %s", path)
+	return "", fmt.Errorf("could not create a unique legacy credentials backup beside %s", path)
 }
 
 var _ Profile = (*profile)(nil)
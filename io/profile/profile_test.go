@@ -1,6 +1,7 @@
 package profile
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -157,7 +158,7 @@ aws_secret_access_key = DEVSECRETKEY
 	assert.Equal(t, model.SelectedProfile, "AWS Account Dev")
 	assert.DeepEqual(t, credentialNames(model.Credentials), []string{"AWS Account Dev", "default"})
 
-	payload, err := p.CredentialProcessPayload("")
+	payload, err := p.CredentialProcessPayload(context.Background(), "")
 	assert.NilError(t, err)
 	assert.DeepEqual(t, credentialProcessJSON(t, payload), map[string]interface{}{
 		"Version":         float64(1),
@@ -343,7 +344,7 @@ func TestCredentialProcessPayloadResolution(t *testing.T) {
 			p := newTestProfile(t, store)
 			tc.setup(t, p, store)
 
-			payload, err := p.CredentialProcessPayload(tc.profileName)
+			payload, err := p.CredentialProcessPayload(context.Background(), tc.profileName)
 			if tc.wantErr != "" {
 				assert.ErrorContains(t, err, tc.wantErr)
 				return
@@ -396,7 +397,7 @@ func TestStoreSessionTokenValidation(t *testing.T) {
 			state, stateErr := p.loadState()
 			assert.NilError(t, stateErr)
 			assert.Equal(t, state.SelectedProfile, "dev")
-			stored, expired, sessionErr := p.sessionCredentialForProfile("dev")
+			stored, expired, sessionErr := p.sessionCredentialForProfile("dev", isDirectSessionType)
 			assert.NilError(t, sessionErr)
 			assert.Assert(t, stored != nil)
 			assert.Assert(t, !expired)
@@ -457,6 +458,34 @@ func TestConfigAndCredentialLookup(t *testing.T) {
 	assert.ErrorContains(t, err, "model is nil")
 }
 
+func TestLoadConfigsReadsEndpointSettings(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, `
+[default]
+region = us-east-1
+
+[profile gov]
+region = us-gov-west-1
+endpoint_url = https://sts.example.internal
+sts_regional_endpoints = legacy
+use_fips_endpoint = true
+use_dualstack_endpoint = true
+`)
+
+	configs, err := p.loadConfigs()
+	assert.NilError(t, err)
+	assert.Equal(t, len(configs), 2)
+	assert.DeepEqual(t, configs[0], &Config{Name: Default, Region: "us-east-1"})
+	assert.DeepEqual(t, configs[1], &Config{
+		Name:                 "gov",
+		Region:               "us-gov-west-1",
+		EndpointURL:          "https://sts.example.internal",
+		STSRegionalEndpoints: "legacy",
+		UseFIPSEndpoint:      true,
+		UseDualStackEndpoint: true,
+	})
+}
+
 func TestStoresAWSVaultCompatibleCredentialData(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -825,7 +854,7 @@ func TestMigratesLegacySessionCredentials(t *testing.T) {
 	_, err = p.Load()
 	assert.NilError(t, err)
 
-	payload, err := p.CredentialProcessPayload("dev")
+	payload, err := p.CredentialProcessPayload(context.Background(), "dev")
 	assert.NilError(t, err)
 	assert.DeepEqual(t, credentialProcessJSON(t, payload), map[string]interface{}{
 		"Version":         float64(1),
@@ -1230,7 +1259,7 @@ func TestFileStateStore(t *testing.T) {
 	}{
 		{name: "missing", wantErr: errStateNotFound},
 		{name: "invalid json", contents: "{", contains: "unexpected end of JSON input"},
-		{name: "unsupported version", contents: `{"Version":2}`, contains: "unsupported actool state version"},
+		{name: "unsupported version", contents: `{"Version":3}`, contains: "unsupported actool state version"},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -0,0 +1,250 @@
+package profile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const (
+	// ChainProfile on a role profile names a profile that actool gives a
+	// credential_process resolving the role. AWS CLI assumes role profiles
+	// itself, so the role profile cannot carry the credential_process.
+	ChainProfile = "actool_chain_profile"
+
+	// roleInputField holds roleHop.cacheKey in a cached AssumeRole session.
+	roleInputField = "RoleInput"
+)
+
+// roleHop is one AssumeRole call of a source_profile chain.
+type roleHop struct {
+	ProfileName string
+	MFASerial   string
+	// InputHash identifies the role_arn, external_id, role_session_name,
+	// source_profile and mfa_serial the hop was configured with.
+	InputHash string
+	Input     sts.AssumeRoleInput
+	Options   sts.Options
+}
+
+func roleInputHash(values ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKey identifies the hop's input together with the identity the chain
+// starts from. A cached session is only used while both are unchanged.
+func (h roleHop) cacheKey(rootIdentity string) string {
+	return roleInputHash(h.InputHash, rootIdentity)
+}
+
+// sessionHasInput accepts the cached sessions stored with cacheKey. Sessions
+// cached without one are not used.
+func sessionHasInput(cacheKey string) func(data []byte) bool {
+	return func(data []byte) bool {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return false
+		}
+		return jsonString(values, roleInputField) == cacheKey
+	}
+}
+
+func newSTSService(credential *Credential, options sts.Options) sts.Service {
+	return sts.NewSessionService(credential.AccessKey, credential.SecretKey, credential.SessionToken, options)
+}
+
+// isDirectSessionType excludes cached AssumeRole results, which belong to a
+// role chain rather than to the profile's own credentials.
+func isDirectSessionType(sessionType string) bool {
+	return sessionType != sessionTypeAssumeRole
+}
+
+func isAssumeRoleSessionType(sessionType string) bool {
+	return sessionType == sessionTypeAssumeRole
+}
+
+// roleChain follows source_profile from profileName to the profile that
+// holds the root credentials. The hops are returned in the order they have to
+// be assumed. A profile whose source_profile is itself uses its own keys, as
+// in AWS CLI.
+func (p *profile) roleChain(profileName string) (string, []roleHop, error) {
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var hops []roleHop
+	var path []string
+	visited := make(map[string]bool)
+	name := profileName
+	for {
+		section, err := cfg.GetSection(profileSectionName(name))
+		if err != nil {
+			break
+		}
+		roleARN := strings.TrimSpace(section.Key(RoleARN).String())
+		if roleARN == "" {
+			break
+		}
+		path = append(path, name)
+		if visited[name] {
+			return "", nil, fmt.Errorf("source_profile cycle detected: %s", strings.Join(path, " -> "))
+		}
+		visited[name] = true
+
+		source := strings.TrimSpace(section.Key(SourceProfile).String())
+		if source == "" {
+			if strings.TrimSpace(section.Key(CredentialSource).String()) != "" {
+				return "", nil, fmt.Errorf("profile %q uses credential_source, which actool credential-process does not support", name)
+			}
+			return "", nil, fmt.Errorf("profile %q has role_arn but no source_profile", name)
+		}
+		if err := validateProfileName(source); err != nil {
+			return "", nil, fmt.Errorf("profile %q has an invalid source_profile: %w", name, err)
+		}
+
+		duration := int64(0)
+		if value := strings.TrimSpace(section.Key(DurationSeconds).String()); value != "" {
+			duration, err = strconv.ParseInt(value, 10, 32)
+			if err != nil || duration <= 0 {
+				return "", nil, fmt.Errorf("profile %q has an invalid duration_seconds: %s", name, value)
+			}
+		}
+		externalID := strings.TrimSpace(section.Key(ExternalID).String())
+		configuredSessionName := strings.TrimSpace(section.Key(RoleSessionName).String())
+		sessionName := configuredSessionName
+		if sessionName == "" {
+			sessionName = fmt.Sprintf("actool-%d", p.now().UnixMilli())
+		}
+		region := strings.TrimSpace(section.Key(Region).String())
+		if region == "" {
+			partition, ok := sts.PartitionFromARN(roleARN)
+			if !ok {
+				return "", nil, fmt.Errorf("profile %q has an invalid role_arn: %s", name, roleARN)
+			}
+			region = sts.DefaultRegion(partition)
+		}
+
+		mfaSerial := strings.TrimSpace(section.Key(MFASerial).String())
+		hop := roleHop{
+			ProfileName: name,
+			MFASerial:   mfaSerial,
+			InputHash:   roleInputHash(roleARN, externalID, configuredSessionName, source, mfaSerial),
+			Input: sts.AssumeRoleInput{
+				RoleARN:         roleARN,
+				RoleSessionName: sessionName,
+				ExternalID:      externalID,
+				DurationSeconds: int32(duration),
+			},
+			Options: sts.Options{
+				Region:               region,
+				EndpointURL:          strings.TrimSpace(section.Key(EndpointURL).String()),
+				RegionalEndpoints:    strings.TrimSpace(section.Key(STSRegionalEndpoints).String()),
+				UseFIPSEndpoint:      section.Key(UseFIPSEndpoint).MustBool(false),
+				UseDualStackEndpoint: section.Key(UseDualStackEndpoint).MustBool(false),
+			},
+		}
+		hops = append([]roleHop{hop}, hops...)
+		if source == name {
+			break
+		}
+		name = source
+	}
+	return name, hops, nil
+}
+
+// chainCredential starts from the deepest hop that still has a cached
+// session for its current input and root identity, so a warm cache needs no
+// STS call.
+func (p *profile) chainCredential(ctx context.Context, root string, hops []roleHop) (*Credential, error) {
+	rootIdentity, err := p.rootIdentity(root)
+	if err != nil {
+		return nil, err
+	}
+	var credential *Credential
+	start := 0
+	for i := len(hops) - 1; i >= 0; i-- {
+		cached, _, err := p.matchingSessionCredential(hops[i].ProfileName, isAssumeRoleSessionType, sessionHasInput(hops[i].cacheKey(rootIdentity)))
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			credential = cached
+			start = i + 1
+			break
+		}
+	}
+	if credential == nil {
+		var err error
+		credential, err = p.directCredential(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, hop := range hops[start:] {
+		next, err := p.assumeRoleHop(ctx, credential, hop, hop.cacheKey(rootIdentity))
+		if err != nil {
+			return nil, err
+		}
+		credential = next
+	}
+	return credential, nil
+}
+
+// rootIdentity names the credentials a chain starts from without calling
+// STS: the IAM Identity Center account and role, or the stored access key
+// ID. Rotating or replacing the root keys changes it.
+func (p *profile) rootIdentity(root string) (string, error) {
+	settings, isSSO, err := p.ssoSettings(root)
+	if err != nil {
+		return "", err
+	}
+	if isSSO {
+		return strings.Join([]string{settings.StartURL, settings.AccountID, settings.RoleName}, "/"), nil
+	}
+	base, err := p.baseCredential(root)
+	if err != nil {
+		return "", err
+	}
+	return base.AccessKey, nil
+}
+
+// assumeRoleHop does not prompt for MFA: credential_process has no terminal.
+// A role that sets mfa_serial is reachable when the chain starts from an MFA
+// session obtained with "Set choose sessionToken.", whose MFA context carries
+// over to the assumed role.
+func (p *profile) assumeRoleHop(ctx context.Context, source *Credential, hop roleHop, cacheKey string) (*Credential, error) {
+	if hop.MFASerial != "" && source.MFASerial == "" {
+		return nil, fmt.Errorf("profile %q requires MFA (mfa_serial); run actool and choose \"Set choose sessionToken.\" for its source profile first", hop.ProfileName)
+	}
+	token, err := p.stsService(source, hop.Options).AssumeRole(ctx, hop.Input)
+	if err != nil {
+		return nil, fmt.Errorf("assume role for profile %q: %w", hop.ProfileName, err)
+	}
+
+	mfaSerial := hop.MFASerial
+	if mfaSerial == "" {
+		mfaSerial = source.MFASerial
+	}
+	expiration := token.Expiration.UTC()
+	credential := &Credential{
+		Name:         hop.ProfileName,
+		AccessKey:    token.AccessKey,
+		SecretKey:    token.SecretKey,
+		SessionToken: token.SessionToken,
+		Expiration:   &expiration,
+		MFASerial:    mfaSerial,
+	}
+	if err := p.storeTypedSessionCredential(sessionTypeAssumeRole, credential, map[string]string{roleInputField: cacheKey}); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
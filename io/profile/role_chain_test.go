@@ -0,0 +1,326 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const testRoleChainConfig = `[profile base]
+region = eu-west-1
+
+[profile admin]
+role_arn = arn:aws:iam::111111111111:role/Admin
+source_profile = base
+region = eu-west-1
+
+[profile deep]
+role_arn = arn:aws:iam::222222222222:role/Deploy
+source_profile = admin
+external_id = partner
+duration_seconds = 900
+role_session_name = deploy-session
+
+[profile china]
+role_arn = arn:aws-cn:iam::333333333333:role/ReadOnly
+source_profile = base
+
+[profile self]
+role_arn = arn:aws:iam::444444444444:role/Self
+source_profile = self
+
+[profile loop-a]
+role_arn = arn:aws:iam::555555555555:role/A
+source_profile = loop-b
+
+[profile loop-b]
+role_arn = arn:aws:iam::555555555555:role/B
+source_profile = loop-a
+
+[profile orphan]
+role_arn = arn:aws:iam::666666666666:role/Orphan
+
+[profile instance]
+role_arn = arn:aws:iam::666666666666:role/Instance
+credential_source = Ec2InstanceMetadata
+
+[profile guarded]
+role_arn = arn:aws:iam::777777777777:role/Guarded
+source_profile = base
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+`
+
+type assumeRoleCall struct {
+	SourceAccessKey string
+	Input           sts.AssumeRoleInput
+	Options         sts.Options
+}
+
+// fakeRoleSTS answers AssumeRole with credentials named after the role.
+type fakeRoleSTS struct {
+	calls *[]assumeRoleCall
+	from  *Credential
+	opts  sts.Options
+}
+
+func (f *fakeRoleSTS) SessionToken(context.Context, int64, *sts.Account, string) (*sts.SessionToken, error) {
+	return nil, fmt.Errorf("unexpected GetSessionToken")
+}
+
+func (f *fakeRoleSTS) Account(context.Context) (*sts.Account, error) {
+	return nil, fmt.Errorf("unexpected GetCallerIdentity")
+}
+
+func (f *fakeRoleSTS) AssumeRole(_ context.Context, input sts.AssumeRoleInput) (*sts.SessionToken, error) {
+	*f.calls = append(*f.calls, assumeRoleCall{SourceAccessKey: f.from.AccessKey, Input: input, Options: f.opts})
+	return &sts.SessionToken{
+		AccessKey:    "ACCESS-" + input.RoleARN,
+		SecretKey:    "SECRET-" + input.RoleARN,
+		SessionToken: "TOKEN-" + input.RoleARN,
+		Expiration:   time.Now().Add(time.Hour).UTC(),
+	}, nil
+}
+
+func newRoleChainTestProfile(t *testing.T) (*profile, *fakeSecretStore, *[]assumeRoleCall) {
+	t.Helper()
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	writeTestFile(t, p.configPath, testRoleChainConfig)
+	storeBaseCredential(t, p, "base", "BASEACCESSKEY", "BASESECRETKEY", nil)
+	storeBaseCredential(t, p, "self", "SELFACCESSKEY", "SELFSECRETKEY", nil)
+	calls := &[]assumeRoleCall{}
+	p.stsService = func(credential *Credential, options sts.Options) sts.Service {
+		return &fakeRoleSTS{calls: calls, from: credential, opts: options}
+	}
+	return p, store, calls
+}
+
+func assumeRoleCacheCount(store *fakeSecretStore, profileName string) int {
+	count := 0
+	for key := range store.values {
+		if metadata, ok := parseSessionKey(key); ok && metadata.Type == sessionTypeAssumeRole && metadata.ProfileName == profileName {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCredentialProcessWalksSourceProfileChain(t *testing.T) {
+	p, store, calls := newRoleChainTestProfile(t)
+
+	payload, err := p.CredentialProcessPayload(context.Background(), "deep")
+	assert.NilError(t, err)
+	value := credentialProcessJSON(t, payload)
+	assert.Equal(t, value["AccessKeyId"], "ACCESS-arn:aws:iam::222222222222:role/Deploy")
+	assert.Equal(t, value["SessionToken"], "TOKEN-arn:aws:iam::222222222222:role/Deploy")
+
+	assert.Equal(t, len(*calls), 2)
+	first, second := (*calls)[0], (*calls)[1]
+	assert.Equal(t, first.SourceAccessKey, "BASEACCESSKEY")
+	assert.Equal(t, first.Input.RoleARN, "arn:aws:iam::111111111111:role/Admin")
+	assert.Equal(t, first.Options.Region, "eu-west-1")
+	assert.Equal(t, second.SourceAccessKey, "ACCESS-arn:aws:iam::111111111111:role/Admin")
+	assert.DeepEqual(t, second.Input, sts.AssumeRoleInput{
+		RoleARN:         "arn:aws:iam::222222222222:role/Deploy",
+		RoleSessionName: "deploy-session",
+		ExternalID:      "partner",
+		DurationSeconds: 900,
+	})
+	assert.Equal(t, second.Options.Region, "us-east-1")
+	assert.Equal(t, assumeRoleCacheCount(store, "admin"), 1)
+	assert.Equal(t, assumeRoleCacheCount(store, "deep"), 1)
+
+	_, err = p.CredentialProcessPayload(context.Background(), "deep")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 2)
+
+	for key := range store.values {
+		if metadata, ok := parseSessionKey(key); ok && metadata.ProfileName == "deep" {
+			delete(store.values, key)
+		}
+	}
+	_, err = p.CredentialProcessPayload(context.Background(), "deep")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 3)
+	assert.Equal(t, (*calls)[2].SourceAccessKey, "ACCESS-arn:aws:iam::111111111111:role/Admin")
+
+	// The base profile's own credentials are unaffected by the role caches.
+	payload, err = p.CredentialProcessPayload(context.Background(), "base")
+	assert.NilError(t, err)
+	assert.Equal(t, credentialProcessJSON(t, payload)["AccessKeyId"], "BASEACCESSKEY")
+}
+
+func TestRoleChainCacheFollowsHopInput(t *testing.T) {
+	cases := []struct {
+		name       string
+		from       string
+		to         string
+		wantCalls  int
+		wantSource string
+	}{
+		{name: "unchanged", wantCalls: 2},
+		{name: "role_arn", from: "role/Deploy", to: "role/Release", wantCalls: 3, wantSource: "ACCESS-arn:aws:iam::111111111111:role/Admin"},
+		{name: "external_id", from: "external_id = partner", to: "external_id = other", wantCalls: 3, wantSource: "ACCESS-arn:aws:iam::111111111111:role/Admin"},
+		{name: "role_session_name", from: "deploy-session", to: "release-session", wantCalls: 3, wantSource: "ACCESS-arn:aws:iam::111111111111:role/Admin"},
+		{name: "source_profile", from: "source_profile = admin", to: "source_profile = base", wantCalls: 3, wantSource: "BASEACCESSKEY"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, store, calls := newRoleChainTestProfile(t)
+			_, err := p.CredentialProcessPayload(context.Background(), "deep")
+			assert.NilError(t, err)
+			assert.Equal(t, len(*calls), 2)
+
+			writeTestFile(t, p.configPath, strings.Replace(testRoleChainConfig, tc.from, tc.to, 1))
+			_, err = p.CredentialProcessPayload(context.Background(), "deep")
+			assert.NilError(t, err)
+			assert.Equal(t, len(*calls), tc.wantCalls)
+			if tc.wantSource != "" {
+				assert.Equal(t, (*calls)[2].SourceAccessKey, tc.wantSource)
+			}
+			// The session for the old input is replaced.
+			assert.Equal(t, assumeRoleCacheCount(store, "deep"), 1)
+		})
+	}
+}
+
+func TestRoleChainCacheFollowsRootIdentity(t *testing.T) {
+	p, _, calls := newRoleChainTestProfile(t)
+	_, err := p.CredentialProcessPayload(context.Background(), "deep")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 2)
+
+	storeBaseCredential(t, p, "base", "ROTATEDACCESSKEY", "ROTATEDSECRETKEY", nil)
+	_, err = p.CredentialProcessPayload(context.Background(), "deep")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 4)
+	assert.Equal(t, (*calls)[2].SourceAccessKey, "ROTATEDACCESSKEY")
+}
+
+func TestRoleChainCacheFollowsMFASerial(t *testing.T) {
+	p, _, calls := newRoleChainTestProfile(t)
+	expiration := time.Now().Add(time.Hour)
+	assert.NilError(t, p.storeSessionCredential(&Credential{
+		Name:         "base",
+		AccessKey:    "MFASESSIONACCESSKEY",
+		SecretKey:    "MFASESSIONSECRETKEY",
+		SessionToken: "MFASESSIONTOKEN",
+		Expiration:   &expiration,
+		MFASerial:    "arn:aws:iam::123456789012:mfa/alice",
+	}))
+	_, err := p.CredentialProcessPayload(context.Background(), "guarded")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 1)
+
+	writeTestFile(t, p.configPath, strings.Replace(testRoleChainConfig, "mfa/alice", "mfa/bob", 1))
+	_, err = p.CredentialProcessPayload(context.Background(), "guarded")
+	assert.NilError(t, err)
+	assert.Equal(t, len(*calls), 2)
+}
+
+func TestChainProfileRunsRoleChain(t *testing.T) {
+	p, _, calls := newRoleChainTestProfile(t)
+	config := strings.Replace(testRoleChainConfig, "role_session_name = deploy-session", "role_session_name = deploy-session\nactool_chain_profile = deploy", 1)
+	config = strings.Replace(config, "source_profile = base\nregion = eu-west-1", "source_profile = base\nregion = eu-west-1\nactool_chain_profile = base", 1)
+	config = strings.Replace(config, "credential_source = Ec2InstanceMetadata", "credential_source = Ec2InstanceMetadata\nactool_chain_profile = instance-cli", 1)
+	config += "\n[profile guarded-cli]\naws_access_key_id = STATICKEY\n"
+	config = strings.Replace(config, "mfa/alice", "mfa/alice\nactool_chain_profile = guarded-cli", 1)
+	writeTestFile(t, p.configPath, config)
+
+	assert.NilError(t, p.SetSelected("base"))
+
+	cfg := loadTestConfig(t, p)
+	deploy, err := cfg.GetSection(profileSectionName("deploy"))
+	assert.NilError(t, err)
+	args, ok := splitCommandLine(keyValue(deploy, CredentialProcess))
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, args[1:], []string{credentialProcessCommand, "--profile", "deep"})
+	// A stored profile keeps its own credential_process.
+	base, err := cfg.GetSection(profileSectionName("base"))
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(base, CredentialProcess), p.credentialProcessCommand("base"))
+	// The chain profile is wired even where actool cannot resolve the role.
+	instance, err := cfg.GetSection(profileSectionName("instance-cli"))
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(instance, CredentialProcess), p.credentialProcessCommand("instance"))
+	// A section with its own credentials is left alone.
+	guarded, err := cfg.GetSection(profileSectionName("guarded-cli"))
+	assert.NilError(t, err)
+	assert.Equal(t, keyValue(guarded, CredentialProcess), "")
+
+	payload, err := p.CredentialProcessPayload(context.Background(), args[3])
+	assert.NilError(t, err)
+	assert.Equal(t, credentialProcessJSON(t, payload)["AccessKeyId"], "ACCESS-arn:aws:iam::222222222222:role/Deploy")
+	assert.Equal(t, len(*calls), 2)
+}
+
+func TestRoleChainResolution(t *testing.T) {
+	cases := []struct {
+		name       string
+		profile    string
+		wantRoot   string
+		wantHops   []string
+		wantRegion string
+		wantErr    string
+	}{
+		{name: "plain profile", profile: "base", wantRoot: "base"},
+		{name: "two hops", profile: "deep", wantRoot: "base", wantHops: []string{"admin", "deep"}},
+		{name: "partition default region", profile: "china", wantRoot: "base", wantHops: []string{"china"}, wantRegion: "cn-north-1"},
+		{name: "self reference uses own keys", profile: "self", wantRoot: "self", wantHops: []string{"self"}},
+		{name: "cycle", profile: "loop-a", wantErr: "source_profile cycle detected: loop-a -> loop-b -> loop-a"},
+		{name: "missing source profile", profile: "orphan", wantErr: "has role_arn but no source_profile"},
+		{name: "credential source", profile: "instance", wantErr: "does not support"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _, _ := newRoleChainTestProfile(t)
+			root, hops, err := p.roleChain(tc.profile)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, root, tc.wantRoot)
+			names := make([]string, 0, len(hops))
+			for _, hop := range hops {
+				names = append(names, hop.ProfileName)
+			}
+			if tc.wantHops == nil {
+				tc.wantHops = []string{}
+			}
+			assert.DeepEqual(t, names, tc.wantHops)
+			if tc.wantRegion != "" {
+				assert.Equal(t, hops[len(hops)-1].Options.Region, tc.wantRegion)
+			}
+		})
+	}
+}
+
+func TestRoleChainWithMFASerialNeedsMFASession(t *testing.T) {
+	p, _, calls := newRoleChainTestProfile(t)
+
+	_, err := p.CredentialProcessPayload(context.Background(), "guarded")
+	assert.ErrorContains(t, err, "requires MFA")
+	assert.Equal(t, len(*calls), 0)
+
+	expiration := time.Now().Add(time.Hour)
+	assert.NilError(t, p.storeSessionCredential(&Credential{
+		Name:         "base",
+		AccessKey:    "MFASESSIONACCESSKEY",
+		SecretKey:    "MFASESSIONSECRETKEY",
+		SessionToken: "MFASESSIONTOKEN",
+		Expiration:   &expiration,
+		MFASerial:    "arn:aws:iam::123456789012:mfa/alice",
+	}))
+	_, err = p.CredentialProcessPayload(context.Background(), "guarded")
+	assert.NilError(t, err)
+	assert.Equal(t, (*calls)[0].SourceAccessKey, "MFASESSIONACCESSKEY")
+}
@@ -0,0 +1,382 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sso"
+)
+
+const (
+	defaultSSORegistrationScope = "sso:account:access"
+
+	// Tokens and client registrations this close to expiry are treated as
+	// expired so a credential_process call does not race the deadline.
+	ssoTokenExpiryWindow  = time.Minute
+	ssoClientExpiryWindow = time.Hour
+)
+
+// SSODevicePrompt shows the user where to approve a device-authorization
+// request. It must not block until the request is approved.
+type SSODevicePrompt func(authorization *sso.DeviceAuthorization) error
+
+// ssoSettings is an SSO profile resolved against its [sso-session] section.
+type ssoSettings struct {
+	ProfileName string
+	SessionName string
+	StartURL    string
+	Region      string
+	AccountID   string
+	RoleName    string
+	Scopes      []string
+}
+
+// oidcTokenData has the layout aws-vault uses for oidc:<start URL> entries,
+// so a login made by either tool is reused by the other.
+type oidcTokenData struct {
+	Token      oidcToken
+	Expiration time.Time
+}
+
+type oidcToken struct {
+	AccessToken  string
+	ExpiresIn    int32
+	IdToken      string
+	RefreshToken string
+	TokenType    string
+}
+
+type oidcClientData struct {
+	ClientID     string
+	ClientSecret string
+	ExpiresAt    time.Time
+	Scopes       []string
+}
+
+// SSOLogin runs the OIDC device-authorization flow for the profile's IAM
+// Identity Center instance and stores the resulting token in the keyring.
+func (p *profile) SSOLogin(ctx context.Context, profileName string, prompt SSODevicePrompt) error {
+	settings, isSSO, err := p.ssoSettings(profileName)
+	if err != nil {
+		return err
+	}
+	if !isSSO {
+		return fmt.Errorf("profile %q has no sso_session or sso_start_url with sso_account_id and sso_role_name", profileName)
+	}
+
+	if p.plan != nil {
+		p.plan.note("log in to IAM Identity Center at %s for profile %q and store the token", settings.StartURL, profileName)
+		return nil
+	}
+
+	service := p.ssoService(sso.Options{Region: settings.Region})
+	client, err := p.ssoClient(ctx, service, settings)
+	if err != nil {
+		return err
+	}
+	authorization, err := service.StartDeviceAuthorization(ctx, client, settings.StartURL)
+	if err != nil {
+		return err
+	}
+	if prompt != nil {
+		if err := prompt(authorization); err != nil {
+			return err
+		}
+	}
+	token, err := service.WaitForToken(ctx, client, authorization)
+	if err != nil {
+		return err
+	}
+	// The browser approval can take minutes; only the write is locked.
+	return p.withLock(func() error {
+		if err := p.saveOIDCToken(settings.StartURL, token); err != nil {
+			return err
+		}
+		return p.recordSessionRefresh(profileName)
+	})
+}
+
+// SSOLoggedIn reports whether credential-process can serve profileName
+// without a new device-authorization login.
+func (p *profile) SSOLoggedIn(profileName string) (bool, error) {
+	settings, isSSO, err := p.ssoSettings(profileName)
+	if err != nil {
+		return false, err
+	}
+	if !isSSO {
+		return false, fmt.Errorf("profile %q is not an IAM Identity Center profile", profileName)
+	}
+	data, err := p.loadOIDCToken(settings.StartURL)
+	if errors.Is(err, errSecretNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if data.Expiration.After(p.now().Add(ssoTokenExpiryWindow)) {
+		return true, nil
+	}
+	if data.Token.RefreshToken == "" {
+		return false, nil
+	}
+	client, err := p.loadOIDCClient(settings)
+	return err == nil && p.ssoClientUsable(client, settings), nil
+}
+
+// ssoRoleCredential exchanges the stored access token for role credentials
+// and caches them the way aws-vault does.
+func (p *profile) ssoRoleCredential(ctx context.Context, settings *ssoSettings) (*Credential, error) {
+	if settings.AccountID == "" || settings.RoleName == "" {
+		return nil, fmt.Errorf("profile %q needs sso_account_id and sso_role_name", settings.ProfileName)
+	}
+	service := p.ssoService(sso.Options{Region: settings.Region})
+	accessToken, err := p.ssoAccessToken(ctx, service, settings)
+	if err != nil {
+		return nil, err
+	}
+	role, err := service.RoleCredentials(ctx, accessToken, settings.AccountID, settings.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	expiration := role.Expiration.UTC()
+	credential := &Credential{
+		Name:         settings.ProfileName,
+		AccessKey:    role.AccessKey,
+		SecretKey:    role.SecretKey,
+		SessionToken: role.SessionToken,
+		Expiration:   &expiration,
+	}
+	if err := p.storeTypedSessionCredential(sessionTypeSSORole, credential, nil); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+func (p *profile) ssoAccessToken(ctx context.Context, service sso.Service, settings *ssoSettings) (string, error) {
+	loginRequired := fmt.Errorf("SSO session for profile %q has expired or was never started; run actool sso login --profile %s", settings.ProfileName, quoteCommandArg(settings.ProfileName))
+
+	data, err := p.loadOIDCToken(settings.StartURL)
+	if errors.Is(err, errSecretNotFound) {
+		return "", loginRequired
+	}
+	if err != nil {
+		return "", err
+	}
+	if data.Expiration.After(p.now().Add(ssoTokenExpiryWindow)) {
+		return data.Token.AccessToken, nil
+	}
+	if data.Token.RefreshToken == "" {
+		return "", loginRequired
+	}
+
+	client, err := p.loadOIDCClient(settings)
+	if err != nil || !p.ssoClientUsable(client, settings) {
+		return "", loginRequired
+	}
+	token, err := service.RefreshToken(ctx, &sso.ClientRegistration{ClientID: client.ClientID, ClientSecret: client.ClientSecret}, data.Token.RefreshToken)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w (refresh failed: %v)", loginRequired, err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = data.Token.RefreshToken
+	}
+	if err := p.saveOIDCToken(settings.StartURL, token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// ssoClient reuses the stored client registration until it nears expiry or
+// the requested scopes change.
+func (p *profile) ssoClient(ctx context.Context, service sso.Service, settings *ssoSettings) (*sso.ClientRegistration, error) {
+	client, err := p.loadOIDCClient(settings)
+	if err == nil && p.ssoClientUsable(client, settings) {
+		return &sso.ClientRegistration{ClientID: client.ClientID, ClientSecret: client.ClientSecret, ExpiresAt: client.ExpiresAt, Scopes: client.Scopes}, nil
+	}
+	if err != nil && !errors.Is(err, errSecretNotFound) {
+		return nil, err
+	}
+
+	registration, err := service.RegisterClient(ctx, ssoClientName, settings.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(oidcClientData{
+		ClientID:     registration.ClientID,
+		ClientSecret: registration.ClientSecret,
+		ExpiresAt:    registration.ExpiresAt.UTC(),
+		Scopes:       settings.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.secrets.Set(oidcClientKey(settings), encoded); err != nil {
+		return nil, err
+	}
+	return registration, nil
+}
+
+func (p *profile) ssoClientUsable(client *oidcClientData, settings *ssoSettings) bool {
+	return client.ClientID != "" && client.ExpiresAt.After(p.now().Add(ssoClientExpiryWindow)) && slices.Equal(client.Scopes, settings.Scopes)
+}
+
+func (p *profile) loadOIDCClient(settings *ssoSettings) (*oidcClientData, error) {
+	data, err := p.secrets.Get(oidcClientKey(settings))
+	if err != nil {
+		return nil, err
+	}
+	var client oidcClientData
+	if err := json.Unmarshal(data, &client); err != nil {
+		return nil, fmt.Errorf("stored SSO client registration is malformed: %w", err)
+	}
+	return &client, nil
+}
+
+func (p *profile) loadOIDCToken(startURL string) (*oidcTokenData, error) {
+	data, err := p.secrets.Get(oidcTokenKeyPrefix + startURL)
+	if err != nil {
+		return nil, err
+	}
+	var token oidcTokenData
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("stored SSO token for %s is malformed: %w", startURL, err)
+	}
+	return &token, nil
+}
+
+func (p *profile) saveOIDCToken(startURL string, token *sso.Token) error {
+	encoded, err := json.Marshal(oidcTokenData{
+		Token: oidcToken{
+			AccessToken:  token.AccessToken,
+			ExpiresIn:    token.ExpiresIn,
+			IdToken:      token.IDToken,
+			RefreshToken: token.RefreshToken,
+			TokenType:    token.TokenType,
+		},
+		Expiration: token.Expiration.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return p.secrets.Set(oidcTokenKeyPrefix+startURL, encoded)
+}
+
+// ssoSettings reports whether profileName is an IAM Identity Center profile
+// and resolves its start URL, region and scopes.
+func (p *profile) ssoSettings(profileName string) (*ssoSettings, bool, error) {
+	if err := validateProfileName(profileName); err != nil {
+		return nil, false, err
+	}
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return nil, false, err
+	}
+	return resolveSSOSettings(cfg, profileName)
+}
+
+func resolveSSOSettings(cfg *ini.File, profileName string) (*ssoSettings, bool, error) {
+	section, err := cfg.GetSection(profileSectionName(profileName))
+	if err != nil || !isSSOProfileSection(section) {
+		return nil, false, nil
+	}
+
+	settings := &ssoSettings{
+		ProfileName: profileName,
+		SessionName: strings.TrimSpace(section.Key(SSOSession).String()),
+		StartURL:    ssoSessionValue(cfg, section, SSOStartURL),
+		Region:      ssoSessionValue(cfg, section, SSORegion),
+		AccountID:   strings.TrimSpace(section.Key(SSOAccountID).String()),
+		RoleName:    strings.TrimSpace(section.Key(SSORoleName).String()),
+	}
+	if settings.SessionName != "" {
+		sessionSection, err := cfg.GetSection(ssoSessionSectionPrefix + settings.SessionName)
+		if err != nil {
+			return nil, false, fmt.Errorf("profile %q refers to missing [sso-session %s]", profileName, settings.SessionName)
+		}
+		settings.Scopes = []string{defaultSSORegistrationScope}
+		if scopes := strings.TrimSpace(sessionSection.Key(SSORegistrationScopes).String()); scopes != "" {
+			settings.Scopes = nil
+			for _, scope := range strings.Split(scopes, ",") {
+				if scope = strings.TrimSpace(scope); scope != "" {
+					settings.Scopes = append(settings.Scopes, scope)
+				}
+			}
+		}
+	}
+	if settings.StartURL == "" || settings.Region == "" {
+		return nil, false, fmt.Errorf("profile %q needs sso_start_url and sso_region", profileName)
+	}
+	return settings, true, nil
+}
+
+// ssoProfileNames lists AWS config profiles that actool can serve from IAM
+// Identity Center, skipping names that already have stored keys and profiles
+// whose SSO settings are incomplete.
+func (p *profile) ssoProfileNames(exclude []string) ([]string, error) {
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, section := range cfg.Sections() {
+		profileName, ok := configProfileName(section.Name())
+		if !ok || profileName == Default || containsProfile(exclude, profileName) {
+			continue
+		}
+		if _, isSSO, err := resolveSSOSettings(cfg, profileName); err != nil || !isSSO {
+			continue
+		}
+		names = append(names, profileName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isSSOProfileSection matches profiles whose only credential source is IAM
+// Identity Center.
+func isSSOProfileSection(section *ini.Section) bool {
+	if strings.TrimSpace(section.Key(SSOAccountID).String()) == "" || strings.TrimSpace(section.Key(SSORoleName).String()) == "" {
+		return false
+	}
+	if strings.TrimSpace(section.Key(SSOSession).String()) == "" && strings.TrimSpace(section.Key(SSOStartURL).String()) == "" {
+		return false
+	}
+	for _, keyName := range []string{"role_arn", "source_profile", "credential_source", "web_identity_token_file", "web_identity_token_process"} {
+		if strings.TrimSpace(section.Key(keyName).String()) != "" {
+			return false
+		}
+	}
+	return !hasStaticCredentials(section)
+}
+
+// ssoSessionValue reads keyName from the profile, falling back to the
+// [sso-session] section it refers to.
+func ssoSessionValue(cfg *ini.File, section *ini.Section, keyName string) string {
+	if value := strings.TrimSpace(section.Key(keyName).String()); value != "" {
+		return value
+	}
+	sessionName := strings.TrimSpace(section.Key(SSOSession).String())
+	if sessionName == "" {
+		return ""
+	}
+	sessionSection, err := cfg.GetSection(ssoSessionSectionPrefix + sessionName)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(sessionSection.Key(keyName).String())
+}
+
+func oidcClientKey(settings *ssoSettings) string {
+	return oidcClientKeyPrefix + settings.Region + ":" + settings.StartURL
+}
@@ -0,0 +1,239 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sso"
+)
+
+const (
+	testSSOStartURL = "https://example.awsapps.com/start"
+	testSSOConfig   = `[profile sso-dev]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = ReadOnly
+region = eu-west-1
+
+[profile sso-legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 210987654321
+sso_role_name = Admin
+
+[profile broken]
+sso_session = missing
+sso_account_id = 123456789012
+sso_role_name = ReadOnly
+
+[sso-session corp]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_registration_scopes = sso:account:access
+`
+)
+
+type fakeSSOService struct {
+	options       []sso.Options
+	registrations int
+	refreshes     int
+	roleCalls     []string
+	accessToken   string
+}
+
+func (f *fakeSSOService) RegisterClient(_ context.Context, clientName string, scopes []string) (*sso.ClientRegistration, error) {
+	f.registrations++
+	return &sso.ClientRegistration{ClientID: "client-" + clientName, ClientSecret: "secret", ExpiresAt: time.Now().Add(90 * 24 * time.Hour), Scopes: scopes}, nil
+}
+
+func (f *fakeSSOService) StartDeviceAuthorization(_ context.Context, _ *sso.ClientRegistration, startURL string) (*sso.DeviceAuthorization, error) {
+	return &sso.DeviceAuthorization{DeviceCode: "device", UserCode: "ABCD-EFGH", VerificationURIComplete: startURL + "/device"}, nil
+}
+
+func (f *fakeSSOService) WaitForToken(context.Context, *sso.ClientRegistration, *sso.DeviceAuthorization) (*sso.Token, error) {
+	return &sso.Token{AccessToken: "access-token", RefreshToken: "refresh-token", TokenType: "Bearer", ExpiresIn: 3600, Expiration: time.Now().Add(time.Hour)}, nil
+}
+
+func (f *fakeSSOService) RefreshToken(_ context.Context, _ *sso.ClientRegistration, refreshToken string) (*sso.Token, error) {
+	f.refreshes++
+	return &sso.Token{AccessToken: "refreshed-" + refreshToken, ExpiresIn: 3600, Expiration: time.Now().Add(time.Hour)}, nil
+}
+
+func (f *fakeSSOService) RoleCredentials(_ context.Context, accessToken string, accountID string, roleName string) (*sso.RoleCredentials, error) {
+	f.accessToken = accessToken
+	f.roleCalls = append(f.roleCalls, accountID+"/"+roleName)
+	return &sso.RoleCredentials{AccessKey: "ROLEACCESSKEY", SecretKey: "ROLESECRETKEY", SessionToken: "ROLESESSIONTOKEN", Expiration: time.Now().Add(time.Hour)}, nil
+}
+
+func newSSOTestProfile(t *testing.T) (*profile, *fakeSecretStore, *fakeSSOService) {
+	t.Helper()
+	store := newFakeSecretStore()
+	p := newTestProfile(t, store)
+	writeTestFile(t, p.configPath, testSSOConfig)
+	service := &fakeSSOService{}
+	p.ssoService = func(options sso.Options) sso.Service {
+		service.options = append(service.options, options)
+		return service
+	}
+	return p, store, service
+}
+
+func storeOIDCToken(t *testing.T, p *profile, token *sso.Token) {
+	t.Helper()
+	assert.NilError(t, p.saveOIDCToken(testSSOStartURL, token))
+}
+
+func TestSSOLoginStoresAWSVaultCompatibleToken(t *testing.T) {
+	p, store, service := newSSOTestProfile(t)
+
+	var prompted *sso.DeviceAuthorization
+	prompt := func(authorization *sso.DeviceAuthorization) error {
+		prompted = authorization
+		return nil
+	}
+	assert.NilError(t, p.SSOLogin(context.Background(), "sso-dev", prompt))
+	assert.Equal(t, prompted.UserCode, "ABCD-EFGH")
+	assert.Equal(t, service.options[0].Region, "us-east-1")
+
+	var stored map[string]interface{}
+	assert.NilError(t, json.Unmarshal(store.values["oidc:"+testSSOStartURL], &stored))
+	token := stored["Token"].(map[string]interface{})
+	assert.Equal(t, token["AccessToken"], "access-token")
+	assert.Equal(t, token["RefreshToken"], "refresh-token")
+	assert.Assert(t, stored["Expiration"] != nil)
+
+	client, err := p.loadOIDCClient(&ssoSettings{Region: "us-east-1", StartURL: testSSOStartURL})
+	assert.NilError(t, err)
+	assert.Equal(t, client.ClientID, "client-actool")
+	assert.DeepEqual(t, client.Scopes, []string{"sso:account:access"})
+
+	assert.NilError(t, p.SSOLogin(context.Background(), "sso-dev", nil))
+	assert.Equal(t, service.registrations, 1)
+
+	loggedIn, err := p.SSOLoggedIn("sso-dev")
+	assert.NilError(t, err)
+	assert.Assert(t, loggedIn)
+	loggedIn, err = p.SSOLoggedIn("sso-legacy")
+	assert.NilError(t, err)
+	assert.Assert(t, !loggedIn)
+}
+
+func TestSSOSettingsResolution(t *testing.T) {
+	p, _, _ := newSSOTestProfile(t)
+
+	settings, ok, err := p.ssoSettings("sso-legacy")
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	assert.Equal(t, settings.StartURL, "https://legacy.awsapps.com/start")
+	assert.Equal(t, settings.Region, "us-east-1")
+	assert.Assert(t, settings.Scopes == nil)
+
+	_, _, err = p.ssoSettings("broken")
+	assert.ErrorContains(t, err, "missing [sso-session missing]")
+
+	_, ok, err = p.ssoSettings("unknown")
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+
+	assert.ErrorContains(t, p.SSOLogin(context.Background(), "unknown", nil), "has no sso_session")
+}
+
+func TestCredentialProcessServesSSOProfile(t *testing.T) {
+	p, store, service := newSSOTestProfile(t)
+	storeOIDCToken(t, p, &sso.Token{AccessToken: "stored-token", Expiration: time.Now().Add(time.Hour)})
+
+	payload, err := p.CredentialProcessPayload(context.Background(), "sso-dev")
+	assert.NilError(t, err)
+	value := credentialProcessJSON(t, payload)
+	assert.Equal(t, value["AccessKeyId"], "ROLEACCESSKEY")
+	assert.Equal(t, value["SessionToken"], "ROLESESSIONTOKEN")
+	assert.Equal(t, service.accessToken, "stored-token")
+	assert.DeepEqual(t, service.roleCalls, []string{"123456789012/ReadOnly"})
+
+	cached := 0
+	for key := range store.values {
+		if metadata, ok := parseSessionKey(key); ok && metadata.Type == sessionTypeSSORole && metadata.ProfileName == "sso-dev" {
+			cached++
+		}
+	}
+	assert.Equal(t, cached, 1)
+
+	_, err = p.CredentialProcessPayload(context.Background(), "sso-dev")
+	assert.NilError(t, err)
+	assert.Equal(t, len(service.roleCalls), 1)
+}
+
+func TestCredentialProcessSSOTokenExpiry(t *testing.T) {
+	cases := []struct {
+		name        string
+		token       *sso.Token
+		client      bool
+		wantToken   string
+		wantErr     string
+		wantRefresh int
+	}{
+		{name: "never logged in", wantErr: "run actool sso login --profile sso-dev"},
+		{name: "expired without refresh token", token: &sso.Token{AccessToken: "old", Expiration: time.Now().Add(-time.Minute)}, wantErr: "has expired"},
+		{name: "expired without client registration", token: &sso.Token{AccessToken: "old", RefreshToken: "refresh", Expiration: time.Now().Add(-time.Minute)}, wantErr: "has expired"},
+		{name: "refreshes expired token", token: &sso.Token{AccessToken: "old", RefreshToken: "refresh", Expiration: time.Now().Add(-time.Minute)}, client: true, wantToken: "refreshed-refresh", wantRefresh: 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _, service := newSSOTestProfile(t)
+			if tc.token != nil {
+				storeOIDCToken(t, p, tc.token)
+			}
+			if tc.client {
+				settings, _, err := p.ssoSettings("sso-dev")
+				assert.NilError(t, err)
+				_, err = p.ssoClient(context.Background(), service, settings)
+				assert.NilError(t, err)
+			}
+
+			_, err := p.CredentialProcessPayload(context.Background(), "sso-dev")
+			assert.Equal(t, service.refreshes, tc.wantRefresh)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, service.accessToken, tc.wantToken)
+
+			stored, err := p.loadOIDCToken(testSSOStartURL)
+			assert.NilError(t, err)
+			assert.Equal(t, stored.Token.AccessToken, tc.wantToken)
+			assert.Equal(t, stored.Token.RefreshToken, "refresh")
+		})
+	}
+}
+
+func TestSSOProfilesAreListedAndSelectable(t *testing.T) {
+	p, _, _ := newSSOTestProfile(t)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, model.SSOProfiles, []string{"sso-dev", "sso-legacy"})
+
+	assert.NilError(t, p.SetSelected("sso-dev"))
+	cfg, err := ini.Load(p.configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Section(Default).Key(CredentialProcess).String(), "actool credential-process --profile sso-dev")
+	assert.Equal(t, cfg.Section(Default).Key(Region).String(), "eu-west-1")
+	assert.Equal(t, cfg.Section("profile sso-dev").Key(CredentialProcess).String(), "")
+
+	model, err = p.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, model.SelectedProfile, "sso-dev")
+
+	config, err := p.Config(model, "sso-dev")
+	assert.NilError(t, err)
+	assert.Equal(t, config.SSOStartURL, testSSOStartURL)
+	assert.Equal(t, config.SSORegion, "us-east-1")
+}
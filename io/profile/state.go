@@ -0,0 +1,268 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ProfileColors are the colours a profile can be shown in.
+var ProfileColors = []string{"red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ProfileMetadata is what actool records about a profile in state.json. It
+// never holds secrets.
+type ProfileMetadata struct {
+	Description        string     `json:",omitempty"`
+	Tags               []string   `json:",omitempty"`
+	Color              string     `json:",omitempty"`
+	Favorite           bool       `json:",omitempty"`
+	LastUsed           *time.Time `json:",omitempty"`
+	LastSessionRefresh *time.Time `json:",omitempty"`
+}
+
+// ProfileFilter selects profiles by their metadata. The zero value matches
+// every profile.
+type ProfileFilter struct {
+	// Tags must all be present on a matching profile.
+	Tags      []string
+	Favorites bool
+}
+
+// ProfileSummary is a profile as listed by actool profile list.
+type ProfileSummary struct {
+	Name     string
+	SSO      bool
+	Selected bool
+	Metadata ProfileMetadata
+}
+
+// stateMigration upgrades the decoded JSON of state.json from version from
+// to from+1. Migrations work on the raw fields so that they keep working
+// after profileState changes.
+type stateMigration struct {
+	from    int
+	migrate func(fields map[string]json.RawMessage) error
+}
+
+var stateMigrations = []stateMigration{
+	{from: 1, migrate: migrateStateV1},
+}
+
+// migrateStateV1 adds the per-profile metadata map.
+func migrateStateV1(fields map[string]json.RawMessage) error {
+	if _, ok := fields["Profiles"]; !ok {
+		fields["Profiles"] = json.RawMessage("{}")
+	}
+	return nil
+}
+
+// decodeState decodes state.json, upgrading older versions in memory. It
+// returns the version found on disk.
+func decodeState(data []byte) (profileState, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return profileState{}, 0, err
+	}
+	version := 0
+	if raw, ok := fields["Version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return profileState{}, 0, fmt.Errorf("invalid actool state version: %w", err)
+		}
+	}
+	if version > stateVersion || version < 0 {
+		return profileState{}, 0, fmt.Errorf("unsupported actool state version: %d; it was written by a newer actool", version)
+	}
+	if version == 0 {
+		// Written before the version field was introduced.
+		version = 1
+	}
+	found := version
+
+	for version < stateVersion {
+		index := slices.IndexFunc(stateMigrations, func(m stateMigration) bool { return m.from == version })
+		if index < 0 {
+			return profileState{}, 0, fmt.Errorf("unsupported actool state version: %d", version)
+		}
+		if err := stateMigrations[index].migrate(fields); err != nil {
+			return profileState{}, 0, fmt.Errorf("migrate actool state from version %d: %w", version, err)
+		}
+		version++
+		fields["Version"] = json.RawMessage(fmt.Sprint(version))
+	}
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return profileState{}, 0, err
+	}
+	var state profileState
+	if err := json.Unmarshal(migrated, &state); err != nil {
+		return profileState{}, 0, err
+	}
+	return state, found, nil
+}
+
+// stateBackupPath is where state.json written by version is kept before it
+// is first overwritten with the current version.
+func stateBackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// backupOlderState copies state.json aside when it still has an older
+// version. An existing backup is kept, so it always holds the file as the
+// older actool left it.
+func (f *fileStateStore) backupOlderState() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	_, version, err := decodeState(data)
+	if err != nil || version >= stateVersion {
+		// An unreadable file is replaced as before.
+		return nil
+	}
+	backup := stateBackupPath(f.path, version)
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	return writeFileAtomic(backup, data, 0o600)
+}
+
+func (s *profileState) updateMetadata(profileName string, update func(metadata *ProfileMetadata)) {
+	if s.Profiles == nil {
+		s.Profiles = make(map[string]ProfileMetadata)
+	}
+	metadata := s.Profiles[profileName]
+	update(&metadata)
+	s.Profiles[profileName] = metadata
+}
+
+// Match reports whether metadata satisfies the filter.
+func (f ProfileFilter) Match(metadata ProfileMetadata) bool {
+	if f.Favorites && !metadata.Favorite {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(metadata.Tags, strings.ToLower(strings.TrimSpace(tag))) {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeTags lowercases, trims, sorts and deduplicates tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if strings.ContainsAny(tag, ", \t\r\n\x00") {
+			return nil, fmt.Errorf("tag must not contain commas or whitespace: %q", tag)
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+func validateProfileMetadata(metadata *ProfileMetadata) error {
+	metadata.Description = strings.TrimSpace(metadata.Description)
+	if strings.ContainsAny(metadata.Description, "\x00\r\n") {
+		return errors.New("description must be a single line")
+	}
+	tags, err := NormalizeTags(metadata.Tags)
+	if err != nil {
+		return err
+	}
+	metadata.Tags = tags
+	if len(metadata.Tags) == 0 {
+		metadata.Tags = nil
+	}
+	metadata.Color = strings.ToLower(strings.TrimSpace(metadata.Color))
+	if metadata.Color != "" && !slices.Contains(ProfileColors, metadata.Color) {
+		return fmt.Errorf("unknown colour %q; use one of %s", metadata.Color, strings.Join(ProfileColors, ", "))
+	}
+	return nil
+}
+
+// UpdateProfileMetadata changes the description, tags, colour or favourite
+// flag of a stored or IAM Identity Center profile.
+func (p *profile) UpdateProfileMetadata(profileName string, update func(metadata *ProfileMetadata)) error {
+	if err := validateProfileName(profileName); err != nil {
+		return err
+	}
+	return p.withLock(func() error {
+		profileNames, err := p.profileNames()
+		if err != nil {
+			return err
+		}
+		if !containsProfile(profileNames, profileName) {
+			if _, isSSO, err := p.ssoSettings(profileName); err != nil {
+				return err
+			} else if !isSSO {
+				return fmt.Errorf("profile not found. [%s]", profileName)
+			}
+		}
+
+		state, err := p.loadState()
+		if err != nil && !errors.Is(err, errStateNotFound) {
+			return err
+		}
+		metadata := state.Profiles[profileName]
+		update(&metadata)
+		if err := validateProfileMetadata(&metadata); err != nil {
+			return err
+		}
+		state.updateMetadata(profileName, func(current *ProfileMetadata) {
+			*current = metadata
+		})
+		return p.saveState(state)
+	})
+}
+
+// Profiles lists the stored and IAM Identity Center profiles that match
+// filter, with their metadata. Like credential-process it does not migrate
+// or rewrite anything.
+func (p *profile) Profiles(filter ProfileFilter) ([]ProfileSummary, error) {
+	profileNames, err := p.profileNames()
+	if err != nil {
+		return nil, err
+	}
+	ssoProfiles, err := p.ssoProfileNames(profileNames)
+	if err != nil {
+		return nil, err
+	}
+	state, err := p.loadState()
+	if err != nil && !errors.Is(err, errStateNotFound) {
+		return nil, err
+	}
+
+	summaries := make([]ProfileSummary, 0, len(profileNames)+len(ssoProfiles))
+	add := func(name string, isSSO bool) {
+		metadata := state.Profiles[name]
+		if !filter.Match(metadata) {
+			return
+		}
+		summaries = append(summaries, ProfileSummary{
+			Name:     name,
+			SSO:      isSSO,
+			Selected: name == state.SelectedProfile,
+			Metadata: metadata,
+		})
+	}
+	for _, name := range profileNames {
+		add(name, false)
+	}
+	for _, name := range ssoProfiles {
+		add(name, true)
+	}
+	return summaries, nil
+}
@@ -0,0 +1,182 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFileStateStoreMigratesVersion1(t *testing.T) {
+	cases := []struct {
+		name       string
+		contents   string
+		wantBackup string
+	}{
+		{name: "version 1", contents: `{"Version":1,"SelectedProfile":"dev","LegacyCredentialsHash":"hash"}`, wantBackup: "state.json.v1.bak"},
+		{name: "before versions", contents: `{"SelectedProfile":"dev","LegacyCredentialsHash":"hash"}`, wantBackup: "state.json.v1.bak"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "state.json")
+			writeTestFile(t, path, tc.contents)
+			store := &fileStateStore{path: path}
+
+			state, err := store.Load()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, state, profileState{
+				Version:               stateVersion,
+				SelectedProfile:       "dev",
+				LegacyCredentialsHash: "hash",
+				Profiles:              map[string]ProfileMetadata{},
+			})
+			// Loading alone does not rewrite the file.
+			_, err = os.Stat(filepath.Join(dir, tc.wantBackup))
+			assert.Assert(t, os.IsNotExist(err))
+
+			state.updateMetadata("dev", func(metadata *ProfileMetadata) { metadata.Favorite = true })
+			assert.NilError(t, store.Save(state))
+			backup, err := os.ReadFile(filepath.Join(dir, tc.wantBackup))
+			assert.NilError(t, err)
+			assert.Equal(t, string(backup), tc.contents)
+			info, err := os.Stat(filepath.Join(dir, tc.wantBackup))
+			assert.NilError(t, err)
+			assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+			got, err := store.Load()
+			assert.NilError(t, err)
+			assert.Assert(t, got.Profiles["dev"].Favorite)
+
+			// Later saves keep the original backup.
+			assert.NilError(t, store.Save(got))
+			backup, err = os.ReadFile(filepath.Join(dir, tc.wantBackup))
+			assert.NilError(t, err)
+			assert.Equal(t, string(backup), tc.contents)
+		})
+	}
+}
+
+func TestDecodeStateRejectsNewerVersions(t *testing.T) {
+	_, _, err := decodeState([]byte(`{"Version":99}`))
+	assert.ErrorContains(t, err, "written by a newer actool")
+
+	_, _, err = decodeState([]byte(`{"Version":"two"}`))
+	assert.ErrorContains(t, err, "invalid actool state version")
+}
+
+func TestStateMigrationsCoverEveryVersion(t *testing.T) {
+	for version := 1; version < stateVersion; version++ {
+		found := false
+		for _, migration := range stateMigrations {
+			found = found || migration.from == version
+		}
+		assert.Assert(t, found, "no migration from state version %d", version)
+	}
+}
+
+func TestUpdateProfileMetadata(t *testing.T) {
+	cases := []struct {
+		name    string
+		profile string
+		update  func(metadata *ProfileMetadata)
+		want    ProfileMetadata
+		wantErr string
+	}{
+		{
+			name:    "normalizes fields",
+			profile: "dev",
+			update: func(metadata *ProfileMetadata) {
+				metadata.Description = "  Development account "
+				metadata.Tags = []string{"Team-A", "dev", "team-a", ""}
+				metadata.Color = "Green"
+				metadata.Favorite = true
+			},
+			want: ProfileMetadata{Description: "Development account", Tags: []string{"dev", "team-a"}, Color: "green", Favorite: true},
+		},
+		{name: "unknown colour", profile: "dev", update: func(metadata *ProfileMetadata) { metadata.Color = "orange" }, wantErr: `unknown colour "orange"`},
+		{name: "tag with whitespace", profile: "dev", update: func(metadata *ProfileMetadata) { metadata.Tags = []string{"two words"} }, wantErr: "must not contain commas or whitespace"},
+		{name: "multi-line description", profile: "dev", update: func(metadata *ProfileMetadata) { metadata.Description = "a\nb" }, wantErr: "single line"},
+		{name: "unknown profile", profile: "missing", update: func(*ProfileMetadata) {}, wantErr: "profile not found. [missing]"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProfile(t, newFakeSecretStore())
+			storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+
+			err := p.UpdateProfileMetadata(tc.profile, tc.update)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			state, err := p.loadState()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, state.Profiles[tc.profile], tc.want)
+		})
+	}
+}
+
+func TestProfileMetadataTracksUse(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "prod", "PRODACCESSKEY", "PRODSECRETKEY", nil)
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return clock }
+
+	assert.NilError(t, p.SetSelected("dev"))
+	expiration := time.Now().Add(time.Hour)
+	clock = clock.Add(time.Minute)
+	assert.NilError(t, p.StoreSessionToken("prod", &Credential{AccessKey: "A", SecretKey: "S", SessionToken: "T", Expiration: &expiration}))
+
+	model, err := p.Load()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *model.Metadata["dev"].LastUsed, time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	assert.Assert(t, model.Metadata["dev"].LastSessionRefresh == nil)
+	assert.DeepEqual(t, *model.Metadata["prod"].LastUsed, clock)
+	assert.DeepEqual(t, *model.Metadata["prod"].LastSessionRefresh, clock)
+}
+
+func TestProfilesFiltersByMetadata(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	for _, name := range []string{"default", "dev", "prod"} {
+		storeBaseCredential(t, p, name, "ACCESSKEY", "SECRETKEY", nil)
+	}
+	assert.NilError(t, p.SetSelected("dev"))
+	assert.NilError(t, p.UpdateProfileMetadata("dev", func(metadata *ProfileMetadata) {
+		metadata.Tags = []string{"team-a", "nonprod"}
+		metadata.Favorite = true
+	}))
+	assert.NilError(t, p.UpdateProfileMetadata("prod", func(metadata *ProfileMetadata) {
+		metadata.Tags = []string{"team-a"}
+	}))
+
+	cases := []struct {
+		name   string
+		filter ProfileFilter
+		want   []string
+	}{
+		{name: "everything", want: []string{"default", "dev", "prod"}},
+		{name: "tag", filter: ProfileFilter{Tags: []string{"Team-A"}}, want: []string{"dev", "prod"}},
+		{name: "all tags", filter: ProfileFilter{Tags: []string{"team-a", "nonprod"}}, want: []string{"dev"}},
+		{name: "favourites", filter: ProfileFilter{Favorites: true}, want: []string{"dev"}},
+		{name: "no match", filter: ProfileFilter{Tags: []string{"team-b"}}, want: []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			summaries, err := p.Profiles(tc.filter)
+			assert.NilError(t, err)
+			names := make([]string, 0, len(summaries))
+			for _, summary := range summaries {
+				names = append(names, summary.Name)
+				assert.Equal(t, summary.Selected, summary.Name == "dev")
+			}
+			assert.DeepEqual(t, names, tc.want)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// UninstallOptions controls what actool uninstall does besides reverting
+// the AWS config.
+type UninstallOptions struct {
+	// ExportCredentialsPath, when set, receives the stored base credentials
+	// as a shared credentials file, so that the AWS CLI keeps working
+	// without actool. The file must not exist yet.
+	ExportCredentialsPath string
+	// RemoveState also deletes state.json and the files kept beside it.
+	// Without it they are left for a later reinstall.
+	RemoveState bool
+}
+
+// UninstallSummary lists what actool uninstall changed.
+type UninstallSummary struct {
+	// RemovedKeys are "[section] key" entries removed from the AWS config.
+	RemovedKeys []string
+	// RemovedSections are sections left empty once actool's keys were gone.
+	RemovedSections []string
+	// ExportedProfiles are the profiles written to ExportPath.
+	ExportedProfiles []string
+	ExportPath       string
+	// RemovedFiles are actool's own state files that were deleted.
+	RemovedFiles []string
+	// KeptFiles are the state files left in place without RemoveState.
+	KeptFiles []string
+}
+
+// Uninstall removes what actool added to the AWS config: credential_process
+// lines that run actool and the actool_copied_keys marker. Sections that are
+// empty afterwards are dropped. With options.RemoveState it then deletes
+// state.json and the files kept beside it, except for the config backups.
+// Keyring items are left in place for aws-vault and other tools.
+func (p *profile) Uninstall(options UninstallOptions) (*UninstallSummary, error) {
+	summary := &UninstallSummary{}
+	err := p.withLock(func() error {
+		if options.ExportCredentialsPath != "" {
+			if err := p.exportCredentials(options.ExportCredentialsPath, summary); err != nil {
+				return err
+			}
+		}
+		if err := p.revertConfig(summary); err != nil {
+			return err
+		}
+		return p.removeStateFiles(options.RemoveState, summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (p *profile) revertConfig(summary *UninstallSummary) error {
+	cfg, err := p.loadConfigFile()
+	if err != nil {
+		return err
+	}
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		hadKeys := len(section.Keys()) > 0
+		if section.Name() == Default {
+			for _, keyName := range revertCopiedKeys(section) {
+				summary.RemovedKeys = append(summary.RemovedKeys, fmt.Sprintf("[%s] %s", section.Name(), keyName))
+			}
+		}
+		if p.isActoolCredentialProcess(keyValue(section, CredentialProcess)) {
+			section.DeleteKey(CredentialProcess)
+			summary.RemovedKeys = append(summary.RemovedKeys, fmt.Sprintf("[%s] %s", section.Name(), CredentialProcess))
+		}
+		if section.HasKey(CopiedKeys) {
+			section.DeleteKey(CopiedKeys)
+			summary.RemovedKeys = append(summary.RemovedKeys, fmt.Sprintf("[%s] %s", section.Name(), CopiedKeys))
+		}
+		if hadKeys && len(section.Keys()) == 0 {
+			cfg.DeleteSection(section.Name())
+			summary.RemovedSections = append(summary.RemovedSections, section.Name())
+		}
+	}
+	if len(summary.RemovedKeys) == 0 {
+		return nil
+	}
+	return p.saveConfig(cfg)
+}
+
+// revertCopiedKeys removes the keys actool copied into [default] and puts
+// back the values they replaced. It returns the keys it removed: a copied
+// key, or the actool_original_ key whose value was put back.
+func revertCopiedKeys(defaultSection *ini.Section) []string {
+	var removed []string
+	for _, keyName := range splitKeyList(keyValue(defaultSection, CopiedKeys)) {
+		if !copiableKey(keyName) {
+			continue
+		}
+		original := OriginalKeyPrefix + keyName
+		restored := defaultSection.HasKey(original)
+		if !restoreDefaultKey(defaultSection, keyName) {
+			continue
+		}
+		if restored {
+			removed = append(removed, original)
+		} else {
+			removed = append(removed, keyName)
+		}
+	}
+	for _, keyName := range defaultSection.KeyStrings() {
+		copiedName := strings.TrimPrefix(keyName, OriginalKeyPrefix)
+		if copiedName != keyName && copiedName != "" && restoreDefaultKey(defaultSection, copiedName) {
+			removed = append(removed, keyName)
+		}
+	}
+	return removed
+}
+
+// exportCredentials writes the base credentials as a shared credentials
+// file. The selected profile is also written as [default] unless a base
+// credential of that name exists, as actool's [default] served it.
+func (p *profile) exportCredentials(path string, summary *UninstallSummary) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("export target %s already exists; actool did not overwrite it", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	names, credentials, err := p.loadBaseCredentials()
+	if err != nil {
+		return err
+	}
+	if len(credentials) == 0 {
+		return errors.New("no credentials are stored to export")
+	}
+
+	exported := make([]*Credential, 0, len(credentials)+1)
+	exported = append(exported, credentials...)
+	if state, err := p.loadState(); err == nil && state.SelectedProfile != "" && state.SelectedProfile != Default {
+		hasDefault := false
+		var selected *Credential
+		for _, credential := range credentials {
+			hasDefault = hasDefault || credential.Name == Default
+			if credential.Name == state.SelectedProfile {
+				selected = credential
+			}
+		}
+		if !hasDefault && selected != nil {
+			defaultCredential := *selected
+			defaultCredential.Name = Default
+			exported = append([]*Credential{&defaultCredential}, exported...)
+			names = append([]string{Default}, names...)
+		}
+	}
+
+	cfg := ini.Empty()
+	for _, credential := range exported {
+		section, err := cfg.NewSection(credential.Name)
+		if err != nil {
+			return err
+		}
+		_, _ = section.NewKey(AWSAccessKeyId, credential.AccessKey)
+		_, _ = section.NewKey(AWSSecretAccessKey, credential.SecretKey)
+		if credential.SessionToken != "" {
+			_, _ = section.NewKey(AWSSessionToken, credential.SessionToken)
+		}
+	}
+	var buffer bytes.Buffer
+	if _, err := cfg.WriteTo(&buffer); err != nil {
+		return err
+	}
+	if err := p.writeSecretFile(path, buffer.Bytes()); err != nil {
+		return err
+	}
+	summary.ExportedProfiles = names
+	summary.ExportPath = path
+	return nil
+}
+
+// removeStateFiles deletes state.json and the history, keyring index and
+// directory trust store kept beside it, or only lists them when remove is
+// false. The config backups stay, so the uninstall itself can be rolled
+// back with actool config restore.
+func (p *profile) removeStateFiles(remove bool, summary *UninstallSummary) error {
+	if p.statePath == "" {
+		return nil
+	}
+	dir := filepath.Dir(p.statePath)
+	for _, path := range []string{p.statePath, filepath.Join(dir, historyFileName), keyringIndexPath(p.statePath), filepath.Join(dir, trustFileName)} {
+		if _, err := p.readFile(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !remove {
+			summary.KeptFiles = append(summary.KeptFiles, path)
+			continue
+		}
+		if err := p.removeFile(path); err != nil {
+			return err
+		}
+		summary.RemovedFiles = append(summary.RemovedFiles, path)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+	"gotest.tools/v3/assert"
+)
+
+const uninstallTestConfig = `[default]
+credential_process = actool credential-process --profile dev
+region             = eu-west-1
+output             = json
+actool_copied_keys = output,region
+actool_original_output = text
+
+[profile dev]
+credential_process = actool credential-process --profile dev
+
+[profile keep]
+credential_process = actool credential-process --profile keep
+region             = us-east-1
+
+[profile other]
+credential_process = other-tool get-credentials
+
+[profile empty]
+`
+
+func TestUninstallRevertsConfig(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, uninstallTestConfig)
+
+	summary, err := p.Uninstall(UninstallOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, summary.RemovedKeys, []string{
+		"[default] actool_original_output",
+		"[default] region",
+		"[default] credential_process",
+		"[default] actool_copied_keys",
+		"[profile dev] credential_process",
+		"[profile keep] credential_process",
+	})
+	assert.DeepEqual(t, summary.RemovedSections, []string{"profile dev"})
+
+	cfg, err := ini.Load(p.configPath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg.SectionStrings(), []string{ini.DefaultSection, Default, "profile keep", "profile other", "profile empty"})
+	// Copied keys are removed, and the value a copied key replaced is back.
+	assert.DeepEqual(t, cfg.Section(Default).KeyStrings(), []string{Output})
+	assert.Equal(t, cfg.Section(Default).Key(Output).String(), "text")
+	assert.DeepEqual(t, cfg.Section("profile keep").KeyStrings(), []string{Region})
+	assert.Equal(t, cfg.Section("profile other").Key(CredentialProcess).String(), "other-tool get-credentials")
+
+	// A second run finds nothing left to remove.
+	summary, err = p.Uninstall(UninstallOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(summary.RemovedKeys), 0)
+}
+
+func TestUninstallRestoresDefaultAfterSelection(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, "[default]\nregion = ap-northeast-1\n\n[profile dev]\nregion = eu-west-1\noutput = json\n")
+	storeBaseCredential(t, p, "dev", "ACCESSKEY", "SECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+	assert.Equal(t, loadTestConfig(t, p).Section(Default).Key(Region).String(), "eu-west-1")
+
+	_, err := p.Uninstall(UninstallOptions{})
+	assert.NilError(t, err)
+	cfg := loadTestConfig(t, p)
+	assert.DeepEqual(t, cfg.Section(Default).KeyStrings(), []string{Region})
+	assert.Equal(t, cfg.Section(Default).Key(Region).String(), "ap-northeast-1")
+	assert.DeepEqual(t, cfg.Section("profile dev").KeyStrings(), []string{Region, Output})
+}
+
+func TestUninstallExportsCredentialsAndRemovesState(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	dir := filepath.Dir(p.configPath)
+	p.statePath = filepath.Join(dir, "state.json")
+	writeTestFile(t, p.configPath, uninstallTestConfig)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	storeBaseCredential(t, p, "keep", "KEEPACCESSKEY", "KEEPSECRETKEY", nil)
+	assert.NilError(t, p.SetSelected("dev"))
+	for _, name := range []string{"state.json", historyFileName, keyringIndexFileName} {
+		writeTestFile(t, filepath.Join(dir, name), "{}\n")
+	}
+	exportPath := filepath.Join(dir, "exported-credentials")
+
+	summary, err := p.Uninstall(UninstallOptions{ExportCredentialsPath: exportPath, RemoveState: true})
+	assert.NilError(t, err)
+	assert.Equal(t, summary.ExportPath, exportPath)
+	assert.DeepEqual(t, summary.ExportedProfiles, []string{Default, "dev", "keep"})
+	assert.DeepEqual(t, summary.RemovedFiles, []string{
+		filepath.Join(dir, "state.json"),
+		filepath.Join(dir, historyFileName),
+		filepath.Join(dir, keyringIndexFileName),
+	})
+	assert.Equal(t, len(summary.KeptFiles), 0)
+	for _, path := range summary.RemovedFiles {
+		_, err := os.Stat(path)
+		assert.Assert(t, os.IsNotExist(err), path)
+	}
+
+	info, err := os.Stat(exportPath)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+	exported, err := ini.Load(exportPath)
+	assert.NilError(t, err)
+	assert.Equal(t, exported.Section(Default).Key(AWSAccessKeyId).String(), "DEVACCESSKEY")
+	assert.Equal(t, exported.Section("keep").Key(AWSSecretAccessKey).String(), "KEEPSECRETKEY")
+
+	_, err = p.Uninstall(UninstallOptions{ExportCredentialsPath: exportPath})
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestUninstallKeepsStateByDefault(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	dir := filepath.Dir(p.configPath)
+	p.statePath = filepath.Join(dir, "state.json")
+	writeTestFile(t, p.configPath, uninstallTestConfig)
+	for _, name := range []string{"state.json", historyFileName, trustFileName} {
+		writeTestFile(t, filepath.Join(dir, name), "{}\n")
+	}
+
+	summary, err := p.Uninstall(UninstallOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(summary.RemovedFiles), 0)
+	assert.DeepEqual(t, summary.KeptFiles, []string{
+		filepath.Join(dir, "state.json"),
+		filepath.Join(dir, historyFileName),
+		filepath.Join(dir, trustFileName),
+	})
+	for _, path := range summary.KeptFiles {
+		_, err := os.Stat(path)
+		assert.NilError(t, err)
+	}
+}
+
+func TestDryRunUninstallIsRedacted(t *testing.T) {
+	p := newTestProfile(t, newFakeSecretStore())
+	writeTestFile(t, p.configPath, uninstallTestConfig)
+	storeBaseCredential(t, p, "dev", "DEVACCESSKEY", "DEVSECRETKEY", nil)
+	exportPath := filepath.Join(filepath.Dir(p.configPath), "exported-credentials")
+	plan := startDryRun(t, p)
+
+	_, err := p.Uninstall(UninstallOptions{ExportCredentialsPath: exportPath})
+	assert.NilError(t, err)
+
+	_, err = os.Stat(exportPath)
+	assert.Assert(t, os.IsNotExist(err))
+	output := planOutput(t, plan)
+	assert.Assert(t, strings.Contains(output, "create  "+exportPath), output)
+	assert.Assert(t, strings.Contains(output, "-credential_process = actool credential-process --profile dev"), output)
+	assert.Assert(t, !strings.Contains(output, "DEVSECRETKEY"), output)
+}
@@ -0,0 +1,47 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/vaultkv"
+)
+
+// vaultStore keeps the secrets in a HashiCorp Vault KV v2 engine, configured
+// through the VAULT_* and ACTOOL_VAULT_* environment variables.
+type vaultStore struct {
+	client *vaultkv.Client
+}
+
+func openVaultStore() (secretStore, error) {
+	client, err := vaultkv.NewClient(vaultkv.OptionsFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("AWS_VAULT_BACKEND=%s: %w", vaultBackend, err)
+	}
+	return &vaultStore{client: client}, nil
+}
+
+func (v *vaultStore) Get(key string) ([]byte, error) {
+	value, err := v.client.Get(context.Background(), key)
+	if errors.Is(err, vaultkv.ErrNotFound) {
+		return nil, errSecretNotFound
+	}
+	return value, err
+}
+
+func (v *vaultStore) Set(key string, value []byte) error {
+	return v.client.Set(context.Background(), key, value)
+}
+
+func (v *vaultStore) Remove(key string) error {
+	err := v.client.Remove(context.Background(), key)
+	if errors.Is(err, vaultkv.ErrNotFound) {
+		return errSecretNotFound
+	}
+	return err
+}
+
+func (v *vaultStore) Keys() ([]string, error) {
+	return v.client.Keys(context.Background())
+}
@@ -0,0 +1,49 @@
+package profile
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/vaultkv"
+)
+
+func TestVaultBackendIsSelectedFromEnvironment(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_VAULT_BACKEND", vaultBackend)
+	t.Setenv(vaultkv.AddressEnv, server.URL)
+	t.Setenv(vaultkv.TokenEnv, "token")
+	t.Setenv(vaultkv.MountEnv, "kv")
+	t.Setenv(vaultkv.PathEnv, "aws")
+
+	store, err := openAWSVaultStore()
+	assert.NilError(t, err)
+	_, ok := store.(*vaultStore)
+	assert.Assert(t, ok)
+
+	_, err = store.Get("dev")
+	assert.Assert(t, errors.Is(err, errSecretNotFound), "got %v", err)
+	keys, err := store.Keys()
+	assert.NilError(t, err)
+	assert.Equal(t, len(keys), 0)
+	// Removing a missing key reports it instead of deleting nothing.
+	err = store.Remove("dev")
+	assert.Assert(t, errors.Is(err, errSecretNotFound), "got %v", err)
+	assert.DeepEqual(t, paths, []string{"GET /v1/kv/data/aws/ZGV2", "LIST /v1/kv/metadata/aws", "GET /v1/kv/metadata/aws/ZGV2"})
+}
+
+func TestVaultBackendRequiresAddress(t *testing.T) {
+	t.Setenv("AWS_VAULT_BACKEND", vaultBackend)
+	t.Setenv(vaultkv.AddressEnv, "")
+
+	_, err := openAWSVaultStore()
+	assert.ErrorContains(t, err, "AWS_VAULT_BACKEND=hashicorp-vault: VAULT_ADDR is required")
+}
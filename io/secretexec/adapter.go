@@ -0,0 +1,78 @@
+package secretexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	AdapterOnePassword = "op"
+	AdapterBitwarden   = "bw"
+)
+
+// runner runs a password-manager CLI with stdin and returns its stdout.
+// Secrets are only ever passed on stdin, never as arguments, so that they do
+// not show up in the process list.
+type runner func(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error)
+
+// AdapterOptions configure the bundled password-manager adapters.
+type AdapterOptions struct {
+	// Program overrides the CLI executable (op or bw by default).
+	Program string
+	// Vault is the 1Password vault; the account default when empty.
+	Vault string
+	// Folder is the Bitwarden folder the items are kept in.
+	Folder string
+	// Tag marks the 1Password items that belong to actool.
+	Tag string
+}
+
+// NewAdapter returns the backend for a bundled adapter name.
+func NewAdapter(name string, options AdapterOptions) (Backend, error) {
+	switch name {
+	case AdapterOnePassword:
+		return newOnePassword(options, runCommand), nil
+	case AdapterBitwarden:
+		return newBitwarden(options, runCommand), nil
+	default:
+		return nil, fmt.Errorf("unknown secret adapter: %s (supported: %s, %s)", name, AdapterOnePassword, AdapterBitwarden)
+	}
+}
+
+func runCommand(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = os.Environ()
+	cmd.WaitDelay = waitDelay
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return nil, fmt.Errorf("%s %s: %w", name, args[0], err)
+		}
+		return nil, fmt.Errorf("%s %s: %w: %s", name, args[0], err, message)
+	}
+	return stdout.Bytes(), nil
+}
+
+// textValue is used by adapters that keep the value in a text field. The
+// values actool stores are JSON documents.
+func textValue(value []byte) (string, error) {
+	if !utf8.Valid(value) {
+		return "", errors.New("secret value is not valid UTF-8")
+	}
+	return string(value), nil
+}
@@ -0,0 +1,199 @@
+package secretexec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultBitwardenFolder = "actool"
+	bitwardenSecureNote    = 2
+)
+
+// bitwarden keeps each secret as a secure note named with the key in Folder.
+// It uses the bw CLI, which must be unlocked (BW_SESSION set).
+type bitwarden struct {
+	program string
+	folder  string
+	run     runner
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// bitwardenItem keeps the fields actool does not know about, so that editing
+// an item does not drop them.
+type bitwardenItem struct {
+	ID       string
+	Name     string
+	Notes    string
+	FolderID string
+	raw      map[string]interface{}
+}
+
+func newBitwarden(options AdapterOptions, run runner) *bitwarden {
+	program := strings.TrimSpace(options.Program)
+	if program == "" {
+		program = "bw"
+	}
+	folder := strings.TrimSpace(options.Folder)
+	if folder == "" {
+		folder = defaultBitwardenFolder
+	}
+	return &bitwarden{program: program, folder: folder, run: run}
+}
+
+func (b *bitwarden) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := b.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrNotFound
+	}
+	return []byte(item.Notes), nil
+}
+
+func (b *bitwarden) Set(ctx context.Context, key string, value []byte) error {
+	text, err := textValue(value)
+	if err != nil {
+		return err
+	}
+	folderID, err := b.folderID(ctx, true)
+	if err != nil {
+		return err
+	}
+	item, err := b.find(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if item != nil {
+		item.raw["notes"] = text
+		_, err = b.run(ctx, encodeBitwarden(item.raw), b.program, "edit", "item", item.ID)
+		return err
+	}
+	_, err = b.run(ctx, encodeBitwarden(map[string]interface{}{
+		"type":       bitwardenSecureNote,
+		"secureNote": map[string]interface{}{"type": 0},
+		"name":       key,
+		"notes":      text,
+		"folderId":   folderID,
+	}), b.program, "create", "item")
+	return err
+}
+
+func (b *bitwarden) Remove(ctx context.Context, key string) error {
+	item, err := b.find(ctx, key)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrNotFound
+	}
+	_, err = b.run(ctx, nil, b.program, "delete", "item", item.ID)
+	return err
+}
+
+func (b *bitwarden) Keys(ctx context.Context) ([]string, error) {
+	items, err := b.list(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if !slices.Contains(keys, item.Name) {
+			keys = append(keys, item.Name)
+		}
+	}
+	return keys, nil
+}
+
+func (b *bitwarden) find(ctx context.Context, key string) (*bitwardenItem, error) {
+	items, err := b.list(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		// --search matches substrings; only an exact name is the key.
+		if item.Name == key {
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *bitwarden) list(ctx context.Context, search string) ([]bitwardenItem, error) {
+	folderID, err := b.folderID(ctx, false)
+	if err != nil || folderID == "" {
+		return nil, err
+	}
+	args := []string{"list", "items", "--folderid", folderID}
+	if search != "" {
+		args = append(args, "--search", search)
+	}
+	output, err := b.run(ctx, nil, b.program, args...)
+	if err != nil {
+		return nil, err
+	}
+	var raws []map[string]interface{}
+	if err := json.Unmarshal(output, &raws); err != nil {
+		return nil, fmt.Errorf("parse bw item list: %w", err)
+	}
+	items := make([]bitwardenItem, 0, len(raws))
+	for _, raw := range raws {
+		item := bitwardenItem{raw: raw}
+		item.ID, _ = raw["id"].(string)
+		item.Name, _ = raw["name"].(string)
+		item.Notes, _ = raw["notes"].(string)
+		item.FolderID, _ = raw["folderId"].(string)
+		if item.FolderID == folderID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// folderID looks the folder up by name and creates it when create is set.
+// An empty ID without an error means the folder does not exist yet.
+func (b *bitwarden) folderID(ctx context.Context, create bool) (string, error) {
+	output, err := b.run(ctx, nil, b.program, "list", "folders", "--search", b.folder)
+	if err != nil {
+		return "", err
+	}
+	var folders []bitwardenFolder
+	if err := json.Unmarshal(output, &folders); err != nil {
+		return "", fmt.Errorf("parse bw folder list: %w", err)
+	}
+	for _, folder := range folders {
+		if folder.Name == b.folder {
+			return folder.ID, nil
+		}
+	}
+	if !create {
+		return "", nil
+	}
+
+	output, err = b.run(ctx, encodeBitwarden(bitwardenFolder{Name: b.folder}), b.program, "create", "folder")
+	if err != nil {
+		return "", err
+	}
+	var folder bitwardenFolder
+	if err := json.Unmarshal(output, &folder); err != nil || folder.ID == "" {
+		return "", fmt.Errorf("bw did not return the created folder %q", b.folder)
+	}
+	return folder.ID, nil
+}
+
+// encodeBitwarden produces the input of bw create and bw edit, which is the
+// same as bw encode: base64 of the JSON object.
+func encodeBitwarden(value interface{}) []byte {
+	data, _ := json.Marshal(value)
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,142 @@
+package secretexec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// fakeBitwarden emulates the bw subcommands the adapter uses.
+type fakeBitwarden struct {
+	folders []bitwardenFolder
+	items   []map[string]interface{}
+	nextID  int
+	calls   []string
+}
+
+func (f *fakeBitwarden) decode(stdin []byte) (map[string]interface{}, error) {
+	data, err := base64.StdEncoding.DecodeString(string(stdin))
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	return value, json.Unmarshal(data, &value)
+}
+
+func (f *fakeBitwarden) run(_ context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	switch strings.Join(args[:2], " ") {
+	case "list folders":
+		return json.Marshal(f.folders)
+	case "create folder":
+		value, err := f.decode(stdin)
+		if err != nil {
+			return nil, err
+		}
+		folder := bitwardenFolder{ID: "folder-id", Name: value["name"].(string)}
+		f.folders = append(f.folders, folder)
+		return json.Marshal(folder)
+	case "list items":
+		listed := []map[string]interface{}{}
+		for _, item := range f.items {
+			if item["folderId"] == args[3] && (len(args) < 6 || strings.Contains(item["name"].(string), args[5])) {
+				listed = append(listed, item)
+			}
+		}
+		return json.Marshal(listed)
+	case "create item":
+		value, err := f.decode(stdin)
+		if err != nil {
+			return nil, err
+		}
+		f.nextID++
+		value["id"] = fmt.Sprintf("item%d", f.nextID)
+		f.items = append(f.items, value)
+		return json.Marshal(value)
+	case "edit item":
+		value, err := f.decode(stdin)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range f.items {
+			if item["id"] == args[2] {
+				f.items[i] = value
+				return json.Marshal(value)
+			}
+		}
+	case "delete item":
+		for i, item := range f.items {
+			if item["id"] == args[2] {
+				f.items = append(f.items[:i], f.items[i+1:]...)
+				return nil, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected bw call: %v", args)
+}
+
+func TestBitwardenAdapter(t *testing.T) {
+	fake := &fakeBitwarden{}
+	backend := newBitwarden(AdapterOptions{}, fake.run)
+	ctx := context.Background()
+
+	keys, err := backend.Keys(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(keys), 0)
+	_, err = backend.Get(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	assert.NilError(t, backend.Set(ctx, "dev", []byte(`{"SecretAccessKey":"first"}`)))
+	assert.DeepEqual(t, fake.folders, []bitwardenFolder{{ID: "folder-id", Name: "actool"}})
+	fake.items[0]["favorite"] = true
+	assert.NilError(t, backend.Set(ctx, "dev", []byte(`{"SecretAccessKey":"second"}`)))
+	assert.NilError(t, backend.Set(ctx, "dev-admin", []byte("other")))
+	assert.Equal(t, len(fake.items), 2)
+	assert.Equal(t, fake.items[0]["favorite"], true)
+	assert.Equal(t, fake.items[0]["type"], float64(bitwardenSecureNote))
+
+	value, err := backend.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), `{"SecretAccessKey":"second"}`)
+
+	keys, err = backend.Keys(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev", "dev-admin"})
+
+	assert.NilError(t, backend.Remove(ctx, "dev"))
+	assert.Assert(t, errors.Is(backend.Remove(ctx, "dev"), ErrNotFound))
+	value, err = backend.Get(ctx, "dev-admin")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "other")
+
+	for _, call := range fake.calls {
+		assert.Assert(t, !strings.Contains(call, "SecretAccessKey"), "secret passed as an argument: %s", call)
+	}
+}
+
+func TestBitwardenAdapterIgnoresOtherFolders(t *testing.T) {
+	fake := &fakeBitwarden{
+		folders: []bitwardenFolder{{ID: "personal", Name: "actool-personal"}, {ID: "folder-id", Name: "actool"}},
+		items: []map[string]interface{}{
+			{"id": "a", "name": "dev", "notes": "personal", "folderId": "personal"},
+			{"id": "b", "name": "dev", "notes": "work", "folderId": "folder-id"},
+		},
+	}
+	backend := newBitwarden(AdapterOptions{}, fake.run)
+
+	value, err := backend.Get(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "work")
+}
+
+func TestAdaptersRejectBinaryValues(t *testing.T) {
+	ctx := context.Background()
+	assert.ErrorContains(t, newBitwarden(AdapterOptions{}, (&fakeBitwarden{}).run).Set(ctx, "dev", []byte{0xff}), "not valid UTF-8")
+	assert.ErrorContains(t, newOnePassword(AdapterOptions{}, (&fakeOnePassword{}).run).Set(ctx, "dev", []byte{0xff}), "not valid UTF-8")
+}
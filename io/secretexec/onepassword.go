@@ -0,0 +1,187 @@
+package secretexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultOnePasswordTag = "actool"
+	onePasswordFieldID    = "password"
+)
+
+// onePassword keeps each secret as a Password item titled with the key and
+// tagged with Tag. It uses the op CLI (version 2), which must be signed in.
+type onePassword struct {
+	program string
+	vault   string
+	tag     string
+	run     runner
+}
+
+type onePasswordItem struct {
+	ID        string             `json:"id,omitempty"`
+	Title     string             `json:"title"`
+	Category  string             `json:"category,omitempty"`
+	Tags      []string           `json:"tags,omitempty"`
+	UpdatedAt string             `json:"updated_at,omitempty"`
+	Fields    []onePasswordField `json:"fields,omitempty"`
+}
+
+type onePasswordField struct {
+	ID      string `json:"id"`
+	Type    string `json:"type,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Value   string `json:"value"`
+}
+
+func newOnePassword(options AdapterOptions, run runner) *onePassword {
+	program := strings.TrimSpace(options.Program)
+	if program == "" {
+		program = "op"
+	}
+	tag := strings.TrimSpace(options.Tag)
+	if tag == "" {
+		tag = defaultOnePasswordTag
+	}
+	return &onePassword{program: program, vault: strings.TrimSpace(options.Vault), tag: tag, run: run}
+}
+
+func (o *onePassword) Get(ctx context.Context, key string) ([]byte, error) {
+	items, err := o.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	output, err := o.run(ctx, nil, o.program, o.args("item", "get", items[0].ID, "--format", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	var item onePasswordItem
+	if err := json.Unmarshal(output, &item); err != nil {
+		return nil, fmt.Errorf("parse op item %q: %w", key, err)
+	}
+	for _, field := range item.Fields {
+		if field.ID == onePasswordFieldID {
+			return []byte(field.Value), nil
+		}
+	}
+	return nil, fmt.Errorf("op item %q has no %s field", key, onePasswordFieldID)
+}
+
+// Set creates the new item before deleting the old one, so a failed write
+// never loses the stored value.
+func (o *onePassword) Set(ctx context.Context, key string, value []byte) error {
+	text, err := textValue(value)
+	if err != nil {
+		return err
+	}
+	existing, err := o.find(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	template, err := json.Marshal(onePasswordItem{
+		Title:    key,
+		Category: "PASSWORD",
+		Tags:     []string{o.tag},
+		Fields: []onePasswordField{{
+			ID:      onePasswordFieldID,
+			Type:    "CONCEALED",
+			Purpose: "PASSWORD",
+			Label:   onePasswordFieldID,
+			Value:   text,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := o.run(ctx, template, o.program, o.args("item", "create", "--format", "json")...); err != nil {
+		return err
+	}
+	for _, item := range existing {
+		if err := o.delete(ctx, item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *onePassword) Remove(ctx context.Context, key string) error {
+	items, err := o.find(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+	for _, item := range items {
+		if err := o.delete(ctx, item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *onePassword) Keys(ctx context.Context) ([]string, error) {
+	items, err := o.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if !slices.Contains(keys, item.Title) {
+			keys = append(keys, item.Title)
+		}
+	}
+	return keys, nil
+}
+
+// find returns the items titled key, newest first.
+func (o *onePassword) find(ctx context.Context, key string) ([]onePasswordItem, error) {
+	items, err := o.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matches []onePasswordItem
+	for _, item := range items {
+		if item.Title == key {
+			matches = append(matches, item)
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b onePasswordItem) int {
+		return strings.Compare(b.UpdatedAt, a.UpdatedAt)
+	})
+	return matches, nil
+}
+
+func (o *onePassword) list(ctx context.Context) ([]onePasswordItem, error) {
+	output, err := o.run(ctx, nil, o.program, o.args("item", "list", "--tags", o.tag, "--format", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	var items []onePasswordItem
+	if err := json.Unmarshal(output, &items); err != nil {
+		return nil, fmt.Errorf("parse op item list: %w", err)
+	}
+	return items, nil
+}
+
+func (o *onePassword) delete(ctx context.Context, id string) error {
+	_, err := o.run(ctx, nil, o.program, o.args("item", "delete", id)...)
+	return err
+}
+
+func (o *onePassword) args(args ...string) []string {
+	if o.vault != "" {
+		args = append(args, "--vault", o.vault)
+	}
+	return args
+}
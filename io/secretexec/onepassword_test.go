@@ -0,0 +1,116 @@
+package secretexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// fakeOnePassword emulates the op item subcommands the adapter uses.
+type fakeOnePassword struct {
+	items  []onePasswordItem
+	nextID int
+	calls  []string
+	stdin  []string
+}
+
+func (f *fakeOnePassword) run(_ context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	f.stdin = append(f.stdin, string(stdin))
+	switch strings.Join(args[:2], " ") {
+	case "item list":
+		var listed []onePasswordItem
+		for _, item := range f.items {
+			listed = append(listed, onePasswordItem{ID: item.ID, Title: item.Title, Tags: item.Tags, UpdatedAt: item.UpdatedAt})
+		}
+		return json.Marshal(listed)
+	case "item get":
+		for _, item := range f.items {
+			if item.ID == args[2] {
+				return json.Marshal(item)
+			}
+		}
+		return nil, errors.New("isn't an item")
+	case "item create":
+		var item onePasswordItem
+		if err := json.Unmarshal(stdin, &item); err != nil {
+			return nil, err
+		}
+		f.nextID++
+		item.ID = fmt.Sprintf("id%d", f.nextID)
+		item.UpdatedAt = fmt.Sprintf("2026-01-01T00:00:%02dZ", f.nextID)
+		f.items = append(f.items, item)
+		return json.Marshal(item)
+	case "item delete":
+		for i, item := range f.items {
+			if item.ID == args[2] {
+				f.items = append(f.items[:i], f.items[i+1:]...)
+				return nil, nil
+			}
+		}
+		return nil, errors.New("isn't an item")
+	}
+	return nil, fmt.Errorf("unexpected op call: %v", args)
+}
+
+func TestOnePasswordAdapter(t *testing.T) {
+	fake := &fakeOnePassword{}
+	backend := newOnePassword(AdapterOptions{Vault: "Employee"}, fake.run)
+	ctx := context.Background()
+
+	_, err := backend.Get(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	assert.NilError(t, backend.Set(ctx, "dev", []byte(`{"SecretAccessKey":"first"}`)))
+	assert.NilError(t, backend.Set(ctx, "dev", []byte(`{"SecretAccessKey":"second"}`)))
+	assert.Equal(t, len(fake.items), 1)
+	assert.DeepEqual(t, fake.items[0].Tags, []string{"actool"})
+
+	value, err := backend.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), `{"SecretAccessKey":"second"}`)
+
+	keys, err := backend.Keys(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev"})
+
+	assert.NilError(t, backend.Remove(ctx, "dev"))
+	assert.Assert(t, errors.Is(backend.Remove(ctx, "dev"), ErrNotFound))
+
+	for _, call := range fake.calls {
+		assert.Assert(t, strings.HasPrefix(call, "op item "), call)
+		assert.Assert(t, strings.HasSuffix(call, "--vault Employee"), call)
+		assert.Assert(t, !strings.Contains(call, "SecretAccessKey"), "secret passed as an argument: %s", call)
+	}
+	assert.Assert(t, strings.Contains(strings.Join(fake.stdin, ""), "second"))
+}
+
+func TestOnePasswordAdapterPrefersNewestDuplicate(t *testing.T) {
+	fake := &fakeOnePassword{items: []onePasswordItem{
+		{ID: "old", Title: "dev", Tags: []string{"actool"}, UpdatedAt: "2025-01-01T00:00:00Z", Fields: []onePasswordField{{ID: "password", Value: "old"}}},
+		{ID: "new", Title: "dev", Tags: []string{"actool"}, UpdatedAt: "2026-01-01T00:00:00Z", Fields: []onePasswordField{{ID: "password", Value: "new"}}},
+	}}
+	backend := newOnePassword(AdapterOptions{}, fake.run)
+
+	value, err := backend.Get(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(value), "new")
+
+	keys, err := backend.Keys(context.Background())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"dev"})
+}
+
+func TestNewAdapter(t *testing.T) {
+	_, err := NewAdapter("lastpass", AdapterOptions{})
+	assert.ErrorContains(t, err, "unknown secret adapter: lastpass")
+
+	backend, err := NewAdapter(AdapterOnePassword, AdapterOptions{Program: "/opt/op"})
+	assert.NilError(t, err)
+	assert.Equal(t, backend.(*onePassword).program, "/opt/op")
+}
@@ -0,0 +1,190 @@
+// Package secretexec stores secrets through an external program.
+//
+// actool runs the configured command once per operation, writes one JSON
+// Request to its stdin and reads one JSON Response from its stdout:
+//
+//	{"version":1,"action":"get","key":"dev"}
+//	{"value":"eyJBY2Nlc3NLZXlJRCI6Li4ufQ=="}
+//
+// Actions are get, set, remove and keys. Values are base64 encoded. A missing
+// key is reported as {"error":{"code":"not_found","message":"..."}}; any
+// other error object, a non-zero exit status or unparsable output fails the
+// operation. stderr is passed through so that helpers can show unlock
+// prompts.
+package secretexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	ProtocolVersion = 1
+
+	ActionGet    = "get"
+	ActionSet    = "set"
+	ActionRemove = "remove"
+	ActionKeys   = "keys"
+
+	CodeNotFound = "not_found"
+
+	waitDelay = time.Second
+)
+
+// ErrNotFound is returned when the program reports that a key does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Request is written to the program's stdin.
+type Request struct {
+	Version int    `json:"version"`
+	Action  string `json:"action"`
+	Key     string `json:"key,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+}
+
+// Response is read from the program's stdout.
+type Response struct {
+	Value []byte   `json:"value,omitempty"`
+	Keys  []string `json:"keys,omitempty"`
+	Error *Error   `json:"error,omitempty"`
+}
+
+// Error is the error object of a Response.
+type Error struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Backend is the storage an adapter serves.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Remove(ctx context.Context, key string) error
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// Client runs the configured command for each operation.
+type Client struct {
+	command string
+}
+
+// NewClient returns a client for command, which is run through the platform
+// shell like the MFA process provider.
+func NewClient(command string) (*Client, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil, errors.New("secret backend command must not be empty")
+	}
+	return &Client{command: command}, nil
+}
+
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	response, err := c.call(ctx, Request{Action: ActionGet, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return response.Value, nil
+}
+
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	_, err := c.call(ctx, Request{Action: ActionSet, Key: key, Value: value})
+	return err
+}
+
+func (c *Client) Remove(ctx context.Context, key string) error {
+	_, err := c.call(ctx, Request{Action: ActionRemove, Key: key})
+	return err
+}
+
+func (c *Client) Keys(ctx context.Context) ([]string, error) {
+	response, err := c.call(ctx, Request{Action: ActionKeys})
+	if err != nil {
+		return nil, err
+	}
+	return response.Keys, nil
+}
+
+func (c *Client) call(ctx context.Context, request Request) (*Response, error) {
+	request.Version = ProtocolVersion
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", c.command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = waitDelay
+	runErr := cmd.Run()
+	if runErr != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var response Response
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &response); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("secret backend %s %q failed: %w", request.Action, request.Key, runErr)
+		}
+		return nil, fmt.Errorf("secret backend %s %q returned invalid output: %w", request.Action, request.Key, err)
+	}
+	if response.Error != nil {
+		if response.Error.Code == CodeNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("secret backend %s %q failed: %s", request.Action, request.Key, response.Error.Message)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("secret backend %s %q failed: %w", request.Action, request.Key, runErr)
+	}
+	return &response, nil
+}
+
+// Serve answers one request read from r with backend and writes the response
+// to w. Backend errors are reported in the response; the returned error is
+// only set when the request cannot be read or the response cannot be written.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, backend Backend) error {
+	var request Request
+	if err := json.NewDecoder(r).Decode(&request); err != nil {
+		return fmt.Errorf("read secret backend request: %w", err)
+	}
+
+	var response Response
+	var err error
+	switch {
+	case request.Version != ProtocolVersion:
+		err = fmt.Errorf("unsupported protocol version: %d", request.Version)
+	case request.Action == ActionGet:
+		response.Value, err = backend.Get(ctx, request.Key)
+	case request.Action == ActionSet:
+		err = backend.Set(ctx, request.Key, request.Value)
+	case request.Action == ActionRemove:
+		err = backend.Remove(ctx, request.Key)
+	case request.Action == ActionKeys:
+		response.Keys, err = backend.Keys(ctx)
+	default:
+		err = fmt.Errorf("unknown action: %q", request.Action)
+	}
+	if err != nil {
+		response = Response{Error: &Error{Message: err.Error()}}
+		if errors.Is(err, ErrNotFound) {
+			response.Error.Code = CodeNotFound
+		}
+	}
+	return json.NewEncoder(w).Encode(response)
+}
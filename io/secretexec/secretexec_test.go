@@ -0,0 +1,164 @@
+package secretexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// newStandInClient returns a client for testdata/stand-in.sh and the
+// directory it keeps its secrets in.
+func newStandInClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in scripts use /bin/sh")
+	}
+	script, err := filepath.Abs(filepath.Join("testdata", "stand-in.sh"))
+	assert.NilError(t, err)
+	dir := t.TempDir()
+	t.Setenv("SECRET_STORE_DIR", dir)
+	client, err := NewClient(script)
+	assert.NilError(t, err)
+	return client, dir
+}
+
+func writeStandInScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in scripts use /bin/sh")
+	}
+	path := filepath.Join(t.TempDir(), "backend.sh")
+	assert.NilError(t, os.WriteFile(path, []byte("#!/bin/sh\ncat >/dev/null\n"+body), 0o700))
+	return path
+}
+
+func TestClientAgainstStandInScript(t *testing.T) {
+	client, dir := newStandInClient(t)
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	value := []byte(`{"AccessKeyID":"AKIAEXAMPLE","SecretAccessKey":"secret/with+symbols"}`)
+	assert.NilError(t, client.Set(ctx, "dev", value))
+	assert.NilError(t, client.Set(ctx, "oidc:https://example.awsapps.com/start", []byte("token")))
+
+	got, err := client.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, value)
+
+	keys, err := client.Keys(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(keys), 2)
+	assert.Assert(t, strings.Contains(strings.Join(keys, "\n"), "oidc:https://example.awsapps.com/start"))
+
+	assert.NilError(t, client.Remove(ctx, "dev"))
+	err = client.Remove(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	log, err := os.ReadFile(filepath.Join(dir, "requests.log"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(log), strings.Join([]string{
+		"get dev",
+		"set dev",
+		"set oidc:https://example.awsapps.com/start",
+		"get dev",
+		"keys ",
+		"remove dev",
+		"remove dev",
+	}, "\n")+"\n")
+}
+
+func TestClientReportsBackendFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "error object", body: `echo '{"error":{"message":"vault is locked"}}'` + "\n", wantErr: `secret backend get "dev" failed: vault is locked`},
+		{name: "error object with exit status", body: `echo '{"error":{"message":"vault is locked"}}'; exit 1` + "\n", wantErr: "vault is locked"},
+		{name: "exit status without output", body: "exit 3\n", wantErr: "exit status 3"},
+		{name: "invalid output", body: "echo not-json\n", wantErr: "invalid output"},
+		{name: "exit status with success output", body: "echo '{}'; exit 1\n", wantErr: "exit status 1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(writeStandInScript(t, tc.body))
+			assert.NilError(t, err)
+			_, err = client.Get(context.Background(), "dev")
+			assert.ErrorContains(t, err, tc.wantErr)
+			assert.Assert(t, !errors.Is(err, ErrNotFound))
+		})
+	}
+}
+
+func TestNewClientRequiresCommand(t *testing.T) {
+	_, err := NewClient("  ")
+	assert.ErrorContains(t, err, "must not be empty")
+}
+
+type memoryBackend map[string][]byte
+
+func (m memoryBackend) Get(_ context.Context, key string) ([]byte, error) {
+	value, ok := m[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (m memoryBackend) Set(_ context.Context, key string, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryBackend) Remove(_ context.Context, key string) error {
+	if _, ok := m[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m, key)
+	return nil
+}
+
+func (m memoryBackend) Keys(context.Context) ([]string, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func TestServe(t *testing.T) {
+	cases := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{name: "get", request: `{"version":1,"action":"get","key":"dev"}`, want: `{"value":"c2VjcmV0"}`},
+		{name: "get missing", request: `{"version":1,"action":"get","key":"prod"}`, want: `{"error":{"code":"not_found","message":"secret not found"}}`},
+		{name: "set", request: `{"version":1,"action":"set","key":"prod","value":"bmV3"}`, want: `{}`},
+		{name: "remove", request: `{"version":1,"action":"remove","key":"dev"}`, want: `{}`},
+		{name: "keys", request: `{"version":1,"action":"keys"}`, want: `{"keys":["dev"]}`},
+		{name: "unknown action", request: `{"version":1,"action":"list"}`, want: `{"error":{"message":"unknown action: \"list\""}}`},
+		{name: "unsupported version", request: `{"version":2,"action":"get","key":"dev"}`, want: `{"error":{"message":"unsupported protocol version: 2"}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := memoryBackend{"dev": []byte("secret")}
+			var output bytes.Buffer
+			assert.NilError(t, Serve(context.Background(), strings.NewReader(tc.request), &output, backend))
+			assert.Equal(t, strings.TrimSpace(output.String()), tc.want)
+		})
+	}
+
+	assert.ErrorContains(t, Serve(context.Background(), strings.NewReader("not json"), &bytes.Buffer{}, memoryBackend{}), "read secret backend request")
+}
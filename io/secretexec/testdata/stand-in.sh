@@ -0,0 +1,50 @@
+#!/bin/sh
+# Stand-in secret backend for tests. Each secret is kept in a file under
+# $SECRET_STORE_DIR holding the key on the first line and the base64 value on
+# the second; every request is logged to $SECRET_STORE_DIR/requests.log.
+set -eu
+
+request=$(cat)
+field() {
+	printf '%s' "$request" | sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"
+}
+action=$(field action)
+key=$(field key)
+value=$(field value)
+printf '%s %s\n' "$action" "$key" >>"$SECRET_STORE_DIR/requests.log"
+file="$SECRET_STORE_DIR/store-$(printf '%s' "$key" | od -An -tx1 | tr -d ' \n')"
+
+not_found() {
+	echo '{"error":{"code":"not_found","message":"no such key"}}'
+	exit 0
+}
+
+case "$action" in
+get)
+	[ -f "$file" ] || not_found
+	printf '{"value":"%s"}\n' "$(sed -n 2p "$file")"
+	;;
+set)
+	printf '%s\n%s\n' "$key" "$value" >"$file"
+	echo '{}'
+	;;
+remove)
+	[ -f "$file" ] || not_found
+	rm "$file"
+	echo '{}'
+	;;
+keys)
+	separator=
+	printf '{"keys":['
+	for entry in "$SECRET_STORE_DIR"/store-*; do
+		[ -f "$entry" ] || continue
+		printf '%s"%s"' "$separator" "$(sed -n 1p "$entry")"
+		separator=,
+	done
+	printf ']}\n'
+	;;
+*)
+	echo "unsupported action: $action" >&2
+	exit 2
+	;;
+esac
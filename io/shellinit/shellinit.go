@@ -0,0 +1,131 @@
+// Package shellinit renders the shell functions printed by actool
+// shell-init. The function wraps actool so that a profile picked in a shell
+// is exported as AWS_PROFILE in that shell only, and a hook applies the
+// profile of a trusted directory on cd.
+package shellinit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SelectionFileEnv names the file the wrapper passes to actool. actool
+// writes the profile selected for the shell there instead of rewriting
+// [default].
+const SelectionFileEnv = "ACTOOL_SHELL_SELECTION_FILE"
+
+// Shells lists the supported shells.
+var Shells = []string{"bash", "zsh", "fish"}
+
+const posixTemplate = `# actool shell integration for %[1]s.
+# Load it from ~/.%[1]src with: eval "$(%[2]s shell-init %[1]s)"
+actool() {
+  local actool_selection actool_status
+  actool_selection="$(mktemp "${TMPDIR:-/tmp}/actool-shell.XXXXXX")" || return
+  ACTOOL_SHELL_SELECTION_FILE="$actool_selection" command %[2]s "$@"
+  actool_status=$?
+  if [ -s "$actool_selection" ]; then
+    export AWS_PROFILE="$(cat "$actool_selection")"
+  fi
+  rm -f "$actool_selection"
+  return $actool_status
+}
+
+# Applies the profile of a trusted .actool-profile on cd and restores the
+# previous AWS_PROFILE on leaving, unless it was changed in between.
+_actool_dir_hook() {
+  [ "$PWD" = "${_actool_dir_pwd-}" ] && return
+  _actool_dir_pwd=$PWD
+  local actool_dir_profile
+  actool_dir_profile="$(command %[2]s dir current)"
+  if [ -n "$actool_dir_profile" ]; then
+    if [ -z "${_actool_dir_profile-}" ]; then
+      _actool_dir_saved="${AWS_PROFILE-}"
+    fi
+    _actool_dir_profile=$actool_dir_profile
+    export AWS_PROFILE="$actool_dir_profile"
+  elif [ -n "${_actool_dir_profile-}" ]; then
+    if [ "${AWS_PROFILE-}" = "$_actool_dir_profile" ]; then
+      if [ -n "${_actool_dir_saved-}" ]; then
+        export AWS_PROFILE="$_actool_dir_saved"
+      else
+        unset AWS_PROFILE
+      fi
+    fi
+    _actool_dir_profile=
+  fi
+}
+%[3]s`
+
+const bashHook = `case ";${PROMPT_COMMAND-};" in
+  *";_actool_dir_hook;"*) ;;
+  *) PROMPT_COMMAND="_actool_dir_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`
+
+const zshHook = `autoload -Uz add-zsh-hook
+add-zsh-hook chpwd _actool_dir_hook
+_actool_dir_hook
+`
+
+const fishTemplate = `# actool shell integration for fish.
+# Load it from ~/.config/fish/config.fish with: %[1]s shell-init fish | source
+function actool
+    set -l actool_tmpdir /tmp
+    set -q TMPDIR; and set actool_tmpdir $TMPDIR
+    set -l actool_selection (mktemp "$actool_tmpdir/actool-shell.XXXXXX"); or return
+    env ACTOOL_SHELL_SELECTION_FILE=$actool_selection %[1]s $argv
+    set -l actool_status $status
+    if test -s $actool_selection
+        set -gx AWS_PROFILE (cat $actool_selection)
+    end
+    rm -f $actool_selection
+    return $actool_status
+end
+
+# Applies the profile of a trusted .actool-profile on cd and restores the
+# previous AWS_PROFILE on leaving, unless it was changed in between.
+function __actool_dir_hook --on-variable PWD
+    set -l actool_dir_profile (command %[1]s dir current)
+    if test -n "$actool_dir_profile"
+        if test -z "$__actool_dir_profile"
+            set -g __actool_dir_saved "$AWS_PROFILE"
+        end
+        set -g __actool_dir_profile $actool_dir_profile
+        set -gx AWS_PROFILE $actool_dir_profile
+    else if test -n "$__actool_dir_profile"
+        if test "$AWS_PROFILE" = "$__actool_dir_profile"
+            if test -n "$__actool_dir_saved"
+                set -gx AWS_PROFILE $__actool_dir_saved
+            else
+                set -e AWS_PROFILE
+            end
+        end
+        set -g __actool_dir_profile ""
+    end
+end
+__actool_dir_hook
+`
+
+// Script returns the integration for shell. command is how the wrapper runs
+// the real actool binary, normally its absolute path.
+func Script(shell, command string) (string, error) {
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(posixTemplate, shell, quotePOSIX(command), bashHook), nil
+	case "zsh":
+		return fmt.Sprintf(posixTemplate, shell, quotePOSIX(command), zshHook), nil
+	case "fish":
+		return fmt.Sprintf(fishTemplate, quoteFish(command)), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q; use one of %s", shell, strings.Join(Shells, ", "))
+}
+
+func quotePOSIX(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func quoteFish(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(value) + "'"
+}
@@ -0,0 +1,102 @@
+package shellinit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestScript(t *testing.T) {
+	cases := []struct {
+		shell   string
+		command string
+		want    []string
+		wantErr string
+	}{
+		{shell: "bash", command: "/usr/local/bin/actool", want: []string{"actool() {", `command '/usr/local/bin/actool' "$@"`, "export AWS_PROFILE="}},
+		{shell: "zsh", command: "/opt/it's/actool", want: []string{"~/.zshrc", `command '/opt/it'\''s/actool' "$@"`}},
+		{shell: "fish", command: "/opt/it's/actool", want: []string{"function actool", `'/opt/it\'s/actool' $argv`, "set -gx AWS_PROFILE"}},
+		{shell: "tcsh", command: "actool", wantErr: `unsupported shell "tcsh"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.shell, func(t *testing.T) {
+			script, err := Script(tc.shell, tc.command)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			for _, want := range tc.want {
+				assert.Assert(t, strings.Contains(script, want), script)
+			}
+		})
+	}
+}
+
+func TestBashScriptExportsSelection(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	command := filepath.Join(dir, "actool")
+	// Stands in for actool: selects the profile named by its argument for
+	// the shell, and fails without one.
+	assert.NilError(t, os.WriteFile(command, []byte(`#!/bin/sh
+[ -n "$1" ] || exit 3
+printf '%s' "$1" > "$ACTOOL_SHELL_SELECTION_FILE"
+`), 0o700))
+	script, err := Script("bash", command)
+	assert.NilError(t, err)
+
+	cmd := exec.Command(bash, "--norc", "-c", script+`
+actool dev; echo "status=$? profile=$AWS_PROFILE"
+actool; echo "status=$? profile=$AWS_PROFILE"
+`)
+	cmd.Env = append(os.Environ(), "TMPDIR="+dir, "AWS_PROFILE=")
+	output, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(output))
+	assert.Equal(t, string(output), "status=0 profile=dev\nstatus=3 profile=dev\n")
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "actool-shell.*"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(leftovers), 0)
+}
+
+func TestBashScriptAppliesDirectoryProfile(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	command := filepath.Join(dir, "actool")
+	// Stands in for actool dir current: prints the profile file of the
+	// working directory, as if every file were trusted.
+	assert.NilError(t, os.WriteFile(command, []byte(`#!/bin/sh
+[ "$1 $2" = "dir current" ] || exit 3
+[ -f .actool-profile ] && cat .actool-profile
+exit 0
+`), 0o700))
+	repo := filepath.Join(dir, "repo")
+	assert.NilError(t, os.MkdirAll(repo, 0o700))
+	assert.NilError(t, os.WriteFile(filepath.Join(repo, ".actool-profile"), []byte("dev\n"), 0o600))
+	script, err := Script("bash", command)
+	assert.NilError(t, err)
+
+	cmd := exec.Command(bash, "--norc", "-c", script+`
+cd "$1"; _actool_dir_hook; echo "outside=$AWS_PROFILE"
+cd repo; _actool_dir_hook; echo "repo=$AWS_PROFILE"
+cd ..; _actool_dir_hook; echo "left=$AWS_PROFILE"
+cd repo; _actool_dir_hook; AWS_PROFILE=picked; cd ..; _actool_dir_hook; echo "kept=$AWS_PROFILE"
+echo "hook=$PROMPT_COMMAND"
+`, "bash", dir)
+	cmd.Env = append(os.Environ(), "AWS_PROFILE=shell", "PROMPT_COMMAND=")
+	output, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(output))
+	assert.Equal(t, string(output), "outside=shell\nrepo=dev\nleft=shell\nkept=picked\nhook=_actool_dir_hook\n")
+}
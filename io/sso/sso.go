@@ -0,0 +1,320 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awssso "github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const (
+	// OIDCEndpointEnv and PortalEndpointEnv replace the regional IAM Identity
+	// Center endpoints, which lets the login flow run against local stand-ins.
+	OIDCEndpointEnv   = "ACTOOL_SSO_OIDC_ENDPOINT"
+	PortalEndpointEnv = "ACTOOL_SSO_PORTAL_ENDPOINT"
+
+	grantTypeDeviceCode   = "urn:ietf:params:oauth:grant-type:device_code"
+	grantTypeRefreshToken = "refresh_token"
+	clientTypePublic      = "public"
+
+	defaultPollInterval = 5 * time.Second
+	slowDownIncrement   = 5 * time.Second
+)
+
+// ErrDeviceAuthorizationExpired is returned when the user did not approve
+// the device code before it expired.
+var ErrDeviceAuthorizationExpired = errors.New("SSO device authorization expired before it was approved")
+
+// Options select the IAM Identity Center region and endpoints.
+type Options struct {
+	Region         string
+	OIDCEndpoint   string
+	PortalEndpoint string
+	// Timeout bounds each request; zero uses ACTOOL_TIMEOUT or
+	// sts.DefaultTimeout. Polling for device approval is bounded by the
+	// device code's own expiry instead.
+	Timeout time.Duration
+}
+
+// ClientRegistration is the public OIDC client actool registers for the
+// device-authorization flow.
+type ClientRegistration struct {
+	ClientID     string
+	ClientSecret string
+	ExpiresAt    time.Time
+	Scopes       []string
+}
+
+// DeviceAuthorization is the code the user has to approve in a browser.
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	Interval                time.Duration
+	ExpiresAt               time.Time
+}
+
+// Token is an IAM Identity Center access token.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	TokenType    string
+	ExpiresIn    int32
+	Expiration   time.Time
+}
+
+// RoleCredentials are temporary credentials for an account and permission
+// set.
+type RoleCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expiration   time.Time
+}
+
+type Service interface {
+	RegisterClient(ctx context.Context, clientName string, scopes []string) (*ClientRegistration, error)
+	StartDeviceAuthorization(ctx context.Context, client *ClientRegistration, startURL string) (*DeviceAuthorization, error)
+	WaitForToken(ctx context.Context, client *ClientRegistration, authorization *DeviceAuthorization) (*Token, error)
+	RefreshToken(ctx context.Context, client *ClientRegistration, refreshToken string) (*Token, error)
+	RoleCredentials(ctx context.Context, accessToken string, accountID string, roleName string) (*RoleCredentials, error)
+}
+
+type service struct {
+	options    Options
+	httpClient *http.Client
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewService(options Options) Service {
+	return &service{options: options, now: time.Now, sleep: sleepContext}
+}
+
+func (s *service) RegisterClient(ctx context.Context, clientName string, scopes []string) (*ClientRegistration, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	oidc, err := s.oidcClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := oidc.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String(clientName),
+		ClientType: aws.String(clientTypePublic),
+		Scopes:     scopes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sso fail: %w", err)
+	}
+	return &ClientRegistration{
+		ClientID:     aws.ToString(output.ClientId),
+		ClientSecret: aws.ToString(output.ClientSecret),
+		ExpiresAt:    time.Unix(output.ClientSecretExpiresAt, 0).UTC(),
+		Scopes:       scopes,
+	}, nil
+}
+
+func (s *service) StartDeviceAuthorization(ctx context.Context, client *ClientRegistration, startURL string) (*DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	oidc, err := s.oidcClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := oidc.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     aws.String(client.ClientID),
+		ClientSecret: aws.String(client.ClientSecret),
+		StartUrl:     aws.String(startURL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sso fail: %w", err)
+	}
+	interval := time.Duration(output.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &DeviceAuthorization{
+		DeviceCode:              aws.ToString(output.DeviceCode),
+		UserCode:                aws.ToString(output.UserCode),
+		VerificationURI:         aws.ToString(output.VerificationUri),
+		VerificationURIComplete: aws.ToString(output.VerificationUriComplete),
+		Interval:                interval,
+		ExpiresAt:               s.now().Add(time.Duration(output.ExpiresIn) * time.Second).UTC(),
+	}, nil
+}
+
+// WaitForToken polls CreateToken until the user approves the device code,
+// backing off when the service asks it to slow down.
+func (s *service) WaitForToken(ctx context.Context, client *ClientRegistration, authorization *DeviceAuthorization) (*Token, error) {
+	interval := authorization.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		token, err := s.createToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     aws.String(client.ClientID),
+			ClientSecret: aws.String(client.ClientSecret),
+			DeviceCode:   aws.String(authorization.DeviceCode),
+			GrantType:    aws.String(grantTypeDeviceCode),
+		})
+		var pending *types.AuthorizationPendingException
+		var slowDown *types.SlowDownException
+		var expired *types.ExpiredTokenException
+		switch {
+		case err == nil:
+			return token, nil
+		case errors.As(err, &pending):
+		case errors.As(err, &slowDown):
+			interval += slowDownIncrement
+		case errors.As(err, &expired):
+			return nil, ErrDeviceAuthorizationExpired
+		default:
+			return nil, err
+		}
+		if !authorization.ExpiresAt.IsZero() && s.now().Add(interval).After(authorization.ExpiresAt) {
+			return nil, ErrDeviceAuthorizationExpired
+		}
+		if err := s.sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *service) RefreshToken(ctx context.Context, client *ClientRegistration, refreshToken string) (*Token, error) {
+	return s.createToken(ctx, &ssooidc.CreateTokenInput{
+		ClientId:     aws.String(client.ClientID),
+		ClientSecret: aws.String(client.ClientSecret),
+		RefreshToken: aws.String(refreshToken),
+		GrantType:    aws.String(grantTypeRefreshToken),
+	})
+}
+
+func (s *service) RoleCredentials(ctx context.Context, accessToken string, accountID string, roleName string) (*RoleCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	portal, err := s.portalClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := portal.GetRoleCredentials(ctx, &awssso.GetRoleCredentialsInput{
+		AccessToken: aws.String(accessToken),
+		AccountId:   aws.String(accountID),
+		RoleName:    aws.String(roleName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sso fail: %w", err)
+	}
+	if output.RoleCredentials == nil {
+		return nil, errors.New("sso fail: GetRoleCredentials returned no credentials")
+	}
+	credentials := output.RoleCredentials
+	return &RoleCredentials{
+		AccessKey:    aws.ToString(credentials.AccessKeyId),
+		SecretKey:    aws.ToString(credentials.SecretAccessKey),
+		SessionToken: aws.ToString(credentials.SessionToken),
+		Expiration:   time.UnixMilli(credentials.Expiration).UTC(),
+	}, nil
+}
+
+func (s *service) createToken(ctx context.Context, input *ssooidc.CreateTokenInput) (*Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	oidc, err := s.oidcClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := oidc.CreateToken(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("sso fail: %w", err)
+	}
+	return &Token{
+		AccessToken:  aws.ToString(output.AccessToken),
+		RefreshToken: aws.ToString(output.RefreshToken),
+		IDToken:      aws.ToString(output.IdToken),
+		TokenType:    aws.ToString(output.TokenType),
+		ExpiresIn:    output.ExpiresIn,
+		Expiration:   s.now().Add(time.Duration(output.ExpiresIn) * time.Second).UTC(),
+	}, nil
+}
+
+func (s *service) config(ctx context.Context) (aws.Config, error) {
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(s.options.Region),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	}
+	if s.httpClient != nil {
+		loadOptions = append(loadOptions, config.WithHTTPClient(s.httpClient))
+	}
+	return config.LoadDefaultConfig(ctx, loadOptions...)
+}
+
+func (s *service) oidcClient(ctx context.Context) (*ssooidc.Client, error) {
+	cfg, err := s.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ssooidc.NewFromConfig(cfg, func(o *ssooidc.Options) {
+		if endpoint := endpoint(OIDCEndpointEnv, s.options.OIDCEndpoint); endpoint != nil {
+			o.BaseEndpoint = endpoint
+		}
+	}), nil
+}
+
+func (s *service) portalClient(ctx context.Context) (*awssso.Client, error) {
+	cfg, err := s.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return awssso.NewFromConfig(cfg, func(o *awssso.Options) {
+		if endpoint := endpoint(PortalEndpointEnv, s.options.PortalEndpoint); endpoint != nil {
+			o.BaseEndpoint = endpoint
+		}
+	}), nil
+}
+
+func (s *service) timeout() time.Duration {
+	if s.options.Timeout > 0 {
+		return s.options.Timeout
+	}
+	if value := strings.TrimSpace(os.Getenv(sts.TimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return sts.DefaultTimeout
+}
+
+func endpoint(env string, configured string) *string {
+	if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+		return aws.String(value)
+	}
+	if value := strings.TrimSpace(configured); value != "" {
+		return aws.String(value)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// ssoStandIn answers the IAM Identity Center OIDC and portal APIs well enough
+// for the device-authorization flow.
+type ssoStandIn struct {
+	mu sync.Mutex
+	// pollErrors are returned by CreateToken, in order, before it succeeds.
+	pollErrors []string
+	tokenCalls []map[string]interface{}
+	roleCalls  []*http.Request
+	bearer     string
+}
+
+func (s *ssoStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	body := map[string]interface{}{}
+	if r.Method == http.MethodPost {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	switch r.URL.Path {
+	case "/client/register":
+		writeJSON(w, map[string]interface{}{"clientId": "client-id", "clientSecret": "client-secret", "clientSecretExpiresAt": 1893456000})
+	case "/device_authorization":
+		writeJSON(w, map[string]interface{}{
+			"deviceCode":              "device-code",
+			"userCode":                "ABCD-EFGH",
+			"verificationUri":         "https://device.sso.example/",
+			"verificationUriComplete": "https://device.sso.example/?user_code=ABCD-EFGH",
+			"expiresIn":               600,
+			"interval":                1,
+		})
+	case "/token":
+		s.tokenCalls = append(s.tokenCalls, body)
+		if len(s.pollErrors) > 0 {
+			code := s.pollErrors[0]
+			s.pollErrors = s.pollErrors[1:]
+			w.Header().Set("X-Amzn-ErrorType", code)
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]interface{}{"error": code})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"accessToken": "access-token", "refreshToken": "refresh-token", "tokenType": "Bearer", "expiresIn": 3600})
+	case "/federation/credentials":
+		s.roleCalls = append(s.roleCalls, r)
+		s.bearer = r.Header.Get("X-Amz-Sso_bearer_token")
+		writeJSON(w, map[string]interface{}{"roleCredentials": map[string]interface{}{
+			"accessKeyId":     "ROLEACCESSKEY",
+			"secretAccessKey": "ROLESECRETKEY",
+			"sessionToken":    "ROLESESSIONTOKEN",
+			"expiration":      1893456000000,
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func isolateAWSConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv(OIDCEndpointEnv, "")
+	t.Setenv(PortalEndpointEnv, "")
+}
+
+func newStandInService(t *testing.T, standIn *ssoStandIn) (*service, *[]time.Duration) {
+	t.Helper()
+	isolateAWSConfig(t)
+	server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+	t.Cleanup(server.Close)
+	t.Setenv(OIDCEndpointEnv, server.URL)
+	t.Setenv(PortalEndpointEnv, server.URL)
+
+	var slept []time.Duration
+	s := NewService(Options{Region: "us-east-1"}).(*service)
+	s.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return s, &slept
+}
+
+func TestDeviceAuthorizationFlowAgainstLocalStandIn(t *testing.T) {
+	standIn := &ssoStandIn{pollErrors: []string{"AuthorizationPendingException", "SlowDownException", "AuthorizationPendingException"}}
+	s, slept := newStandInService(t, standIn)
+	ctx := context.Background()
+
+	client, err := s.RegisterClient(ctx, "actool", []string{"sso:account:access"})
+	assert.NilError(t, err)
+	assert.Equal(t, client.ClientID, "client-id")
+	assert.Equal(t, client.ExpiresAt, time.Unix(1893456000, 0).UTC())
+
+	authorization, err := s.StartDeviceAuthorization(ctx, client, "https://example.awsapps.com/start")
+	assert.NilError(t, err)
+	assert.Equal(t, authorization.UserCode, "ABCD-EFGH")
+	assert.Equal(t, authorization.Interval, time.Second)
+
+	token, err := s.WaitForToken(ctx, client, authorization)
+	assert.NilError(t, err)
+	assert.Equal(t, token.AccessToken, "access-token")
+	assert.Equal(t, token.RefreshToken, "refresh-token")
+	assert.Equal(t, token.ExpiresIn, int32(3600))
+	assert.DeepEqual(t, *slept, []time.Duration{time.Second, 6 * time.Second, 6 * time.Second})
+	assert.Equal(t, len(standIn.tokenCalls), 4)
+	assert.Equal(t, standIn.tokenCalls[0]["grantType"], grantTypeDeviceCode)
+	assert.Equal(t, standIn.tokenCalls[0]["deviceCode"], "device-code")
+
+	refreshed, err := s.RefreshToken(ctx, client, "refresh-token")
+	assert.NilError(t, err)
+	assert.Equal(t, refreshed.AccessToken, "access-token")
+	assert.Equal(t, standIn.tokenCalls[4]["grantType"], grantTypeRefreshToken)
+	assert.Equal(t, standIn.tokenCalls[4]["refreshToken"], "refresh-token")
+
+	credentials, err := s.RoleCredentials(ctx, token.AccessToken, "123456789012", "ReadOnly")
+	assert.NilError(t, err)
+	assert.Equal(t, credentials.AccessKey, "ROLEACCESSKEY")
+	assert.Equal(t, credentials.SessionToken, "ROLESESSIONTOKEN")
+	assert.Equal(t, credentials.Expiration, time.Unix(1893456000, 0).UTC())
+	assert.Equal(t, standIn.bearer, "access-token")
+	assert.Equal(t, standIn.roleCalls[0].URL.Query().Get("account_id"), "123456789012")
+	assert.Equal(t, standIn.roleCalls[0].URL.Query().Get("role_name"), "ReadOnly")
+}
+
+func TestWaitForTokenStopsWhenAuthorizationExpires(t *testing.T) {
+	cases := []struct {
+		name       string
+		pollErrors []string
+		expiresAt  time.Time
+	}{
+		{name: "service reports expiry", pollErrors: []string{"ExpiredTokenException"}},
+		{name: "device code lifetime elapsed", pollErrors: []string{"AuthorizationPendingException"}, expiresAt: time.Now().Add(time.Second)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newStandInService(t, &ssoStandIn{pollErrors: tc.pollErrors})
+			authorization := &DeviceAuthorization{DeviceCode: "device-code", Interval: 5 * time.Second, ExpiresAt: tc.expiresAt}
+			_, err := s.WaitForToken(context.Background(), &ClientRegistration{ClientID: "id", ClientSecret: "secret"}, authorization)
+			assert.Assert(t, errors.Is(err, ErrDeviceAuthorizationExpired), "got %v", err)
+		})
+	}
+}
+
+func TestWaitForTokenHonorsCancellation(t *testing.T) {
+	s, _ := newStandInService(t, &ssoStandIn{pollErrors: []string{"AuthorizationPendingException"}})
+	s.sleep = sleepContext
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	authorization := &DeviceAuthorization{DeviceCode: "device-code", Interval: time.Minute}
+	_, err := s.WaitForToken(ctx, &ClientRegistration{ClientID: "id", ClientSecret: "secret"}, authorization)
+	assert.Assert(t, errors.Is(err, context.Canceled), "got %v", err)
+}
@@ -0,0 +1,85 @@
+package sts
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	PartitionAWS      = "aws"
+	PartitionChina    = "aws-cn"
+	PartitionGovCloud = "aws-us-gov"
+)
+
+// regionPartitions maps region name prefixes to their partition. Regions that
+// match none of them belong to the commercial "aws" partition.
+var regionPartitions = []struct {
+	prefix    string
+	partition string
+}{
+	{prefix: "aws-cn-", partition: PartitionChina},
+	{prefix: "aws-us-gov-", partition: PartitionGovCloud},
+	{prefix: "cn-", partition: PartitionChina},
+	{prefix: "us-gov-", partition: PartitionGovCloud},
+	{prefix: "us-iso-", partition: "aws-iso"},
+	{prefix: "us-isob-", partition: "aws-iso-b"},
+	{prefix: "eu-isoe-", partition: "aws-iso-e"},
+	{prefix: "us-isof-", partition: "aws-iso-f"},
+	{prefix: "eusc-", partition: "aws-eusc"},
+}
+
+// partitionDefaultRegions are used when a profile sets no region at all.
+var partitionDefaultRegions = map[string]string{
+	PartitionAWS:      "us-east-1",
+	PartitionChina:    "cn-north-1",
+	PartitionGovCloud: "us-gov-west-1",
+}
+
+// PartitionForRegion returns the partition a region belongs to.
+func PartitionForRegion(region string) string {
+	region = strings.ToLower(strings.TrimSpace(region))
+	for _, candidate := range regionPartitions {
+		if strings.HasPrefix(region, candidate.prefix) {
+			return candidate.partition
+		}
+	}
+	return PartitionAWS
+}
+
+// PartitionFromARN returns the partition field of an ARN.
+func PartitionFromARN(arn string) (string, bool) {
+	parts := strings.SplitN(arn, ":", 3)
+	if len(parts) < 3 || parts[0] != "arn" || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// DefaultRegion returns the region STS requests fall back to in partition.
+func DefaultRegion(partition string) string {
+	if region, ok := partitionDefaultRegions[partition]; ok {
+		return region
+	}
+	return partitionDefaultRegions[PartitionAWS]
+}
+
+// ValidateRegion reports an error when region is set and does not belong to
+// partition, which STS would otherwise reject with a confusing signature or
+// token error.
+func ValidateRegion(partition string, region string) error {
+	if strings.TrimSpace(region) == "" || partition == "" {
+		return nil
+	}
+	if regionPartition := PartitionForRegion(region); regionPartition != partition {
+		return fmt.Errorf("region %q belongs to partition %q, but the credentials belong to partition %q; choose a region in the %s partition for this profile", region, regionPartition, partition, partition)
+	}
+	return nil
+}
+
+// MFASerialARN builds the ARN of a virtual MFA device named after the IAM user.
+func MFASerialARN(partition string, account string, userName string) string {
+	if partition == "" {
+		partition = PartitionAWS
+	}
+	return "arn:" + partition + ":iam::" + account + ":mfa/" + userName
+}
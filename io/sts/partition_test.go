@@ -0,0 +1,56 @@
+package sts
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestPartitionForRegion(t *testing.T) {
+	cases := []struct {
+		region string
+		want   string
+	}{
+		{region: "us-east-1", want: PartitionAWS},
+		{region: "", want: PartitionAWS},
+		{region: "aws-global", want: PartitionAWS},
+		{region: "cn-northwest-1", want: PartitionChina},
+		{region: "us-gov-east-1", want: PartitionGovCloud},
+		{region: "aws-us-gov-global", want: PartitionGovCloud},
+		{region: "us-iso-east-1", want: "aws-iso"},
+		{region: "us-isob-east-1", want: "aws-iso-b"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.region, func(t *testing.T) {
+			assert.Equal(t, PartitionForRegion(tc.region), tc.want)
+		})
+	}
+}
+
+func TestPartitionFromARN(t *testing.T) {
+	partition, ok := PartitionFromARN("arn:aws-cn:sts::123456789012:assumed-role/role/session")
+	assert.Assert(t, ok)
+	assert.Equal(t, partition, PartitionChina)
+
+	_, ok = PartitionFromARN("not-an-arn")
+	assert.Assert(t, !ok)
+}
+
+func TestDefaultRegion(t *testing.T) {
+	assert.Equal(t, DefaultRegion(PartitionAWS), "us-east-1")
+	assert.Equal(t, DefaultRegion(PartitionChina), "cn-north-1")
+	assert.Equal(t, DefaultRegion(PartitionGovCloud), "us-gov-west-1")
+	assert.Equal(t, DefaultRegion("unknown"), "us-east-1")
+}
+
+func TestValidateRegion(t *testing.T) {
+	assert.NilError(t, ValidateRegion(PartitionChina, "cn-north-1"))
+	assert.NilError(t, ValidateRegion(PartitionGovCloud, ""))
+	assert.ErrorContains(t, ValidateRegion(PartitionGovCloud, "us-west-2"), `credentials belong to partition "aws-us-gov"`)
+}
+
+func TestMFASerialARN(t *testing.T) {
+	assert.Equal(t, MFASerialARN("", "123456789012", "alice"), "arn:aws:iam::123456789012:mfa/alice")
+	assert.Equal(t, MFASerialARN(PartitionGovCloud, "123456789012", "alice"), "arn:aws-us-gov:iam::123456789012:mfa/alice")
+}
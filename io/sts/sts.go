@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -11,6 +14,31 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	awssts "github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	// EndpointEnv overrides every other endpoint setting. It is intended for
+	// local STS stand-ins and private endpoints that are not in the profile.
+	EndpointEnv = "ACTOOL_STS_ENDPOINT"
+
+	RegionalEndpointsRegional = "regional"
+	RegionalEndpointsLegacy   = "legacy"
+
+	// TimeoutEnv sets the per-request timeout when Options.Timeout is zero.
+	TimeoutEnv     = "ACTOOL_TIMEOUT"
+	DefaultTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNetwork marks failures to reach STS at all: DNS, TLS, proxies,
+	// captive portals and timeouts.
+	ErrNetwork = errors.New("could not reach AWS STS")
+	// ErrAuth marks requests STS answered with an error, such as invalid
+	// access keys or a wrong MFA code.
+	ErrAuth = errors.New("AWS STS rejected the request")
 )
 
 type SessionToken struct {
@@ -22,47 +50,99 @@ type SessionToken struct {
 }
 
 type Account struct {
-	Account  string
-	Arn      string
-	UserId   string
-	UserID   string
-	UserName string
+	Account   string
+	Arn       string
+	Partition string
+	UserId    string
+	UserID    string
+	UserName  string
+}
+
+// AssumeRoleInput mirrors the role settings of an AWS config profile.
+type AssumeRoleInput struct {
+	RoleARN         string
+	RoleSessionName string
+	ExternalID      string
+	DurationSeconds int32
 }
 
 type Service interface {
-	SessionToken(durationSeconds int64, serialNumber string, user string, token string) (*SessionToken, error)
-	Account() (*Account, error)
+	SessionToken(ctx context.Context, durationSeconds int64, account *Account, token string) (*SessionToken, error)
+	Account(ctx context.Context) (*Account, error)
+	AssumeRole(ctx context.Context, input AssumeRoleInput) (*SessionToken, error)
+}
+
+// Options are the endpoint-related settings of the AWS profile the STS client
+// is built for.
+type Options struct {
+	Region               string
+	EndpointURL          string
+	RegionalEndpoints    string
+	UseFIPSEndpoint      bool
+	UseDualStackEndpoint bool
+	// Timeout bounds each STS request. Zero uses ACTOOL_TIMEOUT or
+	// DefaultTimeout.
+	Timeout time.Duration
 }
 
 type service struct {
-	accessKey string
-	secretKey string
-	region    string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	options      Options
+
+	httpClient *http.Client
 }
 
-func NewService(accessKey string, secretKey string, region string) Service {
-	return &service{accessKey: accessKey, secretKey: secretKey, region: region}
+type globalEndpointResolver struct {
+	next awssts.EndpointResolverV2
 }
 
-func (s *service) SessionToken(durationSeconds int64, account string, userName string, token string) (*SessionToken, error) {
-	client, err := s.client()
+// requestError classifies an STS failure as ErrNetwork or ErrAuth while
+// keeping the SDK error available to errors.As.
+type requestError struct {
+	kind error
+	err  error
+}
+
+func NewService(accessKey string, secretKey string, options Options) Service {
+	return &service{accessKey: accessKey, secretKey: secretKey, options: options}
+}
+
+// NewSessionService signs requests with temporary credentials, as needed for
+// each hop of a role chain after the first.
+func NewSessionService(accessKey string, secretKey string, sessionToken string, options Options) Service {
+	return &service{accessKey: accessKey, secretKey: secretKey, sessionToken: sessionToken, options: options}
+}
+
+func (s *service) SessionToken(ctx context.Context, durationSeconds int64, account *Account, token string) (*SessionToken, error) {
+	if account == nil {
+		return nil, errors.New("sts account is nil")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	client, err := s.client(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := client.GetSessionToken(context.Background(), &awssts.GetSessionTokenInput{
+	partition := account.Partition
+	if partition == "" {
+		partition = PartitionForRegion(s.options.Region)
+	}
+	mfaSerial := MFASerialARN(partition, account.Account, account.UserName)
+	output, err := client.GetSessionToken(ctx, &awssts.GetSessionTokenInput{
 		DurationSeconds: aws.Int32(int32(durationSeconds)),
-		SerialNumber:    aws.String("arn:aws:iam::" + account + ":mfa/" + userName),
+		SerialNumber:    aws.String(mfaSerial),
 		TokenCode:       aws.String(token),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("sts fail: %w", err)
+		return nil, classifyError(err)
 	}
 	if output.Credentials == nil {
 		return nil, errors.New("sts credentials are empty")
 	}
 
-	mfaSerial := "arn:aws:iam::" + account + ":mfa/" + userName
 	return &SessionToken{
 		AccessKey:    aws.ToString(output.Credentials.AccessKeyId),
 		SecretKey:    aws.ToString(output.Credentials.SecretAccessKey),
@@ -72,15 +152,54 @@ func (s *service) SessionToken(durationSeconds int64, account string, userName s
 	}, nil
 }
 
-func (s *service) Account() (*Account, error) {
-	client, err := s.client()
+func (s *service) AssumeRole(ctx context.Context, input AssumeRoleInput) (*SessionToken, error) {
+	if strings.TrimSpace(input.RoleARN) == "" {
+		return nil, errors.New("role ARN is empty")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	client, err := s.client(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := client.GetCallerIdentity(context.Background(), &awssts.GetCallerIdentityInput{})
+	request := &awssts.AssumeRoleInput{
+		RoleArn:         aws.String(input.RoleARN),
+		RoleSessionName: aws.String(input.RoleSessionName),
+	}
+	if input.ExternalID != "" {
+		request.ExternalId = aws.String(input.ExternalID)
+	}
+	if input.DurationSeconds > 0 {
+		request.DurationSeconds = aws.Int32(input.DurationSeconds)
+	}
+	output, err := client.AssumeRole(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("sts fail: %w", err)
+		return nil, classifyError(err)
+	}
+	if output.Credentials == nil {
+		return nil, errors.New("sts credentials are empty")
+	}
+
+	return &SessionToken{
+		AccessKey:    aws.ToString(output.Credentials.AccessKeyId),
+		SecretKey:    aws.ToString(output.Credentials.SecretAccessKey),
+		SessionToken: aws.ToString(output.Credentials.SessionToken),
+		Expiration:   aws.ToTime(output.Credentials.Expiration),
+	}, nil
+}
+
+func (s *service) Account(ctx context.Context) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.GetCallerIdentity(ctx, &awssts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, classifyError(err)
 	}
 
 	account := aws.ToString(output.Account)
@@ -91,25 +210,106 @@ func (s *service) Account() (*Account, error) {
 		parts := strings.Split(arn, "/")
 		userName = parts[len(parts)-1]
 	}
+	partition, ok := PartitionFromARN(arn)
+	if !ok {
+		partition = PartitionForRegion(s.options.Region)
+	}
+	if err := ValidateRegion(partition, s.options.Region); err != nil {
+		return nil, err
+	}
 
 	return &Account{
-		Account:  account,
-		Arn:      arn,
-		UserId:   userID,
-		UserID:   userID,
-		UserName: userName,
+		Account:   account,
+		Arn:       arn,
+		Partition: partition,
+		UserId:    userID,
+		UserID:    userID,
+		UserName:  userName,
 	}, nil
 }
 
-func (s *service) client() (*awssts.Client, error) {
-	cfg, err := config.LoadDefaultConfig(
-		context.Background(),
-		config.WithRegion(s.region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.accessKey, s.secretKey, "")),
-	)
+func (s *service) client(ctx context.Context) (*awssts.Client, error) {
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(s.options.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.accessKey, s.secretKey, s.sessionToken)),
+	}
+	if endpoint := s.endpoint(); endpoint != "" {
+		loadOptions = append(loadOptions, config.WithBaseEndpoint(endpoint))
+	}
+	if s.options.UseFIPSEndpoint {
+		loadOptions = append(loadOptions, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if s.options.UseDualStackEndpoint {
+		loadOptions = append(loadOptions, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if s.httpClient != nil {
+		loadOptions = append(loadOptions, config.WithHTTPClient(s.httpClient))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	return awssts.NewFromConfig(cfg), nil
+	var clientOptions []func(*awssts.Options)
+	switch strings.ToLower(strings.TrimSpace(s.options.RegionalEndpoints)) {
+	case "", RegionalEndpointsRegional:
+	case RegionalEndpointsLegacy:
+		clientOptions = append(clientOptions, func(o *awssts.Options) {
+			o.EndpointResolverV2 = &globalEndpointResolver{next: awssts.NewDefaultEndpointResolverV2()}
+		})
+	default:
+		return nil, fmt.Errorf("unsupported sts_regional_endpoints value: %s", s.options.RegionalEndpoints)
+	}
+	return awssts.NewFromConfig(cfg, clientOptions...), nil
+}
+
+func (s *service) endpoint() string {
+	if endpoint := strings.TrimSpace(os.Getenv(EndpointEnv)); endpoint != "" {
+		return endpoint
+	}
+	return strings.TrimSpace(s.options.EndpointURL)
+}
+
+func (s *service) timeout() time.Duration {
+	if s.options.Timeout > 0 {
+		return s.options.Timeout
+	}
+	if value := strings.TrimSpace(os.Getenv(TimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return DefaultTimeout
+}
+
+func classifyError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return &requestError{kind: ErrAuth, err: err}
+	}
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &sendErr) || errors.As(err, &netErr) {
+		return &requestError{kind: ErrNetwork, err: err}
+	}
+	return fmt.Errorf("sts fail: %w", err)
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("%s: %v", e.kind, e.err)
+}
+
+func (e *requestError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// ResolveEndpoint implements sts_regional_endpoints = legacy, which sends
+// requests from the legacy global regions to sts.amazonaws.com.
+func (g *globalEndpointResolver) ResolveEndpoint(ctx context.Context, params awssts.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	params.UseGlobalEndpoint = aws.Bool(true)
+	return g.next.ResolveEndpoint(ctx, params)
 }
@@ -0,0 +1,330 @@
+package sts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+const (
+	callerIdentityResponse = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>%s</Arn>
+    <UserId>AIDAEXAMPLE</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata><RequestId>caller</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`
+	sessionTokenResponse = `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetSessionTokenResult>
+    <Credentials>
+      <AccessKeyId>SESSIONACCESSKEY</AccessKeyId>
+      <SecretAccessKey>SESSIONSECRETKEY</SecretAccessKey>
+      <SessionToken>SESSIONTOKEN</SessionToken>
+      <Expiration>2030-01-02T03:04:05Z</Expiration>
+    </Credentials>
+  </GetSessionTokenResult>
+  <ResponseMetadata><RequestId>session</RequestId></ResponseMetadata>
+</GetSessionTokenResponse>`
+	assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ROLEACCESSKEY</AccessKeyId>
+      <SecretAccessKey>ROLESECRETKEY</SecretAccessKey>
+      <SessionToken>ROLESESSIONTOKEN</SessionToken>
+      <Expiration>2030-01-02T03:04:05Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/ReadOnly/actool</Arn>
+      <AssumedRoleId>AROAEXAMPLE:actool</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>role</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+)
+
+// stsStandIn answers the STS query protocol well enough for actool's calls and
+// records every form it receives.
+type stsStandIn struct {
+	mu       sync.Mutex
+	hosts    []string
+	requests []url.Values
+	tokens   []string
+	status   int
+	body     string
+	// callerArn defaults to a commercial-partition IAM user.
+	callerArn string
+}
+
+func (s *stsStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = r.ParseForm()
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	s.hosts = append(s.hosts, host)
+	s.requests = append(s.requests, r.PostForm)
+	s.tokens = append(s.tokens, r.Header.Get("X-Amz-Security-Token"))
+	w.Header().Set("Content-Type", "text/xml")
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		_, _ = io.WriteString(w, s.body)
+		return
+	}
+	switch r.PostForm.Get("Action") {
+	case "GetCallerIdentity":
+		arn := s.callerArn
+		if arn == "" {
+			arn = "arn:aws:iam::123456789012:user/alice"
+		}
+		_, _ = fmt.Fprintf(w, callerIdentityResponse, arn)
+	case "GetSessionToken":
+		_, _ = io.WriteString(w, sessionTokenResponse)
+	case "AssumeRole":
+		_, _ = io.WriteString(w, assumeRoleResponse)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *stsStandIn) RoundTrip(r *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	s.handle(recorder, r)
+	return recorder.Result(), nil
+}
+
+func isolateAWSConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_STS", "")
+	t.Setenv("AWS_USE_FIPS_ENDPOINT", "")
+	t.Setenv("AWS_USE_DUALSTACK_ENDPOINT", "")
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv(EndpointEnv, "")
+}
+
+func TestServiceAgainstLocalStandIn(t *testing.T) {
+	cases := []struct {
+		name        string
+		envEndpoint bool
+	}{
+		{name: "profile endpoint_url"},
+		{name: "ACTOOL_STS_ENDPOINT override", envEndpoint: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isolateAWSConfig(t)
+			standIn := &stsStandIn{}
+			server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+			defer server.Close()
+
+			options := Options{Region: "us-east-1", EndpointURL: server.URL}
+			if tc.envEndpoint {
+				options.EndpointURL = "http://127.0.0.1:1/unused"
+				t.Setenv(EndpointEnv, server.URL)
+			}
+			service := NewService("BASEACCESSKEY", "BASESECRETKEY", options)
+
+			account, err := service.Account(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, account.Account, "123456789012")
+			assert.Equal(t, account.UserName, "alice")
+
+			token, err := service.SessionToken(context.Background(), 3600, account, "123456")
+			assert.NilError(t, err)
+			assert.Equal(t, token.AccessKey, "SESSIONACCESSKEY")
+			assert.Equal(t, token.SessionToken, "SESSIONTOKEN")
+			assert.Equal(t, token.Expiration, time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC))
+			assert.Equal(t, token.MFASerial, "arn:aws:iam::123456789012:mfa/alice")
+
+			assert.Equal(t, len(standIn.requests), 2)
+			assert.Equal(t, standIn.requests[1].Get("SerialNumber"), "arn:aws:iam::123456789012:mfa/alice")
+			assert.Equal(t, standIn.requests[1].Get("TokenCode"), "123456")
+		})
+	}
+}
+
+func TestServiceResolvesEndpointFromProfileSettings(t *testing.T) {
+	cases := []struct {
+		name      string
+		options   Options
+		callerArn string
+		wantHost  string
+		wantErr   string
+	}{
+		{name: "regional", options: Options{Region: "ap-northeast-1"}, wantHost: "sts.ap-northeast-1.amazonaws.com"},
+		{name: "legacy global", options: Options{Region: "ap-northeast-1", RegionalEndpoints: RegionalEndpointsLegacy}, wantHost: "sts.amazonaws.com"},
+		{name: "fips", options: Options{Region: "us-east-1", UseFIPSEndpoint: true}, wantHost: "sts-fips.us-east-1.amazonaws.com"},
+		{name: "dual-stack", options: Options{Region: "us-east-1", UseDualStackEndpoint: true}, wantHost: "sts.us-east-1.api.aws"},
+		{name: "govcloud fips", options: Options{Region: "us-gov-west-1", UseFIPSEndpoint: true}, callerArn: "arn:aws-us-gov:iam::123456789012:user/alice", wantHost: "sts.us-gov-west-1.amazonaws.com"},
+		{name: "invalid regional setting", options: Options{Region: "us-east-1", RegionalEndpoints: "sometimes"}, wantErr: "unsupported sts_regional_endpoints"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isolateAWSConfig(t)
+			standIn := &stsStandIn{callerArn: tc.callerArn}
+			s := &service{accessKey: "A", secretKey: "S", options: tc.options, httpClient: &http.Client{Transport: standIn}}
+
+			_, err := s.Account(context.Background())
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, standIn.hosts, []string{tc.wantHost})
+		})
+	}
+}
+
+func TestServiceReportsSTSErrors(t *testing.T) {
+	isolateAWSConfig(t)
+	standIn := &stsStandIn{
+		status: http.StatusForbidden,
+		body: `<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code>` +
+			`<Message>The security token included in the request is invalid.</Message></Error></ErrorResponse>`,
+	}
+	s := &service{accessKey: "A", secretKey: "S", options: Options{Region: "us-east-1"}, httpClient: &http.Client{Transport: standIn}}
+
+	_, err := s.Account(context.Background())
+	assert.ErrorContains(t, err, "InvalidClientTokenId")
+	assert.Assert(t, bytes.Contains([]byte(err.Error()), []byte("STS")))
+	assert.Assert(t, errors.Is(err, ErrAuth))
+	assert.Assert(t, !errors.Is(err, ErrNetwork))
+}
+
+func TestServiceReportsNetworkFailuresSeparately(t *testing.T) {
+	isolateAWSConfig(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cases := []struct {
+		name     string
+		endpoint string
+		timeout  time.Duration
+	}{
+		{name: "connection refused", endpoint: "http://127.0.0.1:1", timeout: time.Second},
+		{name: "hung endpoint", endpoint: server.URL, timeout: 50 * time.Millisecond},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService("A", "S", Options{Region: "us-east-1", EndpointURL: tc.endpoint, Timeout: tc.timeout})
+			_, err := service.Account(context.Background())
+			assert.Assert(t, errors.Is(err, ErrNetwork), "got %v", err)
+			assert.Assert(t, !errors.Is(err, ErrAuth))
+		})
+	}
+}
+
+func TestServiceHonorsCancellation(t *testing.T) {
+	isolateAWSConfig(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	service := NewService("A", "S", Options{Region: "us-east-1", EndpointURL: server.URL})
+	_, err := service.Account(ctx)
+	assert.Assert(t, errors.Is(err, context.Canceled), "got %v", err)
+}
+
+func TestServiceTimeoutFromEnvironment(t *testing.T) {
+	t.Setenv(TimeoutEnv, "")
+	assert.Equal(t, (&service{}).timeout(), DefaultTimeout)
+	t.Setenv(TimeoutEnv, "5s")
+	assert.Equal(t, (&service{}).timeout(), 5*time.Second)
+	t.Setenv(TimeoutEnv, "invalid")
+	assert.Equal(t, (&service{}).timeout(), DefaultTimeout)
+	assert.Equal(t, (&service{options: Options{Timeout: time.Minute}}).timeout(), time.Minute)
+}
+
+func TestServiceUsesCallerPartition(t *testing.T) {
+	cases := []struct {
+		name       string
+		callerArn  string
+		region     string
+		wantSerial string
+		wantErr    string
+	}{
+		{name: "commercial", callerArn: "arn:aws:iam::123456789012:user/alice", region: "us-east-1", wantSerial: "arn:aws:iam::123456789012:mfa/alice"},
+		{name: "china", callerArn: "arn:aws-cn:iam::123456789012:user/alice", region: "cn-north-1", wantSerial: "arn:aws-cn:iam::123456789012:mfa/alice"},
+		{name: "govcloud with path", callerArn: "arn:aws-us-gov:iam::123456789012:user/ops/alice", region: "us-gov-west-1", wantSerial: "arn:aws-us-gov:iam::123456789012:mfa/alice"},
+		{name: "region outside credential partition", callerArn: "arn:aws-cn:iam::123456789012:user/alice", region: "us-east-1", wantErr: `belongs to partition "aws"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isolateAWSConfig(t)
+			standIn := &stsStandIn{callerArn: tc.callerArn}
+			server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+			defer server.Close()
+			service := NewService("A", "S", Options{Region: tc.region, EndpointURL: server.URL})
+
+			account, err := service.Account(context.Background())
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			token, err := service.SessionToken(context.Background(), 3600, account, "123456")
+			assert.NilError(t, err)
+			assert.Equal(t, token.MFASerial, tc.wantSerial)
+			assert.Equal(t, standIn.requests[1].Get("SerialNumber"), tc.wantSerial)
+		})
+	}
+}
+
+func TestServiceAssumeRoleWithSessionCredentials(t *testing.T) {
+	isolateAWSConfig(t)
+	standIn := &stsStandIn{}
+	server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+	defer server.Close()
+
+	service := NewSessionService("SESSIONACCESSKEY", "SESSIONSECRETKEY", "SESSIONTOKEN", Options{Region: "us-east-1", EndpointURL: server.URL})
+	token, err := service.AssumeRole(context.Background(), AssumeRoleInput{
+		RoleARN:         "arn:aws:iam::123456789012:role/ReadOnly",
+		RoleSessionName: "actool",
+		ExternalID:      "external",
+		DurationSeconds: 900,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, token.AccessKey, "ROLEACCESSKEY")
+	assert.Equal(t, token.SessionToken, "ROLESESSIONTOKEN")
+	assert.Equal(t, token.Expiration, time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC))
+
+	form := standIn.requests[0]
+	assert.Equal(t, form.Get("RoleArn"), "arn:aws:iam::123456789012:role/ReadOnly")
+	assert.Equal(t, form.Get("RoleSessionName"), "actool")
+	assert.Equal(t, form.Get("ExternalId"), "external")
+	assert.Equal(t, form.Get("DurationSeconds"), "900")
+	assert.DeepEqual(t, standIn.tokens, []string{"SESSIONTOKEN"})
+
+	_, err = service.AssumeRole(context.Background(), AssumeRoleInput{})
+	assert.ErrorContains(t, err, "role ARN is empty")
+}
@@ -0,0 +1,86 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestUnified(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{name: "equal", old: "a\nb\n", new: "a\nb\n", want: ""},
+		{
+			name: "changed line",
+			old:  "[default]\nregion = us-east-1\noutput = json\n",
+			new:  "[default]\nregion = eu-west-1\noutput = json\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n [default]\n-region = us-east-1\n+region = eu-west-1\n output = json\n",
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "[default]\nregion = us-east-1\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+[default]\n+region = us-east-1\n",
+		},
+		{
+			name: "deleted file",
+			old:  "x\n",
+			new:  "",
+			want: "--- a\n+++ b\n@@ -1 +0,0 @@\n-x\n",
+		},
+		{
+			name: "missing final newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, Unified("a", "b", tc.old, tc.new), tc.want)
+		})
+	}
+}
+
+func TestUnifiedSplitsDistantChangesIntoHunks(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 1; i <= 20; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		oldLines = append(oldLines, line)
+		switch i {
+		case 2:
+			newLines = append(newLines, "line two\n")
+		case 18:
+			// deleted
+		default:
+			newLines = append(newLines, line)
+		}
+	}
+	newLines = append(newLines, "line 21\n")
+
+	got := Unified("a", "b", strings.Join(oldLines, ""), strings.Join(newLines, ""))
+	assert.Equal(t, got, `--- a
++++ b
+@@ -1,5 +1,5 @@
+ line 1
+-line 2
++line two
+ line 3
+ line 4
+ line 5
+@@ -15,6 +15,6 @@
+ line 15
+ line 16
+ line 17
+-line 18
+ line 19
+ line 20
++line 21
+`)
+}
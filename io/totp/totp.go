@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Period and Digits match the RFC 6238 defaults used by AWS virtual MFA
+	// devices and the authenticator apps that enroll them.
+	Period = 30 * time.Second
+	Digits = 6
+)
+
+// NormalizeSecret accepts the base32 seed as shown by the IAM console or an
+// authenticator export (spaces, lower case and missing padding are allowed)
+// and returns the canonical unpadded upper-case form.
+func NormalizeSecret(secret string) (string, error) {
+	normalized := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\t", "").Replace(strings.TrimSpace(secret)))
+	normalized = strings.TrimRight(normalized, "=")
+	if normalized == "" {
+		return "", errors.New("TOTP secret is empty")
+	}
+	if _, err := decodeSecret(normalized); err != nil {
+		return "", fmt.Errorf("TOTP secret is not valid base32: %w", err)
+	}
+	return normalized, nil
+}
+
+// TimeStep returns the RFC 6238 counter for t.
+func TimeStep(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// StepStart returns the first instant of the given time step.
+func StepStart(step int64) time.Time {
+	return time.Unix(step*int64(Period/time.Second), 0).UTC()
+}
+
+// Code generates the RFC 6238 (HMAC-SHA1) code for the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("TOTP secret is not valid base32: %w", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	normalized := strings.TrimRight(strings.ToUpper(strings.TrimSpace(secret)), "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, errors.New("TOTP secret is empty")
+	}
+	return key, nil
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// rfc6238Secret is the base32 form of the RFC 6238 SHA1 test seed
+// "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.want, func(t *testing.T) {
+			code, err := Code(rfc6238Secret, TimeStep(time.Unix(tc.unix, 0)))
+			assert.NilError(t, err)
+			assert.Equal(t, code, tc.want)
+		})
+	}
+}
+
+func TestNormalizeSecret(t *testing.T) {
+	cases := []struct {
+		name    string
+		secret  string
+		want    string
+		wantErr string
+	}{
+		{name: "canonical", secret: rfc6238Secret, want: rfc6238Secret},
+		{name: "grouped lower case", secret: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", want: rfc6238Secret},
+		{name: "padded", secret: "GEZDGNBV====", want: "GEZDGNBV"},
+		{name: "empty", secret: "  ", wantErr: "empty"},
+		{name: "invalid alphabet", secret: "GEZDGNB1", wantErr: "not valid base32"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NormalizeSecret(tc.secret)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, got, tc.want)
+		})
+	}
+}
+
+func TestStepStart(t *testing.T) {
+	step := TimeStep(time.Unix(1111111111, 0))
+	assert.Equal(t, StepStart(step), time.Unix(1111111110, 0).UTC())
+	assert.Equal(t, TimeStep(StepStart(step+1)), step+1)
+}
@@ -0,0 +1,76 @@
+package vaultkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// authToken returns the token for the next request, logging in or reading
+// the token file on first use.
+func (c *Client) authToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+
+	var err error
+	if c.options.RoleID != "" {
+		token, err = c.appRoleLogin(ctx)
+	} else {
+		token, err = readTokenFile(c.options.TokenFile)
+	}
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+	return token, nil
+}
+
+func (c *Client) appRoleLogin(ctx context.Context) (string, error) {
+	path := "auth/" + c.options.AppRoleMount + "/login"
+	body := map[string]string{"role_id": c.options.RoleID, "secret_id": c.options.SecretID}
+	var response kvResponse
+	status, err := c.send(ctx, http.MethodPost, path, "", body, &response)
+	if err != nil {
+		return "", err
+	}
+	if status >= 300 {
+		return "", fmt.Errorf("vault AppRole login failed: %s", responseErrors(status, &response))
+	}
+	if response.Auth.ClientToken == "" {
+		return "", errors.New("vault AppRole login returned no client token")
+	}
+	return response.Auth.ClientToken, nil
+}
+
+// readTokenFile reads the token written by `vault login`.
+func readTokenFile(path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(homeDir, ".vault-token")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no Vault token: set %s, %s/%s, or run vault login (%s not found)", TokenEnv, RoleIDEnv, SecretIDEnv, path)
+		}
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("vault token file is empty: %s", path)
+	}
+	return token, nil
+}
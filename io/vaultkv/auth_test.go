@@ -0,0 +1,59 @@
+package vaultkv
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "vault-token")
+	assert.NilError(t, os.WriteFile(tokenFile, []byte(testToken+"\n"), 0o600))
+	emptyTokenFile := filepath.Join(dir, "empty-token")
+	assert.NilError(t, os.WriteFile(emptyTokenFile, nil, 0o600))
+
+	cases := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{
+		{name: "token", options: Options{Token: testToken}},
+		{name: "approle", options: Options{RoleID: testRoleID, SecretID: testSecretID}},
+		{name: "token wins over approle", options: Options{Token: testToken, RoleID: testRoleID, SecretID: "wrong"}},
+		{name: "approle with wrong secret", options: Options{RoleID: testRoleID, SecretID: "wrong"}, wantErr: "AppRole login failed: invalid role or secret ID"},
+		{name: "token file", options: Options{TokenFile: tokenFile}},
+		{name: "missing token file", options: Options{TokenFile: filepath.Join(dir, "missing")}, wantErr: "run vault login"},
+		{name: "empty token file", options: Options{TokenFile: emptyTokenFile}, wantErr: "token file is empty"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, server := newKVStandIn(t)
+			tc.options.Address = server.URL
+			client := newTestClient(t, tc.options)
+
+			_, err := client.Get(context.Background(), "dev")
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+		})
+	}
+}
+
+func TestTokenFileDefaultsToVaultCLILocation(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	assert.NilError(t, os.WriteFile(filepath.Join(home, ".vault-token"), []byte(testToken), 0o600))
+
+	token, err := readTokenFile("")
+	assert.NilError(t, err)
+	assert.Equal(t, token, testToken)
+}
@@ -0,0 +1,75 @@
+package vaultkv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// TLSOptions mirror the Vault CLI's VAULT_CACERT, VAULT_CAPATH,
+// VAULT_CLIENT_CERT, VAULT_CLIENT_KEY, VAULT_TLS_SERVER_NAME and
+// VAULT_SKIP_VERIFY settings.
+type TLSOptions struct {
+	CACert     string
+	CAPath     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	Insecure   bool
+}
+
+func newTransport(options TLSOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.Insecure, //nolint:gosec // explicit VAULT_SKIP_VERIFY opt-in
+	}
+
+	if options.CACert != "" || options.CAPath != "" {
+		pool := x509.NewCertPool()
+		files := []string{}
+		if options.CACert != "" {
+			files = append(files, options.CACert)
+		}
+		if options.CAPath != "" {
+			entries, err := os.ReadDir(options.CAPath)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", CAPathEnv, err)
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(options.CAPath, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read Vault CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) && file == options.CACert {
+				return nil, fmt.Errorf("no PEM certificates in %s", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if (options.ClientCert == "") != (options.ClientKey == "") {
+		return nil, errors.New("vault client certificate needs both " + ClientCertEnv + " and " + ClientKeyEnv)
+	}
+	if options.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load Vault client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport.TLSClientConfig = config
+	return transport, nil
+}
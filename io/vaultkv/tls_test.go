@@ -0,0 +1,62 @@
+package vaultkv
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestTLSOptions(t *testing.T) {
+	standIn := &kvStandIn{mount: "secret", entries: make(map[string]*kvEntry)}
+	server := httptest.NewTLSServer(http.HandlerFunc(standIn.handle))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca")
+	assert.NilError(t, os.Mkdir(caPath, 0o700))
+	caCert := filepath.Join(caPath, "ca.pem")
+	assert.NilError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	notPEM := filepath.Join(dir, "not-pem")
+	assert.NilError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	cases := []struct {
+		name       string
+		tls        TLSOptions
+		wantErr    string
+		wantNewErr string
+	}{
+		{name: "untrusted server", wantErr: "certificate"},
+		{name: "CA certificate", tls: TLSOptions{CACert: caCert}},
+		{name: "CA directory", tls: TLSOptions{CAPath: caPath}},
+		{name: "skip verify", tls: TLSOptions{Insecure: true}},
+		{name: "wrong server name", tls: TLSOptions{CACert: caCert, ServerName: "vault.internal"}, wantErr: "vault.internal"},
+		{name: "CA file without certificates", tls: TLSOptions{CACert: notPEM}, wantNewErr: "no PEM certificates"},
+		{name: "missing CA directory", tls: TLSOptions{CAPath: filepath.Join(dir, "missing")}, wantNewErr: "VAULT_CAPATH"},
+		{name: "invalid client certificate", tls: TLSOptions{ClientCert: notPEM, ClientKey: notPEM}, wantNewErr: "load Vault client certificate"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(Options{Address: server.URL, Token: testToken, TLS: tc.tls})
+			if tc.wantNewErr != "" {
+				assert.ErrorContains(t, err, tc.wantNewErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			_, err = client.Get(context.Background(), "dev")
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+		})
+	}
+}
@@ -0,0 +1,417 @@
+// Package vaultkv stores secrets in a HashiCorp Vault KV version 2 engine
+// through the Vault HTTP API.
+//
+// Each key is one KV entry below Options.Path. Entry names are the base64url
+// encoding of the key, because actool keys contain "/" and ":"; the key itself
+// is kept next to the value so that the entries stay readable in the Vault UI.
+package vaultkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
+)
+
+const (
+	AddressEnv       = "VAULT_ADDR"
+	TokenEnv         = "VAULT_TOKEN"
+	NamespaceEnv     = "VAULT_NAMESPACE"
+	CACertEnv        = "VAULT_CACERT"
+	CAPathEnv        = "VAULT_CAPATH"
+	ClientCertEnv    = "VAULT_CLIENT_CERT"
+	ClientKeyEnv     = "VAULT_CLIENT_KEY"
+	SkipVerifyEnv    = "VAULT_SKIP_VERIFY"
+	TLSServerNameEnv = "VAULT_TLS_SERVER_NAME"
+
+	MountEnv        = "ACTOOL_VAULT_MOUNT"
+	PathEnv         = "ACTOOL_VAULT_PATH"
+	TokenFileEnv    = "ACTOOL_VAULT_TOKEN_FILE"
+	RoleIDEnv       = "ACTOOL_VAULT_ROLE_ID"
+	SecretIDEnv     = "ACTOOL_VAULT_SECRET_ID"
+	AppRoleMountEnv = "ACTOOL_VAULT_APPROLE_MOUNT"
+
+	defaultMount        = "secret"
+	defaultPath         = "actool"
+	defaultAppRoleMount = "approle"
+
+	namespaceHeader = "X-Vault-Namespace"
+	tokenHeader     = "X-Vault-Token"
+)
+
+var (
+	// ErrNotFound is returned when a key has no live version.
+	ErrNotFound = errors.New("secret not found")
+	// ErrConflict is returned when a Set lost a check-and-set race.
+	ErrConflict = errors.New("secret was changed concurrently")
+)
+
+// Options select the Vault server, authentication and KV location.
+type Options struct {
+	Address   string
+	Namespace string
+	// Mount is the KV v2 mount ("secret" by default) and Path the folder
+	// below it ("actool" by default).
+	Mount string
+	Path  string
+
+	// Token is used when set. Otherwise RoleID and SecretID log in through
+	// the AppRole method at AppRoleMount, and when those are empty too the
+	// token is read from TokenFile (~/.vault-token by default, as the Vault
+	// CLI does).
+	Token        string
+	RoleID       string
+	SecretID     string
+	AppRoleMount string
+	TokenFile    string
+
+	TLS TLSOptions
+	// Timeout bounds each request; zero uses ACTOOL_TIMEOUT or
+	// sts.DefaultTimeout.
+	Timeout time.Duration
+}
+
+// OptionsFromEnv reads the standard VAULT_* variables and actool's
+// ACTOOL_VAULT_* settings.
+func OptionsFromEnv() Options {
+	return Options{
+		Address:      os.Getenv(AddressEnv),
+		Namespace:    os.Getenv(NamespaceEnv),
+		Mount:        os.Getenv(MountEnv),
+		Path:         os.Getenv(PathEnv),
+		Token:        os.Getenv(TokenEnv),
+		RoleID:       os.Getenv(RoleIDEnv),
+		SecretID:     os.Getenv(SecretIDEnv),
+		AppRoleMount: os.Getenv(AppRoleMountEnv),
+		TokenFile:    os.Getenv(TokenFileEnv),
+		TLS: TLSOptions{
+			CACert:     os.Getenv(CACertEnv),
+			CAPath:     os.Getenv(CAPathEnv),
+			ClientCert: os.Getenv(ClientCertEnv),
+			ClientKey:  os.Getenv(ClientKeyEnv),
+			ServerName: os.Getenv(TLSServerNameEnv),
+			Insecure:   parseBool(os.Getenv(SkipVerifyEnv)),
+		},
+	}
+}
+
+// Client reads and writes KV v2 entries. It remembers the version of every
+// entry it has read or written, and writes with that version as the
+// check-and-set parameter, so a Set never overwrites a change made by another
+// process since this client last saw the entry.
+type Client struct {
+	options    Options
+	address    *url.URL
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	token    string
+	versions map[string]int
+}
+
+type secretData struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type kvResponse struct {
+	Data struct {
+		Data     *secretData `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+		Version        int      `json:"version"`
+		CurrentVersion int      `json:"current_version"`
+		Keys           []string `json:"keys"`
+	} `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewClient validates options and prepares the HTTP client. It does not
+// contact Vault.
+func NewClient(options Options) (*Client, error) {
+	address := strings.TrimSpace(options.Address)
+	if address == "" {
+		return nil, fmt.Errorf("%s is required", AddressEnv)
+	}
+	parsed, err := url.Parse(strings.TrimRight(address, "/"))
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid %s: %s", AddressEnv, address)
+	}
+	options.Mount = strings.Trim(strings.TrimSpace(options.Mount), "/")
+	if options.Mount == "" {
+		options.Mount = defaultMount
+	}
+	options.Path = strings.Trim(strings.TrimSpace(options.Path), "/")
+	if options.Path == "" {
+		options.Path = defaultPath
+	}
+	options.AppRoleMount = strings.Trim(strings.TrimSpace(options.AppRoleMount), "/")
+	if options.AppRoleMount == "" {
+		options.AppRoleMount = defaultAppRoleMount
+	}
+	if (options.RoleID == "") != (options.SecretID == "") {
+		return nil, fmt.Errorf("AppRole login needs both %s and %s", RoleIDEnv, SecretIDEnv)
+	}
+	options.Timeout = timeout(options.Timeout)
+
+	transport, err := newTransport(options.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		options:    options,
+		address:    parsed,
+		httpClient: &http.Client{Transport: transport, Timeout: options.Timeout},
+		token:      strings.TrimSpace(options.Token),
+		versions:   make(map[string]int),
+	}, nil
+}
+
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	var response kvResponse
+	status, err := c.do(ctx, http.MethodGet, c.dataPath(key), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound || response.Data.Data == nil {
+		return nil, ErrNotFound
+	}
+	c.remember(key, response.Data.Metadata.Version)
+	return []byte(response.Data.Data.Value), nil
+}
+
+// Set writes with the version this client last saw, or with the current
+// version when it has not seen the entry yet.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	if !utf8.Valid(value) {
+		return errors.New("secret value is not valid UTF-8")
+	}
+	version, ok := c.version(key)
+	if !ok {
+		var err error
+		version, err = c.currentVersion(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	body := map[string]interface{}{
+		"options": map[string]int{"cas": version},
+		"data":    secretData{Key: key, Value: string(value)},
+	}
+	var response kvResponse
+	status, err := c.do(ctx, http.MethodPost, c.dataPath(key), body, &response)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusBadRequest {
+		message := responseErrors(status, &response)
+		if !strings.Contains(message, "check-and-set") {
+			return fmt.Errorf("vault %s %s: %s", http.MethodPost, c.dataPath(key), message)
+		}
+		c.forget(key)
+		return fmt.Errorf("write %q: %w", key, ErrConflict)
+	}
+	c.remember(key, response.Data.Version)
+	return nil
+}
+
+// Remove deletes every version of the entry, so that it no longer appears in
+// Keys. Vault answers a delete of a missing key with 204, so the metadata is
+// read first and a missing key returns ErrNotFound.
+func (c *Client) Remove(ctx context.Context, key string) error {
+	status, err := c.do(ctx, http.MethodGet, c.metadataPath(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		c.forget(key)
+		return ErrNotFound
+	}
+	if _, err := c.do(ctx, http.MethodDelete, c.metadataPath(key), nil, nil); err != nil {
+		return err
+	}
+	c.forget(key)
+	return nil
+}
+
+func (c *Client) Keys(ctx context.Context) ([]string, error) {
+	var response kvResponse
+	status, err := c.do(ctx, "LIST", c.metadataPath(""), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return []string{}, nil
+	}
+	keys := make([]string, 0, len(response.Data.Keys))
+	for _, name := range response.Data.Keys {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(name)
+		if err != nil {
+			// Not written by actool.
+			continue
+		}
+		keys = append(keys, string(key))
+	}
+	return keys, nil
+}
+
+func (c *Client) currentVersion(ctx context.Context, key string) (int, error) {
+	var response kvResponse
+	status, err := c.do(ctx, http.MethodGet, c.metadataPath(key), nil, &response)
+	if err != nil {
+		return 0, err
+	}
+	if status == http.StatusNotFound {
+		return 0, nil
+	}
+	return response.Data.CurrentVersion, nil
+}
+
+func (c *Client) dataPath(key string) string {
+	return c.options.Mount + "/data/" + c.options.Path + "/" + entryName(key)
+}
+
+func (c *Client) metadataPath(key string) string {
+	if key == "" {
+		return c.options.Mount + "/metadata/" + c.options.Path
+	}
+	return c.options.Mount + "/metadata/" + c.options.Path + "/" + entryName(key)
+}
+
+func entryName(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func (c *Client) version(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	version, ok := c.versions[key]
+	return version, ok
+}
+
+func (c *Client) remember(key string, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[key] = version
+}
+
+func (c *Client) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.versions, key)
+}
+
+// do sends one request and decodes a JSON response into out. 404 and, for
+// writes, 400 are returned as a status for the caller to interpret; other
+// error statuses become errors carrying Vault's messages.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out *kvResponse) (int, error) {
+	if out == nil {
+		out = &kvResponse{}
+	}
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+	status, err := c.send(ctx, method, path, token, body, out)
+	if err != nil {
+		return 0, err
+	}
+	if status == http.StatusNotFound || (status == http.StatusBadRequest && method == http.MethodPost) {
+		return status, nil
+	}
+	if status >= 300 {
+		return 0, fmt.Errorf("vault %s %s: %s", method, path, responseErrors(status, out))
+	}
+	return status, nil
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, token string, body interface{}, out *kvResponse) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := c.address.JoinPath("v1", path)
+	request, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set(tokenHeader, token)
+	}
+	if c.options.Namespace != "" {
+		request.Header.Set(namespaceHeader, c.options.Namespace)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("could not reach Vault: %w", err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, out); err != nil && response.StatusCode < 300 {
+			return 0, fmt.Errorf("vault %s %s returned invalid JSON: %w", method, path, err)
+		}
+	}
+	return response.StatusCode, nil
+}
+
+func responseErrors(status int, response *kvResponse) string {
+	if response != nil && len(response.Errors) > 0 {
+		return strings.Join(response.Errors, "; ")
+	}
+	return fmt.Sprintf("HTTP %d", status)
+}
+
+func timeout(configured time.Duration) time.Duration {
+	if configured > 0 {
+		return configured
+	}
+	if value := strings.TrimSpace(os.Getenv(sts.TimeoutEnv)); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return sts.DefaultTimeout
+}
+
+func parseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package vaultkv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+const (
+	testToken    = "root-token"
+	testRoleID   = "role-id"
+	testSecretID = "secret-id"
+)
+
+type kvEntry struct {
+	versions []map[string]interface{}
+}
+
+// kvStandIn implements the KV v2 and AppRole endpoints actool uses, for a
+// single mount.
+type kvStandIn struct {
+	mu         sync.Mutex
+	mount      string
+	entries    map[string]*kvEntry
+	namespaces []string
+}
+
+func newKVStandIn(t *testing.T) (*kvStandIn, *httptest.Server) {
+	t.Helper()
+	standIn := &kvStandIn{mount: "secret", entries: make(map[string]*kvEntry)}
+	server := httptest.NewServer(http.HandlerFunc(standIn.handle))
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+func (s *kvStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	s.namespaces = append(s.namespaces, r.Header.Get(namespaceHeader))
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if path == "auth/approle/login" {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != testRoleID || body["secret_id"] != testSecretID {
+			writeStatus(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		writeStatus(w, http.StatusOK, map[string]interface{}{"auth": map[string]string{"client_token": testToken}})
+		return
+	}
+	if r.Header.Get(tokenHeader) != testToken {
+		writeStatus(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(path, s.mount+"/data/"):
+		s.handleData(w, r, strings.TrimPrefix(path, s.mount+"/data/"))
+	case strings.HasPrefix(path, s.mount+"/metadata/"):
+		s.handleMetadata(w, r, strings.TrimPrefix(path, s.mount+"/metadata/"))
+	default:
+		writeStatus(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (s *kvStandIn) handleData(w http.ResponseWriter, r *http.Request, name string) {
+	entry := s.entries[name]
+	switch r.Method {
+	case http.MethodGet:
+		if entry == nil {
+			writeStatus(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeStatus(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data":     entry.versions[len(entry.versions)-1],
+			"metadata": map[string]int{"version": len(entry.versions)},
+		}})
+	case http.MethodPost:
+		var body struct {
+			Options map[string]int         `json:"options"`
+			Data    map[string]interface{} `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Data == nil {
+			writeStatus(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"no data provided"}})
+			return
+		}
+		current := 0
+		if entry != nil {
+			current = len(entry.versions)
+		}
+		if cas, ok := body.Options["cas"]; ok && cas != current {
+			writeStatus(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"check-and-set parameter did not match the current version"}})
+			return
+		}
+		if entry == nil {
+			entry = &kvEntry{}
+			s.entries[name] = entry
+		}
+		entry.versions = append(entry.versions, body.Data)
+		writeStatus(w, http.StatusOK, map[string]interface{}{"data": map[string]int{"version": len(entry.versions)}})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *kvStandIn) handleMetadata(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case "LIST":
+		prefix := name + "/"
+		keys := []string{}
+		for entryName := range s.entries {
+			if strings.HasPrefix(entryName, prefix) {
+				keys = append(keys, strings.TrimPrefix(entryName, prefix))
+			}
+		}
+		if len(keys) == 0 {
+			writeStatus(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		sort.Strings(keys)
+		writeStatus(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case http.MethodGet:
+		entry := s.entries[name]
+		if entry == nil {
+			writeStatus(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeStatus(w, http.StatusOK, map[string]interface{}{"data": map[string]int{"current_version": len(entry.versions)}})
+	case http.MethodDelete:
+		delete(s.entries, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status int, value interface{}) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func newTestClient(t *testing.T, options Options) *Client {
+	t.Helper()
+	client, err := NewClient(options)
+	assert.NilError(t, err)
+	return client
+}
+
+func TestClientAgainstKVStandIn(t *testing.T) {
+	standIn, server := newKVStandIn(t)
+	client := newTestClient(t, Options{Address: server.URL, Token: testToken, Namespace: "team-a"})
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+	keys, err := client.Keys(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{})
+
+	value := []byte(`{"AccessKeyID":"AKIAEXAMPLE","SecretAccessKey":"SECRET"}`)
+	assert.NilError(t, client.Set(ctx, "dev", value))
+	assert.NilError(t, client.Set(ctx, "dev", value))
+	assert.NilError(t, client.Set(ctx, "oidc:https://example.awsapps.com/start", []byte("token")))
+
+	got, err := client.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, value)
+
+	stored := standIn.entries["actool/"+entryName("dev")]
+	assert.Equal(t, len(stored.versions), 2)
+	assert.Equal(t, stored.versions[1]["key"], "dev")
+
+	keys, err = client.Keys(ctx)
+	assert.NilError(t, err)
+	sort.Strings(keys)
+	assert.DeepEqual(t, keys, []string{"dev", "oidc:https://example.awsapps.com/start"})
+
+	assert.NilError(t, client.Remove(ctx, "dev"))
+	_, err = client.Get(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+	err = client.Remove(ctx, "dev")
+	assert.Assert(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	for _, namespace := range standIn.namespaces {
+		assert.Equal(t, namespace, "team-a")
+	}
+}
+
+func TestSetUsesCheckAndSet(t *testing.T) {
+	_, server := newKVStandIn(t)
+	ctx := context.Background()
+	first := newTestClient(t, Options{Address: server.URL, Token: testToken})
+	second := newTestClient(t, Options{Address: server.URL, Token: testToken})
+
+	assert.NilError(t, first.Set(ctx, "dev", []byte("v1")))
+	_, err := second.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.NilError(t, first.Set(ctx, "dev", []byte("v2")))
+
+	err = second.Set(ctx, "dev", []byte("stale"))
+	assert.Assert(t, errors.Is(err, ErrConflict), "got %v", err)
+	got, err := first.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), "v2")
+
+	// After a conflict the next write starts from the current version.
+	assert.NilError(t, second.Set(ctx, "dev", []byte("v3")))
+	got, err = first.Get(ctx, "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), "v3")
+}
+
+func TestClientErrors(t *testing.T) {
+	_, server := newKVStandIn(t)
+	ctx := context.Background()
+
+	client := newTestClient(t, Options{Address: server.URL, Token: "wrong"})
+	_, err := client.Get(ctx, "dev")
+	assert.ErrorContains(t, err, "permission denied")
+
+	client = newTestClient(t, Options{Address: server.URL, Token: testToken})
+	assert.ErrorContains(t, client.Set(ctx, "dev", []byte{0xff}), "not valid UTF-8")
+
+	client = newTestClient(t, Options{Address: "http://127.0.0.1:1", Token: testToken})
+	_, err = client.Get(ctx, "dev")
+	assert.ErrorContains(t, err, "could not reach Vault")
+}
+
+func TestNewClientValidatesOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{
+		{name: "missing address", options: Options{}, wantErr: "VAULT_ADDR is required"},
+		{name: "invalid address", options: Options{Address: "vault.example"}, wantErr: "invalid VAULT_ADDR"},
+		{name: "role without secret", options: Options{Address: "https://vault.example", RoleID: testRoleID}, wantErr: "needs both"},
+		{name: "client cert without key", options: Options{Address: "https://vault.example", TLS: TLSOptions{ClientCert: "cert.pem"}}, wantErr: "needs both"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewClient(tc.options)
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+
+	client, err := NewClient(Options{Address: "https://vault.example/", Mount: "/kv/", Path: "/teams/aws/"})
+	assert.NilError(t, err)
+	assert.Equal(t, client.dataPath("dev"), "kv/data/teams/aws/"+entryName("dev"))
+	assert.Equal(t, client.metadataPath(""), "kv/metadata/teams/aws")
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(AddressEnv, "https://vault.example:8200")
+	t.Setenv(TokenEnv, "token")
+	t.Setenv(NamespaceEnv, "admin/team")
+	t.Setenv(MountEnv, "kv")
+	t.Setenv(PathEnv, "aws")
+	t.Setenv(RoleIDEnv, "")
+	t.Setenv(SecretIDEnv, "")
+	t.Setenv(AppRoleMountEnv, "")
+	t.Setenv(TokenFileEnv, "/tmp/token")
+	t.Setenv(CACertEnv, "/tmp/ca.pem")
+	t.Setenv(CAPathEnv, "")
+	t.Setenv(ClientCertEnv, "")
+	t.Setenv(ClientKeyEnv, "")
+	t.Setenv(TLSServerNameEnv, "vault.internal")
+	t.Setenv(SkipVerifyEnv, "true")
+
+	assert.DeepEqual(t, OptionsFromEnv(), Options{
+		Address:   "https://vault.example:8200",
+		Namespace: "admin/team",
+		Mount:     "kv",
+		Path:      "aws",
+		Token:     "token",
+		TokenFile: "/tmp/token",
+		TLS:       TLSOptions{CACert: "/tmp/ca.pem", ServerName: "vault.internal", Insecure: true},
+	})
+}
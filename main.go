@@ -1,28 +1,122 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/tomtwinkle/aws-credential-tool/io/mfa"
+	"github.com/tomtwinkle/aws-credential-tool/io/org"
 	"github.com/tomtwinkle/aws-credential-tool/io/profile"
+	"github.com/tomtwinkle/aws-credential-tool/io/secretexec"
+	"github.com/tomtwinkle/aws-credential-tool/io/shellinit"
 	"github.com/tomtwinkle/aws-credential-tool/ui"
 )
 
 var version = "unknown"
 var revision = "unknown"
 
+// dryRunPlan is set while a command runs with --dry-run.
+var dryRunPlan *profile.Plan
+
 func main() {
-	if err := run(os.Args[1:]); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:])
+	stop()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "canceled")
+			os.Exit(130)
+		}
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 }
 
-func run(args []string) error {
+func run(ctx context.Context, args []string) error {
+	args, dryRun := extractDryRun(args)
+	if !dryRun {
+		return runCommand(ctx, args)
+	}
+
+	dryRunPlan = profile.NewPlan()
+	defer func() { dryRunPlan = nil }()
+	if err := runCommand(ctx, args); err != nil {
+		return err
+	}
+	// credential-process owns stdout for its JSON payload.
+	out := io.Writer(os.Stdout)
 	if len(args) > 0 && args[0] == "credential-process" {
-		return runCredentialProcess(args[1:])
+		out = os.Stderr
+	}
+	_, err := dryRunPlan.WriteTo(out)
+	return err
+}
+
+// extractDryRun removes the global --dry-run flag, which may appear before
+// or after the command.
+func extractDryRun(args []string) ([]string, bool) {
+	dryRun := false
+	rest := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if arg == "--dry-run" || arg == "-dry-run" {
+			dryRun = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, dryRun
+}
+
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "credential-process":
+			return runCredentialProcess(ctx, args[1:])
+		case "mfa":
+			return runMFA(args[1:])
+		case "sso":
+			return runSSO(ctx, args[1:])
+		case "secret-adapter":
+			return runSecretAdapter(ctx, args[1:])
+		case "profile":
+			return runProfile(args[1:])
+		case "history":
+			return runHistory(args[1:])
+		case "undo":
+			return runUndo(args[1:])
+		case "use":
+			return runUse(args[1:])
+		case "config":
+			return runConfig(args[1:])
+		case "uninstall":
+			return runUninstall(args[1:])
+		case "shell-init":
+			return runShellInit(args[1:])
+		case "dir":
+			return runDir(args[1:])
+		case "import":
+			return runImport(args[1:])
+		case "migrate":
+			return runMigrate(args[1:])
+		case "org":
+			return runOrg(ctx, args[1:])
+		}
 	}
 
 	flags := flag.NewFlagSet("actool", flag.ContinueOnError)
@@ -31,6 +125,11 @@ func run(args []string) error {
 	showVersion := false
 	flags.BoolVar(&showVersion, "v", false, "show application version")
 	flags.BoolVar(&showVersion, "version", false, "show application version")
+	timeout := time.Duration(0)
+	flags.DurationVar(&timeout, "timeout", 0, "timeout for each STS request (default 30s or ACTOOL_TIMEOUT)")
+	filter := profile.ProfileFilter{}
+	flags.Var((*tagList)(&filter.Tags), "tag", "only offer profiles with this tag (repeatable)")
+	flags.BoolVar(&filter.Favorites, "favorites", false, "only offer favourite profiles")
 
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -44,15 +143,27 @@ func run(args []string) error {
 		return fmt.Errorf("unknown command: %s", flags.Arg(0))
 	}
 
-	u, err := ui.NewUI()
+	if timeout < 0 {
+		return errors.New("--timeout must not be negative")
+	}
+
+	// The shell-init wrapper passes a file to receive the selection.
+	selectionFile := os.Getenv(shellinit.SelectionFileEnv)
+	u, err := ui.NewUI(ui.Options{Timeout: timeout, Filter: filter, DryRun: dryRunPlan, Shell: selectionFile != ""})
 	if err != nil {
 		return err
 	}
 
-	return u.Run()
+	if err := u.Run(ctx); err != nil {
+		return err
+	}
+	if selectionFile == "" || dryRunPlan != nil || u.SelectedProfile() == "" {
+		return nil
+	}
+	return os.WriteFile(selectionFile, []byte(u.SelectedProfile()), 0o600)
 }
 
-func runCredentialProcess(args []string) error {
+func runCredentialProcess(ctx context.Context, args []string) error {
 	flags := flag.NewFlagSet("credential-process", flag.ContinueOnError)
 	flags.SetOutput(io.Discard)
 
@@ -66,12 +177,12 @@ func runCredentialProcess(args []string) error {
 		return fmt.Errorf("unexpected arguments: %v", flags.Args())
 	}
 
-	p, err := profile.NewProfile()
+	p, err := openProfile()
 	if err != nil {
 		return err
 	}
 
-	payload, err := p.CredentialProcessPayload(profileName)
+	payload, err := p.CredentialProcessPayload(ctx, profileName)
 	if err != nil {
 		return err
 	}
@@ -79,3 +190,869 @@ func runCredentialProcess(args []string) error {
 	_, err = os.Stdout.Write(payload)
 	return err
 }
+
+func runMFA(args []string) error {
+	if len(args) == 0 {
+		return errors.New("mfa requires a subcommand: set-seed or remove-seed")
+	}
+	subcommand := args[0]
+	if subcommand != "set-seed" && subcommand != "remove-seed" {
+		return fmt.Errorf("unknown mfa subcommand: %s", subcommand)
+	}
+
+	flags := flag.NewFlagSet("mfa "+subcommand, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	profileName := ""
+	flags.StringVar(&profileName, "profile", "", "AWS profile name")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if profileName == "" {
+		return errors.New("--profile is required")
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+
+	if subcommand == "remove-seed" {
+		if err := p.RemoveMFASeed(profileName); err != nil {
+			return err
+		}
+		fmt.Printf("removed MFA seed for profile [%q]\n", profileName)
+		return nil
+	}
+
+	seed, err := ui.PromptMFASeed(profileName)
+	if err != nil {
+		return err
+	}
+	if err := p.SetMFASeed(profileName, seed); err != nil {
+		return err
+	}
+	fmt.Printf("stored MFA seed for profile [%q]; set %s = %s in its AWS config section to use it\n", profileName, profile.MFAProvider, mfa.ProviderTOTP)
+	return nil
+}
+
+func runSSO(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("sso requires a subcommand: login")
+	}
+	if args[0] != "login" {
+		return fmt.Errorf("unknown sso subcommand: %s", args[0])
+	}
+
+	flags := flag.NewFlagSet("sso login", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	profileName := ""
+	flags.StringVar(&profileName, "profile", "", "AWS profile name")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if profileName == "" {
+		return errors.New("--profile is required")
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	if err := p.SSOLogin(ctx, profileName, ui.PrintSSODeviceAuthorization); err != nil {
+		return err
+	}
+	fmt.Printf("logged in to IAM Identity Center for profile [%q]\n", profileName)
+	return nil
+}
+
+// runSecretAdapter answers one AWS_VAULT_BACKEND=exec request from stdin with
+// a password-manager CLI.
+func runSecretAdapter(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("secret-adapter requires an adapter: %s or %s", secretexec.AdapterOnePassword, secretexec.AdapterBitwarden)
+	}
+
+	flags := flag.NewFlagSet("secret-adapter "+args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	options := secretexec.AdapterOptions{}
+	flags.StringVar(&options.Program, "program", "", "path to the password-manager CLI")
+	flags.StringVar(&options.Vault, "vault", "", "1Password vault")
+	flags.StringVar(&options.Tag, "tag", "", "1Password tag marking actool items")
+	flags.StringVar(&options.Folder, "folder", "", "Bitwarden folder")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	backend, err := secretexec.NewAdapter(args[0], options)
+	if err != nil {
+		return err
+	}
+	return secretexec.Serve(ctx, os.Stdin, os.Stdout, backend)
+}
+
+// tagList collects a repeatable or comma-separated --tag flag.
+type tagList []string
+
+func (t *tagList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagList) Set(value string) error {
+	*t = append(*t, strings.Split(value, ",")...)
+	return nil
+}
+
+func runProfile(args []string) error {
+	if len(args) == 0 {
+		return errors.New("profile requires a subcommand: list or set")
+	}
+	switch args[0] {
+	case "list":
+		return runProfileList(args[1:])
+	case "set":
+		return runProfileSet(args[1:])
+	}
+	return fmt.Errorf("unknown profile subcommand: %s", args[0])
+}
+
+func runProfileList(args []string) error {
+	flags := flag.NewFlagSet("profile list", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	filter := profile.ProfileFilter{}
+	flags.Var((*tagList)(&filter.Tags), "tag", "only list profiles with this tag (repeatable)")
+	flags.BoolVar(&filter.Favorites, "favorites", false, "only list favourite profiles")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	summaries, err := p.Profiles(filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tPROFILE\tTAGS\tLAST USED\tDESCRIPTION")
+	for _, summary := range summaries {
+		marker := " "
+		if summary.Selected {
+			marker = "*"
+		}
+		if summary.Metadata.Favorite {
+			marker += "★"
+		}
+		name := summary.Name
+		if summary.SSO {
+			name += " (sso)"
+		}
+		lastUsed := "-"
+		if summary.Metadata.LastUsed != nil {
+			lastUsed = summary.Metadata.LastUsed.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", marker, name, strings.Join(summary.Metadata.Tags, ","), lastUsed, summary.Metadata.Description)
+	}
+	return w.Flush()
+}
+
+func runProfileSet(args []string) error {
+	flags := flag.NewFlagSet("profile set", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	profileName := ""
+	flags.StringVar(&profileName, "profile", "", "AWS profile name")
+	description := ""
+	flags.StringVar(&description, "description", "", "one-line description")
+	tags := tagList{}
+	flags.Var(&tags, "tag", "tag to set; replaces the existing tags (repeatable, empty clears)")
+	color := ""
+	flags.StringVar(&color, "color", "", "colour in the profile list: "+strings.Join(profile.ProfileColors, ", ")+", or empty")
+	favorite := false
+	flags.BoolVar(&favorite, "favorite", false, "mark the profile as a favourite; --favorite=false clears it")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if profileName == "" {
+		return errors.New("--profile is required")
+	}
+	changed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { changed[f.Name] = true })
+	if !changed["description"] && !changed["tag"] && !changed["color"] && !changed["favorite"] {
+		return errors.New("nothing to set: use --description, --tag, --color or --favorite")
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	err = p.UpdateProfileMetadata(profileName, func(metadata *profile.ProfileMetadata) {
+		if changed["description"] {
+			metadata.Description = description
+		}
+		if changed["tag"] {
+			metadata.Tags = tags
+		}
+		if changed["color"] {
+			metadata.Color = color
+		}
+		if changed["favorite"] {
+			metadata.Favorite = favorite
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("updated profile [%q]\n", profileName)
+	return nil
+}
+
+func runHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	limit := 20
+	flags.IntVar(&limit, "limit", 20, "number of entries to show; 0 shows all")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if limit < 0 {
+		return errors.New("--limit must not be negative")
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	entries, err := p.History()
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tFROM\tTO\tTTY")
+	for _, entry := range entries {
+		from := entry.From
+		if from == "" {
+			from = "-"
+		}
+		tty := entry.TTY
+		if tty == "" {
+			tty = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format(time.DateTime), entry.Action, from, entry.To, tty)
+	}
+	return w.Flush()
+}
+
+func runUndo(args []string) error {
+	flags := flag.NewFlagSet("undo", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	confirmed := ""
+	flags.StringVar(&confirmed, "confirm-production", "", "name of the production profile being restored, confirming it without a prompt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	entry, err := p.UndoWithOptions(profile.SelectOptions{ConfirmProduction: productionConfirm(confirmed)})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored profile [%q] (was [%q])\n", entry.To, entry.From)
+	return nil
+}
+
+func runUse(args []string) error {
+	flags := flag.NewFlagSet("use", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	options := profile.SelectOptions{}
+	flags.DurationVar(&options.Lease, "for", 0, "select the profile for this long, then fall back to the safe profile")
+	flags.StringVar(&options.Fallback, "fallback", "", "safe profile for [default] once the lease ends (default: "+profile.SafeProfileEnv+")")
+	confirmed := ""
+	flags.StringVar(&confirmed, "confirm-production", "", "name of the production profile being selected, confirming it without a prompt")
+
+	// The profile may come before or after the flags.
+	profileName := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		profileName, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if profileName == "" && flags.NArg() > 0 {
+		profileName = flags.Arg(0)
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if strings.TrimSpace(profileName) == "" {
+		return errors.New("use requires a profile name")
+	}
+	options.ConfirmProduction = productionConfirm(confirmed)
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	if err := p.SetSelectedWithOptions(profileName, options); err != nil {
+		return err
+	}
+	if options.Lease == 0 {
+		fmt.Printf("selected profile [%q]\n", profileName)
+		return nil
+	}
+	fallback := options.Fallback
+	if fallback == "" {
+		fallback = strings.TrimSpace(os.Getenv(profile.SafeProfileEnv))
+	}
+	until := time.Now().Add(options.Lease).Format(time.DateTime)
+	if fallback == "" {
+		fmt.Printf("selected profile [%q] until %s; [default] fails after that\n", profileName, until)
+		return nil
+	}
+	fmt.Printf("selected profile [%q] until %s; [default] falls back to [%q] after that\n", profileName, until, fallback)
+	return nil
+}
+
+// productionConfirm confirms a production profile with the
+// --confirm-production value, or asks for its name on the terminal.
+func productionConfirm(confirmed string) profile.ProductionConfirmPrompt {
+	return func(profileName string) (string, error) {
+		if confirmed != "" {
+			return confirmed, nil
+		}
+		return ui.PromptProductionConfirm(profileName)
+	}
+}
+
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return errors.New("config requires a subcommand: restore")
+	}
+	switch args[0] {
+	case "restore":
+		return runConfigRestore(args[1:])
+	}
+	return fmt.Errorf("unknown config subcommand: %s", args[0])
+}
+
+func runConfigRestore(args []string) error {
+	flags := flag.NewFlagSet("config restore", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	list := false
+	flags.BoolVar(&list, "list", false, "list the backups of the AWS config")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case list && flags.NArg() > 0:
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	case !list && flags.NArg() == 0:
+		return errors.New("config restore requires --list or a backup timestamp")
+	case !list && flags.NArg() > 1:
+		return fmt.Errorf("unexpected arguments: %v", flags.Args()[1:])
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	if list {
+		backups, err := p.ConfigBackups()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIMESTAMP\tTIME\tSIZE")
+		for _, backup := range backups {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", backup.Timestamp, backup.Time.Local().Format(time.DateTime), backup.Size)
+		}
+		return w.Flush()
+	}
+
+	backup, err := p.RestoreConfigBackup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored AWS config from backup [%q]\n", backup.Timestamp)
+	return nil
+}
+
+func runUninstall(args []string) error {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	options := profile.UninstallOptions{}
+	flags.StringVar(&options.ExportCredentialsPath, "export-credentials", "", "write the stored credentials to this shared credentials file")
+	flags.BoolVar(&options.RemoveState, "remove-state", false, "also delete state.json, the history, the keyring index and the directory trust store")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	summary, err := p.Uninstall(options)
+	if err != nil {
+		return err
+	}
+	for _, key := range summary.RemovedKeys {
+		fmt.Printf("removed %s\n", key)
+	}
+	for _, section := range summary.RemovedSections {
+		fmt.Printf("removed empty section [%s]\n", section)
+	}
+	if summary.ExportPath != "" {
+		fmt.Printf("exported %d profiles to [%q]: %s\n", len(summary.ExportedProfiles), summary.ExportPath, strings.Join(summary.ExportedProfiles, ", "))
+	}
+	for _, path := range summary.RemovedFiles {
+		fmt.Printf("deleted [%q]\n", path)
+	}
+	if len(summary.RemovedKeys) == 0 && len(summary.RemovedFiles) == 0 {
+		fmt.Println("nothing to uninstall")
+	}
+	for _, path := range summary.KeptFiles {
+		fmt.Printf("kept [%q]; run actool uninstall --remove-state to delete it\n", path)
+	}
+	fmt.Println("keyring items were left in place; remove them with aws-vault if they are no longer needed")
+	return nil
+}
+
+func runShellInit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("shell-init requires one shell: %s", strings.Join(shellinit.Shells, ", "))
+	}
+	command, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if absolute, err := filepath.Abs(command); err == nil {
+		command = absolute
+	}
+	script, err := shellinit.Script(args[0], command)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(os.Stdout, script)
+	return err
+}
+
+func runDir(args []string) error {
+	if len(args) == 0 {
+		return errors.New("dir requires a subcommand: trust, untrust, status or current")
+	}
+	subcommand := args[0]
+	switch subcommand {
+	case "trust", "untrust", "status", "current":
+	default:
+		return fmt.Errorf("unknown dir subcommand: %s", subcommand)
+	}
+	if len(args) > 2 || subcommand == "current" && len(args) > 1 {
+		return fmt.Errorf("unexpected arguments: %v", args[1:])
+	}
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	trust := profile.NewDirectoryTrust(dryRunPlan)
+	switch subcommand {
+	case "trust":
+		found, err := trust.Trust(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("trusted [%q] for profile [%q]\n", found.Path, found.Profile)
+	case "untrust":
+		found, err := trust.Untrust(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("untrusted [%q]\n", found.Path)
+	case "status":
+		found, err := trust.Lookup(dir)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			fmt.Printf("no %s or %s found\n", profile.DirectoryProfileFile, profile.AWSProfileFile)
+			return nil
+		}
+		status := "trusted"
+		if !found.Trusted {
+			status = "not trusted"
+		}
+		fmt.Printf("[%q] selects profile [%q] (%s)\n", found.Path, found.Profile, status)
+	case "current":
+		// Used by the shell-init cd hook: prints the profile of a trusted
+		// file and nothing otherwise.
+		found, err := trust.Lookup(dir)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return nil
+		}
+		if !found.Trusted {
+			fmt.Fprintf(os.Stderr, "actool: %s is not trusted; review it and run actool dir trust %s\n", found.Path, filepath.Dir(found.Path))
+			return nil
+		}
+		fmt.Println(found.Profile)
+	}
+	return nil
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	format := ""
+	flags.StringVar(&format, "from", "", "source format: "+strings.Join(profile.ImportFormats, ", "))
+	profileName := ""
+	flags.StringVar(&profileName, "profile", "", "profile name for sources that do not carry one")
+	overwrite := false
+	flags.BoolVar(&overwrite, "overwrite", false, "replace stored credentials that differ from the source")
+	yes := false
+	flags.BoolVar(&yes, "yes", false, "import without confirmation")
+	shred := false
+	flags.BoolVar(&shred, "shred", false, "shred the source file after importing it")
+	keepSource := false
+	flags.BoolVar(&keepSource, "keep-source", false, "keep the source file without asking")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if format == "" {
+		return fmt.Errorf("import requires --from: %s", strings.Join(profile.ImportFormats, ", "))
+	}
+	if flags.NArg() != 1 {
+		return errors.New("import requires one source path")
+	}
+	if shred && keepSource {
+		return errors.New("--shred and --keep-source cannot be combined")
+	}
+	if shred && format == profile.ImportFromAWSVaultFile {
+		return errors.New("--shred only applies to file sources; remove the aws-vault keyring directory with aws-vault")
+	}
+	source := flags.Arg(0)
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	plan, err := p.PlanImport(format, source, profileName)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tACCESS KEY\tSTATUS")
+	for _, entry := range plan.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Profile, maskAccessKey(entry.AccessKeyID), entry.Status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if plan.Changes() > 0 {
+		if !yes && dryRunPlan == nil {
+			confirmed, err := ui.ConfirmImport(plan.Changes())
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("import cancelled")
+				return nil
+			}
+		}
+		if err := p.ApplyImport(plan, overwrite); err != nil {
+			return err
+		}
+		fmt.Printf("imported %d credentials from [%q]\n", plan.Changes(), source)
+	} else {
+		fmt.Println("all credentials are already stored")
+	}
+
+	if format == profile.ImportFromAWSVaultFile || keepSource {
+		return nil
+	}
+	if !shred && dryRunPlan == nil {
+		if shred, err = ui.PromptShredImportSource(source); err != nil {
+			return err
+		}
+	}
+	if !shred {
+		return nil
+	}
+	if err := p.ShredImportSource(source); err != nil {
+		return err
+	}
+	fmt.Printf("shredded [%q]\n", source)
+	return nil
+}
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	var keep, overwrite, rename stringList
+	flags.Var(&keep, "keep", "keep the stored credential of this conflicting profile (repeatable)")
+	flags.Var(&overwrite, "overwrite", "overwrite the stored credential of this conflicting profile (repeatable)")
+	flags.Var(&rename, "rename", "import a conflicting profile under a new name, as OLD=NEW (repeatable)")
+	options := profile.MigrationOptions{Resolutions: make(map[string]profile.MigrationResolution)}
+	flags.BoolVar(&options.DeleteCredentialsFile, "delete-credentials-file", false, "delete the credentials file instead of keeping a .actool-backup copy")
+	yes := false
+	flags.BoolVar(&yes, "yes", false, "migrate without confirmation")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	resolve := func(name string, resolution profile.MigrationResolution) error {
+		if _, ok := options.Resolutions[name]; ok {
+			return fmt.Errorf("profile %q is resolved more than once", name)
+		}
+		options.Resolutions[name] = resolution
+		return nil
+	}
+	for _, name := range keep {
+		if err := resolve(name, profile.MigrationResolution{Strategy: profile.MigrateKeep}); err != nil {
+			return err
+		}
+	}
+	for _, name := range overwrite {
+		if err := resolve(name, profile.MigrationResolution{Strategy: profile.MigrateOverwrite}); err != nil {
+			return err
+		}
+	}
+	for _, value := range rename {
+		from, to, ok := strings.Cut(value, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("--rename expects OLD=NEW: %q", value)
+		}
+		if err := resolve(from, profile.MigrationResolution{Strategy: profile.MigrateRename, NewName: to}); err != nil {
+			return err
+		}
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	plan, err := p.PlanMigration()
+	if err != nil {
+		return err
+	}
+	if plan.Empty() {
+		fmt.Println("nothing to migrate")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tPROFILE\tACCESS KEY\tSTATUS")
+	for _, entry := range plan.Entries {
+		status := entry.Status
+		if entry.Status == profile.MigrationConflict {
+			status = fmt.Sprintf("%s with %s", entry.Status, maskAccessKey(entry.ExistingAccessKeyID))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Source, entry.Profile, maskAccessKey(entry.AccessKeyID), status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if plan.CredentialsFile != "" {
+		action := "moved to a .actool-backup file"
+		if options.DeleteCredentialsFile {
+			action = "deleted"
+		}
+		fmt.Printf("[%q] will be %s\n", plan.CredentialsFile, action)
+	}
+
+	for _, entry := range plan.Entries {
+		if entry.Status != profile.MigrationConflict {
+			continue
+		}
+		if _, ok := options.Resolutions[entry.Profile]; ok {
+			continue
+		}
+		resolution, err := ui.PromptMigrationConflict(entry)
+		if err != nil {
+			return err
+		}
+		options.Resolutions[entry.Profile] = resolution
+	}
+	if !yes && dryRunPlan == nil {
+		confirmed, err := ui.ConfirmMigration()
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Println("migration cancelled")
+			return nil
+		}
+	}
+
+	result, err := p.ApplyMigration(plan, options)
+	if err != nil {
+		return err
+	}
+	renamed := make(map[string]bool, len(result.Renamed))
+	for _, to := range result.Renamed {
+		renamed[to] = true
+	}
+	for _, name := range result.Stored {
+		if !renamed[name] {
+			fmt.Printf("stored profile [%q]\n", name)
+		}
+	}
+	for _, name := range result.Kept {
+		fmt.Printf("kept existing profile [%q]\n", name)
+	}
+	for _, from := range slices.Sorted(maps.Keys(result.Renamed)) {
+		fmt.Printf("imported profile [%q] as [%q]\n", from, result.Renamed[from])
+	}
+	if result.CredentialsFileBackup != "" {
+		fmt.Printf("moved [%q] to [%q]; delete the backup once the migration is verified\n", plan.CredentialsFile, result.CredentialsFileBackup)
+	}
+	if result.CredentialsFileDeleted {
+		fmt.Printf("deleted [%q]\n", plan.CredentialsFile)
+	}
+	return nil
+}
+
+func runOrg(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("org requires a subcommand: sync")
+	}
+	if args[0] != "sync" {
+		return fmt.Errorf("unknown org subcommand: %s", args[0])
+	}
+	flags := flag.NewFlagSet("org sync", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	options := profile.OrgSyncOptions{}
+	flags.StringVar(&options.SourceProfile, "source-profile", "", "profile that lists the accounts and becomes their source_profile")
+	flags.StringVar(&options.RoleName, "role-name", "", "role to assume in each account")
+	flags.StringVar(&options.NameTemplate, "name-template", profile.DefaultOrgNameTemplate, "profile name template; sees .Name, .ID, .Email and .RoleName")
+	flags.StringVar(&options.Region, "region", "", "region of the generated profiles (default: the source profile's region)")
+	flags.StringVar(&options.Endpoint, "endpoint", "", "Organizations endpoint URL (default: "+org.EndpointEnv+" or the AWS endpoint)")
+	flags.BoolVar(&options.Prune, "prune", false, "remove generated profiles whose account is gone")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if options.SourceProfile == "" || options.RoleName == "" {
+		return errors.New("org sync requires --source-profile and --role-name")
+	}
+
+	p, err := openProfile()
+	if err != nil {
+		return err
+	}
+	result, err := p.SyncOrganization(ctx, options)
+	if err != nil {
+		return err
+	}
+	for _, name := range result.Created {
+		fmt.Printf("created profile [%q]\n", name)
+	}
+	for _, from := range slices.Sorted(maps.Keys(result.Renamed)) {
+		fmt.Printf("renamed profile [%q] to [%q]\n", from, result.Renamed[from])
+	}
+	for _, name := range result.Updated {
+		fmt.Printf("updated profile [%q]\n", name)
+	}
+	for _, name := range result.Pruned {
+		fmt.Printf("pruned profile [%q]\n", name)
+	}
+	for _, name := range result.Stale {
+		fmt.Printf("profile [%q] belongs to no active account; pass --prune to remove it\n", name)
+	}
+	if len(result.Unchanged) > 0 {
+		fmt.Printf("%d profiles unchanged\n", len(result.Unchanged))
+	}
+	return nil
+}
+
+// stringList collects a repeatable flag without splitting its values, as
+// profile names may contain commas.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// maskAccessKey keeps the first and last four characters of an access key
+// ID, enough to tell keys apart in a preview.
+func maskAccessKey(accessKey string) string {
+	if len(accessKey) <= 8 {
+		return strings.Repeat("*", len(accessKey))
+	}
+	return accessKey[:4] + strings.Repeat("*", len(accessKey)-8) + accessKey[len(accessKey)-4:]
+}
+
+// openProfile opens the runtime profile, or a dry-run view of it under
+// --dry-run.
+func openProfile() (profile.Profile, error) {
+	if dryRunPlan != nil {
+		return profile.NewDryRunProfile(nil, dryRunPlan)
+	}
+	return profile.NewProfile()
+}
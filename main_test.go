@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"gotest.tools/v3/assert"
 
 	"github.com/tomtwinkle/aws-credential-tool/io/profile"
+	"github.com/tomtwinkle/aws-credential-tool/io/sso"
 )
 
 func configureIsolatedRuntime(t *testing.T) {
@@ -69,7 +75,7 @@ func TestRunVersionFlags(t *testing.T) {
 	for _, flag := range []string{"-v", "--version"} {
 		t.Run(flag, func(t *testing.T) {
 			output, runErr := captureStdout(t, func() error {
-				return run([]string{flag})
+				return run(context.Background(), []string{flag})
 			})
 			assert.NilError(t, runErr)
 			assert.Assert(t, strings.Contains(string(output), "aws-credential-tool version"))
@@ -80,7 +86,7 @@ func TestRunVersionFlags(t *testing.T) {
 func TestRunWithoutArgumentsReturnsUIInitializationError(t *testing.T) {
 	configureIsolatedRuntime(t)
 
-	assert.ErrorContains(t, run(nil), "Profile not defined.")
+	assert.ErrorContains(t, run(context.Background(), nil), "Profile not defined.")
 }
 
 func TestRunArgumentValidation(t *testing.T) {
@@ -91,11 +97,13 @@ func TestRunArgumentValidation(t *testing.T) {
 	}{
 		{name: "unknown command", args: []string{"unknown"}, want: "unknown command: unknown"},
 		{name: "unknown flag", args: []string{"--unknown"}, want: "flag provided but not defined"},
+		{name: "invalid timeout", args: []string{"--timeout", "soon"}, want: "invalid value"},
+		{name: "negative timeout", args: []string{"--timeout", "-1s"}, want: "must not be negative"},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			assert.ErrorContains(t, run(tc.args), tc.want)
+			assert.ErrorContains(t, run(context.Background(), tc.args), tc.want)
 		})
 	}
 }
@@ -113,7 +121,7 @@ func TestRunCredentialProcessArgumentValidation(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			assert.ErrorContains(t, runCredentialProcess(tc.args), tc.want)
+			assert.ErrorContains(t, runCredentialProcess(context.Background(), tc.args), tc.want)
 		})
 	}
 }
@@ -135,7 +143,7 @@ func TestRunCredentialProcessSuccess(t *testing.T) {
 			initializeRuntimeProfile(t)
 
 			output, err := captureStdout(t, func() error {
-				return runCredentialProcess(tc.args)
+				return runCredentialProcess(context.Background(), tc.args)
 			})
 			assert.NilError(t, err)
 
@@ -173,7 +181,7 @@ func TestRunCredentialProcessErrors(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.setup(t)
-			assert.ErrorContains(t, runCredentialProcess(tc.args), tc.wantErr)
+			assert.ErrorContains(t, runCredentialProcess(context.Background(), tc.args), tc.wantErr)
 		})
 	}
 }
@@ -189,8 +197,576 @@ func TestRunCredentialProcessReturnsStdoutWriteError(t *testing.T) {
 	assert.NilError(t, err)
 	originalStdout := os.Stdout
 	os.Stdout = readOnlyStdout
-	runErr := runCredentialProcess([]string{"--profile", "dev"})
+	runErr := runCredentialProcess(context.Background(), []string{"--profile", "dev"})
 	os.Stdout = originalStdout
 	assert.NilError(t, readOnlyStdout.Close())
 	assert.Assert(t, runErr != nil)
 }
+
+func TestRunMFAArgumentValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "missing subcommand", args: nil, want: "requires a subcommand"},
+		{name: "unknown subcommand", args: []string{"unknown"}, want: "unknown mfa subcommand"},
+		{name: "missing profile", args: []string{"set-seed"}, want: "--profile is required"},
+		{name: "unexpected positional argument", args: []string{"set-seed", "--profile", "dev", "SEED"}, want: "unexpected arguments"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorContains(t, runMFA(tc.args), tc.want)
+		})
+	}
+}
+
+func TestRunMFASetSeedReadsStdin(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	assert.NilError(t, os.WriteFile(path, []byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n"), 0o600))
+	stdin, err := os.Open(path)
+	assert.NilError(t, err)
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() {
+		os.Stdin = originalStdin
+		_ = stdin.Close()
+	})
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"mfa", "set-seed", "--profile", "dev"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "stored MFA seed"))
+
+	p, err := profile.NewProfile()
+	assert.NilError(t, err)
+	code, err := p.MFAToken(context.Background(), "dev")
+	assert.NilError(t, err)
+	assert.Equal(t, len(code), 6)
+
+	_, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"mfa", "remove-seed", "--profile", "dev"})
+	})
+	assert.NilError(t, err)
+	_, err = p.MFAToken(context.Background(), "dev")
+	assert.ErrorContains(t, err, "no MFA seed")
+}
+
+func TestRunSSOArgumentValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "missing subcommand", args: nil, want: "requires a subcommand"},
+		{name: "unknown subcommand", args: []string{"logout"}, want: "unknown sso subcommand"},
+		{name: "missing profile", args: []string{"login"}, want: "--profile is required"},
+		{name: "unexpected positional argument", args: []string{"login", "--profile", "dev", "extra"}, want: "unexpected arguments"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorContains(t, runSSO(context.Background(), tc.args), tc.want)
+		})
+	}
+}
+
+func TestRunSSOLoginAndCredentialProcessAgainstStandIn(t *testing.T) {
+	configureIsolatedRuntime(t)
+	t.Setenv("AWS_CA_BUNDLE", "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/client/register":
+			_, _ = io.WriteString(w, `{"clientId":"client","clientSecret":"secret","clientSecretExpiresAt":1893456000}`)
+		case "/device_authorization":
+			_, _ = io.WriteString(w, `{"deviceCode":"device","userCode":"ABCD-EFGH","verificationUri":"https://device.example/","expiresIn":600,"interval":1}`)
+		case "/token":
+			_, _ = io.WriteString(w, `{"accessToken":"access-token","refreshToken":"refresh-token","tokenType":"Bearer","expiresIn":3600}`)
+		case "/federation/credentials":
+			if r.Header.Get("X-Amz-Sso_bearer_token") != "access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = io.WriteString(w, `{"roleCredentials":{"accessKeyId":"ROLEACCESSKEY","secretAccessKey":"ROLESECRETKEY","sessionToken":"ROLESESSIONTOKEN","expiration":1893456000000}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv(sso.OIDCEndpointEnv, server.URL)
+	t.Setenv(sso.PortalEndpointEnv, server.URL)
+
+	configPath := os.Getenv("AWS_CONFIG_FILE")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(configPath), 0o700))
+	assert.NilError(t, os.WriteFile(configPath, []byte(`[profile sso-dev]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = ReadOnly
+
+[sso-session corp]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+`), 0o600))
+
+	_, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"credential-process", "--profile", "sso-dev"})
+	})
+	assert.ErrorContains(t, err, "actool sso login --profile sso-dev")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"sso", "login", "--profile", "sso-dev"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "logged in"))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"credential-process", "--profile", "sso-dev"})
+	})
+	assert.NilError(t, err)
+	var payload map[string]interface{}
+	assert.NilError(t, json.Unmarshal(output, &payload))
+	assert.Equal(t, payload["AccessKeyId"], "ROLEACCESSKEY")
+	assert.Equal(t, payload["SessionToken"], "ROLESESSIONTOKEN")
+	assert.Equal(t, payload["Expiration"], "2030-01-01T00:00:00Z")
+}
+
+func TestRunSecretAdapterArgumentValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "missing adapter", args: nil, want: "requires an adapter"},
+		{name: "unknown adapter", args: []string{"lastpass"}, want: "unknown secret adapter"},
+		{name: "unexpected positional argument", args: []string{"op", "extra"}, want: "unexpected arguments"},
+		{name: "unknown flag", args: []string{"bw", "--unknown"}, want: "flag provided but not defined"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorContains(t, runSecretAdapter(context.Background(), tc.args), tc.want)
+		})
+	}
+}
+
+func TestRunSecretAdapterAnswersRequestWithStandInCLI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in scripts use /bin/sh")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "op")
+	assert.NilError(t, os.WriteFile(program, []byte("#!/bin/sh\necho \"$*\" >>\""+filepath.Join(dir, "calls")+"\"\necho '[]'\n"), 0o700))
+
+	path := filepath.Join(dir, "stdin")
+	assert.NilError(t, os.WriteFile(path, []byte(`{"version":1,"action":"get","key":"dev"}`), 0o600))
+	stdin, err := os.Open(path)
+	assert.NilError(t, err)
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() {
+		os.Stdin = originalStdin
+		_ = stdin.Close()
+	})
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"secret-adapter", "op", "--program", program, "--vault", "Employee"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(output)), `{"error":{"code":"not_found","message":"secret not found"}}`)
+	calls, err := os.ReadFile(filepath.Join(dir, "calls"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(calls), "item list --tags actool --format json --vault Employee\n")
+}
+
+func TestRunProfileArgumentValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "missing subcommand", args: nil, want: "requires a subcommand"},
+		{name: "unknown subcommand", args: []string{"unknown"}, want: "unknown profile subcommand"},
+		{name: "set without profile", args: []string{"set", "--favorite"}, want: "--profile is required"},
+		{name: "set without changes", args: []string{"set", "--profile", "dev"}, want: "nothing to set"},
+		{name: "list with positional argument", args: []string{"list", "dev"}, want: "unexpected arguments"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorContains(t, runProfile(tc.args), tc.want)
+		})
+	}
+}
+
+func TestRunProfileSetAndList(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+
+	_, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"profile", "set", "--profile", "dev", "--description", "Development", "--tag", "team-a,nonprod", "--favorite"})
+	})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, runProfile([]string{"set", "--profile", "dev", "--color", "orange"}), "unknown colour")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"profile", "list"})
+	})
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	assert.Equal(t, len(lines), 3)
+	assert.Assert(t, strings.HasPrefix(lines[1], "*"), lines[1])
+	assert.Assert(t, strings.Contains(lines[2], "★"), lines[2])
+	assert.Assert(t, strings.Contains(lines[2], "nonprod,team-a"), lines[2])
+	assert.Assert(t, strings.HasSuffix(lines[2], "Development"), lines[2])
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"profile", "list", "--tag", "team-a"})
+	})
+	assert.NilError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(output)), "\n")
+	assert.Equal(t, len(lines), 2)
+	assert.Assert(t, strings.Contains(lines[1], "dev"), lines[1])
+}
+
+func TestRunUndoConfirmsProductionProfile(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	p, err := profile.NewProfile()
+	assert.NilError(t, err)
+	assert.NilError(t, p.SetSelected("dev"))
+	assert.NilError(t, p.SetSelected("default"))
+	t.Setenv(profile.ProductionPatternEnv, "^dev$")
+
+	assert.ErrorContains(t, run(context.Background(), []string{"undo"}), "pass --confirm-production")
+	assert.ErrorContains(t, run(context.Background(), []string{"undo", "--confirm-production", "default"}), "does not match production profile")
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"undo", "--confirm-production", "dev"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "restored profile [\"dev\"] (was [\"default\"])\n")
+}
+
+func TestRunUse(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	t.Setenv(profile.SafeProfileEnv, "")
+
+	assert.ErrorContains(t, run(context.Background(), []string{"use"}), "use requires a profile name")
+	assert.ErrorContains(t, run(context.Background(), []string{"use", "dev", "extra"}), "unexpected arguments")
+	assert.ErrorContains(t, run(context.Background(), []string{"use", "dev", "--fallback", "default"}), "needs a lease duration")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"use", "dev", "--for", "30m", "--fallback", "default"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(output), "selected profile [\"dev\"] until "), string(output))
+	assert.Assert(t, strings.HasSuffix(string(output), "; [default] falls back to [\"default\"] after that\n"), string(output))
+	config, err := os.ReadFile(os.Getenv("AWS_CONFIG_FILE"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(config), " credential-process\n"), string(config))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"use", "--for", "5m", "default"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(string(output), "; [default] fails after that\n"), string(output))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"use", "dev"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "selected profile [\"dev\"]\n")
+}
+
+func TestRunHistoryAndUndo(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	p, err := profile.NewProfile()
+	assert.NilError(t, err)
+	assert.NilError(t, p.SetSelected("dev"))
+
+	assert.ErrorContains(t, run(context.Background(), []string{"history", "--limit", "-1"}), "must not be negative")
+	assert.ErrorContains(t, run(context.Background(), []string{"undo", "extra"}), "unexpected arguments")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"undo"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "restored profile [\"default\"] (was [\"dev\"])\n")
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"history"})
+	})
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	assert.Equal(t, len(lines), 3)
+	assert.Assert(t, strings.Contains(lines[1], "select") && strings.Contains(lines[1], "default") && strings.Contains(lines[1], "dev"), lines[1])
+	assert.Assert(t, strings.Contains(lines[2], "undo"), lines[2])
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"history", "--limit", "1"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(strings.Split(strings.TrimSpace(string(output)), "\n")), 2)
+}
+
+// snapshotTree reads every file under dir, keyed by path.
+func snapshotTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	assert.NilError(t, filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	}))
+	return files
+}
+
+func TestRunDryRunWritesNothing(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	p, err := profile.NewProfile()
+	assert.NilError(t, err)
+	assert.NilError(t, p.SetSelected("dev"))
+	home := os.Getenv("HOME")
+	before := snapshotTree(t, home)
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"undo", "--dry-run"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "restored profile [\"default\"]"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "Dry run: nothing was written."), string(output))
+	assert.Assert(t, strings.Contains(string(output), "credential-process --profile default\n"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "record undo in history: dev -> default"), string(output))
+
+	_, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"--dry-run", "profile", "set", "--profile", "dev", "--favorite"})
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, snapshotTree(t, home), before)
+	assert.Assert(t, dryRunPlan == nil)
+}
+
+func TestRunConfigRestore(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+
+	assert.ErrorContains(t, run(context.Background(), []string{"config"}), "requires a subcommand")
+	assert.ErrorContains(t, run(context.Background(), []string{"config", "backup"}), "unknown config subcommand")
+	assert.ErrorContains(t, run(context.Background(), []string{"config", "restore"}), "requires --list or a backup timestamp")
+	assert.ErrorContains(t, run(context.Background(), []string{"config", "restore", "--list", "x"}), "unexpected arguments")
+	assert.ErrorContains(t, run(context.Background(), []string{"config", "restore", "a", "b"}), "unexpected arguments")
+
+	configPath := os.Getenv("AWS_CONFIG_FILE")
+	before, err := os.ReadFile(configPath)
+	assert.NilError(t, err)
+	p, err := profile.NewProfile()
+	assert.NilError(t, err)
+	assert.NilError(t, p.SetSelected("dev"))
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"config", "restore", "--list"})
+	})
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	assert.Assert(t, len(lines) >= 2, string(output))
+	timestamp := strings.Fields(lines[1])[0]
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"config", "restore", timestamp})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "restored AWS config from backup [\""+timestamp+"\"]\n")
+	after, err := os.ReadFile(configPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(after), string(before))
+}
+
+func TestRunUninstall(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+
+	assert.ErrorContains(t, run(context.Background(), []string{"uninstall", "extra"}), "unexpected arguments")
+
+	exportPath := filepath.Join(os.Getenv("HOME"), "exported-credentials")
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"uninstall", "--export-credentials", exportPath})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "removed [profile dev] credential_process\n"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "removed empty section [profile dev]\n"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "exported 2 profiles to"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "state.json\"]; run actool uninstall --remove-state to delete it\n"), string(output))
+
+	config, err := os.ReadFile(os.Getenv("AWS_CONFIG_FILE"))
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(config), "credential-process"), string(config))
+	exported, err := os.ReadFile(exportPath)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(exported), "DEVSECRETKEY"))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"uninstall", "--remove-state"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(output), "deleted ["), string(output))
+	assert.Assert(t, strings.Contains(string(output), "state.json\"]\n"), string(output))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"uninstall"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(output), "nothing to uninstall\n"), string(output))
+}
+
+func TestRunShellInit(t *testing.T) {
+	assert.ErrorContains(t, run(context.Background(), []string{"shell-init"}), "requires one shell")
+	assert.ErrorContains(t, run(context.Background(), []string{"shell-init", "tcsh"}), "unsupported shell")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"shell-init", "zsh"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "actool() {"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "ACTOOL_SHELL_SELECTION_FILE"), string(output))
+}
+
+func TestRunDir(t *testing.T) {
+	configureIsolatedRuntime(t)
+	assert.ErrorContains(t, run(context.Background(), []string{"dir"}), "requires a subcommand")
+	assert.ErrorContains(t, run(context.Background(), []string{"dir", "allow"}), "unknown dir subcommand")
+	assert.ErrorContains(t, run(context.Background(), []string{"dir", "current", "x"}), "unexpected arguments")
+
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	profileFile := filepath.Join(repo, ".actool-profile")
+	assert.NilError(t, os.WriteFile(profileFile, []byte("dev\n"), 0o600))
+	t.Chdir(repo)
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"dir", "current"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "")
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"dir", "trust"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "trusted [\""+profileFile+"\"] for profile [\"dev\"]\n")
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"dir", "current"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "dev\n")
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"dir", "status", repo})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "[\""+profileFile+"\"] selects profile [\"dev\"] (trusted)\n")
+}
+
+func TestRunImport(t *testing.T) {
+	configureIsolatedRuntime(t)
+	assert.ErrorContains(t, run(context.Background(), []string{"import", "source"}), "import requires --from")
+	assert.ErrorContains(t, run(context.Background(), []string{"import", "--from", "csv"}), "requires one source path")
+
+	source := filepath.Join(os.Getenv("HOME"), "dev.env")
+	assert.NilError(t, os.WriteFile(source, []byte("export AWS_ACCESS_KEY_ID=DEVACCESSKEY1\nexport AWS_SECRET_ACCESS_KEY=DEVSECRETKEY\n"), 0o600))
+	assert.ErrorContains(t, run(context.Background(), []string{"import", "--from", "env", "--yes", source}), "pass --profile")
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"import", "--from", "env", "--profile", "dev", "--yes", "--shred", source})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "DEVA*****KEY1"), string(output))
+	assert.Assert(t, !strings.Contains(string(output), "DEVSECRETKEY"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "imported 1 credentials from"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "shredded"), string(output))
+	_, err = os.Stat(source)
+	assert.Assert(t, os.IsNotExist(err))
+
+	config, err := os.ReadFile(os.Getenv("AWS_CONFIG_FILE"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(config), "[profile dev]"), string(config))
+}
+
+func TestRunMigrate(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	assert.ErrorContains(t, run(context.Background(), []string{"migrate", "extra"}), "unexpected arguments")
+	assert.ErrorContains(t, run(context.Background(), []string{"migrate", "--rename", "dev"}), "--rename expects OLD=NEW")
+
+	credentialsPath := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	assert.NilError(t, os.WriteFile(credentialsPath, []byte("[dev]\naws_access_key_id = DEVNEWACCESSKEY\naws_secret_access_key = DEVNEWSECRETKEY\n\n"+
+		"[stage]\naws_access_key_id = STAGEACCESSKEY\naws_secret_access_key = STAGESECRETKEY\n"), 0o600))
+
+	_, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"migrate", "--yes"})
+	})
+	assert.ErrorContains(t, err, `profile "dev" conflicts with an existing aws-vault credential; pass --keep, --overwrite or --rename`)
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"migrate", "--yes", "--rename", "dev=dev-new"})
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(output), "conflict with DEVA****SKEY"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "stored profile [\"stage\"]\n"), string(output))
+	assert.Assert(t, strings.Contains(string(output), "imported profile [\"dev\"] as [\"dev-new\"]\n"), string(output))
+	_, err = os.Stat(credentialsPath)
+	assert.Assert(t, os.IsNotExist(err))
+
+	output, err = captureStdout(t, func() error {
+		return run(context.Background(), []string{"migrate"})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "nothing to migrate\n")
+}
+
+func TestRunOrgSync(t *testing.T) {
+	configureIsolatedRuntime(t)
+	writeRuntimeLegacyCredentials(t)
+	initializeRuntimeProfile(t)
+	assert.ErrorContains(t, run(context.Background(), []string{"org"}), "requires a subcommand")
+	assert.ErrorContains(t, run(context.Background(), []string{"org", "sync", "--role-name", "Admin"}), "requires --source-profile and --role-name")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("X-Amz-Target"), "AWSOrganizationsV20161128.ListAccounts")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"Accounts": []map[string]string{
+			{"Id": "111111111111", "Name": "Sandbox", "Status": "ACTIVE"},
+		}})
+	}))
+	t.Cleanup(server.Close)
+
+	output, err := captureStdout(t, func() error {
+		return run(context.Background(), []string{"org", "sync", "--source-profile", "dev", "--role-name", "Admin", "--name-template", "org-{{.Name}}", "--endpoint", server.URL})
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, string(output), "created profile [\"org-Sandbox\"]\n")
+	config, err := os.ReadFile(os.Getenv("AWS_CONFIG_FILE"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(config), "[profile org-Sandbox]"), string(config))
+	assert.Assert(t, strings.Contains(string(config), "arn:aws:iam::111111111111:role/Admin"), string(config))
+}
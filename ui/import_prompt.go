@@ -0,0 +1,80 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chzyer/readline"
+	"github.com/manifoldco/promptui"
+)
+
+// ConfirmImport asks whether to store the previewed credentials. It fails
+// when stdin is not a terminal, so scripts have to pass --yes.
+func ConfirmImport(changes int) (bool, error) {
+	return confirm(fmt.Sprintf("Store %d credentials in the keyring", changes))
+}
+
+func confirm(label string) (bool, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("stdin is not a terminal; pass --yes to continue without confirmation")
+	}
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		if errors.Is(err, promptui.ErrAbort) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type importSourceCleanupOption struct {
+	Name   string
+	Detail string
+	Shred  bool
+}
+
+// PromptShredImportSource offers to shred the plaintext file that was just
+// imported. It keeps the file when stdin is not a terminal.
+func PromptShredImportSource(path string) (bool, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return false, nil
+	}
+	options := []importSourceCleanupOption{
+		{
+			Name:   "Shred file now.",
+			Detail: fmt.Sprintf("Overwrite %s with random bytes and remove it.", path),
+			Shred:  true,
+		},
+		{
+			Name:   "Keep file.",
+			Detail: fmt.Sprintf("Leave %s in place. It still holds the credentials in plaintext.", path),
+			Shred:  false,
+		},
+	}
+
+	prompt := promptui.Select{
+		Label: "Credentials imported. Shred the source file?",
+		Items: options,
+		Templates: &promptui.SelectTemplates{
+			Label:    "{{ . }}",
+			Active:   "-> {{ .Name | cyan }}",
+			Inactive: "   {{ .Name | cyan }}",
+			Selected: "{{ .Name | green }}",
+			Details: `
+--------- Option ----------
+{{ .Detail }}
+`,
+		},
+	}
+
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return false, err
+	}
+	return options[idx].Shred, nil
+}
@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/manifoldco/promptui"
+)
+
+// PromptMFASeed reads the base32 TOTP seed from the terminal without echoing
+// it, or from the first line of stdin when it is not a terminal. The seed is
+// never accepted as a command-line argument so it stays out of shell history.
+func PromptMFASeed(profileName string) (string, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	prompt := promptui.Prompt{
+		Label: fmt.Sprintf("Input MFA seed (base32). Profile[%s]", profileName),
+		Mask:  '*',
+	}
+	return prompt.Run()
+}
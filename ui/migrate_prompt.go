@@ -0,0 +1,89 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/manifoldco/promptui"
+	"github.com/tomtwinkle/aws-credential-tool/io/profile"
+)
+
+// ConfirmMigration asks whether to apply the previewed migration.
+func ConfirmMigration() (bool, error) {
+	return confirm("Apply the migration")
+}
+
+type migrationConflictOption struct {
+	Name     string
+	Detail   string
+	Strategy string
+}
+
+// PromptMigrationConflict asks how to resolve a profile that already holds
+// a different credential. It fails when stdin is not a terminal, so scripts
+// have to resolve conflicts with flags.
+func PromptMigrationConflict(entry profile.MigrationEntry) (profile.MigrationResolution, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return profile.MigrationResolution{}, fmt.Errorf("profile %q conflicts with an existing aws-vault credential; pass --keep, --overwrite or --rename", entry.Profile)
+	}
+	options := []migrationConflictOption{
+		{
+			Name:     "Keep existing.",
+			Detail:   fmt.Sprintf("Keep %s in the keyring and drop %s from %s.", entry.ExistingAccessKeyID, entry.AccessKeyID, entry.Source),
+			Strategy: profile.MigrateKeep,
+		},
+		{
+			Name:     "Overwrite.",
+			Detail:   fmt.Sprintf("Replace %s in the keyring with %s from %s.", entry.ExistingAccessKeyID, entry.AccessKeyID, entry.Source),
+			Strategy: profile.MigrateOverwrite,
+		},
+		{
+			Name:     "Import under a new name.",
+			Detail:   fmt.Sprintf("Keep %s and store %s as another profile.", entry.ExistingAccessKeyID, entry.AccessKeyID),
+			Strategy: profile.MigrateRename,
+		},
+	}
+
+	prompt := promptui.Select{
+		Label: fmt.Sprintf("Profile [%s] already holds a different credential.", entry.Profile),
+		Items: options,
+		Templates: &promptui.SelectTemplates{
+			Label:    "{{ . }}",
+			Active:   "-> {{ .Name | cyan }}",
+			Inactive: "   {{ .Name | cyan }}",
+			Selected: "{{ .Name | green }}",
+			Details: `
+--------- Option ----------
+{{ .Detail }}
+`,
+		},
+	}
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return profile.MigrationResolution{}, err
+	}
+	resolution := profile.MigrationResolution{Strategy: options[idx].Strategy}
+	if resolution.Strategy != profile.MigrateRename {
+		return resolution, nil
+	}
+
+	namePrompt := promptui.Prompt{
+		Label:   fmt.Sprintf("New profile name for [%s]", entry.Profile),
+		Default: entry.Profile + " (migrated)",
+		Validate: func(input string) error {
+			if strings.TrimSpace(input) == "" {
+				return errors.New("profile name is empty")
+			}
+			return nil
+		},
+	}
+	resolution.NewName, err = namePrompt.Run()
+	if err != nil {
+		return profile.MigrationResolution{}, err
+	}
+	resolution.NewName = strings.TrimSpace(resolution.NewName)
+	return resolution, nil
+}
@@ -1,6 +1,7 @@
 package mode
 
 import (
+	"context"
 	"fmt"
 	"github.com/chzyer/readline"
 	"github.com/manifoldco/promptui"
@@ -14,7 +15,7 @@ type mode struct {
 }
 
 type ActionSelect interface {
-	Select() (model.SelectMode, error)
+	Select(ctx context.Context) (model.SelectMode, error)
 }
 
 type actionSelect struct {
@@ -24,7 +25,7 @@ func NewModeActionSelect() ActionSelect {
 	return &actionSelect{}
 }
 
-func (a *actionSelect) Select() (model.SelectMode, error) {
+func (a *actionSelect) Select(ctx context.Context) (model.SelectMode, error) {
 	modes := []mode{
 		{
 			Name:       "Set choose profile.",
@@ -59,7 +60,10 @@ func (a *actionSelect) Select() (model.SelectMode, error) {
 		Templates: templates,
 	}
 
-	idx, _, err := prompt.Run()
+	idx, err := runPrompt(ctx, func() (int, error) {
+		idx, _, err := prompt.Run()
+		return idx, err
+	})
 	if err != nil {
 		fmt.Printf("Prompt failed %v\n", err)
 		return 0, err
@@ -1,35 +1,50 @@
 package mode
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
 	"github.com/chzyer/readline"
 	"github.com/manifoldco/promptui"
 	"github.com/tomtwinkle/aws-credential-tool/io/profile"
 )
 
 type ProfileSelect interface {
-	Select() (string, error)
+	Select(ctx context.Context) (string, error)
 }
 
 type profileSelect struct {
 	mProfile *profile.Model
+	filter   profile.ProfileFilter
+}
+
+// profileItem is one line of the profile prompt.
+type profileItem struct {
+	Name   string
+	Label  string
+	Detail string
+	search string
 }
 
-func NewModeProfileSelect(mProfile *profile.Model) ProfileSelect {
-	return &profileSelect{mProfile: mProfile}
+func NewModeProfileSelect(mProfile *profile.Model, filter profile.ProfileFilter) ProfileSelect {
+	return &profileSelect{mProfile: mProfile, filter: filter}
 }
 
-func (l *profileSelect) Select() (string, error) {
-	var profiles = make([]string, len(l.mProfile.Credentials))
-	for i, p := range l.mProfile.Credentials {
-		profiles[i] = p.Name
+func (l *profileSelect) Select(ctx context.Context) (string, error) {
+	items := l.items()
+	if len(items) == 0 {
+		return "", errors.New("no profile matches the filter")
 	}
 
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}?",
-		Active:   "-> {{ . | cyan }}",
-		Inactive: "  {{ . | cyan }}",
-		Selected: "-> {{ . | green | cyan }}",
+		Active:   "-> {{ .Label }}{{ if .Detail }} {{ .Detail | faint }}{{ end }}",
+		Inactive: "   {{ .Label }}{{ if .Detail }} {{ .Detail | faint }}{{ end }}",
+		Selected: "-> {{ .Name | green }}",
 	}
 
 	prompt := promptui.Select{
@@ -38,11 +53,20 @@ func (l *profileSelect) Select() (string, error) {
 			Prev: promptui.Key{Code: readline.CharPrev, Display: "↑"},
 		},
 		Label:     "Select Profile",
-		Items:     profiles,
+		Items:     items,
 		Templates: templates,
+		Searcher: func(input string, index int) bool {
+			return strings.Contains(items[index].search, strings.ToLower(strings.TrimSpace(input)))
+		},
 	}
 
-	_, result, err := prompt.Run()
+	result, err := runPrompt(ctx, func() (string, error) {
+		index, _, err := prompt.Run()
+		if err != nil {
+			return "", err
+		}
+		return items[index].Name, nil
+	})
 	if err != nil {
 		fmt.Printf("Prompt failed %v\n", err)
 		return "", err
@@ -51,3 +75,69 @@ func (l *profileSelect) Select() (string, error) {
 	fmt.Printf("choose profile [%q]\n", result)
 	return result, nil
 }
+
+// items lists the profiles that match the filter, favourites first.
+func (l *profileSelect) items() []profileItem {
+	names := make([]string, 0, len(l.mProfile.Credentials)+len(l.mProfile.SSOProfiles))
+	for _, p := range l.mProfile.Credentials {
+		names = append(names, p.Name)
+	}
+	names = append(names, l.mProfile.SSOProfiles...)
+
+	items := make([]profileItem, 0, len(names))
+	favorites := make(map[string]bool)
+	for _, name := range names {
+		metadata := l.mProfile.Metadata[name]
+		if !l.filter.Match(metadata) {
+			continue
+		}
+		favorites[name] = metadata.Favorite
+		items = append(items, newProfileItem(name, metadata, l.mProfile.Guards[name]))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return favorites[items[i].Name] && !favorites[items[j].Name]
+	})
+	return items
+}
+
+func newProfileItem(name string, metadata profile.ProfileMetadata, guard profile.Guard) profileItem {
+	// Profile colours are named after promptui's colour template functions.
+	color := "cyan"
+	if slices.Contains(profile.ProfileColors, metadata.Color) {
+		color = metadata.Color
+	}
+	// Production profiles are always red.
+	if guard.Production {
+		color = "red"
+	}
+	label := promptui.FuncMap[color].(func(interface{}) string)(name)
+	if metadata.Favorite {
+		label = "★ " + label
+	} else {
+		label = "  " + label
+	}
+
+	var details []string
+	var marks []string
+	if guard.Production {
+		marks = append(marks, "production")
+	}
+	if guard.Restricted {
+		marks = append(marks, "--profile only")
+	}
+	if len(marks) > 0 {
+		details = append(details, "("+strings.Join(marks, ", ")+")")
+	}
+	if metadata.Description != "" {
+		details = append(details, metadata.Description)
+	}
+	if len(metadata.Tags) > 0 {
+		details = append(details, "["+strings.Join(metadata.Tags, ", ")+"]")
+	}
+	return profileItem{
+		Name:   name,
+		Label:  label,
+		Detail: strings.Join(details, " "),
+		search: strings.ToLower(strings.Join(append([]string{name, metadata.Description}, metadata.Tags...), " ")),
+	}
+}
@@ -0,0 +1,50 @@
+package mode
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/chzyer/readline"
+	"github.com/manifoldco/promptui"
+)
+
+// runPrompt runs a blocking promptui call so that it can be abandoned when ctx
+// is canceled. promptui switches the terminal to raw mode, where Ctrl-C is read
+// as input rather than raised as SIGINT, so both paths are reported as
+// context.Canceled and the terminal state is restored before returning.
+func runPrompt[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	fd := int(os.Stdin.Fd())
+	var state *readline.State
+	if readline.IsTerminal(fd) {
+		state, _ = readline.GetState(fd)
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value: value, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if errors.Is(r.err, promptui.ErrInterrupt) || errors.Is(r.err, promptui.ErrEOF) {
+			return zero, context.Canceled
+		}
+		return r.value, r.err
+	case <-ctx.Done():
+		if state != nil {
+			_ = readline.Restore(fd, state)
+		}
+		return zero, ctx.Err()
+	}
+}
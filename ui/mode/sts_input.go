@@ -1,38 +1,59 @@
 package mode
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/manifoldco/promptui"
+	"github.com/tomtwinkle/aws-credential-tool/io/mfa"
 	"github.com/tomtwinkle/aws-credential-tool/io/sts"
 	"strconv"
 )
 
 type STSInput interface {
-	GetSessionToken() (*sts.SessionToken, error)
+	GetSessionToken(ctx context.Context) (*sts.SessionToken, error)
 }
 
 type stsInput struct {
-	sts sts.Service
+	sts         sts.Service
+	profileName string
+	mfa         mfa.Provider
 }
 
-func NewModeSTS(accessKey string, secretKey string, region string) STSInput {
-	s := sts.NewService(accessKey, secretKey, region)
-	return &stsInput{sts: s}
+type promptMFAProvider struct {
 }
 
-func (s *stsInput) GetSessionToken() (*sts.SessionToken, error) {
-	account, err := s.sts.Account()
+// NewModeSTS obtains the MFA code from provider, or prompts for it when
+// provider is nil.
+func NewModeSTS(accessKey string, secretKey string, options sts.Options, profileName string, provider mfa.Provider) STSInput {
+	s := sts.NewService(accessKey, secretKey, options)
+	if provider == nil {
+		provider = NewPromptMFAProvider()
+	}
+	return &stsInput{sts: s, profileName: profileName, mfa: provider}
+}
+
+// NewPromptMFAProvider asks for the MFA code on the terminal.
+func NewPromptMFAProvider() mfa.Provider {
+	return &promptMFAProvider{}
+}
+
+func (s *stsInput) GetSessionToken(ctx context.Context) (*sts.SessionToken, error) {
+	account, err := s.sts.Account(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := s.inputToken(account.Account, account.UserName)
+	token, err := s.mfa.Token(ctx, mfa.Request{
+		ProfileName: s.profileName,
+		Account:     account.Account,
+		UserName:    account.UserName,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	sToken, err := s.sts.SessionToken(43200, account.Account, account.UserName, token)
+	sToken, err := s.sts.SessionToken(ctx, 43200, account, token)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +63,7 @@ func (s *stsInput) GetSessionToken() (*sts.SessionToken, error) {
 	return sToken, nil
 }
 
-func (s *stsInput) inputToken(account string, userName string) (string, error) {
+func (p *promptMFAProvider) Token(ctx context.Context, request mfa.Request) (string, error) {
 	validate := func(input string) error {
 		_, err := strconv.ParseFloat(input, 64)
 		if err != nil {
@@ -55,11 +76,11 @@ func (s *stsInput) inputToken(account string, userName string) (string, error) {
 	}
 
 	prompt := promptui.Prompt{
-		Label:    fmt.Sprintf("Input MFA Token. Account[%s] User[%s]", account, userName),
+		Label:    fmt.Sprintf("Input MFA Token. Account[%s] User[%s]", request.Account, request.UserName),
 		Validate: validate,
 	}
 
-	result, err := prompt.Run()
+	result, err := runPrompt(ctx, prompt.Run)
 
 	if err != nil {
 		fmt.Printf("%v\n", err.Error())
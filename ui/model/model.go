@@ -7,4 +7,5 @@ const (
 	SelectModeActionSelect
 	SelectModeSTS
 	SelectModeEnd
+	SelectModeSSOLogin
 )
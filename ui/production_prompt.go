@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chzyer/readline"
+	"github.com/manifoldco/promptui"
+)
+
+// PromptProductionConfirm asks for the name of a production profile before
+// it is selected and returns what was typed; io/profile checks that it
+// matches. It fails when stdin is not a terminal, so scripts have to pass
+// --confirm-production.
+func PromptProductionConfirm(profileName string) (string, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("profile %q is a production profile and stdin is not a terminal; pass --confirm-production to select it", profileName)
+	}
+	red := promptui.Styler(promptui.FGRed, promptui.FGBold)
+	prompt := promptui.Prompt{
+		Label: fmt.Sprintf("%s is a production profile. Type its name to continue", red(profileName)),
+	}
+	typed, err := prompt.Run()
+	if err != nil {
+		if errors.Is(err, promptui.ErrInterrupt) {
+			return "", errors.New("production profile was not confirmed")
+		}
+		return "", err
+	}
+	return typed, nil
+}
@@ -0,0 +1,20 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tomtwinkle/aws-credential-tool/io/sso"
+)
+
+// PrintSSODeviceAuthorization tells the user where to approve an IAM
+// Identity Center login. It writes to stderr so it never mixes with
+// credential_process output.
+func PrintSSODeviceAuthorization(authorization *sso.DeviceAuthorization) error {
+	url := authorization.VerificationURIComplete
+	if url == "" {
+		url = authorization.VerificationURI
+	}
+	_, err := fmt.Fprintf(os.Stderr, "Open the following URL in a browser and confirm the code %s:\n\n  %s\n\nWaiting for approval...\n", authorization.UserCode, url)
+	return err
+}
@@ -1,14 +1,36 @@
 package ui
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/tomtwinkle/aws-credential-tool/io/mfa"
 	"github.com/tomtwinkle/aws-credential-tool/io/profile"
+	"github.com/tomtwinkle/aws-credential-tool/io/sts"
 	"github.com/tomtwinkle/aws-credential-tool/ui/mode"
 	"github.com/tomtwinkle/aws-credential-tool/ui/model"
+	"slices"
+	"time"
 )
 
 type UI interface {
-	Run() error
+	Run(ctx context.Context) error
+	// SelectedProfile is the profile chosen by Run, or "" before that.
+	SelectedProfile() string
+}
+
+// Options adjust an interactive session.
+type Options struct {
+	// Timeout bounds each STS request; zero uses sts.DefaultTimeout or
+	// ACTOOL_TIMEOUT.
+	Timeout time.Duration
+	// Filter limits the profiles offered for selection.
+	Filter profile.ProfileFilter
+	// DryRun, when set, collects the changes instead of making them.
+	DryRun *profile.Plan
+	// Shell selects the profile for the calling shell only; the caller
+	// exports it as AWS_PROFILE.
+	Shell bool
 }
 
 type ui struct {
@@ -24,11 +46,23 @@ type ui struct {
 	selectProfile    string
 	selectCredential *profile.Credential
 	selectConfig     *profile.Config
+
+	// confirmed is the name typed to confirm a production profile, so that
+	// it is asked for once.
+	confirmed map[string]string
+
+	options Options
 }
 
-func NewUI() (UI, error) {
+func NewUI(options Options) (UI, error) {
 	initMode := model.SelectModeProfileSelect
-	p, err := profile.NewInteractiveProfile(promptDeleteLegacyCredentials)
+	var p profile.Profile
+	var err error
+	if options.DryRun != nil {
+		p, err = profile.NewDryRunProfile(promptDeleteLegacyCredentials, options.DryRun)
+	} else {
+		p, err = profile.NewInteractiveProfile(promptDeleteLegacyCredentials)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -37,10 +71,10 @@ func NewUI() (UI, error) {
 	if err != nil {
 		return nil, err
 	}
-	if mProfile == nil || len(mProfile.Credentials) == 0 {
+	if mProfile == nil || (len(mProfile.Credentials) == 0 && len(mProfile.SSOProfiles) == 0) {
 		return nil, errors.New("Profile not defined.") //nolint:staticcheck // preserve the existing user-facing error text
 	}
-	profileSelect := mode.NewModeProfileSelect(mProfile)
+	profileSelect := mode.NewModeProfileSelect(mProfile, options.Filter)
 	actionSelect := mode.NewModeActionSelect()
 
 	return &ui{
@@ -49,12 +83,13 @@ func NewUI() (UI, error) {
 		profile:       p,
 		nextMode:      initMode,
 		mProfile:      mProfile,
+		options:       options,
 	}, nil
 }
 
-func (u *ui) Run() error {
+func (u *ui) Run(ctx context.Context) error {
 	for {
-		exit, err := u.render()
+		exit, err := u.render(ctx)
 		if err != nil {
 			return err
 		}
@@ -64,27 +99,57 @@ func (u *ui) Run() error {
 	}
 }
 
-func (u *ui) render() (bool, error) {
+func (u *ui) SelectedProfile() string {
+	if u.mode != model.SelectModeEnd {
+		return ""
+	}
+	return u.selectProfile
+}
+
+func (u *ui) selectOptions() profile.SelectOptions {
+	return profile.SelectOptions{Shell: u.options.Shell, ConfirmProduction: u.confirmProduction}
+}
+
+func (u *ui) confirmProduction(profileName string) (string, error) {
+	if typed, ok := u.confirmed[profileName]; ok {
+		return typed, nil
+	}
+	typed, err := PromptProductionConfirm(profileName)
+	if err != nil {
+		return "", err
+	}
+	if u.confirmed == nil {
+		u.confirmed = make(map[string]string)
+	}
+	u.confirmed[profileName] = typed
+	return typed, nil
+}
+
+func (u *ui) render(ctx context.Context) (bool, error) {
 	if u.nextMode == u.mode {
 		return false, nil
 	}
 
 	switch u.nextMode {
 	case model.SelectModeProfileSelect:
-		if err := u.modeProfileSelect(); err != nil {
+		if err := u.modeProfileSelect(ctx); err != nil {
 			return false, err
 		}
 	case model.SelectModeActionSelect:
-		if err := u.modeActionSelect(); err != nil {
+		if err := u.modeActionSelect(ctx); err != nil {
 			return false, err
 		}
 	case model.SelectModeSTS:
-		if err := u.modeSTS(); err != nil {
+		if err := u.modeSTS(ctx); err != nil {
+			return false, err
+		}
+	case model.SelectModeSSOLogin:
+		if err := u.modeSSOLogin(ctx); err != nil {
 			return false, err
 		}
 	case model.SelectModeEnd:
 		u.mode = model.SelectModeEnd
-		if err := u.profile.SetSelected(u.selectProfile); err != nil {
+		if err := u.profile.SetSelectedWithOptions(u.selectProfile, u.selectOptions()); err != nil {
 			return false, err
 		}
 		return true, nil
@@ -94,15 +159,31 @@ func (u *ui) render() (bool, error) {
 	return false, nil
 }
 
-func (u *ui) modeProfileSelect() error {
+func (u *ui) modeProfileSelect(ctx context.Context) error {
 	u.mode = model.SelectModeProfileSelect
-	profileStr, err := u.profileSelect.Select()
+	profileStr, err := u.profileSelect.Select(ctx)
 	if err != nil {
 		return err
 	}
 	if profileStr == "" {
 		return errors.New("not select profile.") //nolint:staticcheck // preserve the existing error text
 	}
+	// Production profiles are confirmed before an MFA code or SSO login is
+	// asked for; io/profile checks again when the profile is selected.
+	if err := u.mProfile.Guards[profileStr].Check(profileStr, u.selectOptions()); err != nil {
+		return err
+	}
+	if slices.Contains(u.mProfile.SSOProfiles, profileStr) {
+		conf, err := u.profile.Config(u.mProfile, profileStr)
+		if err != nil {
+			return err
+		}
+		u.selectProfile = profileStr
+		u.selectCredential = nil
+		u.selectConfig = conf
+		u.nextMode = model.SelectModeSSOLogin
+		return nil
+	}
 	cre, err := u.profile.Credential(profileStr)
 	if err != nil {
 		return err
@@ -120,9 +201,9 @@ func (u *ui) modeProfileSelect() error {
 	return nil
 }
 
-func (u *ui) modeActionSelect() error {
+func (u *ui) modeActionSelect(ctx context.Context) error {
 	u.mode = model.SelectModeActionSelect
-	nextMode, err := u.actionSelect.Select()
+	nextMode, err := u.actionSelect.Select(ctx)
 	if err != nil {
 		return err
 	}
@@ -130,10 +211,22 @@ func (u *ui) modeActionSelect() error {
 	return nil
 }
 
-func (u *ui) modeSTS() error {
+func (u *ui) modeSTS(ctx context.Context) error {
 	u.mode = model.SelectModeSTS
-	sts := mode.NewModeSTS(u.selectCredential.AccessKey, u.selectCredential.SecretKey, u.selectConfig.Region)
-	sToken, err := sts.GetSessionToken()
+	provider, err := u.mfaProvider()
+	if err != nil {
+		return err
+	}
+	options := sts.Options{
+		Region:               u.selectConfig.Region,
+		EndpointURL:          u.selectConfig.EndpointURL,
+		RegionalEndpoints:    u.selectConfig.STSRegionalEndpoints,
+		UseFIPSEndpoint:      u.selectConfig.UseFIPSEndpoint,
+		UseDualStackEndpoint: u.selectConfig.UseDualStackEndpoint,
+		Timeout:              u.options.Timeout,
+	}
+	stsInput := mode.NewModeSTS(u.selectCredential.AccessKey, u.selectCredential.SecretKey, options, u.selectProfile, provider)
+	sToken, err := stsInput.GetSessionToken(ctx)
 	if err != nil {
 		return err
 	}
@@ -145,9 +238,44 @@ func (u *ui) modeSTS() error {
 		Expiration:   &sToken.Expiration,
 		MFASerial:    sToken.MFASerial,
 	}
-	if err := u.profile.StoreSessionToken(u.selectProfile, cre); err != nil {
+	if err := u.profile.StoreSessionTokenWithOptions(u.selectProfile, cre, u.selectOptions()); err != nil {
 		return err
 	}
 	u.nextMode = model.SelectModeEnd
 	return nil
 }
+
+// modeSSOLogin selects an IAM Identity Center profile, running the device
+// login first when no usable token is stored.
+func (u *ui) modeSSOLogin(ctx context.Context) error {
+	u.mode = model.SelectModeSSOLogin
+	loggedIn, err := u.profile.SSOLoggedIn(u.selectProfile)
+	if err != nil {
+		return err
+	}
+	if !loggedIn {
+		if err := u.profile.SSOLogin(ctx, u.selectProfile, PrintSSODeviceAuthorization); err != nil {
+			return err
+		}
+		fmt.Println("Success SSO login.")
+	}
+	u.nextMode = model.SelectModeEnd
+	return nil
+}
+
+// mfaProvider honours the actool_mfa_* settings only when they come from the
+// selected profile's own section, not from the [default] fallback.
+func (u *ui) mfaProvider() (mfa.Provider, error) {
+	settings := mfa.Settings{}
+	if u.selectConfig.Name == u.selectProfile {
+		settings = mfa.Settings{
+			Provider: u.selectConfig.MFAProvider,
+			Process:  u.selectConfig.MFAProcess,
+			Askpass:  u.selectConfig.MFAAskpass,
+		}
+	}
+	totp := mfa.ProviderFunc(func(ctx context.Context, request mfa.Request) (string, error) {
+		return u.profile.MFAToken(ctx, request.ProfileName)
+	})
+	return mfa.NewProvider(settings, mode.NewPromptMFAProvider(), totp)
+}